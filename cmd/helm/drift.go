@@ -0,0 +1,132 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm_v3
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/werf/3p-helm-for-werf-helm/cmd/helm/require"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/action"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/cli/output"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases"
+)
+
+const driftDesc = `
+This command compares the resources of a release with their live state in the
+cluster and shows the fields that were changed outside of Helm, e.g. with
+'kubectl edit'.
+
+Only the fields set in the release manifests are compared, so the fields
+defaulted by the server and the status of resources are ignored.
+`
+
+func NewDriftCmd(cfg *action.Configuration, out io.Writer, opts DriftCmdOptions) *cobra.Command {
+	client := action.NewDrift(cfg, opts.StagesSplitter)
+	var outfmt output.Format
+	var failOnDrift bool
+
+	cmd := &cobra.Command{
+		Use:   "drift RELEASE_NAME",
+		Short: "show the differences between a release and its live resources",
+		Long:  driftDesc,
+		Args:  require.ExactArgs(1),
+		ValidArgsFunction: func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+			if len(args) != 0 {
+				return nil, cobra.ShellCompDirectiveNoFileComp
+			}
+			return compListReleases(toComplete, args, cfg)
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			drifts, err := client.Run(args[0])
+			if err != nil {
+				return err
+			}
+
+			if err := outfmt.Write(out, driftPrinter(drifts)); err != nil {
+				return err
+			}
+
+			if failOnDrift && len(drifts) > 0 {
+				return fmt.Errorf("release %q has %d drifted resource(s)", args[0], len(drifts))
+			}
+
+			return nil
+		},
+	}
+
+	f := cmd.Flags()
+	f.BoolVar(&failOnDrift, "fail-on-drift", false, "if set, exit with an error when any of the release resources has drifted")
+	bindOutputFlag(cmd, &outfmt)
+
+	return cmd
+}
+
+func newDriftCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
+	return NewDriftCmd(cfg, out, DriftCmdOptions{})
+}
+
+type DriftCmdOptions struct {
+	StagesSplitter phases.Splitter
+}
+
+type driftPrinter []*action.ResourceDrift
+
+func (d driftPrinter) WriteJSON(out io.Writer) error {
+	return output.EncodeJSON(out, d)
+}
+
+func (d driftPrinter) WriteYAML(out io.Writer) error {
+	return output.EncodeYAML(out, d)
+}
+
+func (d driftPrinter) WriteTable(out io.Writer) error {
+	if len(d) == 0 {
+		_, err := fmt.Fprintln(out, "No drift detected")
+		return err
+	}
+
+	for _, resDrift := range d {
+		if resDrift.Missing {
+			fmt.Fprintf(out, "%s: missing in the cluster\n", resDrift.Resource)
+			continue
+		}
+
+		fmt.Fprintf(out, "%s:\n", resDrift.Resource)
+		for _, field := range resDrift.Fields {
+			fmt.Fprintf(out, "  %s: expected %s, live %s\n", field.Path, formatDriftValue(field.Expected), formatDriftValue(field.Live))
+		}
+	}
+
+	return nil
+}
+
+func formatDriftValue(value interface{}) string {
+	if value == nil {
+		return "<none>"
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+
+	return string(data)
+}
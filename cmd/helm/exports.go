@@ -31,6 +31,7 @@ var (
 	// NewUpgradeCmd    = newUpgradeCmd
 	// NewUninstallCmd  = newUninstallCmd
 	// NewRollbackCmd   = newRollbackCmd
+	// NewDriftCmd      = newDriftCmd
 	NewPullCmd = newPullCmd
 	NewPushCmd = newPushCmd
 
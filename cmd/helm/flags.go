@@ -51,6 +51,11 @@ func addValueOptionsFlags(f *pflag.FlagSet, v *values.Options) {
 	f.StringArrayVar(&v.LiteralValues, "set-literal", []string{}, "set a literal STRING value on the command line")
 }
 
+func addHookLogsFlags(f *pflag.FlagSet, cfg *action.Configuration) {
+	f.Int64Var(&cfg.HookLogsTailLines, "hook-logs-tail-lines", 100, "number of last lines of the container logs of failed hooks saved in the release, a negative value disables capturing")
+	f.BoolVar(&cfg.CaptureSucceededHookLogs, "capture-succeeded-hook-logs", false, "if set, save the container logs of succeeded hooks in the release too")
+}
+
 func addChartPathOptionsFlags(f *pflag.FlagSet, c *action.ChartPathOptions) {
 	f.StringVar(&c.Version, "version", "", "specify a version constraint for the chart version to use. This constraint can be a specific tag (e.g. 1.1.1) or it may reference a valid range (e.g. ^2.0.0). If this is not specified, the latest version is used")
 	f.BoolVar(&c.Verify, "verify", false, "verify the package before using it")
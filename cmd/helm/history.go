@@ -112,7 +112,7 @@ func (r releaseHistory) WriteTable(out io.Writer) error {
 }
 
 func getHistory(client *action.History, name string) (releaseHistory, error) {
-	hist, err := client.Run(name)
+	hist, err := client.RunSummaries(name)
 	if err != nil {
 		return nil, err
 	}
@@ -188,7 +188,7 @@ func compListRevisions(_ string, cfg *action.Configuration, releaseName string)
 	client := action.NewHistory(cfg)
 
 	var revisions []string
-	if hist, err := client.Run(releaseName); err == nil {
+	if hist, err := client.RunSummaries(releaseName); err == nil {
 		for _, release := range hist {
 			appVersion := fmt.Sprintf("App: %s", release.Chart.Metadata.AppVersion)
 			chartDesc := fmt.Sprintf("Chart: %s-%s", release.Chart.Metadata.Name, release.Chart.Metadata.Version)
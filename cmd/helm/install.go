@@ -196,6 +196,7 @@ func NewInstallCmd(cfg *action.Configuration, out io.Writer, opts InstallCmdOpti
 	}
 
 	addInstallFlags(cmd, cmd.Flags(), client, valueOpts)
+	addHookLogsFlags(cmd.Flags(), cfg)
 	bindOutputFlag(cmd, &outfmt)
 	bindPostRenderFlag(cmd, &client.PostRenderer)
 
@@ -233,6 +234,11 @@ func addInstallFlags(cmd *cobra.Command, f *pflag.FlagSet, client *action.Instal
 
 	f.BoolVar(&client.CleanupOnFail, "cleanup-on-fail", false, "allow deletion of new resources created in this installation when install fails")
 	f.StringVar(&client.DeployReportPath, "deploy-report-path", "", "save deploy report in JSON to the specified path")
+	f.BoolVar(&client.Resume, "resume", false, "if set, continue an interrupted install of the same manifests from the last recorded hook or stage")
+	f.BoolVar(&client.RollbackStagesOnFail, "rollback-stages-on-fail", false, "if set, restore resources of all applied stages to their previously deployed state when the install fails")
+	f.IntVar(&client.ApplyConcurrency, "apply-concurrency", 0, "number of resources of the same kind applied at once during install, 0 applies them one by one")
+	f.BoolVar(&client.ServerSideApply, "server-side", false, "if set, apply resources with server-side apply instead of client-side patches")
+	f.BoolVar(&client.ForceConflicts, "force-conflicts", false, "if set, server-side apply takes ownership of fields managed by other field managers instead of failing")
 
 	err := cmd.RegisterFlagCompletionFunc("version", func(cmd *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
 		requiredArgs := 2
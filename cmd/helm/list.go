@@ -77,7 +77,7 @@ func newListCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
 			}
 			client.SetStateMask()
 
-			results, err := client.Run()
+			results, err := client.RunSummaries()
 			if err != nil {
 				return err
 			}
@@ -235,7 +235,7 @@ func compListReleases(toComplete string, ignoredReleaseNames []string, cfg *acti
 	// client.Filter = fmt.Sprintf("^%s", toComplete)
 
 	client.SetStateMask()
-	releases, err := client.Run()
+	releases, err := client.RunSummaries()
 	if err != nil {
 		return nil, cobra.ShellCompDirectiveDefault
 	}
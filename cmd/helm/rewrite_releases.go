@@ -0,0 +1,61 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm_v3
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/werf/3p-helm-for-werf-helm/cmd/helm/require"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/action"
+)
+
+const rewriteReleasesDesc = `
+This command stores all releases in the namespace again, so they are encoded
+with the current storage settings. The labels and timestamps of the stored
+releases are kept.
+
+Run it after changing the compression with $HELM_DRIVER_CODEC or the primary
+encryption key with $HELM_DRIVER_ENCRYPTION_KEYS, $HELM_DRIVER_ENCRYPTION_KEYFILE
+or $HELM_DRIVER_ENCRYPTION_COMMAND. Keep the old encryption keys until the
+command completes, as they are needed to read the releases.
+`
+
+func newRewriteReleasesCmd(cfg *action.Configuration, out io.Writer) *cobra.Command {
+	client := action.NewRewriteReleases(cfg)
+
+	cmd := &cobra.Command{
+		Use:               "rewrite-releases",
+		Short:             "store the releases again with the current storage settings",
+		Long:              rewriteReleasesDesc,
+		Args:              require.NoArgs,
+		ValidArgsFunction: noCompletions,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			rewritten, err := client.Run()
+			if err != nil {
+				return err
+			}
+
+			fmt.Fprintf(out, "Rewrote %d release(s)\n", rewritten)
+			return nil
+		},
+	}
+
+	return cmd
+}
@@ -0,0 +1,45 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package helm_v3
+
+import (
+	"testing"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
+)
+
+func TestRewriteReleasesCmd(t *testing.T) {
+	tests := []cmdTestCase{{
+		name:   "rewrite releases",
+		cmd:    "rewrite-releases",
+		golden: "output/rewrite-releases.txt",
+		rels: []*release.Release{
+			release.Mock(&release.MockReleaseOptions{Name: "thomas-guide", Version: 1}),
+			release.Mock(&release.MockReleaseOptions{Name: "thomas-guide", Version: 2}),
+		},
+	}, {
+		name:      "rewrite releases doesn't take args",
+		cmd:       "rewrite-releases thomas-guide",
+		golden:    "output/rewrite-releases-args.txt",
+		wantError: true,
+	}}
+	runTestCmd(t, tests)
+}
+
+func TestRewriteReleasesFileCompletion(t *testing.T) {
+	checkFileCompletion(t, "rewrite-releases", false)
+}
@@ -52,6 +52,10 @@ Environment variables:
 | $HELM_DATA_HOME                    | set an alternative location for storing Helm data.                                                         |
 | $HELM_DEBUG                        | indicate whether or not Helm is running in Debug mode                                                      |
 | $HELM_DRIVER                       | set the backend storage driver. Values are: configmap, secret, memory, sql.                                |
+| $HELM_DRIVER_CODEC                 | set the compression of the stored releases. Values are: gzip, zstd, none.                                  |
+| $HELM_DRIVER_ENCRYPTION_COMMAND    | set the command printing the keys encrypting the stored releases.                                          |
+| $HELM_DRIVER_ENCRYPTION_KEYFILE    | set the file with the keys encrypting the stored releases, one "<id>:<base64 key>" per line.               |
+| $HELM_DRIVER_ENCRYPTION_KEYS       | set the comma separated "<id>:<base64 key>" keys encrypting the stored releases.                           |
 | $HELM_DRIVER_SQL_CONNECTION_STRING | set the connection string the SQL storage driver should use.                                               |
 | $HELM_MAX_HISTORY                  | set the maximum number of helm release history.                                                            |
 | $HELM_NAMESPACE                    | set the namespace used for the helm operations.                                                            |
@@ -173,11 +177,13 @@ func newRootCmd(actionConfig *action.Configuration, out io.Writer, args []string
 		newVerifyCmd(out),
 
 		// release commands
+		newDriftCmd(actionConfig, out),
 		newGetCmd(actionConfig, out),
 		newHistoryCmd(actionConfig, out),
 		newInstallCmd(actionConfig, out),
 		newListCmd(actionConfig, out),
 		newReleaseTestCmd(actionConfig, out),
+		newRewriteReleasesCmd(actionConfig, out),
 		newRollbackCmd(actionConfig, out),
 		newStatusCmd(actionConfig, out),
 		newTemplateCmd(actionConfig, out),
@@ -46,6 +46,7 @@ The status consists of:
 - description of the release (can be completion message or error message, need to enable --show-desc)
 - list of resources that this release consists of (need to enable --show-resources)
 - details on last test suite run, if applicable
+- logs of hooks captured during the last run, if any
 - additional notes provided by the chart
 `
 
@@ -188,6 +189,16 @@ func (s statusPrinter) WriteTable(out io.Writer) error {
 		}
 	}
 
+	var hookLogs []string
+	for _, h := range s.release.Hooks {
+		for _, l := range h.LastRun.Logs {
+			hookLogs = append(hookLogs, fmt.Sprintf("HOOK: %s (%s)\n%s", h.Name, h.LastRun.Phase, l))
+		}
+	}
+	if len(hookLogs) > 0 {
+		_, _ = fmt.Fprintf(out, "HOOK LOGS:\n%s\n", strings.Join(hookLogs, "\n"))
+	}
+
 	if s.debug {
 		_, _ = fmt.Fprintln(out, "USER-SUPPLIED VALUES:")
 		err := output.EncodeYAML(out, s.release.Config)
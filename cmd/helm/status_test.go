@@ -126,6 +126,29 @@ func TestStatusCmd(t *testing.T) {
 				},
 			},
 		),
+	}, {
+		name:   "get status of a failed release with hook logs",
+		cmd:    "status flummoxed-chickadee",
+		golden: "output/status-with-hook-logs.txt",
+		rels: releasesMockWithStatus(
+			&release.Info{
+				Status: release.StatusFailed,
+			},
+			&release.Hook{
+				Name:   "failing-migration",
+				Events: []release.HookEvent{release.HookPreUpgrade},
+				LastRun: release.HookExecution{
+					StartedAt:   mustParseTime("2006-01-02T15:00:05Z"),
+					CompletedAt: mustParseTime("2006-01-02T15:00:07Z"),
+					Phase:       release.HookPhaseFailed,
+					Logs: []release.HookContainerLogs{{
+						Pod:       "failing-migration-x7k2p",
+						Container: "migrate",
+						Logs:      "applying migration 42\nerror: relation \"users\" already exists\n",
+					}},
+				},
+			},
+		),
 	}}
 	runTestCmd(t, tests)
 }
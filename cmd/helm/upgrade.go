@@ -164,6 +164,12 @@ func NewUpgradeCmd(cfg *action.Configuration, out io.Writer, opts UpgradeCmdOpti
 				histClient := action.NewHistory(cfg)
 				histClient.Max = 1
 				if _, err := histClient.Run(args[0]); err == driver.ErrReleaseNotFound {
+					// The diff needs a deployed release to compare with, and
+					// installing would change the cluster.
+					if client.ServerDryRunDiff {
+						return fmt.Errorf("release %q does not exist, --server-dry-run-diff only works for upgrades of existing releases", args[0])
+					}
+
 					// Only print this to stdout for table output
 					if outfmt == output.Table {
 						fmt.Fprintf(out, "Release %q does not exist. Installing it now.\n", args[0])
@@ -192,6 +198,11 @@ func NewUpgradeCmd(cfg *action.Configuration, out io.Writer, opts UpgradeCmdOpti
 
 					instClient.CleanupOnFail = client.CleanupOnFail
 					instClient.DeployReportPath = client.DeployReportPath
+					instClient.Resume = client.Resume
+					instClient.RollbackStagesOnFail = client.RollbackStagesOnFail
+					instClient.ApplyConcurrency = client.ApplyConcurrency
+					instClient.ServerSideApply = client.ServerSideApply
+					instClient.ForceConflicts = client.ForceConflicts
 
 					rel, err := runInstall(args, instClient, valueOpts, out)
 					if err != nil {
@@ -292,6 +303,10 @@ func NewUpgradeCmd(cfg *action.Configuration, out io.Writer, opts UpgradeCmdOpti
 				return errors.Wrap(errs.FormatTemplatingError(err), "UPGRADE FAILED")
 			}
 
+			if client.ServerDryRunDiff {
+				return outfmt.Write(out, upgradeDiffPrinter{client.Diff})
+			}
+
 			if outfmt == output.Table {
 				fmt.Fprintf(out, "Release %q has been upgraded. Happy Helming!\n", args[0])
 			}
@@ -327,8 +342,15 @@ func NewUpgradeCmd(cfg *action.Configuration, out io.Writer, opts UpgradeCmdOpti
 	f.BoolVar(&client.DependencyUpdate, "dependency-update", false, "update dependencies if they are missing before installing the chart")
 	f.BoolVar(&client.EnableDNS, "enable-dns", false, "enable DNS lookups when rendering templates")
 	f.StringVar(&client.DeployReportPath, "deploy-report-path", "", "save deploy report in JSON to the specified path")
+	f.BoolVar(&client.Resume, "resume", false, "if set, continue an interrupted upgrade of the same manifests from the last recorded hook or stage")
+	f.BoolVar(&client.RollbackStagesOnFail, "rollback-stages-on-fail", false, "if set, restore resources of all applied stages to their previously deployed state when the upgrade fails")
+	f.IntVar(&client.ApplyConcurrency, "apply-concurrency", 0, "number of resources of the same kind applied at once during upgrade, 0 applies them one by one")
+	f.BoolVar(&client.ServerSideApply, "server-side", false, "if set, apply resources with server-side apply instead of client-side patches")
+	f.BoolVar(&client.ForceConflicts, "force-conflicts", false, "if set, server-side apply takes ownership of fields managed by other field managers instead of failing")
+	f.BoolVar(&client.ServerDryRunDiff, "server-dry-run-diff", false, "if set, send the resources of every stage to the cluster as server-side dry-run requests and show what the upgrade would change, without changing anything")
 	addChartPathOptionsFlags(f, &client.ChartPathOptions)
 	addValueOptionsFlags(f, valueOpts)
+	addHookLogsFlags(f, cfg)
 	bindOutputFlag(cmd, &outfmt)
 	bindPostRenderFlag(cmd, &client.PostRenderer)
 
@@ -366,3 +388,54 @@ type UpgradeCmdOptions struct {
 	DeployReportPath            *string
 	StagesExternalDepsGenerator phases.ExternalDepsGenerator
 }
+
+type upgradeDiffPrinter struct {
+	diff *action.UpgradeDiff
+}
+
+func (p upgradeDiffPrinter) WriteJSON(out io.Writer) error {
+	return output.EncodeJSON(out, p.diff)
+}
+
+func (p upgradeDiffPrinter) WriteYAML(out io.Writer) error {
+	return output.EncodeYAML(out, p.diff)
+}
+
+func (p upgradeDiffPrinter) WriteTable(out io.Writer) error {
+	for i, stage := range p.diff.Stages {
+		if len(stage.Created) == 0 && len(stage.Updated) == 0 && len(stage.Adopted) == 0 && len(stage.Unverified) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(out, "STAGE %d (weight %d):\n", i, stage.Weight)
+		for _, res := range stage.Created {
+			fmt.Fprintf(out, "  create %s\n", res)
+		}
+		for _, resDiff := range stage.Updated {
+			fmt.Fprintf(out, "  update %s\n", resDiff.Resource)
+			printResourceDiffFields(out, resDiff)
+		}
+		for _, resDiff := range stage.Adopted {
+			fmt.Fprintf(out, "  adopt %s\n", resDiff.Resource)
+			printResourceDiffFields(out, resDiff)
+		}
+		for _, res := range stage.Unverified {
+			fmt.Fprintf(out, "  unverified %s (something it needs does not exist yet)\n", res)
+		}
+	}
+
+	if len(p.diff.Orphaned) > 0 {
+		fmt.Fprintln(out, "ORPHANED:")
+		for _, res := range p.diff.Orphaned {
+			fmt.Fprintf(out, "  delete %s\n", res)
+		}
+	}
+
+	return nil
+}
+
+func printResourceDiffFields(out io.Writer, resDiff *action.ResourceDiff) {
+	for _, field := range resDiff.Fields {
+		fmt.Fprintf(out, "      %s: %s -> %s\n", field.Path, formatDriftValue(field.Live), formatDriftValue(field.Expected))
+	}
+}
@@ -126,6 +126,12 @@ func TestUpgradeCmd(t *testing.T) {
 			golden: "output/upgrade-with-install-timeout.txt",
 			rels:   []*release.Release{relMock("crazy-bunny", 1, ch)},
 		},
+		{
+			name:      "install a release with 'upgrade --install --server-dry-run-diff'",
+			cmd:       fmt.Sprintf("upgrade zany-bunny -i --server-dry-run-diff '%s'", chartPath),
+			golden:    "output/upgrade-with-install-server-dry-run-diff.txt",
+			wantError: true,
+		},
 		{
 			name:   "upgrade a release with wait",
 			cmd:    fmt.Sprintf("upgrade crazy-bunny --wait '%s'", chartPath),
@@ -18,6 +18,7 @@ package action
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"path"
@@ -35,6 +36,7 @@ import (
 	"github.com/werf/3p-helm-for-werf-helm/pkg/chart"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/chartutil"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/engine"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/events"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/postrender"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/registry"
@@ -95,6 +97,61 @@ type Configuration struct {
 	Capabilities *chartutil.Capabilities
 
 	Log func(string, ...interface{})
+
+	// Events receives typed rollout progress events. The kube client and the
+	// storage created by Init emit their events here too.
+	Events events.Recorder
+
+	// HookLogsTailLines is the number of last lines of the hook container logs
+	// saved in the release when a hook fails. Zero means 100 lines, a negative
+	// value disables capturing.
+	HookLogsTailLines int64
+	// CaptureSucceededHookLogs makes the logs of succeeded hooks saved too.
+	CaptureSucceededHookLogs bool
+
+	// ReleaseLock makes Install, Upgrade, Rollback and Uninstall lock the
+	// release in storage for the whole operation, so concurrent operations on
+	// the same release fail or wait. The operation is cancelled if the lock is
+	// lost. Locking fails with storage drivers which don't support it, like
+	// SQL. Nil disables locking.
+	ReleaseLock *storage.LockOptions
+}
+
+// heldLockContextKey is the context key of storage.ReleaseLock.HeldContext in
+// the context returned by lockRelease.
+type heldLockContextKey struct{}
+
+// lockRelease locks the release if release locking is enabled. The returned
+// context is cancelled once the lock is lost and the returned function unlocks
+// the release.
+func (cfg *Configuration) lockRelease(ctx context.Context, name string) (context.Context, func(), error) {
+	if cfg.ReleaseLock == nil {
+		return ctx, func() {}, nil
+	}
+
+	lock, err := cfg.Releases.Lock(ctx, name, *cfg.ReleaseLock)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return context.WithValue(lock.Context(), heldLockContextKey{}, lock.HeldContext()), func() {
+		if err := lock.Unlock(); err != nil {
+			cfg.Log("warning: %s", err)
+		}
+	}, nil
+}
+
+// cleanupContext returns the context for the cleanup after a failed
+// operation, like the atomic rollback or uninstall. Unlike ctx, it is not
+// cancelled when the operation is, so the cleanup runs after a timeout or an
+// interrupt as well, but it is cancelled once the release lock taken by
+// lockRelease is lost.
+func cleanupContext(ctx context.Context) context.Context {
+	if held, ok := ctx.Value(heldLockContextKey{}).(context.Context); ok {
+		return held
+	}
+
+	return context.WithoutCancel(ctx)
 }
 
 // renderResources renders the templates in a chart
@@ -365,25 +422,42 @@ func (cfg *Configuration) recordRelease(r *release.Release) {
 	}
 }
 
+// recordEvent passes the event to cfg.Events, if it is set. The kube client
+// and the release storage record their events with it.
+func (cfg *Configuration) recordEvent(event events.Event) {
+	events.Record(cfg.Events, event)
+}
+
 // Init initializes the action configuration
 func (cfg *Configuration) Init(getter genericclioptions.RESTClientGetter, namespace, helmDriver string, log DebugLog) error {
 	kc := kube.New(getter)
 	kc.Log = log
+	kc.Events = events.RecorderFunc(cfg.recordEvent)
 
 	lazyClient := &lazyClient{
 		namespace: namespace,
 		clientFn:  kc.Factory.KubernetesClientSet,
 	}
 
+	codec, err := driver.CodecByName(os.Getenv("HELM_DRIVER_CODEC"))
+	if err != nil {
+		return err
+	}
+	if keys := encryptionKeyProvider(); keys != nil {
+		codec = &driver.EncryptedCodec{Codec: codec, Keys: keys}
+	}
+
 	var store *storage.Storage
 	switch helmDriver {
 	case "secret", "secrets", "":
 		d := driver.NewSecrets(newSecretClient(lazyClient))
 		d.Log = log
+		d.Codec = codec
 		store = storage.Init(d)
 	case "configmap", "configmaps":
 		d := driver.NewConfigMaps(newConfigMapClient(lazyClient))
 		d.Log = log
+		d.Codec = codec
 		store = storage.Init(d)
 	case "memory":
 		var d *driver.Memory
@@ -409,12 +483,15 @@ func (cfg *Configuration) Init(getter genericclioptions.RESTClientGetter, namesp
 		if err != nil {
 			panic(fmt.Sprintf("Unable to instantiate SQL driver: %v", err))
 		}
+		d.Codec = codec
 		store = storage.Init(d)
 	default:
 		// Not sure what to do here.
 		panic("Unknown driver in HELM_DRIVER: " + helmDriver)
 	}
 
+	store.Events = events.RecorderFunc(cfg.recordEvent)
+
 	cfg.RESTClientGetter = getter
 	cfg.KubeClient = kc
 	cfg.Releases = store
@@ -423,6 +500,22 @@ func (cfg *Configuration) Init(getter genericclioptions.RESTClientGetter, namesp
 	return nil
 }
 
+// encryptionKeyProvider returns the release encryption keys configured in the
+// environment or nil if the releases are not encrypted.
+func encryptionKeyProvider() driver.KeyProvider {
+	if os.Getenv("HELM_DRIVER_ENCRYPTION_KEYS") != "" {
+		return driver.NewEnvKeyProvider("HELM_DRIVER_ENCRYPTION_KEYS")
+	}
+	if path := os.Getenv("HELM_DRIVER_ENCRYPTION_KEYFILE"); path != "" {
+		return driver.NewFileKeyProvider(path)
+	}
+	if command := strings.Fields(os.Getenv("HELM_DRIVER_ENCRYPTION_COMMAND")); len(command) > 0 {
+		return driver.NewCommandKeyProvider(command[0], command[1:]...)
+	}
+
+	return nil
+}
+
 func (cfg *Configuration) RenderResources(ch *chart.Chart, values chartutil.Values, releaseName, outputDir string, subNotes, useReleaseName, includeCrds bool, pr postrender.PostRenderer, interactWithRemote, enableDNS bool) ([]*release.Hook, *bytes.Buffer, string, error) {
 	return cfg.renderResources(ch, values, releaseName, outputDir, subNotes, useReleaseName, includeCrds, pr, interactWithRemote, enableDNS)
 }
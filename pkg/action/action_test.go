@@ -16,6 +16,7 @@ limitations under the License.
 package action
 
 import (
+	"context"
 	"flag"
 	"io"
 	"testing"
@@ -281,3 +282,31 @@ func TestGetVersionSet(t *testing.T) {
 		t.Error("Non-existent version is reported found.")
 	}
 }
+
+func TestCleanupContext(t *testing.T) {
+	cfg := actionConfigFixture(t)
+	cfg.ReleaseLock = &storage.LockOptions{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lockCtx, unlock, err := cfg.lockRelease(ctx, "cleaned-up")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cancel()
+	if lockCtx.Err() == nil {
+		t.Error("Expected the lock context to be cancelled with the operation")
+	}
+	if err := cleanupContext(lockCtx).Err(); err != nil {
+		t.Errorf("Expected the cleanup context not to be cancelled with the operation, got %v", err)
+	}
+
+	unlock()
+	if cleanupContext(lockCtx).Err() == nil {
+		t.Error("Expected the cleanup context to be cancelled once the release is unlocked")
+	}
+
+	if err := cleanupContext(ctx).Err(); err != nil {
+		t.Errorf("Expected the cleanup context without a lock not to be cancelled with the operation, got %v", err)
+	}
+}
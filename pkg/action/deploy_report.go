@@ -0,0 +1,92 @@
+/*
+Copyright The Helm Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"os"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/phasemanagers"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
+	helmtime "github.com/werf/3p-helm-for-werf-helm/pkg/time"
+)
+
+// writeDeployReport saves the deploy report of the release to the path in
+// JSON. The stages and the deleted orphans are taken from the rollout phase
+// manager, if the deploy got to the rollout phase. Failures are only logged,
+// so the report never changes the outcome of the deploy.
+func (cfg *Configuration) writeDeployReport(path string, rel *release.Release, rolloutPhaseManager *phasemanagers.RolloutPhaseManager, deployErr error) {
+	report := release.NewDeployReport().FromRelease(rel)
+
+	if rolloutPhaseManager != nil {
+		for _, stage := range rolloutPhaseManager.Phase.SortedStages {
+			report.Stages = append(report.Stages, newDeployReportStage(stage))
+		}
+
+		report.DeletedOrphans = resourceNames(rolloutPhaseManager.DeletedOrphanedResources())
+	}
+
+	if deployErr != nil {
+		report.Errors = append(report.Errors, deployErr.Error())
+	}
+
+	deployReportData, err := report.ToJSONData()
+	if err != nil {
+		cfg.Log("warning: error creating deploy report data: %s", err)
+		return
+	}
+
+	if err := os.WriteFile(path, deployReportData, 0o644); err != nil {
+		cfg.Log("warning: error writing deploy report file: %s", err)
+		return
+	}
+}
+
+func newDeployReportStage(stage *stages.Stage) *release.DeployReportStage {
+	reportStage := &release.DeployReportStage{
+		Weight:      stage.Weight,
+		StartedAt:   helmtime.Time{Time: stage.StartedAt},
+		CompletedAt: helmtime.Time{Time: stage.CompletedAt},
+	}
+
+	if stage.Result != nil {
+		reportStage.Created = resourceNames(stage.Result.Created)
+		reportStage.Updated = resourceNames(stage.Result.Updated)
+		reportStage.Deleted = resourceNames(stage.Result.Deleted)
+
+		for _, resErr := range stage.Result.Errors {
+			reportStage.Errors = append(reportStage.Errors, resErr.Error())
+		}
+	}
+
+	// Errors of single resources are more precise than the stage error, which
+	// usually wraps one of them.
+	if stage.Err != nil && len(reportStage.Errors) == 0 {
+		reportStage.Errors = append(reportStage.Errors, stage.Err.Error())
+	}
+
+	return reportStage
+}
+
+func resourceNames(resources kube.ResourceList) []string {
+	var names []string
+	for _, res := range resources {
+		names = append(names, kube.ResourceNameNamespaceKind(res))
+	}
+
+	return names
+}
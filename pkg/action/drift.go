@@ -0,0 +1,309 @@
+/*
+Copyright The Helm Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/chartutil"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/releaseutil"
+)
+
+// driftIgnoredMetadataFields are set by the API server and never drift from
+// the manifests in a meaningful way.
+var driftIgnoredMetadataFields = map[string]bool{
+	"creationTimestamp":          true,
+	"deletionGracePeriodSeconds": true,
+	"deletionTimestamp":          true,
+	"generation":                 true,
+	"managedFields":              true,
+	"resourceVersion":            true,
+	"selfLink":                   true,
+	"uid":                        true,
+}
+
+var driftPlainFieldRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// Drift is the action for detecting the differences between the resources of
+// a release and their live state in the cluster.
+//
+// It provides the implementation of 'helm drift'.
+type Drift struct {
+	cfg *Configuration
+
+	StagesSplitter phases.Splitter
+}
+
+// ResourceDrift describes the differences between a resource in the release
+// manifests and in the cluster.
+type ResourceDrift struct {
+	Resource string `json:"resource"`
+	// Missing is true if the resource doesn't exist in the cluster.
+	Missing bool         `json:"missing,omitempty"`
+	Fields  []FieldDrift `json:"fields,omitempty"`
+}
+
+// FieldDrift is a single field which value in the cluster differs from the
+// one in the release manifests.
+type FieldDrift struct {
+	Path     string      `json:"path"`
+	Expected interface{} `json:"expected"`
+	Live     interface{} `json:"live"`
+}
+
+// NewDrift creates a new Drift object with the given configuration.
+func NewDrift(cfg *Configuration, stagesSplitter phases.Splitter) *Drift {
+	if stagesSplitter == nil {
+		stagesSplitter = &phases.SingleStageSplitter{}
+	}
+
+	return &Drift{
+		cfg:            cfg,
+		StagesSplitter: stagesSplitter,
+	}
+}
+
+// Run compares the deployed resources of the release with the cluster and
+// returns the drifted ones. Fields which are absent in the manifests, such as
+// the ones defaulted by the server, and the status are not compared.
+func (d *Drift) Run(name string) ([]*ResourceDrift, error) {
+	if err := d.cfg.KubeClient.IsReachable(); err != nil {
+		return nil, err
+	}
+
+	if err := chartutil.ValidateReleaseName(name); err != nil {
+		return nil, fmt.Errorf("release name is invalid: %s", name)
+	}
+
+	kubeClient, ok := d.cfg.KubeClient.(kube.InterfaceLive)
+	if !ok {
+		return nil, errors.New("unable to get kubeClient with interface InterfaceLive")
+	}
+
+	history, err := d.cfg.Releases.HistorySummaries(name)
+	if err != nil {
+		return nil, fmt.Errorf("error getting history for release %q: %w", name, err)
+	}
+	releaseutil.SortByRevision(history)
+
+	deployedResources, err := phases.NewDeployedResourcesCalculator(history, d.StagesSplitter, d.cfg.KubeClient).Calculate()
+	if err != nil {
+		return nil, fmt.Errorf("error calculating deployed resources: %w", err)
+	}
+
+	liveResources, err := kubeClient.GetLive(deployedResources)
+	if err != nil {
+		return nil, fmt.Errorf("error getting live resources: %w", err)
+	}
+
+	var drifts []*ResourceDrift
+	for _, info := range deployedResources {
+		resDrift := &ResourceDrift{Resource: kube.ResourceNameNamespaceKind(info)}
+
+		liveInfo := liveResources.Get(info)
+		if liveInfo == nil {
+			resDrift.Missing = true
+			drifts = append(drifts, resDrift)
+			continue
+		}
+
+		expected, err := runtime.DefaultUnstructuredConverter.ToUnstructured(info.Object)
+		if err != nil {
+			return nil, fmt.Errorf("error converting %q to unstructured: %w", resDrift.Resource, err)
+		}
+
+		live, err := runtime.DefaultUnstructuredConverter.ToUnstructured(liveInfo.Object)
+		if err != nil {
+			return nil, fmt.Errorf("error converting live %q to unstructured: %w", resDrift.Resource, err)
+		}
+
+		resDrift.Fields = diffObjects(expected, live, false)
+		if len(resDrift.Fields) > 0 {
+			drifts = append(drifts, resDrift)
+		}
+	}
+
+	return drifts, nil
+}
+
+// diffObjects compares the fields of the expected object with the live one,
+// ignoring the status and the metadata fields set by the server. If
+// includeLiveOnly is false, the fields absent in the expected object are not
+// compared, so the ones added by the server or controllers are ignored.
+func diffObjects(expected, live map[string]interface{}, includeLiveOnly bool) []FieldDrift {
+	expected = copyWithoutKeys(expected, map[string]bool{"status": true})
+	live = copyWithoutKeys(live, map[string]bool{"status": true})
+
+	if expectedMeta, ok := expected["metadata"].(map[string]interface{}); ok {
+		expected["metadata"] = copyWithoutKeys(expectedMeta, driftIgnoredMetadataFields)
+	}
+	if liveMeta, ok := live["metadata"].(map[string]interface{}); ok {
+		live["metadata"] = copyWithoutKeys(liveMeta, driftIgnoredMetadataFields)
+	}
+
+	return diffValues("", expected, live, includeLiveOnly)
+}
+
+func diffValues(path string, expected, live interface{}, includeLiveOnly bool) []FieldDrift {
+	if expected == nil {
+		if includeLiveOnly && live != nil {
+			return []FieldDrift{{Path: path, Expected: expected, Live: live}}
+		}
+		return nil
+	}
+
+	switch expectedValue := expected.(type) {
+	case map[string]interface{}:
+		liveValue, ok := live.(map[string]interface{})
+		if !ok {
+			return []FieldDrift{{Path: path, Expected: expected, Live: live}}
+		}
+
+		keys := sortedKeys(expectedValue)
+		if includeLiveOnly {
+			for _, key := range sortedKeys(liveValue) {
+				if _, found := expectedValue[key]; !found {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+		}
+
+		var drifts []FieldDrift
+		for _, key := range keys {
+			drifts = append(drifts, diffValues(fieldPath(path, key), expectedValue[key], liveValue[key], includeLiveOnly)...)
+		}
+
+		return drifts
+	case []interface{}:
+		liveValue, ok := live.([]interface{})
+		if !ok || len(liveValue) != len(expectedValue) {
+			return []FieldDrift{{Path: path, Expected: expected, Live: live}}
+		}
+
+		var drifts []FieldDrift
+		for i := range expectedValue {
+			drifts = append(drifts, diffValues(fmt.Sprintf("%s[%d]", path, i), expectedValue[i], liveValue[i], includeLiveOnly)...)
+		}
+
+		return drifts
+	default:
+		if scalarsEqual(expected, live) {
+			return nil
+		}
+
+		return []FieldDrift{{Path: path, Expected: expected, Live: live}}
+	}
+}
+
+func scalarsEqual(a, b interface{}) bool {
+	aNum, aIsNum := toFloat64(a)
+	bNum, bIsNum := toFloat64(b)
+	if aIsNum && bIsNum {
+		return aNum == bNum
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return true
+	}
+
+	// The server stores quantities in the canonical form, e.g. 0.5 as "500m".
+	_, aIsString := a.(string)
+	_, bIsString := b.(string)
+	if aIsString || bIsString {
+		aQuantity, aIsQuantity := toQuantity(a)
+		bQuantity, bIsQuantity := toQuantity(b)
+		if aIsQuantity && bIsQuantity {
+			return aQuantity.Cmp(bQuantity) == 0
+		}
+	}
+
+	return false
+}
+
+func toQuantity(v interface{}) (resource.Quantity, bool) {
+	s, ok := v.(string)
+	if !ok {
+		n, isNum := toFloat64(v)
+		if !isNum {
+			return resource.Quantity{}, false
+		}
+		s = strconv.FormatFloat(n, 'f', -1, 64)
+	}
+
+	quantity, err := resource.ParseQuantity(s)
+	if err != nil {
+		return resource.Quantity{}, false
+	}
+
+	return quantity, true
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	default:
+		return 0, false
+	}
+}
+
+func fieldPath(parent, key string) string {
+	if driftPlainFieldRegexp.MatchString(key) {
+		return parent + "." + key
+	}
+
+	return fmt.Sprintf("%s[%q]", parent, key)
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func copyWithoutKeys(m map[string]interface{}, ignored map[string]bool) map[string]interface{} {
+	result := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		if !ignored[key] {
+			result[key] = value
+		}
+	}
+
+	return result
+}
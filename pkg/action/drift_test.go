@@ -0,0 +1,250 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"io"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/cli-runtime/pkg/resource"
+	"sigs.k8s.io/yaml"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	kubefake "github.com/werf/3p-helm-for-werf-helm/pkg/kube/fake"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/releaseutil"
+)
+
+func TestDiffObjects(t *testing.T) {
+	is := assert.New(t)
+
+	expected := map[string]interface{}{
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{
+			"name": "web",
+			"annotations": map[string]interface{}{
+				"werf.io/weight": "10",
+			},
+		},
+		"spec": map[string]interface{}{
+			"replicas": int64(2),
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app", "image": "app:v1"},
+					},
+				},
+			},
+		},
+	}
+
+	live := map[string]interface{}{
+		"kind": "Deployment",
+		"metadata": map[string]interface{}{
+			"name":            "web",
+			"resourceVersion": "42",
+			"annotations": map[string]interface{}{
+				"werf.io/weight":                    "10",
+				"deployment.kubernetes.io/revision": "3",
+			},
+		},
+		"spec": map[string]interface{}{
+			"replicas":                float64(2),
+			"progressDeadlineSeconds": int64(600),
+			"template": map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []interface{}{
+						map[string]interface{}{"name": "app", "image": "app:edited", "imagePullPolicy": "IfNotPresent"},
+					},
+				},
+			},
+		},
+		"status": map[string]interface{}{
+			"replicas": int64(1),
+		},
+	}
+
+	is.Equal([]FieldDrift{
+		{Path: ".spec.template.spec.containers[0].image", Expected: "app:v1", Live: "app:edited"},
+	}, diffObjects(expected, live, false))
+
+	live["metadata"].(map[string]interface{})["annotations"] = map[string]interface{}{}
+	is.Equal([]FieldDrift{
+		{Path: `.metadata.annotations["werf.io/weight"]`, Expected: "10", Live: nil},
+		{Path: ".spec.template.spec.containers[0].image", Expected: "app:v1", Live: "app:edited"},
+	}, diffObjects(expected, live, false))
+
+	live["metadata"].(map[string]interface{})["annotations"] = map[string]interface{}{
+		"werf.io/weight":                    "10",
+		"deployment.kubernetes.io/revision": "3",
+	}
+	is.Equal([]FieldDrift{
+		{Path: ".metadata.annotations[\"deployment.kubernetes.io/revision\"]", Expected: nil, Live: "3"},
+		{Path: ".spec.progressDeadlineSeconds", Expected: nil, Live: int64(600)},
+		{Path: ".spec.template.spec.containers[0].image", Expected: "app:v1", Live: "app:edited"},
+		{Path: ".spec.template.spec.containers[0].imagePullPolicy", Expected: nil, Live: "IfNotPresent"},
+	}, diffObjects(expected, live, true))
+}
+
+func TestDiffObjectsQuantities(t *testing.T) {
+	is := assert.New(t)
+
+	expected := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"cpu":    0.5,
+			"memory": "1Gi",
+			"name":   "1",
+		},
+	}
+	live := map[string]interface{}{
+		"spec": map[string]interface{}{
+			"cpu":    "500m",
+			"memory": "1024Mi",
+			"name":   "2",
+		},
+	}
+
+	is.Equal([]FieldDrift{
+		{Path: ".spec.name", Expected: "1", Live: "2"},
+	}, diffObjects(expected, live, false))
+}
+
+// driftKubeClient builds the resources from the manifests and returns the live
+// objects it keeps by name.
+type driftKubeClient struct {
+	kubefake.FailingKubeClient
+	live map[string]map[string]interface{}
+}
+
+func (c *driftKubeClient) Build(reader io.Reader, _ bool) (kube.ResourceList, error) {
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests := releaseutil.SplitManifests(string(data))
+	keys := make([]string, 0, len(manifests))
+	for key := range manifests {
+		keys = append(keys, key)
+	}
+	sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+	var resources kube.ResourceList
+	for _, key := range keys {
+		obj := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal([]byte(manifests[key]), &obj.Object); err != nil {
+			return nil, err
+		}
+
+		resources = append(resources, &resource.Info{
+			Name:      obj.GetName(),
+			Namespace: obj.GetNamespace(),
+			Mapping:   &meta.RESTMapping{GroupVersionKind: obj.GroupVersionKind()},
+			Object:    obj,
+		})
+	}
+
+	return resources, nil
+}
+
+func (c *driftKubeClient) GetLive(resources kube.ResourceList) (kube.ResourceList, error) {
+	var live kube.ResourceList
+	for _, info := range resources {
+		if obj, found := c.live[info.Name]; found {
+			liveInfo := *info
+			liveInfo.Object = &unstructured.Unstructured{Object: obj}
+			live = append(live, &liveInfo)
+		}
+	}
+
+	return live, nil
+}
+
+func TestDriftRun(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	config := actionConfigFixture(t)
+	config.KubeClient = &driftKubeClient{
+		live: map[string]map[string]interface{}{
+			"web": {
+				"apiVersion": "apps/v1",
+				"kind":       "Deployment",
+				"metadata":   map[string]interface{}{"name": "web", "resourceVersion": "42"},
+				"spec": map[string]interface{}{
+					"replicas": int64(2),
+					"template": map[string]interface{}{
+						"spec": map[string]interface{}{
+							"containers": []interface{}{
+								map[string]interface{}{
+									"name":      "app",
+									"image":     "app:edited",
+									"resources": map[string]interface{}{"requests": map[string]interface{}{"cpu": "500m"}},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	rel := releaseStub()
+	rel.Name = "drifted"
+	rel.Info.Status = release.StatusDeployed
+	rel.Manifest = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: app
+        image: app:v1
+        resources:
+          requests:
+            cpu: 0.5
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+`
+	req.NoError(config.Releases.Create(rel))
+
+	drifts, err := NewDrift(config, nil).Run(rel.Name)
+	req.NoError(err)
+	is.Equal([]*ResourceDrift{
+		{
+			Resource: ":Deployment/web",
+			Fields: []FieldDrift{
+				{Path: ".spec.template.spec.containers[0].image", Expected: "app:v1", Live: "app:edited"},
+			},
+		},
+		{
+			Resource: ":ConfigMap/settings",
+			Missing:  true,
+		},
+	}, drifts)
+}
@@ -56,3 +56,19 @@ func (h *History) Run(name string) ([]*release.Release, error) {
 	h.cfg.Log("getting history for release %s", name)
 	return h.cfg.Releases.History(name)
 }
+
+// RunSummaries executes 'helm history' like Run, but the returned revisions
+// are summaries which keep only the release info, the chart metadata and the
+// manifest. Use Get to load a whole revision.
+func (h *History) RunSummaries(name string) ([]*release.Release, error) {
+	if err := h.cfg.KubeClient.IsReachable(); err != nil {
+		return nil, err
+	}
+
+	if err := chartutil.ValidateReleaseName(name); err != nil {
+		return nil, errors.Errorf("release name is invalid: %s", name)
+	}
+
+	h.cfg.Log("getting history summaries for release %s", name)
+	return h.cfg.Releases.HistorySummaries(name)
+}
@@ -17,23 +17,60 @@ package action
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/werf/3p-helm-for-werf-helm/pkg/events"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/phasemanagers"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
 	helmtime "github.com/werf/3p-helm-for-werf-helm/pkg/time"
 )
 
-// execHook executes all of the hooks for the given hook event.
-func (cfg *Configuration) execHook(rl *release.Release, hook release.HookEvent, timeout time.Duration) error {
+const defaultHookLogsTailLines = 100
+
+// execHook executes all of the hooks for the given hook event. Once ctx is
+// done, no more hooks are created and the running ones are not waited for.
+func (cfg *Configuration) execHook(ctx context.Context, rl *release.Release, hook release.HookEvent, timeout time.Duration) error {
+	return cfg.execHookFrom(ctx, rl, hook, timeout, 0)
+}
+
+// execHookFrom executes the hooks for the given hook event, skipping the ones
+// sorted before firstHookIndex. Used to resume interrupted releases.
+func (cfg *Configuration) execHookFrom(ctx context.Context, rl *release.Release, hook release.HookEvent, timeout time.Duration, firstHookIndex int) error {
+	executingHooks := []*release.Hook{}
+
+	for _, h := range rl.Hooks {
+		for _, e := range h.Events {
+			if e == hook {
+				executingHooks = append(executingHooks, h)
+			}
+		}
+	}
+
+	return cfg.execHooks(ctx, executingHooks, hook, timeout, firstHookIndex, func(groupStart int) error {
+		return cfg.Releases.Update(release.SetHookPhaseStageInfo(rl, groupStart, hook))
+	})
+}
+
+// execStageHooks executes the hooks for the given stage hook event which are
+// bound to the rollout stage with the given weight. The record function is
+// called before every group of hooks is started and has to save the release.
+func (cfg *Configuration) execStageHooks(ctx context.Context, rl *release.Release, hook release.HookEvent, stageWeight int, timeout time.Duration, record func() error) error {
 	executingHooks := []*release.Hook{}
 
 	for _, h := range rl.Hooks {
+		if h.StageWeight != stageWeight {
+			continue
+		}
+
 		for _, e := range h.Events {
 			if e == hook {
 				executingHooks = append(executingHooks, h)
@@ -41,10 +78,70 @@ func (cfg *Configuration) execHook(rl *release.Release, hook release.HookEvent,
 		}
 	}
 
+	return cfg.execHooks(ctx, executingHooks, hook, timeout, 0, func(_ int) error {
+		return record()
+	})
+}
+
+// stageHooksFunc returns the function executing the stage hooks of the
+// release for the rollout phase manager, or nil if hooks are disabled.
+func (cfg *Configuration) stageHooksFunc(ctx context.Context, rl *release.Release, disableHooks bool, timeout time.Duration) phasemanagers.StageHooksFunc {
+	if disableHooks {
+		return nil
+	}
+
+	return func(hook release.HookEvent, stage *stages.Stage, record func() error) error {
+		return cfg.execStageHooks(ctx, rl, hook, stage.Weight, timeout, record)
+	}
+}
+
+// execHooks executes the hooks sorted by weight, skipping the ones sorted
+// before firstHookIndex. Before every group of hooks is started, record is
+// called with the index of the first hook of the group.
+func (cfg *Configuration) execHooks(ctx context.Context, executingHooks []*release.Hook, hook release.HookEvent, timeout time.Duration, firstHookIndex int, record func(groupStart int) error) error {
 	// hooke are pre-ordered by kind, so keep order stable
 	sort.Stable(hookByWeight(executingHooks))
 
+	// Hooks with the same weight are executed concurrently. Groups of them are
+	// executed one after another in the order of their weights.
+	for groupStart := 0; groupStart < len(executingHooks); {
+		groupEnd := groupStart + 1
+		for groupEnd < len(executingHooks) && executingHooks[groupEnd].Weight == executingHooks[groupStart].Weight {
+			groupEnd++
+		}
+
+		if first := max(groupStart, firstHookIndex); first < groupEnd {
+			if err := cfg.execHookGroup(ctx, hook, executingHooks[first:groupEnd], timeout, func() error {
+				return record(first)
+			}); err != nil {
+				return err
+			}
+		}
+
+		groupStart = groupEnd
+	}
+
+	// If all hooks are successful, check the annotation of each hook to determine whether the hook should be deleted
+	// under succeeded condition. If so, then clear the corresponding resource object in each hook
 	for i, h := range executingHooks {
+		if i < firstHookIndex {
+			continue
+		}
+
+		if err := cfg.deleteHookByPolicy(h, release.HookSucceeded, timeout); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// execHookGroup executes hooks concurrently, each one tracked on its own, and
+// waits for all of them to finish. The hooks are recorded as started before
+// any of them is created, so resuming restarts the whole group.
+func (cfg *Configuration) execHookGroup(ctx context.Context, hook release.HookEvent, hooks []*release.Hook, timeout time.Duration, record func() error) error {
+	hooksResources := make([]kube.ResourceList, len(hooks))
+	for i, h := range hooks {
 		// Set default delete policy to before-hook-creation
 		if h.DeletePolicies == nil || len(h.DeletePolicies) == 0 {
 			// TODO(jlegrone): Only apply before-hook-creation delete policy to run to completion
@@ -62,57 +159,177 @@ func (cfg *Configuration) execHook(rl *release.Release, hook release.HookEvent,
 		if err != nil {
 			return errors.Wrapf(err, "unable to build kubernetes object for %s hook %s", hook, h.Path)
 		}
+		hooksResources[i] = resources
 
 		// Record the time at which the hook was applied to the cluster
 		h.LastRun = release.HookExecution{
 			StartedAt: helmtime.Now(),
 			Phase:     release.HookPhaseRunning,
 		}
+	}
+
+	if err := record(); err != nil {
+		return fmt.Errorf("error recording release: %w", err)
+	}
 
-		if err := cfg.Releases.Update(release.SetHookPhaseStageInfo(rl, i, hook)); err != nil {
-			return fmt.Errorf("error recording release: %w", err)
+	errs := make([]error, len(hooks))
+	var wg sync.WaitGroup
+	for i, h := range hooks {
+		wg.Add(1)
+		go func(i int, h *release.Hook) {
+			defer wg.Done()
+			errs[i] = cfg.runHookWithRetries(ctx, h, hook, hooksResources[i], timeout)
+		}(i, h)
+	}
+	wg.Wait()
+
+	var failed []error
+	for _, err := range errs {
+		if err != nil {
+			failed = append(failed, err)
 		}
+	}
 
-		// As long as the implementation of WatchUntilReady does not panic, HookPhaseFailed or HookPhaseSucceeded
-		// should always be set by this function. If we fail to do that for any reason, then HookPhaseUnknown is
-		// the most appropriate value to surface.
-		h.LastRun.Phase = release.HookPhaseUnknown
+	switch len(failed) {
+	case 0:
+		return nil
+	case 1:
+		return failed[0]
+	default:
+		return fmt.Errorf("%d %s hooks failed: %s", len(failed), hook, joinErrors(failed))
+	}
+}
 
-		// Create hook resources
-		if _, err := cfg.KubeClient.Create(resources, kube.CreateOptions{}); err != nil {
-			h.LastRun.CompletedAt = helmtime.Now()
-			h.LastRun.Phase = release.HookPhaseFailed
-			return errors.Wrapf(err, "warning: Hook %s %s failed", hook, h.Path)
+// runHookWithRetries runs the hook and, if it fails, runs it again as many
+// times as the hook retries annotation allows. Before every retry the hook
+// resources are deleted and created anew, the same way as with the
+// before-hook-creation delete policy.
+func (cfg *Configuration) runHookWithRetries(ctx context.Context, h *release.Hook, hook release.HookEvent, resources kube.ResourceList, timeout time.Duration) error {
+	if h.Timeout > 0 {
+		timeout = h.Timeout
+	}
+
+	backoff := h.RetryBackoff
+	for retry := 1; ; retry++ {
+		err := cfg.runHook(ctx, h, hook, resources, timeout)
+		if err == nil || retry > h.Retries || ctx.Err() != nil {
+			return err
 		}
 
-		// Watch hook resources until they have completed
-		err = cfg.KubeClient.WatchUntilReady(resources, timeout)
-		// Note the time of success/failure
-		h.LastRun.CompletedAt = helmtime.Now()
-		// Mark hook as succeeded or failed
-		if err != nil {
-			h.LastRun.Phase = release.HookPhaseFailed
-			// If a hook is failed, check the annotation of the hook to determine whether the hook should be deleted
-			// under failed condition. If so, then clear the corresponding resource object in the hook
-			if err := cfg.deleteHookByPolicy(h, release.HookFailed, timeout); err != nil {
-				return err
-			}
+		cfg.Log("hook %s failed, retry %d of %d in %s: %s", h.Path, retry, h.Retries, backoff, err)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
 			return err
 		}
-		h.LastRun.Phase = release.HookPhaseSucceeded
+		backoff *= 2
+
+		if err := cfg.deleteHook(h, timeout); err != nil {
+			return errors.Wrapf(err, "unable to delete %s hook %s before retry", hook, h.Path)
+		}
+
+		resources, err = cfg.KubeClient.Build(bytes.NewBufferString(h.Manifest), true)
+		if err != nil {
+			return errors.Wrapf(err, "unable to build kubernetes object for %s hook %s", hook, h.Path)
+		}
+
+		h.LastRun.Retries = retry
+		h.LastRun.Phase = release.HookPhaseRunning
+		h.LastRun.CompletedAt = helmtime.Time{}
+		h.LastRun.Logs = nil
 	}
+}
 
-	// If all hooks are successful, check the annotation of each hook to determine whether the hook should be deleted
-	// under succeeded condition. If so, then clear the corresponding resource object in each hook
-	for _, h := range executingHooks {
-		if err := cfg.deleteHookByPolicy(h, release.HookSucceeded, timeout); err != nil {
+// runHook creates the hook resources and watches them until they are ready,
+// recording the outcome in the hook's LastRun.
+func (cfg *Configuration) runHook(ctx context.Context, h *release.Hook, hook release.HookEvent, resources kube.ResourceList, timeout time.Duration) error {
+	cfg.recordEvent(events.NewHookStarted(h.Path, hook.String()))
+
+	// As long as the implementation of WatchUntilReady does not panic, HookPhaseFailed or HookPhaseSucceeded
+	// should always be set by this function. If we fail to do that for any reason, then HookPhaseUnknown is
+	// the most appropriate value to surface.
+	h.LastRun.Phase = release.HookPhaseUnknown
+
+	// Create hook resources
+	kubeClient := kube.AsInterfaceContext(cfg.KubeClient)
+	if _, err := kubeClient.CreateWithContext(ctx, resources, kube.CreateOptions{}); err != nil {
+		h.LastRun.CompletedAt = helmtime.Now()
+		h.LastRun.Phase = release.HookPhaseFailed
+		cfg.recordEvent(events.NewHookFailed(h.Path, hook.String(), err))
+		return errors.Wrapf(err, "warning: Hook %s %s failed", hook, h.Path)
+	}
+
+	// Watch hook resources until they have completed
+	err := kubeClient.WatchUntilReadyWithContext(ctx, resources, timeout)
+	// Note the time of success/failure
+	h.LastRun.CompletedAt = helmtime.Now()
+	// Capture the logs before the hook pods are deleted by the delete policies
+	if err != nil || cfg.CaptureSucceededHookLogs {
+		h.LastRun.Logs = cfg.captureHookLogs(h, resources)
+	}
+	// Mark hook as succeeded or failed
+	if err != nil {
+		h.LastRun.Phase = release.HookPhaseFailed
+		cfg.recordEvent(events.NewHookFailed(h.Path, hook.String(), err))
+		err = withHookLogs(err, h.LastRun.Logs)
+		// If a hook is failed, check the annotation of the hook to determine whether the hook should be deleted
+		// under failed condition. If so, then clear the corresponding resource object in the hook
+		if err := cfg.deleteHookByPolicy(h, release.HookFailed, timeout); err != nil {
 			return err
 		}
+		return err
 	}
+	h.LastRun.Phase = release.HookPhaseSucceeded
 
 	return nil
 }
 
+// captureHookLogs returns the tails of the container logs of the hook pods.
+// Failures are only logged, since the logs are informational.
+func (cfg *Configuration) captureHookLogs(h *release.Hook, resources kube.ResourceList) []release.HookContainerLogs {
+	tailLines := cfg.HookLogsTailLines
+	if tailLines < 0 {
+		return nil
+	} else if tailLines == 0 {
+		tailLines = defaultHookLogsTailLines
+	}
+
+	kubeClient, ok := cfg.KubeClient.(kube.InterfaceLogs)
+	if !ok {
+		return nil
+	}
+
+	containersLogs, err := kubeClient.GetContainerLogs(resources, tailLines)
+	if err != nil {
+		cfg.Log("warning: unable to capture logs of hook %s: %s", h.Path, err)
+	}
+
+	var logs []release.HookContainerLogs
+	for _, containerLogs := range containersLogs {
+		logs = append(logs, release.HookContainerLogs{
+			Pod:       containerLogs.Pod,
+			Container: containerLogs.Container,
+			Logs:      containerLogs.Logs,
+		})
+	}
+
+	return logs
+}
+
+// withHookLogs appends the hook logs to the error message.
+func withHookLogs(err error, logs []release.HookContainerLogs) error {
+	if len(logs) == 0 {
+		return err
+	}
+
+	formatted := make([]string, 0, len(logs))
+	for _, l := range logs {
+		formatted = append(formatted, l.String())
+	}
+
+	return fmt.Errorf("%w\n%s", err, strings.Join(formatted, "\n"))
+}
+
 // hookByWeight is a sorter for hooks
 type hookByWeight []*release.Hook
 
@@ -127,26 +344,33 @@ func (x hookByWeight) Less(i, j int) bool {
 
 // deleteHookByPolicy deletes a hook if the hook policy instructs it to
 func (cfg *Configuration) deleteHookByPolicy(h *release.Hook, policy release.HookDeletePolicy, timeout time.Duration) error {
+	if hookHasDeletePolicy(h, policy) {
+		return cfg.deleteHook(h, timeout)
+	}
+	return nil
+}
+
+// deleteHook deletes the hook resources and waits until they are gone.
+func (cfg *Configuration) deleteHook(h *release.Hook, timeout time.Duration) error {
 	// Never delete CustomResourceDefinitions; this could cause lots of
 	// cascading garbage collection.
 	if h.Kind == "CustomResourceDefinition" {
 		return nil
 	}
-	if hookHasDeletePolicy(h, policy) {
-		resources, err := cfg.KubeClient.Build(bytes.NewBufferString(h.Manifest), false)
-		if err != nil {
-			return errors.Wrapf(err, "unable to build kubernetes object for deleting hook %s", h.Path)
-		}
-		_, errs := cfg.KubeClient.Delete(resources, kube.DeleteOptions{Wait: true})
-		if len(errs) > 0 {
-			return errors.New(joinErrors(errs))
-		}
 
-		// wait for resources until they are deleted to avoid conflicts
-		if kubeClient, ok := cfg.KubeClient.(kube.InterfaceExt); ok {
-			if err := kubeClient.WaitForDelete(resources, timeout); err != nil {
-				return err
-			}
+	resources, err := cfg.KubeClient.Build(bytes.NewBufferString(h.Manifest), false)
+	if err != nil {
+		return errors.Wrapf(err, "unable to build kubernetes object for deleting hook %s", h.Path)
+	}
+	_, errs := cfg.KubeClient.Delete(resources, kube.DeleteOptions{Wait: true})
+	if len(errs) > 0 {
+		return errors.New(joinErrors(errs))
+	}
+
+	// wait for resources until they are deleted to avoid conflicts
+	if kubeClient, ok := cfg.KubeClient.(kube.InterfaceExt); ok {
+		if err := kubeClient.WaitForDelete(resources, timeout); err != nil {
+			return err
 		}
 	}
 	return nil
@@ -0,0 +1,253 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	kubefake "github.com/werf/3p-helm-for-werf-helm/pkg/kube/fake"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
+)
+
+// blockingWatchKubeClient blocks WatchUntilReady until unblock is closed.
+type blockingWatchKubeClient struct {
+	*kubefake.FailingKubeClient
+	started chan struct{}
+	unblock chan struct{}
+}
+
+func (c *blockingWatchKubeClient) WatchUntilReady(resources kube.ResourceList, timeout time.Duration) error {
+	c.started <- struct{}{}
+	<-c.unblock
+
+	return c.FailingKubeClient.WatchUntilReady(resources, timeout)
+}
+
+func hooksReleaseStub(weights ...int) *release.Release {
+	rel := releaseStub()
+	rel.Hooks = nil
+	for i, weight := range weights {
+		rel.Hooks = append(rel.Hooks, &release.Hook{
+			Name:     fmt.Sprintf("hook-%d", i),
+			Kind:     "Job",
+			Path:     fmt.Sprintf("templates/hook-%d", i),
+			Manifest: manifestWithHook,
+			Events:   []release.HookEvent{release.HookPreInstall},
+			Weight:   weight,
+		})
+	}
+
+	return rel
+}
+
+func TestExecHook_EqualWeightsConcurrently(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	cfg := actionConfigFixture(t)
+	kubeClient := &blockingWatchKubeClient{
+		FailingKubeClient: cfg.KubeClient.(*kubefake.FailingKubeClient),
+		started:           make(chan struct{}, 3),
+		unblock:           make(chan struct{}),
+	}
+	cfg.KubeClient = kubeClient
+
+	rel := hooksReleaseStub(0, 0, 1)
+	req.NoError(cfg.Releases.Create(rel))
+
+	done := make(chan error)
+	go func() {
+		done <- cfg.execHook(context.Background(), rel, release.HookPreInstall, time.Minute)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-kubeClient.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("hooks with equal weight are not executed concurrently")
+		}
+	}
+
+	select {
+	case <-kubeClient.started:
+		t.Fatal("hook with a greater weight started before the previous ones completed")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	close(kubeClient.unblock)
+	req.NoError(<-done)
+	is.Len(kubeClient.started, 1)
+
+	for _, h := range rel.Hooks {
+		is.Equal(release.HookPhaseSucceeded, h.LastRun.Phase, h.Name)
+		is.False(h.LastRun.CompletedAt.IsZero(), h.Name)
+	}
+}
+
+func TestExecHook_EqualWeightsFailures(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	cfg := actionConfigFixture(t)
+	cfg.KubeClient.(*kubefake.FailingKubeClient).WatchUntilReadyError = fmt.Errorf("failed watch")
+
+	rel := hooksReleaseStub(0, 0, 1)
+	req.NoError(cfg.Releases.Create(rel))
+
+	err := cfg.execHook(context.Background(), rel, release.HookPreInstall, time.Minute)
+	req.Error(err)
+	is.Contains(err.Error(), "2 pre-install hooks failed")
+
+	is.Equal(release.HookPhaseFailed, rel.Hooks[0].LastRun.Phase)
+	is.Equal(release.HookPhaseFailed, rel.Hooks[1].LastRun.Phase)
+	is.True(rel.Hooks[2].LastRun.StartedAt.IsZero())
+}
+
+// logsKubeClient returns the same logs for every resource.
+type logsKubeClient struct {
+	*kubefake.FailingKubeClient
+}
+
+func (c *logsKubeClient) GetContainerLogs(_ kube.ResourceList, _ int64) ([]kube.ContainerLogs, error) {
+	return []kube.ContainerLogs{{Pod: "hook-pod", Container: "main", Logs: "migration failed\n"}}, nil
+}
+
+func TestExecHook_CaptureLogs(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	cfg := actionConfigFixture(t)
+	kubeClient := &logsKubeClient{FailingKubeClient: cfg.KubeClient.(*kubefake.FailingKubeClient)}
+	cfg.KubeClient = kubeClient
+
+	rel := hooksReleaseStub(0)
+	req.NoError(cfg.Releases.Create(rel))
+
+	req.NoError(cfg.execHook(context.Background(), rel, release.HookPreInstall, time.Minute))
+	is.Empty(rel.Hooks[0].LastRun.Logs, "logs of succeeded hooks are captured only on request")
+
+	cfg.CaptureSucceededHookLogs = true
+	req.NoError(cfg.execHook(context.Background(), rel, release.HookPreInstall, time.Minute))
+	is.Len(rel.Hooks[0].LastRun.Logs, 1)
+
+	cfg.CaptureSucceededHookLogs = false
+	kubeClient.WatchUntilReadyError = fmt.Errorf("job failed")
+	err := cfg.execHook(context.Background(), rel, release.HookPreInstall, time.Minute)
+	req.Error(err)
+	is.Contains(err.Error(), "job failed")
+	is.Contains(err.Error(), "hook-pod/main logs:\nmigration failed")
+	is.Equal([]release.HookContainerLogs{{Pod: "hook-pod", Container: "main", Logs: "migration failed\n"}}, rel.Hooks[0].LastRun.Logs)
+}
+
+// flakyWatchKubeClient fails WatchUntilReady the given number of times.
+type flakyWatchKubeClient struct {
+	*kubefake.FailingKubeClient
+	failures int
+	timeouts []time.Duration
+}
+
+func (c *flakyWatchKubeClient) WatchUntilReady(resources kube.ResourceList, timeout time.Duration) error {
+	c.timeouts = append(c.timeouts, timeout)
+	if len(c.timeouts) <= c.failures {
+		return fmt.Errorf("transient failure %d", len(c.timeouts))
+	}
+
+	return c.FailingKubeClient.WatchUntilReady(resources, timeout)
+}
+
+func TestExecHook_Retries(t *testing.T) {
+	for _, tt := range []struct {
+		name          string
+		retries       int
+		failures      int
+		expectErr     bool
+		expectRetries int
+	}{
+		{name: "succeeds after retries", retries: 2, failures: 2, expectRetries: 2},
+		{name: "fails when out of retries", retries: 1, failures: 2, expectErr: true, expectRetries: 1},
+		{name: "no retries by default", failures: 1, expectErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			is := assert.New(t)
+			req := require.New(t)
+
+			cfg := actionConfigFixture(t)
+			kubeClient := &flakyWatchKubeClient{
+				FailingKubeClient: cfg.KubeClient.(*kubefake.FailingKubeClient),
+				failures:          tt.failures,
+			}
+			cfg.KubeClient = kubeClient
+
+			rel := hooksReleaseStub(0)
+			rel.Hooks[0].Retries = tt.retries
+			rel.Hooks[0].RetryBackoff = time.Millisecond
+			rel.Hooks[0].Timeout = 42 * time.Second
+			req.NoError(cfg.Releases.Create(rel))
+
+			err := cfg.execHook(context.Background(), rel, release.HookPreInstall, time.Minute)
+			if tt.expectErr {
+				req.Error(err)
+				is.Equal(release.HookPhaseFailed, rel.Hooks[0].LastRun.Phase)
+			} else {
+				req.NoError(err)
+				is.Equal(release.HookPhaseSucceeded, rel.Hooks[0].LastRun.Phase)
+			}
+
+			is.Equal(tt.expectRetries, rel.Hooks[0].LastRun.Retries)
+			is.Len(kubeClient.timeouts, tt.expectRetries+1)
+			for _, timeout := range kubeClient.timeouts {
+				is.Equal(42*time.Second, timeout, "hook timeout overrides the action timeout")
+			}
+		})
+	}
+}
+
+func TestExecHook_CancelledContext(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	cfg := actionConfigFixture(t)
+	kubeClient := &flakyWatchKubeClient{
+		FailingKubeClient: cfg.KubeClient.(*kubefake.FailingKubeClient),
+		failures:          1,
+	}
+	cfg.KubeClient = kubeClient
+
+	rel := hooksReleaseStub(0)
+	rel.Hooks[0].Retries = 3
+	rel.Hooks[0].RetryBackoff = time.Hour
+	req.NoError(cfg.Releases.Create(rel))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	err := cfg.execHook(ctx, rel, release.HookPreInstall, time.Minute)
+	req.Error(err)
+	is.Equal(release.HookPhaseFailed, rel.Hooks[0].LastRun.Phase)
+	is.Len(kubeClient.timeouts, 1, "no retries once the context is done")
+
+	err = cfg.execHook(ctx, rel, release.HookPreInstall, time.Minute)
+	req.ErrorIs(err, context.Canceled)
+	is.Len(kubeClient.timeouts, 1, "hooks are not started once the context is done")
+}
@@ -117,6 +117,23 @@ type Install struct {
 	StagesSplitter              phases.Splitter
 	StagesExternalDepsGenerator phases.ExternalDepsGenerator
 	DeployReportPath            string
+	// Resume continues an install that was interrupted while pending, skipping
+	// the hooks and stages it has already deployed.
+	// It fails if the rendered manifests differ from the interrupted ones.
+	Resume bool
+	// RollbackStagesOnFail restores resources of all applied stages to their
+	// previously deployed state if the rollout fails.
+	RollbackStagesOnFail bool
+	// ApplyConcurrency is the number of resources of the same kind in a stage
+	// applied at once. Zero applies them one by one.
+	ApplyConcurrency int
+	// ServerSideApply makes stage resources to be applied with server-side
+	// apply. ForceConflicts makes it take over fields owned by other managers.
+	ServerSideApply bool
+	ForceConflicts  bool
+
+	resumePoint         *resumePoint
+	rolloutPhaseManager *phasemanagers.RolloutPhaseManager
 }
 
 // ChartPathOptions captures common options used for controlling chart paths
@@ -240,9 +257,11 @@ func (i *Install) Run(chrt *chart.Chart, vals map[string]interface{}) (*release.
 
 // Run executes the installation with Context
 //
-// When the task is cancelled through ctx, the function returns and the install
+// When the task is cancelled through ctx, API calls of a kube client
+// implementing kube.InterfaceContext are stopped and the release is recorded as
+// failed. With other kube clients the function returns and the install
 // proceeds in the background.
-func (i *Install) RunWithContext(ctx context.Context, chrt *chart.Chart, vals map[string]interface{}) (*release.Release, error) {
+func (i *Install) RunWithContext(ctx context.Context, chrt *chart.Chart, vals map[string]interface{}) (_ *release.Release, err error) {
 	// Check reachability of cluster unless in client-only mode (e.g. `helm template` without `--validate`)
 	if !i.ClientOnly {
 		if err := i.cfg.KubeClient.IsReachable(); err != nil {
@@ -250,6 +269,19 @@ func (i *Install) RunWithContext(ctx context.Context, chrt *chart.Chart, vals ma
 		}
 	}
 
+	if !i.ClientOnly && !i.isDryRun() {
+		if err := chartutil.ValidateReleaseName(i.ReleaseName); err != nil {
+			return nil, errors.Wrapf(err, "release name %q", i.ReleaseName)
+		}
+
+		var unlock func()
+		ctx, unlock, err = i.cfg.lockRelease(ctx, i.ReleaseName)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
 	if err := i.availableName(); err != nil {
 		return nil, err
 	}
@@ -322,16 +354,7 @@ func (i *Install) RunWithContext(ctx context.Context, chrt *chart.Chart, vals ma
 
 	if !i.isDryRun() && i.DeployReportPath != "" {
 		defer func() {
-			deployReportData, err := release.NewDeployReport().FromRelease(rel).ToJSONData()
-			if err != nil {
-				i.cfg.Log("warning: error creating deploy report data: %s", err)
-				return
-			}
-
-			if err := os.WriteFile(i.DeployReportPath, deployReportData, 0o644); err != nil {
-				i.cfg.Log("warning: error writing deploy report file: %s", err)
-				return
-			}
+			i.cfg.writeDeployReport(i.DeployReportPath, rel, i.rolloutPhaseManager, err)
 		}()
 	}
 
@@ -410,16 +433,37 @@ func (i *Install) RunWithContext(ctx context.Context, chrt *chart.Chart, vals ma
 		}
 	}
 
+	i.resumePoint = nil
+	i.rolloutPhaseManager = nil
+	if i.Resume {
+		resumedRel, point, err := i.cfg.resumableRelease(rel)
+		if err != nil && !errors.Is(err, errNothingToResume) {
+			return nil, errors.Wrapf(err, "unable to resume install of %q", rel.Name)
+		}
+
+		if err == nil {
+			i.cfg.Log("resuming install of %q revision %d from %s", resumedRel.Name, resumedRel.Version, point)
+			resumedRel.Info.LastDeployed = rel.Info.LastDeployed
+			rel = resumedRel
+			i.resumePoint = point
+		}
+	}
+
 	// If Replace is true, we need to supercede the last release.
-	if i.Replace {
+	if i.Replace && i.resumePoint == nil {
 		if err := i.replaceRelease(rel); err != nil {
 			return nil, err
 		}
 	}
 
-	// Store the release in history before continuing (new in Helm 3). We always know
-	// that this is a create operation.
-	if err := i.cfg.Releases.Create(rel); err != nil {
+	if i.resumePoint != nil {
+		if err := i.cfg.Releases.Update(rel); err != nil {
+			return rel, err
+		}
+	} else if err := i.cfg.Releases.Create(rel); err != nil {
+		// Store the release in history before continuing (new in Helm 3). We always know
+		// that this is a create operation.
+		//
 		// We could try to recover gracefully here, but since nothing has been installed
 		// yet, this is probably safer than trying to continue when we know storage is
 		// not working.
@@ -429,7 +473,7 @@ func (i *Install) RunWithContext(ctx context.Context, chrt *chart.Chart, vals ma
 	var createdToCleanup kube.ResourceList
 	rel, createdToCleanup, err = i.performInstallCtx(ctx, rel, toBeAdopted, resources)
 	if err != nil {
-		rel, err = i.failRelease(rel, createdToCleanup, err)
+		rel, err = i.failRelease(ctx, rel, createdToCleanup, err)
 	}
 	return rel, err
 }
@@ -444,12 +488,20 @@ func (i *Install) performInstallCtx(ctx context.Context, rel *release.Release, t
 	resultChan := make(chan Msg, 1)
 
 	go func() {
-		rel, createdToCleanup, err := i.performInstall(rel, toBeAdopted, resources)
+		rel, createdToCleanup, err := i.performInstall(ctx, rel, toBeAdopted, resources)
 		resultChan <- Msg{rel, err, createdToCleanup}
 	}()
+
+	// The install stops on its own once ctx is done, so wait for it to get the
+	// resources created so far cleaned up.
+	if _, ok := i.cfg.KubeClient.(kube.InterfaceContext); ok {
+		msg := <-resultChan
+		return msg.r, msg.createdToCleanup, msg.e
+	}
+
 	select {
 	case <-ctx.Done():
-		err := ctx.Err()
+		err := context.Cause(ctx)
 		return rel, nil, err
 	case msg := <-resultChan:
 		return msg.r, msg.createdToCleanup, msg.e
@@ -464,11 +516,18 @@ func (i *Install) isDryRun() bool {
 	return false
 }
 
-func (i *Install) performInstall(rel *release.Release, toBeAdopted kube.ResourceList, resources kube.ResourceList) (*release.Release, kube.ResourceList, error) {
+func (i *Install) performInstall(ctx context.Context, rel *release.Release, toBeAdopted kube.ResourceList, resources kube.ResourceList) (*release.Release, kube.ResourceList, error) {
 	var err error
+	kubeClient := kube.AsInterfaceContext(i.cfg.KubeClient)
+
+	resume := i.resumePoint
+	if resume == nil {
+		resume = &resumePoint{}
+	}
+
 	// pre-install hooks
 	if !i.DisableHooks {
-		if err := i.cfg.execHook(rel, release.HookPreInstall, i.Timeout); err != nil {
+		if err := i.cfg.execHookFrom(ctx, rel, release.HookPreInstall, i.Timeout, resume.preHookIndex); err != nil {
 			return rel, nil, fmt.Errorf("failed pre-install: %s", err)
 		}
 	}
@@ -490,39 +549,64 @@ func (i *Install) performInstall(rel *release.Release, toBeAdopted kube.Resource
 
 	deployedResourcesCalculator := phases.NewDeployedResourcesCalculator(history, i.StagesSplitter, i.cfg.KubeClient)
 
+	var resumedDeployedResources kube.ResourceList
+	if i.resumePoint != nil {
+		resumedDeployedResources = rolloutPhase.DeployedResources()
+	}
+
 	rolloutPhaseManager, err := phasemanagers.NewRolloutPhaseManager(rolloutPhase, deployedResourcesCalculator, rel, i.cfg.Releases, i.cfg.KubeClient).
 		AddPreviouslyDeployedResources(toBeAdopted).
+		AddResumedResources(resumedDeployedResources).
+		SkipStagesBefore(resume.stageIndex).
+		WithEvents(i.cfg.Events).
+		WithStageHooks(i.cfg.stageHooksFunc(ctx, rel, i.DisableHooks, i.Timeout)).
+		WithContext(ctx).
 		AddCalculatedPreviouslyDeployedResources()
 	if err != nil {
 		return rel, nil, fmt.Errorf("error calculating previously deployed resources for rollout phase manager: %w", err)
 	}
+	i.rolloutPhaseManager = rolloutPhaseManager
 
-	if err := rolloutPhaseManager.DoStage(
+	doStages := rolloutPhaseManager.DoStage
+	if rolloutPhase.SortedStages.IsDependencyGraph() {
+		doStages = rolloutPhaseManager.DoStagesConcurrently
+	}
+
+	if err := doStages(
 		func(stgIndex int, stage *stages.Stage) error {
 			if len(stage.ExternalDependencies) == 0 || !i.Wait {
 				return nil
 			}
 
 			if i.WaitForJobs {
-				return i.cfg.KubeClient.WaitWithJobs(stage.ExternalDependencies.AsResourceList(), i.Timeout)
+				return kubeClient.WaitWithJobsWithContext(ctx, stage.ExternalDependencies.AsResourceList(), i.Timeout)
 			} else {
-				return i.cfg.KubeClient.Wait(stage.ExternalDependencies.AsResourceList(), i.Timeout)
+				return kubeClient.WaitWithContext(ctx, stage.ExternalDependencies.AsResourceList(), i.Timeout)
 			}
 		},
 		func(stgIndex int, stage *stages.Stage, prevDeployedStgResources kube.ResourceList) error {
+			var err error
+
 			// At this point, we can do the install. Note that before we were detecting whether to
 			// do an update, but it's not clear whether we WANT to do an update if the re-use is set
 			// to true, since that is basically an upgrade operation.
 			if len(prevDeployedStgResources) == 0 && len(stage.DesiredResources) > 0 {
-				stage.Result, err = i.cfg.KubeClient.Create(stage.DesiredResources, kube.CreateOptions{})
+				stage.Result, err = kubeClient.CreateWithContext(ctx, stage.DesiredResources, kube.CreateOptions{
+					Concurrency:     i.ApplyConcurrency,
+					ServerSideApply: i.ServerSideApply,
+					ForceConflicts:  i.ForceConflicts,
+				})
 				if err != nil {
 					return err
 				}
 			} else if len(stage.DesiredResources) > 0 {
-				stage.Result, err = i.cfg.KubeClient.Update(prevDeployedStgResources, stage.DesiredResources, i.Force, kube.UpdateOptions{
+				stage.Result, err = kubeClient.UpdateWithContext(ctx, prevDeployedStgResources, stage.DesiredResources, i.Force, kube.UpdateOptions{
 					SkipDeleteIfInvalidOwnership: true,
 					ReleaseName:                  rel.Name,
 					ReleaseNamespace:             rel.Namespace,
+					Concurrency:                  i.ApplyConcurrency,
+					ServerSideApply:              i.ServerSideApply,
+					ForceConflicts:               i.ForceConflicts,
 				})
 				if err != nil {
 					return err
@@ -537,16 +621,21 @@ func (i *Install) performInstall(rel *release.Release, toBeAdopted kube.Resource
 			}
 
 			if i.WaitForJobs {
-				return i.cfg.KubeClient.WaitWithJobs(stage.DesiredResources, i.Timeout)
+				return kubeClient.WaitWithJobsWithContext(ctx, stage.DesiredResources, i.Timeout)
 			} else {
-				return i.cfg.KubeClient.Wait(stage.DesiredResources, i.Timeout)
+				return kubeClient.WaitWithContext(ctx, stage.DesiredResources, i.Timeout)
 			}
 		},
 	); err != nil {
-		createdResourcesToDelete := kube.ResourceList{}
-		var applyErr *phasemanagers.ApplyError
-		if errors.As(err, &applyErr) {
-			createdResourcesToDelete = rolloutPhaseManager.Phase.SortedStages[applyErr.StageIndex].Result.Created
+		createdResourcesToDelete := rolloutPhaseManager.CreatedResourcesOfFailedStages()
+
+		if i.RollbackStagesOnFail {
+			if rollbackErr := i.cfg.rollbackRolloutStages(rolloutPhaseManager, rel, i.Force); rollbackErr != nil {
+				i.cfg.Log("warning: unable to roll back applied stages: %s", rollbackErr)
+			} else {
+				i.cfg.Log("applied stages have been rolled back")
+				createdResourcesToDelete = kube.ResourceList{}
+			}
 		}
 
 		return rel, createdResourcesToDelete, fmt.Errorf("error processing rollout phase stage: %w", err)
@@ -557,7 +646,7 @@ func (i *Install) performInstall(rel *release.Release, toBeAdopted kube.Resource
 	}
 
 	if !i.DisableHooks {
-		if err := i.cfg.execHook(rel, release.HookPostInstall, i.Timeout); err != nil {
+		if err := i.cfg.execHookFrom(ctx, rel, release.HookPostInstall, i.Timeout, resume.postHookIndex); err != nil {
 			return rel, nil, fmt.Errorf("failed post-install: %s", err)
 		}
 	}
@@ -582,7 +671,9 @@ func (i *Install) performInstall(rel *release.Release, toBeAdopted kube.Resource
 	return rel, nil, nil
 }
 
-func (i *Install) failRelease(rel *release.Release, createdToCleanup kube.ResourceList, err error) (*release.Release, error) {
+// failRelease marks the release as failed and cleans up after it. The atomic
+// uninstall runs under the release lock held for ctx, see cleanupContext.
+func (i *Install) failRelease(ctx context.Context, rel *release.Release, createdToCleanup kube.ResourceList, err error) (*release.Release, error) {
 	rel.SetStatus(release.StatusFailed, fmt.Sprintf("Release %q failed: %s", i.ReleaseName, err.Error()))
 
 	if i.CleanupOnFail && len(createdToCleanup) > 0 {
@@ -610,7 +701,8 @@ func (i *Install) failRelease(rel *release.Release, createdToCleanup kube.Resour
 		uninstall.DisableHooks = i.DisableHooks
 		uninstall.KeepHistory = false
 		uninstall.Timeout = i.Timeout
-		if _, uninstallErr := uninstall.Run(i.ReleaseName); uninstallErr != nil {
+		uninstall.releaseLocked = true
+		if _, uninstallErr := uninstall.RunWithContext(cleanupContext(ctx), i.ReleaseName); uninstallErr != nil {
 			return rel, errors.Wrapf(uninstallErr, "an error occurred while uninstalling the release. original install error: %s", err)
 		}
 		return rel, errors.Wrapf(err, "release %s failed, and has been uninstalled due to atomic being set", i.ReleaseName)
@@ -648,6 +740,9 @@ func (i *Install) availableName() error {
 	if st := rel.Info.Status; i.Replace && (st == release.StatusUninstalled || st == release.StatusFailed) {
 		return nil
 	}
+	if st := rel.Info.Status; i.Resume && st == release.StatusPendingInstall {
+		return nil
+	}
 	return errors.New("cannot re-use a name that is still in use")
 }
 
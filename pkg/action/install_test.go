@@ -18,6 +18,7 @@ package action
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
@@ -36,6 +37,7 @@ import (
 	"github.com/werf/3p-helm-for-werf-helm/pkg/chartutil"
 	kubefake "github.com/werf/3p-helm-for-werf-helm/pkg/kube/fake"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/storage"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/storage/driver"
 	helmtime "github.com/werf/3p-helm-for-werf-helm/pkg/time"
 )
@@ -91,6 +93,36 @@ func TestInstallRelease(t *testing.T) {
 	is.Equal(lastRelease.Info.Status, release.StatusDeployed)
 }
 
+func TestInstallRelease_DeployReport(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	instAction := installAction(t)
+	instAction.DeployReportPath = filepath.Join(t.TempDir(), "report.json")
+	_, err := instAction.Run(buildChart(), map[string]interface{}{})
+	req.NoError(err)
+
+	data, err := os.ReadFile(instAction.DeployReportPath)
+	req.NoError(err)
+
+	report := &release.DeployReport{}
+	req.NoError(json.Unmarshal(data, report))
+
+	is.Equal("test-install-release", report.Release)
+	is.Equal(release.StatusDeployed, report.Status)
+	is.Empty(report.Errors)
+
+	req.Len(report.Stages, 1)
+	is.Equal(0, report.Stages[0].Weight)
+	is.Empty(report.Stages[0].Errors)
+	is.False(report.Stages[0].StartedAt.IsZero())
+	is.False(report.Stages[0].CompletedAt.Before(report.Stages[0].StartedAt))
+
+	req.Len(report.Hooks, 1)
+	is.Equal("hello/templates/hooks", report.Hooks[0].Path)
+	is.Equal(release.HookPhaseSucceeded, report.Hooks[0].Execution.Phase)
+}
+
 func TestInstallReleaseWithValues(t *testing.T) {
 	is := assert.New(t)
 	instAction := installAction(t)
@@ -464,6 +496,31 @@ func TestInstallRelease_Atomic(t *testing.T) {
 		is.Contains(err.Error(), "an error occurred while uninstalling the release")
 	})
 }
+func TestInstallRelease_AtomicLocked(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	instAction := installAction(t)
+	instAction.cfg.ReleaseLock = &storage.LockOptions{}
+	instAction.ReleaseName = "come-fail-away-locked"
+	// The memory driver keeps the locks in the namespace of the last stored
+	// release, so lock in the release namespace from the start.
+	instAction.cfg.Releases.Driver.(*driver.Memory).SetNamespace(instAction.Namespace)
+	failer := instAction.cfg.KubeClient.(*kubefake.FailingKubeClient)
+	failer.WaitError = fmt.Errorf("I timed out")
+	instAction.cfg.KubeClient = failer
+	instAction.Atomic = true
+	instAction.DisableHooks = true
+
+	_, err := instAction.Run(buildChart(), map[string]interface{}{})
+	req.Error(err)
+	is.NotErrorIs(err, driver.ErrReleaseLocked, "the uninstall runs under the lock of the install")
+	is.Contains(err.Error(), "has been uninstalled")
+
+	_, err = instAction.cfg.Releases.Lock(context.Background(), instAction.ReleaseName, storage.LockOptions{Holder: "ci-2"})
+	req.NoError(err, "the lock is released after the install")
+}
+
 func TestInstallRelease_Atomic_Interrupted(t *testing.T) {
 
 	is := assert.New(t)
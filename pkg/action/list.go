@@ -146,6 +146,17 @@ func NewList(cfg *Configuration) *List {
 
 // Run executes the list command, returning a set of matches.
 func (l *List) Run() ([]*release.Release, error) {
+	return l.run(l.cfg.Releases.List)
+}
+
+// RunSummaries executes the list command like Run, but the matches are
+// release summaries which keep only the release info, the chart metadata and
+// the manifest.
+func (l *List) RunSummaries() ([]*release.Release, error) {
+	return l.run(l.cfg.Releases.ListSummaries)
+}
+
+func (l *List) run(list func(func(*release.Release) bool) ([]*release.Release, error)) ([]*release.Release, error) {
 	if err := l.cfg.KubeClient.IsReachable(); err != nil {
 		return nil, err
 	}
@@ -159,7 +170,7 @@ func (l *List) Run() ([]*release.Release, error) {
 		}
 	}
 
-	results, err := l.cfg.Releases.List(func(rel *release.Release) bool {
+	results, err := list(func(rel *release.Release) bool {
 		// Skip anything that doesn't match the filter.
 		if filter != nil && !filter.MatchString(rel.Name) {
 			return false
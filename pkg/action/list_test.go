@@ -100,6 +100,19 @@ func TestList_Sort(t *testing.T) {
 	is.Equal("one", list[2].Name)
 }
 
+func TestList_RunSummaries(t *testing.T) {
+	is := assert.New(t)
+	lister := newListFixture(t)
+	lister.Sort = ByNameDesc
+	makeMeSomeReleases(lister.cfg.Releases, t)
+	list, err := lister.RunSummaries()
+	is.NoError(err)
+	is.Len(list, 3)
+	is.Equal("two", list[0].Name)
+	is.Equal("three", list[1].Name)
+	is.Equal("one", list[2].Name)
+}
+
 func TestList_Limit(t *testing.T) {
 	is := assert.New(t)
 	lister := newListFixture(t)
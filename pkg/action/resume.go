@@ -0,0 +1,125 @@
+/*
+Copyright The Helm Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/pkg/errors"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/releaseutil"
+)
+
+// errNothingToResume indicates that the last revision of a release was not
+// interrupted in the middle of an install or upgrade.
+var errNothingToResume = errors.New("last revision of the release is not an interrupted install or upgrade")
+
+// skipAll is used as a resume index for phases that were fully completed.
+const skipAll = math.MaxInt
+
+// resumePoint holds the indexes of the first pre-hook, rollout stage and
+// post-hook that have to be executed when an interrupted install or upgrade is
+// resumed. Everything before them has already been done by the interrupted
+// run. The hook or stage that was in progress when the run was interrupted is
+// executed once again, since it is not known whether it has finished.
+type resumePoint struct {
+	preHookIndex  int
+	stageIndex    int
+	postHookIndex int
+}
+
+// newResumePoint determines where to continue the interrupted release from,
+// using the phase and stage recorded in the release info.
+func newResumePoint(rel *release.Release) (*resumePoint, error) {
+	switch rel.Info.Status {
+	case release.StatusPendingInstall, release.StatusPendingUpgrade:
+	default:
+		return nil, errNothingToResume
+	}
+
+	if rel.Info.LastPhase == nil {
+		return nil, errNothingToResume
+	}
+
+	var lastStage int
+	if rel.Info.LastStage != nil {
+		lastStage = *rel.Info.LastStage
+	}
+
+	switch *rel.Info.LastPhase {
+	case release.PhaseInit:
+		return &resumePoint{}, nil
+	case release.PhaseHooksPre:
+		return &resumePoint{preHookIndex: lastStage}, nil
+	case release.PhaseRollout, release.PhaseStageHooksPre, release.PhaseStageHooksPost:
+		return &resumePoint{preHookIndex: skipAll, stageIndex: lastStage}, nil
+	case release.PhaseHooksPost:
+		return &resumePoint{preHookIndex: skipAll, stageIndex: skipAll, postHookIndex: lastStage}, nil
+	default:
+		return nil, errNothingToResume
+	}
+}
+
+func (p *resumePoint) String() string {
+	switch {
+	case p.stageIndex == skipAll:
+		return fmt.Sprintf("post-hook %d", p.postHookIndex)
+	case p.preHookIndex == skipAll:
+		return fmt.Sprintf("rollout stage %d", p.stageIndex)
+	default:
+		return fmt.Sprintf("pre-hook %d", p.preHookIndex)
+	}
+}
+
+// resumableRelease returns the last revision of the release if its install or
+// upgrade was interrupted and can be continued in place of the freshly
+// rendered release. Resuming is only possible if the rendered manifests and
+// hooks are the same as the ones of the interrupted revision.
+func (cfg *Configuration) resumableRelease(rendered *release.Release) (*release.Release, *resumePoint, error) {
+	history, err := cfg.Releases.History(rendered.Name)
+	if err != nil || len(history) == 0 {
+		return nil, nil, errNothingToResume
+	}
+	releaseutil.Reverse(history, releaseutil.SortByRevision)
+	last := history[0]
+
+	point, err := newResumePoint(last)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if last.Manifest != rendered.Manifest || !hookManifestsEqual(last.Hooks, rendered.Hooks) {
+		return nil, nil, fmt.Errorf("manifests of the interrupted revision %d differ from the rendered ones", last.Version)
+	}
+
+	return last, point, nil
+}
+
+func hookManifestsEqual(a, b []*release.Hook) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i].Path != b[i].Path || a[i].Manifest != b[i].Manifest {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,43 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+// RewriteReleases is the action for storing all releases again with the
+// current codec of the storage driver.
+//
+// It provides the implementation of 'helm rewrite-releases'.
+type RewriteReleases struct {
+	cfg *Configuration
+}
+
+// NewRewriteReleases creates a new RewriteReleases object with the given
+// configuration.
+func NewRewriteReleases(cfg *Configuration) *RewriteReleases {
+	return &RewriteReleases{
+		cfg: cfg,
+	}
+}
+
+// Run executes 'helm rewrite-releases'. It returns the number of rewritten
+// releases.
+func (r *RewriteReleases) Run() (int, error) {
+	if err := r.cfg.KubeClient.IsReachable(); err != nil {
+		return 0, err
+	}
+
+	return r.cfg.Releases.RewriteReleases()
+}
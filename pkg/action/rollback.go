@@ -18,8 +18,8 @@ package action
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"os"
 	"strings"
 	"time"
 
@@ -55,6 +55,11 @@ type Rollback struct {
 	StagesSplitter              phases.Splitter
 	StagesExternalDepsGenerator phases.ExternalDepsGenerator
 	DeployReportPath            string
+
+	rolloutPhaseManager *phasemanagers.RolloutPhaseManager
+	// releaseLocked is set when the rollback runs under the release lock
+	// already held by the calling action.
+	releaseLocked bool
 }
 
 // NewRollback creates a new Rollback object with the given configuration.
@@ -77,11 +82,28 @@ func NewRollback(cfg *Configuration, stagesSplitter phases.Splitter, stagesExter
 
 // Run executes 'helm rollback' against the given release.
 func (r *Rollback) Run(name string) error {
+	return r.RunWithContext(context.Background(), name)
+}
+
+// RunWithContext executes 'helm rollback' against the given release. Once ctx
+// is done, no more resources are applied, waiting is stopped and the rollback
+// is recorded as failed.
+func (r *Rollback) RunWithContext(ctx context.Context, name string) (err error) {
 	if err := r.cfg.KubeClient.IsReachable(); err != nil {
 		return err
 	}
 
 	r.cfg.Releases.MaxHistory = r.MaxHistory
+	r.rolloutPhaseManager = nil
+
+	if !r.DryRun && !r.releaseLocked {
+		var unlock func()
+		ctx, unlock, err = r.cfg.lockRelease(ctx, name)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
 
 	r.cfg.Log("preparing rollback of %s", name)
 	currentRelease, targetRelease, err := r.prepareRollback(name)
@@ -91,16 +113,7 @@ func (r *Rollback) Run(name string) error {
 
 	if !r.DryRun && r.DeployReportPath != "" {
 		defer func() {
-			deployReportData, err := release.NewDeployReport().FromRelease(targetRelease).ToJSONData()
-			if err != nil {
-				r.cfg.Log("warning: error creating deploy report data: %s", err)
-				return
-			}
-
-			if err := os.WriteFile(r.DeployReportPath, deployReportData, 0o644); err != nil {
-				r.cfg.Log("warning: error writing deploy report file: %s", err)
-				return
-			}
+			r.cfg.writeDeployReport(r.DeployReportPath, targetRelease, r.rolloutPhaseManager, err)
 		}()
 	}
 
@@ -112,7 +125,7 @@ func (r *Rollback) Run(name string) error {
 	}
 
 	r.cfg.Log("performing rollback of %s", name)
-	if _, err := r.performRollback(currentRelease, targetRelease); err != nil {
+	if _, err := r.performRollback(ctx, currentRelease, targetRelease); err != nil {
 		return err
 	}
 
@@ -171,6 +184,16 @@ func (r *Rollback) prepareRollback(name string) (*release.Release, *release.Rele
 		return nil, nil, err
 	}
 
+	// The hooks are copied without their last runs, so the rollback records
+	// and reports only its own hook runs and doesn't change the previous
+	// release.
+	hooks := make([]*release.Hook, 0, len(previousRelease.Hooks))
+	for _, h := range previousRelease.Hooks {
+		hook := *h
+		hook.LastRun = release.HookExecution{}
+		hooks = append(hooks, &hook)
+	}
+
 	// Store a new release object with previous release's configuration
 	targetRelease := release.SetInitPhaseStageInfo(&release.Release{
 		Name:      name,
@@ -189,18 +212,20 @@ func (r *Rollback) prepareRollback(name string) (*release.Release, *release.Rele
 		Version:  currentRelease.Version + 1,
 		Labels:   previousRelease.Labels,
 		Manifest: previousRelease.Manifest,
-		Hooks:    previousRelease.Hooks,
+		Hooks:    hooks,
 	})
 
 	return currentRelease, targetRelease, nil
 }
 
-func (r *Rollback) performRollback(currentRelease, targetRelease *release.Release) (*release.Release, error) {
+func (r *Rollback) performRollback(ctx context.Context, currentRelease, targetRelease *release.Release) (*release.Release, error) {
 	if r.DryRun {
 		r.cfg.Log("dry run for %s", targetRelease.Name)
 		return targetRelease, nil
 	}
 
+	kubeClient := kube.AsInterfaceContext(r.cfg.KubeClient)
+
 	target, err := r.cfg.KubeClient.Build(bytes.NewBufferString(targetRelease.Manifest), false)
 	if err != nil {
 		return targetRelease, errors.Wrap(err, "unable to build kubernetes objects from new release manifest")
@@ -208,7 +233,7 @@ func (r *Rollback) performRollback(currentRelease, targetRelease *release.Releas
 
 	// pre-rollback hooks
 	if !r.DisableHooks {
-		if err := r.cfg.execHook(targetRelease, release.HookPreRollback, r.Timeout); err != nil {
+		if err := r.cfg.execHook(ctx, targetRelease, release.HookPreRollback, r.Timeout); err != nil {
 			return targetRelease, err
 		}
 	} else {
@@ -242,32 +267,43 @@ func (r *Rollback) performRollback(currentRelease, targetRelease *release.Releas
 	deployedResourcesCalculator := phases.NewDeployedResourcesCalculator(history, r.StagesSplitter, r.cfg.KubeClient)
 
 	rolloutPhaseManager, err := phasemanagers.NewRolloutPhaseManager(rolloutPhase, deployedResourcesCalculator, targetRelease, r.cfg.Releases, r.cfg.KubeClient).
+		WithEvents(r.cfg.Events).
+		WithStageHooks(r.cfg.stageHooksFunc(ctx, targetRelease, r.DisableHooks, r.Timeout)).
+		WithContext(ctx).
 		AddCalculatedPreviouslyDeployedResources()
 	if err != nil {
 		recordFailedStatus(r.cfg, currentRelease, targetRelease, err)
 		return targetRelease, err
 	}
+	r.rolloutPhaseManager = rolloutPhaseManager
 
-	if err := rolloutPhaseManager.DoStage(
+	doStages := rolloutPhaseManager.DoStage
+	if rolloutPhase.SortedStages.IsDependencyGraph() {
+		doStages = rolloutPhaseManager.DoStagesConcurrently
+	}
+
+	if err := doStages(
 		func(stgIndex int, stage *stages.Stage) error {
 			if len(stage.ExternalDependencies) == 0 || !r.Wait {
 				return nil
 			}
 
 			if r.WaitForJobs {
-				return r.cfg.KubeClient.WaitWithJobs(stage.ExternalDependencies.AsResourceList(), r.Timeout)
+				return kubeClient.WaitWithJobsWithContext(ctx, stage.ExternalDependencies.AsResourceList(), r.Timeout)
 			} else {
-				return r.cfg.KubeClient.Wait(stage.ExternalDependencies.AsResourceList(), r.Timeout)
+				return kubeClient.WaitWithContext(ctx, stage.ExternalDependencies.AsResourceList(), r.Timeout)
 			}
 		},
 		func(stgIndex int, stage *stages.Stage, prevDeployedStgResources kube.ResourceList) error {
+			var err error
+
 			if len(prevDeployedStgResources) == 0 {
-				stage.Result, err = r.cfg.KubeClient.Create(stage.DesiredResources, kube.CreateOptions{})
+				stage.Result, err = kubeClient.CreateWithContext(ctx, stage.DesiredResources, kube.CreateOptions{})
 				if err != nil {
 					return err
 				}
 			} else {
-				stage.Result, err = r.cfg.KubeClient.Update(prevDeployedStgResources, stage.DesiredResources, r.Force, kube.UpdateOptions{
+				stage.Result, err = kubeClient.UpdateWithContext(ctx, prevDeployedStgResources, stage.DesiredResources, r.Force, kube.UpdateOptions{
 					SkipDeleteIfInvalidOwnership: true,
 					ReleaseName:                  targetRelease.Name,
 					ReleaseNamespace:             targetRelease.Namespace,
@@ -295,20 +331,16 @@ func (r *Rollback) performRollback(currentRelease, targetRelease *release.Releas
 			}
 
 			if r.WaitForJobs {
-				return r.cfg.KubeClient.WaitWithJobs(stage.DesiredResources, r.Timeout)
+				return kubeClient.WaitWithJobsWithContext(ctx, stage.DesiredResources, r.Timeout)
 			} else {
-				return r.cfg.KubeClient.Wait(stage.DesiredResources, r.Timeout)
+				return kubeClient.WaitWithContext(ctx, stage.DesiredResources, r.Timeout)
 			}
 		},
 	); err != nil {
 		recordFailedStatus(r.cfg, currentRelease, targetRelease, err)
 
 		if r.CleanupOnFail {
-			createdResourcesToDelete := kube.ResourceList{}
-			var applyErr *phasemanagers.ApplyError
-			if errors.As(err, &applyErr) {
-				createdResourcesToDelete = rolloutPhaseManager.Phase.SortedStages[applyErr.StageIndex].Result.Created
-			}
+			createdResourcesToDelete := rolloutPhaseManager.CreatedResourcesOfFailedStages()
 
 			if len(createdResourcesToDelete) > 0 {
 				r.cfg.Log("Cleanup on fail set, cleaning up %d resources", len(createdResourcesToDelete))
@@ -339,7 +371,7 @@ func (r *Rollback) performRollback(currentRelease, targetRelease *release.Releas
 
 	// post-rollback hooks
 	if !r.DisableHooks {
-		if err := r.cfg.execHook(targetRelease, release.HookPostRollback, r.Timeout); err != nil {
+		if err := r.cfg.execHook(ctx, targetRelease, release.HookPostRollback, r.Timeout); err != nil {
 			return targetRelease, err
 		}
 	}
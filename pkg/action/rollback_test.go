@@ -0,0 +1,54 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
+	helmtime "github.com/werf/3p-helm-for-werf-helm/pkg/time"
+)
+
+func TestRollbackPrepareResetsHookRuns(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	config := actionConfigFixture(t)
+
+	previous := releaseStub()
+	previous.Name = "rolled-back"
+	previous.Version = 1
+	previous.Info.Status = release.StatusSuperseded
+	previous.Hooks[0].LastRun = release.HookExecution{StartedAt: helmtime.Now(), Phase: release.HookPhaseSucceeded}
+	req.NoError(config.Releases.Create(previous))
+
+	current := releaseStub()
+	current.Name = "rolled-back"
+	current.Version = 2
+	current.Info.Status = release.StatusDeployed
+	req.NoError(config.Releases.Create(current))
+
+	_, target, err := NewRollback(config, nil, nil).prepareRollback("rolled-back")
+	req.NoError(err)
+
+	req.Len(target.Hooks, len(previous.Hooks))
+	is.True(target.Hooks[0].LastRun.StartedAt.IsZero())
+	is.Equal(release.HookPhaseSucceeded, previous.Hooks[0].LastRun.Phase)
+}
@@ -0,0 +1,49 @@
+/*
+Copyright The Helm Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/phasemanagers"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/releaseutil"
+)
+
+// rollbackRolloutStages restores the resources of every stage applied by a
+// failed rollout, last stage first, to the state they had in the previously
+// deployed revisions. Resources that did not exist before the failed rollout
+// are deleted.
+func (cfg *Configuration) rollbackRolloutStages(manager *phasemanagers.RolloutPhaseManager, rel *release.Release, force bool) error {
+	return manager.RollbackStages(func(stgIndex int, stage *stages.Stage, prevDeployedStgResources kube.ResourceList) error {
+		// Previously deployed resources are built from the old manifests, which
+		// don't have the ownership metadata the live objects carry.
+		if err := prevDeployedStgResources.Visit(releaseutil.SetMetadataVisitor(rel.Name, rel.Namespace, true)); err != nil {
+			return err
+		}
+
+		cfg.Log("rolling back stage %d: restoring %d resource(s) to previously deployed state", stgIndex, len(prevDeployedStgResources))
+		if _, err := cfg.KubeClient.Update(stage.DesiredResources, prevDeployedStgResources, force, kube.UpdateOptions{
+			SkipDeleteIfInvalidOwnership: true,
+			ReleaseName:                  rel.Name,
+			ReleaseNamespace:             rel.Namespace,
+		}); err != nil {
+			return err
+		}
+
+		return nil
+	})
+}
@@ -56,6 +56,10 @@ type Uninstall struct {
 	DeleteNamespace bool
 	Namespace       string
 	StagesSplitter  phases.Splitter
+
+	// releaseLocked is set when the uninstall runs under the release lock
+	// already held by the calling action.
+	releaseLocked bool
 }
 
 // NewUninstall creates a new Uninstall object with the given configuration.
@@ -73,6 +77,12 @@ func NewUninstall(cfg *Configuration, stagesSplitter phases.Splitter) *Uninstall
 
 // Run uninstalls the given release.
 func (u *Uninstall) Run(name string) (*release.UninstallReleaseResponse, error) {
+	return u.RunWithContext(context.Background(), name)
+}
+
+// RunWithContext uninstalls the given release. Once ctx is done, no more
+// resources are deleted and waiting is stopped.
+func (u *Uninstall) RunWithContext(ctx context.Context, name string) (*release.UninstallReleaseResponse, error) {
 	if err := u.cfg.KubeClient.IsReachable(); err != nil {
 		return nil, err
 	}
@@ -95,13 +105,23 @@ func (u *Uninstall) Run(name string) (*release.UninstallReleaseResponse, error)
 		return nil, errors.Errorf("uninstall: Release name is invalid: %s", name)
 	}
 
+	if !u.releaseLocked {
+		var unlock func()
+		var err error
+		ctx, unlock, err = u.cfg.lockRelease(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
 	rels, err := u.cfg.Releases.History(name)
 	if err != nil {
 		if u.IgnoreNotFound && errors.Is(err, driver.ErrReleaseNotFound) {
 			u.cfg.Log("No such release %q", name)
 
 			if u.DeleteNamespace && !u.KeepHistory {
-				if err := u.cfg.KubeClient.DeleteNamespace(context.Background(), u.Namespace, kube.DeleteOptions{Wait: true, WaitTimeout: u.Timeout}); err != nil {
+				if err := u.cfg.KubeClient.DeleteNamespace(ctx, u.Namespace, kube.DeleteOptions{Wait: true, WaitTimeout: u.Timeout}); err != nil {
 					if kube.IsNotFound(err) {
 						u.cfg.Log("No such namespace %q", u.Namespace)
 						return &release.UninstallReleaseResponse{}, nil
@@ -143,7 +163,7 @@ func (u *Uninstall) Run(name string) (*release.UninstallReleaseResponse, error)
 	res := &release.UninstallReleaseResponse{Release: rel}
 
 	if !u.DisableHooks {
-		if err := u.cfg.execHook(rel, release.HookPreDelete, u.Timeout); err != nil {
+		if err := u.cfg.execHook(ctx, rel, release.HookPreDelete, u.Timeout); err != nil {
 			return res, err
 		}
 	} else {
@@ -164,7 +184,7 @@ func (u *Uninstall) Run(name string) (*release.UninstallReleaseResponse, error)
 		u.cfg.Log("uninstall: Failed to store updated release: %s", err)
 	}
 
-	deletedResources, kept, errs := u.deleteRelease(rel, deployedResources)
+	deletedResources, kept, errs := u.deleteRelease(ctx, rel, deployedResources)
 	if errs != nil {
 		u.cfg.Log("uninstall: Failed to delete release: %s", errs)
 		return nil, errors.Errorf("failed to delete release: %s", name)
@@ -184,7 +204,7 @@ func (u *Uninstall) Run(name string) (*release.UninstallReleaseResponse, error)
 	}
 
 	if !u.DisableHooks {
-		if err := u.cfg.execHook(rel, release.HookPostDelete, u.Timeout); err != nil {
+		if err := u.cfg.execHook(ctx, rel, release.HookPostDelete, u.Timeout); err != nil {
 			errs = append(errs, err)
 		}
 	}
@@ -221,7 +241,7 @@ func (u *Uninstall) Run(name string) (*release.UninstallReleaseResponse, error)
 		}
 
 		if u.DeleteNamespace {
-			if err := u.cfg.KubeClient.DeleteNamespace(context.Background(), u.Namespace, kube.DeleteOptions{Wait: true, WaitTimeout: u.Timeout}); err != nil {
+			if err := u.cfg.KubeClient.DeleteNamespace(ctx, u.Namespace, kube.DeleteOptions{Wait: true, WaitTimeout: u.Timeout}); err != nil {
 				return res, errors.Wrapf(err, "unable to delete namespace %s", u.Namespace)
 			}
 		}
@@ -257,7 +277,7 @@ func joinErrors(errs []error) string {
 }
 
 // deleteRelease deletes the release and returns list of delete resources and manifests that were kept in the deletion process
-func (u *Uninstall) deleteRelease(rel *release.Release, res kube.ResourceList) (kube.ResourceList, string, []error) {
+func (u *Uninstall) deleteRelease(ctx context.Context, rel *release.Release, res kube.ResourceList) (kube.ResourceList, string, []error) {
 	manifestsStr, err := res.ToYamlDocs()
 	if err != nil {
 		return nil, "", []error{fmt.Errorf("error converting resource list to yaml manifests: %w", err)}
@@ -295,22 +315,26 @@ func (u *Uninstall) deleteRelease(rel *release.Release, res kube.ResourceList) (
 		return nil, "", []error{errors.Wrap(err, "unable to build kubernetes objects for delete")}
 	}
 	if len(resources) > 0 {
-		if kubeClient, ok := u.cfg.KubeClient.(kube.InterfaceDeletionPropagation); ok {
-			_, errs = kubeClient.DeleteWithPropagationPolicy(resources, parseCascadingFlag(u.cfg, u.DeletionPropagation), kube.DeleteOptions{
-				Wait:                   true,
-				SkipIfInvalidOwnership: true,
-				ReleaseName:            rel.Name,
-				ReleaseNamespace:       rel.Namespace,
-			})
-			return resources, kept, errs
+		if err := ctx.Err(); err != nil {
+			return nil, kept, []error{err}
 		}
 
-		_, errs = u.cfg.KubeClient.Delete(resources, kube.DeleteOptions{
+		opts := kube.DeleteOptions{
 			Wait:                   true,
+			WaitTimeout:            u.Timeout,
 			SkipIfInvalidOwnership: true,
 			ReleaseName:            rel.Name,
 			ReleaseNamespace:       rel.Namespace,
-		})
+		}
+
+		switch kubeClient := u.cfg.KubeClient.(type) {
+		case kube.InterfaceDeletionPropagationContext:
+			_, errs = kubeClient.DeleteWithPropagationPolicyWithContext(ctx, resources, parseCascadingFlag(u.cfg, u.DeletionPropagation), opts)
+		case kube.InterfaceDeletionPropagation:
+			_, errs = kubeClient.DeleteWithPropagationPolicy(resources, parseCascadingFlag(u.cfg, u.DeletionPropagation), opts)
+		default:
+			_, errs = kube.AsInterfaceContext(u.cfg.KubeClient).DeleteWithContext(ctx, resources, opts)
+		}
 	}
 	return resources, kept, errs
 }
@@ -20,7 +20,6 @@ import (
 	"bytes"
 	"context"
 	"fmt"
-	"os"
 	"strings"
 	"sync"
 	"time"
@@ -120,6 +119,28 @@ type Upgrade struct {
 	StagesSplitter              phases.Splitter
 	StagesExternalDepsGenerator phases.ExternalDepsGenerator
 	IgnorePending               bool
+	// Resume continues an upgrade that was interrupted while pending, skipping
+	// the hooks and stages it has already deployed.
+	// It fails if the rendered manifests differ from the interrupted ones.
+	Resume bool
+	// RollbackStagesOnFail restores resources of all applied stages to their
+	// previously deployed state if the rollout fails.
+	RollbackStagesOnFail bool
+	// ApplyConcurrency is the number of resources of the same kind in a stage
+	// applied at once. Zero applies them one by one.
+	ApplyConcurrency int
+	// ServerSideApply makes stage resources to be applied with server-side
+	// apply. ForceConflicts makes it take over fields owned by other managers.
+	ServerSideApply bool
+	ForceConflicts  bool
+	// ServerDryRunDiff makes the upgrade a dry run, which sends the resources
+	// of every stage to the cluster as server-side dry-run requests and stores
+	// what would change in Diff.
+	ServerDryRunDiff bool
+	Diff             *UpgradeDiff
+
+	resumePoint         *resumePoint
+	rolloutPhaseManager *phasemanagers.RolloutPhaseManager
 }
 
 type resultMessage struct {
@@ -169,7 +190,7 @@ func (u *Upgrade) Run(name string, chart *chart.Chart, vals map[string]interface
 }
 
 // RunWithContext executes the upgrade on the given release with context.
-func (u *Upgrade) RunWithContext(ctx context.Context, name string, chart *chart.Chart, vals map[string]interface{}) (*release.Release, error) {
+func (u *Upgrade) RunWithContext(ctx context.Context, name string, chart *chart.Chart, vals map[string]interface{}) (_ *release.Release, err error) {
 	if err := u.cfg.KubeClient.IsReachable(); err != nil {
 		return nil, err
 	}
@@ -182,24 +203,41 @@ func (u *Upgrade) RunWithContext(ctx context.Context, name string, chart *chart.
 		return nil, errors.Errorf("release name is invalid: %s", name)
 	}
 
+	if !u.isDryRun() {
+		var unlock func()
+		ctx, unlock, err = u.cfg.lockRelease(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
 	u.cfg.Log("preparing upgrade for %s", name)
 	currentRelease, upgradedRelease, err := u.prepareUpgrade(name, chart, vals)
 	if err != nil {
 		return nil, err
 	}
 
+	u.resumePoint = nil
+	u.rolloutPhaseManager = nil
+	u.Diff = nil
+	if u.Resume && !u.isDryRun() {
+		resumedRelease, point, err := u.cfg.resumableRelease(upgradedRelease)
+		if err != nil && !errors.Is(err, errNothingToResume) {
+			return nil, errors.Wrapf(err, "unable to resume upgrade of %q", name)
+		}
+
+		if err == nil {
+			u.cfg.Log("resuming upgrade for %s revision %d from %s", name, resumedRelease.Version, point)
+			resumedRelease.Info.LastDeployed = upgradedRelease.Info.LastDeployed
+			upgradedRelease = resumedRelease
+			u.resumePoint = point
+		}
+	}
+
 	if !u.isDryRun() && u.DeployReportPath != "" {
 		defer func() {
-			deployReportData, err := release.NewDeployReport().FromRelease(upgradedRelease).ToJSONData()
-			if err != nil {
-				u.cfg.Log("warning: error creating deploy report data: %s", err)
-				return
-			}
-
-			if err := os.WriteFile(u.DeployReportPath, deployReportData, 0o644); err != nil {
-				u.cfg.Log("warning: error writing deploy report file: %s", err)
-				return
-			}
+			u.cfg.writeDeployReport(u.DeployReportPath, upgradedRelease, u.rolloutPhaseManager, err)
 		}()
 	}
 
@@ -224,7 +262,7 @@ func (u *Upgrade) RunWithContext(ctx context.Context, name string, chart *chart.
 
 // isDryRun returns true if Upgrade is set to run as a DryRun
 func (u *Upgrade) isDryRun() bool {
-	if u.DryRun || u.DryRunOption == "client" || u.DryRunOption == "server" || u.DryRunOption == "true" {
+	if u.DryRun || u.DryRunOption == "client" || u.DryRunOption == "server" || u.DryRunOption == "true" || u.ServerDryRunDiff {
 		return true
 	}
 	return false
@@ -282,6 +320,14 @@ func (u *Upgrade) prepareUpgrade(name string, chart *chart.Chart, vals map[strin
 	// the release object.
 	revision := lastRelease.Version + 1
 
+	// An interrupted revision is resumed in place, so it is rendered with its
+	// own revision for the manifests to match the ones it was started with.
+	if u.Resume && !u.isDryRun() {
+		if _, err := newResumePoint(lastRelease); err == nil {
+			revision = lastRelease.Version
+		}
+	}
+
 	options := chartutil.ReleaseOptions{
 		Name:      name,
 		Namespace: currentRelease.Namespace,
@@ -301,7 +347,7 @@ func (u *Upgrade) prepareUpgrade(name string, chart *chart.Chart, vals map[strin
 
 	// Determine whether or not to interact with remote
 	var interactWithRemote bool
-	if !u.isDryRun() || u.DryRunOption == "server" || u.DryRunOption == "none" || u.DryRunOption == "false" {
+	if !u.isDryRun() || u.DryRunOption == "server" || u.DryRunOption == "none" || u.DryRunOption == "false" || u.ServerDryRunDiff {
 		interactWithRemote = true
 	}
 
@@ -384,6 +430,11 @@ func (u *Upgrade) performUpgrade(ctx context.Context, originalRelease, upgradedR
 	// Run if it is a dry run
 	if u.isDryRun() {
 		u.cfg.Log("dry run for %s", upgradedRelease.Name)
+		if u.ServerDryRunDiff {
+			if u.Diff, err = u.serverDryRunDiff(upgradedRelease, target, toBeAdopted); err != nil {
+				return nil, fmt.Errorf("error calculating server-side dry-run diff: %w", err)
+			}
+		}
 		if len(u.Description) > 0 {
 			upgradedRelease.Info.Description = u.Description
 		} else {
@@ -392,16 +443,27 @@ func (u *Upgrade) performUpgrade(ctx context.Context, originalRelease, upgradedR
 		return upgradedRelease, nil
 	}
 
-	u.cfg.Log("creating upgraded release for %s", upgradedRelease.Name)
-	if err := u.cfg.Releases.Create(upgradedRelease); err != nil {
-		return nil, err
+	if u.resumePoint != nil {
+		u.cfg.Log("updating resumed release for %s", upgradedRelease.Name)
+		if err := u.cfg.Releases.Update(upgradedRelease); err != nil {
+			return nil, err
+		}
+	} else {
+		u.cfg.Log("creating upgraded release for %s", upgradedRelease.Name)
+		if err := u.cfg.Releases.Create(upgradedRelease); err != nil {
+			return nil, err
+		}
 	}
 	rChan := make(chan resultMessage)
 	ctxChan := make(chan resultMessage)
 	doneChan := make(chan interface{})
 	defer close(doneChan)
-	go u.releasingUpgrade(rChan, upgradedRelease, toBeAdopted, target, originalRelease)
-	go u.handleContext(ctx, doneChan, ctxChan, upgradedRelease)
+	go u.releasingUpgrade(ctx, rChan, upgradedRelease, toBeAdopted, target, originalRelease)
+	// The upgrade stops on its own once ctx is done with a kube client
+	// implementing kube.InterfaceContext, and then it is failed as usual.
+	if _, ok := u.cfg.KubeClient.(kube.InterfaceContext); !ok {
+		go u.handleContext(ctx, doneChan, ctxChan, upgradedRelease)
+	}
 	select {
 	case result := <-rChan:
 		return result.r, result.e
@@ -413,10 +475,10 @@ func (u *Upgrade) performUpgrade(ctx context.Context, originalRelease, upgradedR
 // Function used to lock the Mutex, this is important for the case when the atomic flag is set.
 // In that case the upgrade will finish before the rollback is finished so it is necessary to wait for the rollback to finish.
 // The rollback will be trigger by the function failRelease
-func (u *Upgrade) reportToPerformUpgrade(c chan<- resultMessage, rel *release.Release, created kube.ResourceList, err error) {
+func (u *Upgrade) reportToPerformUpgrade(ctx context.Context, c chan<- resultMessage, rel *release.Release, created kube.ResourceList, err error) {
 	u.Lock.Lock()
 	if err != nil {
-		rel, err = u.failRelease(rel, created, err)
+		rel, err = u.failRelease(ctx, rel, created, err)
 	}
 	c <- resultMessage{r: rel, e: err}
 	u.Lock.Unlock()
@@ -426,20 +488,26 @@ func (u *Upgrade) reportToPerformUpgrade(c chan<- resultMessage, rel *release.Re
 func (u *Upgrade) handleContext(ctx context.Context, done chan interface{}, c chan<- resultMessage, upgradedRelease *release.Release) {
 	select {
 	case <-ctx.Done():
-		err := ctx.Err()
+		err := context.Cause(ctx)
 
 		// when the atomic flag is set the ongoing release finish first and doesn't give time for the rollback happens.
-		u.reportToPerformUpgrade(c, upgradedRelease, kube.ResourceList{}, err)
+		u.reportToPerformUpgrade(ctx, c, upgradedRelease, kube.ResourceList{}, err)
 	case <-done:
 		return
 	}
 }
-func (u *Upgrade) releasingUpgrade(c chan<- resultMessage, upgradedRelease *release.Release, toBeAdopted kube.ResourceList, target kube.ResourceList, originalRelease *release.Release) {
-	// pre-upgrade hooks
+func (u *Upgrade) releasingUpgrade(ctx context.Context, c chan<- resultMessage, upgradedRelease *release.Release, toBeAdopted kube.ResourceList, target kube.ResourceList, originalRelease *release.Release) {
+	resume := u.resumePoint
+	if resume == nil {
+		resume = &resumePoint{}
+	}
 
+	kubeClient := kube.AsInterfaceContext(u.cfg.KubeClient)
+
+	// pre-upgrade hooks
 	if !u.DisableHooks {
-		if err := u.cfg.execHook(upgradedRelease, release.HookPreUpgrade, u.Timeout); err != nil {
-			u.reportToPerformUpgrade(c, upgradedRelease, kube.ResourceList{}, fmt.Errorf("pre-upgrade hooks failed: %s", err))
+		if err := u.cfg.execHookFrom(ctx, upgradedRelease, release.HookPreUpgrade, u.Timeout, resume.preHookIndex); err != nil {
+			u.reportToPerformUpgrade(ctx, c, upgradedRelease, kube.ResourceList{}, fmt.Errorf("pre-upgrade hooks failed: %s", err))
 			return
 		}
 	} else {
@@ -449,7 +517,7 @@ func (u *Upgrade) releasingUpgrade(c chan<- resultMessage, upgradedRelease *rele
 	history, err := u.cfg.Releases.HistoryUntilRevision(upgradedRelease.Name, upgradedRelease.Version)
 	if err != nil {
 		u.cfg.recordRelease(originalRelease)
-		u.reportToPerformUpgrade(c, upgradedRelease, kube.ResourceList{}, fmt.Errorf("error getting release history: %w", err))
+		u.reportToPerformUpgrade(ctx, c, upgradedRelease, kube.ResourceList{}, fmt.Errorf("error getting release history: %w", err))
 		return
 	}
 
@@ -457,50 +525,75 @@ func (u *Upgrade) releasingUpgrade(c chan<- resultMessage, upgradedRelease *rele
 		ParseStages(target)
 	if err != nil {
 		u.cfg.recordRelease(originalRelease)
-		u.reportToPerformUpgrade(c, upgradedRelease, kube.ResourceList{}, fmt.Errorf("error parsing stages for rollout phase: %w", err))
+		u.reportToPerformUpgrade(ctx, c, upgradedRelease, kube.ResourceList{}, fmt.Errorf("error parsing stages for rollout phase: %w", err))
 		return
 	}
 
 	if err := rolloutPhase.GenerateStagesExternalDeps(u.StagesExternalDepsGenerator); err != nil {
 		u.cfg.recordRelease(originalRelease)
-		u.reportToPerformUpgrade(c, upgradedRelease, kube.ResourceList{}, fmt.Errorf("error generating external deps for rollout phase: %w", err))
+		u.reportToPerformUpgrade(ctx, c, upgradedRelease, kube.ResourceList{}, fmt.Errorf("error generating external deps for rollout phase: %w", err))
 		return
 	}
 
 	deployedResourcesCalculator := phases.NewDeployedResourcesCalculator(history, u.StagesSplitter, u.cfg.KubeClient)
 
+	var resumedDeployedResources kube.ResourceList
+	if u.resumePoint != nil {
+		resumedDeployedResources = rolloutPhase.DeployedResources()
+	}
+
 	rolloutPhaseManager, err := phasemanagers.NewRolloutPhaseManager(rolloutPhase, deployedResourcesCalculator, upgradedRelease, u.cfg.Releases, u.cfg.KubeClient).
 		AddPreviouslyDeployedResources(toBeAdopted).
+		AddResumedResources(resumedDeployedResources).
+		SkipStagesBefore(resume.stageIndex).
+		WithEvents(u.cfg.Events).
+		WithStageHooks(u.cfg.stageHooksFunc(ctx, upgradedRelease, u.DisableHooks, u.Timeout)).
+		WithContext(ctx).
 		AddCalculatedPreviouslyDeployedResources()
 	if err != nil {
 		u.cfg.recordRelease(originalRelease)
-		u.reportToPerformUpgrade(c, upgradedRelease, kube.ResourceList{}, fmt.Errorf("error calculating previously deployed resources for rollout phase manager: %w", err))
+		u.reportToPerformUpgrade(ctx, c, upgradedRelease, kube.ResourceList{}, fmt.Errorf("error calculating previously deployed resources for rollout phase manager: %w", err))
 		return
 	}
+	u.rolloutPhaseManager = rolloutPhaseManager
 
-	if err := rolloutPhaseManager.DoStage(
+	doStages := rolloutPhaseManager.DoStage
+	if rolloutPhase.SortedStages.IsDependencyGraph() {
+		doStages = rolloutPhaseManager.DoStagesConcurrently
+	}
+
+	if err := doStages(
 		func(stgIndex int, stage *stages.Stage) error {
 			if len(stage.ExternalDependencies) == 0 || !u.Wait {
 				return nil
 			}
 
 			if u.WaitForJobs {
-				return u.cfg.KubeClient.WaitWithJobs(stage.ExternalDependencies.AsResourceList(), u.Timeout)
+				return kubeClient.WaitWithJobsWithContext(ctx, stage.ExternalDependencies.AsResourceList(), u.Timeout)
 			} else {
-				return u.cfg.KubeClient.Wait(stage.ExternalDependencies.AsResourceList(), u.Timeout)
+				return kubeClient.WaitWithContext(ctx, stage.ExternalDependencies.AsResourceList(), u.Timeout)
 			}
 		},
 		func(stgIndex int, stage *stages.Stage, prevDeployedStgResources kube.ResourceList) error {
+			var err error
+
 			if len(prevDeployedStgResources) == 0 {
-				stage.Result, err = u.cfg.KubeClient.Create(stage.DesiredResources, kube.CreateOptions{})
+				stage.Result, err = kubeClient.CreateWithContext(ctx, stage.DesiredResources, kube.CreateOptions{
+					Concurrency:     u.ApplyConcurrency,
+					ServerSideApply: u.ServerSideApply,
+					ForceConflicts:  u.ForceConflicts,
+				})
 				if err != nil {
 					return err
 				}
 			} else {
-				stage.Result, err = u.cfg.KubeClient.Update(prevDeployedStgResources, stage.DesiredResources, u.Force, kube.UpdateOptions{
+				stage.Result, err = kubeClient.UpdateWithContext(ctx, prevDeployedStgResources, stage.DesiredResources, u.Force, kube.UpdateOptions{
 					SkipDeleteIfInvalidOwnership: true,
 					ReleaseName:                  upgradedRelease.Name,
 					ReleaseNamespace:             upgradedRelease.Namespace,
+					Concurrency:                  u.ApplyConcurrency,
+					ServerSideApply:              u.ServerSideApply,
+					ForceConflicts:               u.ForceConflicts,
 				})
 				if err != nil {
 					return err
@@ -525,21 +618,26 @@ func (u *Upgrade) releasingUpgrade(c chan<- resultMessage, upgradedRelease *rele
 			}
 
 			if u.WaitForJobs {
-				return u.cfg.KubeClient.WaitWithJobs(stage.DesiredResources, u.Timeout)
+				return kubeClient.WaitWithJobsWithContext(ctx, stage.DesiredResources, u.Timeout)
 			} else {
-				return u.cfg.KubeClient.Wait(stage.DesiredResources, u.Timeout)
+				return kubeClient.WaitWithContext(ctx, stage.DesiredResources, u.Timeout)
 			}
 		},
 	); err != nil {
 		u.cfg.recordRelease(originalRelease)
 
-		createdResourcesToDelete := kube.ResourceList{}
-		var applyErr *phasemanagers.ApplyError
-		if errors.As(err, &applyErr) {
-			createdResourcesToDelete = rolloutPhaseManager.Phase.SortedStages[applyErr.StageIndex].Result.Created
+		createdResourcesToDelete := rolloutPhaseManager.CreatedResourcesOfFailedStages()
+
+		if u.RollbackStagesOnFail {
+			if rollbackErr := u.cfg.rollbackRolloutStages(rolloutPhaseManager, upgradedRelease, u.Force); rollbackErr != nil {
+				u.cfg.Log("warning: unable to roll back applied stages: %s", rollbackErr)
+			} else {
+				u.cfg.Log("applied stages have been rolled back")
+				createdResourcesToDelete = kube.ResourceList{}
+			}
 		}
 
-		u.reportToPerformUpgrade(c, upgradedRelease, createdResourcesToDelete, fmt.Errorf("error processing rollout phase stage: %w", err))
+		u.reportToPerformUpgrade(ctx, c, upgradedRelease, createdResourcesToDelete, fmt.Errorf("error processing rollout phase stage: %w", err))
 
 		return
 	}
@@ -550,8 +648,8 @@ func (u *Upgrade) releasingUpgrade(c chan<- resultMessage, upgradedRelease *rele
 
 	// post-upgrade hooks
 	if !u.DisableHooks {
-		if err := u.cfg.execHook(upgradedRelease, release.HookPostUpgrade, u.Timeout); err != nil {
-			u.reportToPerformUpgrade(c, upgradedRelease, rolloutPhaseManager.Phase.SortedStages.MergedCreatedResources(), fmt.Errorf("post-upgrade hooks failed: %s", err))
+		if err := u.cfg.execHookFrom(ctx, upgradedRelease, release.HookPostUpgrade, u.Timeout, resume.postHookIndex); err != nil {
+			u.reportToPerformUpgrade(ctx, c, upgradedRelease, rolloutPhaseManager.Phase.SortedStages.MergedCreatedResources(), fmt.Errorf("post-upgrade hooks failed: %s", err))
 			return
 		}
 	}
@@ -565,10 +663,12 @@ func (u *Upgrade) releasingUpgrade(c chan<- resultMessage, upgradedRelease *rele
 	} else {
 		upgradedRelease.Info.Description = "Upgrade complete"
 	}
-	u.reportToPerformUpgrade(c, upgradedRelease, nil, nil)
+	u.reportToPerformUpgrade(ctx, c, upgradedRelease, nil, nil)
 }
 
-func (u *Upgrade) failRelease(rel *release.Release, created kube.ResourceList, err error) (*release.Release, error) {
+// failRelease marks the release as failed and cleans up after it. The atomic
+// rollback runs under the release lock held for ctx, see cleanupContext.
+func (u *Upgrade) failRelease(ctx context.Context, rel *release.Release, created kube.ResourceList, err error) (*release.Release, error) {
 	msg := fmt.Sprintf("Upgrade %q failed: %s", rel.Name, err)
 	u.cfg.Log("warning: %s", msg)
 
@@ -626,8 +726,9 @@ func (u *Upgrade) failRelease(rel *release.Release, created kube.ResourceList, e
 		rollin.Timeout = u.Timeout
 
 		rollin.CleanupOnFail = u.CleanupOnFail
+		rollin.releaseLocked = true
 
-		if rollErr := rollin.Run(rel.Name); rollErr != nil {
+		if rollErr := rollin.RunWithContext(cleanupContext(ctx), rel.Name); rollErr != nil {
 			return rel, errors.Wrapf(rollErr, "an error occurred while rolling back the release. original upgrade error: %s", err)
 		}
 		return rel, errors.Wrapf(err, "release %s failed, and has been rolled back due to atomic being set", rel.Name)
@@ -0,0 +1,218 @@
+/*
+Copyright The Helm Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package action
+
+import (
+	"errors"
+	"fmt"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/phasemanagers"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
+)
+
+// UpgradeDiff lists the changes an upgrade would make to the cluster.
+type UpgradeDiff struct {
+	Stages []*StageDiff `json:"stages"`
+	// Orphaned are previously deployed resources which are not in the release
+	// anymore and would be deleted.
+	Orphaned []string `json:"orphaned,omitempty"`
+}
+
+// StageDiff lists the changes the upgrade would make to the resources of a
+// single rollout stage.
+type StageDiff struct {
+	Weight  int             `json:"weight"`
+	Created []string        `json:"created,omitempty"`
+	Updated []*ResourceDiff `json:"updated,omitempty"`
+	// Adopted are existing resources which don't belong to the release yet.
+	Adopted []*ResourceDiff `json:"adopted,omitempty"`
+	// Unverified are resources the server rejected as something they need
+	// was not found, usually a Namespace or another resource created by an
+	// earlier stage, which dry-run requests don't persist.
+	Unverified []string `json:"unverified,omitempty"`
+}
+
+// ResourceDiff is the difference between the live resource and the resource
+// the upgrade would apply. Expected values of the fields are the ones after
+// the upgrade.
+type ResourceDiff struct {
+	Resource string       `json:"resource"`
+	Fields   []FieldDrift `json:"fields,omitempty"`
+}
+
+// serverDryRunDiff sends the resources of every stage to the cluster as
+// server-side dry-run requests, the same way the upgrade would apply them, and
+// compares the responses with the live resources.
+//
+// The stages are dry-run against the current cluster state, without the
+// changes of the earlier stages, so the resources which fail because they
+// need something an earlier stage creates are listed as unverified.
+func (u *Upgrade) serverDryRunDiff(upgradedRelease *release.Release, target, toBeAdopted kube.ResourceList) (*UpgradeDiff, error) {
+	kubeClient, ok := u.cfg.KubeClient.(kube.InterfaceLive)
+	if !ok {
+		return nil, errors.New("unable to get kubeClient with interface InterfaceLive")
+	}
+
+	history, err := u.cfg.Releases.HistoryUntilRevision(upgradedRelease.Name, upgradedRelease.Version)
+	if err != nil {
+		return nil, fmt.Errorf("error getting release history: %w", err)
+	}
+
+	rolloutPhase, err := phases.NewRolloutPhase(upgradedRelease, u.StagesSplitter, u.cfg.KubeClient).
+		ParseStages(target)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing stages for rollout phase: %w", err)
+	}
+
+	deployedResourcesCalculator := phases.NewDeployedResourcesCalculator(history, u.StagesSplitter, u.cfg.KubeClient)
+	rolloutPhaseManager, err := phasemanagers.NewRolloutPhaseManager(rolloutPhase, deployedResourcesCalculator, upgradedRelease, u.cfg.Releases, u.cfg.KubeClient).
+		AddPreviouslyDeployedResources(toBeAdopted).
+		AddCalculatedPreviouslyDeployedResources()
+	if err != nil {
+		return nil, fmt.Errorf("error calculating previously deployed resources for rollout phase manager: %w", err)
+	}
+
+	diff := &UpgradeDiff{}
+	for i, stage := range rolloutPhase.SortedStages {
+		stageDiff := &StageDiff{Weight: stage.Weight}
+		diff.Stages = append(diff.Stages, stageDiff)
+
+		if len(stage.DesiredResources) == 0 {
+			continue
+		}
+
+		liveResources, err := kubeClient.GetLive(stage.DesiredResources)
+		if err != nil {
+			return nil, fmt.Errorf("error getting live resources of stage %d: %w", i, err)
+		}
+
+		prevDeployedStgResources := rolloutPhaseManager.PreviouslyDeployedStageResources(i)
+		dryRun := func(resources kube.ResourceList) (*kube.Result, error) {
+			return u.dryRunStage(upgradedRelease, prevDeployedStgResources, resources)
+		}
+
+		result, err := dryRun(stage.DesiredResources)
+		if err != nil {
+			result, stageDiff.Unverified, err = dryRunOneByOne(stage.DesiredResources, dryRun)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error applying resources of stage %d in dry-run mode: %w", i, err)
+		}
+
+		for _, info := range result.Created {
+			stageDiff.Created = append(stageDiff.Created, kube.ResourceNameNamespaceKind(info))
+		}
+
+		for _, info := range result.Updated {
+			resDiff := &ResourceDiff{Resource: kube.ResourceNameNamespaceKind(info)}
+
+			if liveInfo := liveResources.Get(info); liveInfo != nil {
+				applied, err := runtime.DefaultUnstructuredConverter.ToUnstructured(info.Object)
+				if err != nil {
+					return nil, fmt.Errorf("error converting %q to unstructured: %w", resDiff.Resource, err)
+				}
+
+				live, err := runtime.DefaultUnstructuredConverter.ToUnstructured(liveInfo.Object)
+				if err != nil {
+					return nil, fmt.Errorf("error converting live %q to unstructured: %w", resDiff.Resource, err)
+				}
+
+				resDiff.Fields = diffObjects(applied, live, true)
+			}
+
+			if toBeAdopted.Get(info) != nil {
+				stageDiff.Adopted = append(stageDiff.Adopted, resDiff)
+			} else if len(resDiff.Fields) > 0 {
+				stageDiff.Updated = append(stageDiff.Updated, resDiff)
+			}
+		}
+	}
+
+	for _, info := range rolloutPhaseManager.OrphanedResources() {
+		diff.Orphaned = append(diff.Orphaned, kube.ResourceNameNamespaceKind(info))
+	}
+
+	return diff, nil
+}
+
+// dryRunStage applies the stage resources in dry-run mode the same way the
+// upgrade would apply them.
+func (u *Upgrade) dryRunStage(upgradedRelease *release.Release, prevDeployedStgResources, resources kube.ResourceList) (*kube.Result, error) {
+	if len(prevDeployedStgResources) == 0 {
+		return u.cfg.KubeClient.Create(resources, kube.CreateOptions{
+			Concurrency:     u.ApplyConcurrency,
+			ServerSideApply: u.ServerSideApply,
+			ForceConflicts:  u.ForceConflicts,
+			DryRun:          true,
+		})
+	}
+
+	return u.cfg.KubeClient.Update(prevDeployedStgResources, resources, u.Force, kube.UpdateOptions{
+		SkipDeleteIfInvalidOwnership: true,
+		ReleaseName:                  upgradedRelease.Name,
+		ReleaseNamespace:             upgradedRelease.Namespace,
+		Concurrency:                  u.ApplyConcurrency,
+		ServerSideApply:              u.ServerSideApply,
+		ForceConflicts:               u.ForceConflicts,
+		DryRun:                       true,
+	})
+}
+
+// dryRunOneByOne dry-runs the resources one by one and returns the ones
+// failed as something they need was not found separately, so they don't fail
+// the whole stage.
+func dryRunOneByOne(resources kube.ResourceList, dryRun func(kube.ResourceList) (*kube.Result, error)) (*kube.Result, []string, error) {
+	result := &kube.Result{}
+	var unverified []string
+	for _, info := range resources {
+		res, err := dryRun(kube.ResourceList{info})
+		if err != nil {
+			if isNotFoundResult(res, err) {
+				unverified = append(unverified, kube.ResourceNameNamespaceKind(info))
+				continue
+			}
+
+			return nil, nil, err
+		}
+
+		result.Created = append(result.Created, res.Created...)
+		result.Updated = append(result.Updated, res.Updated...)
+	}
+
+	return result, unverified, nil
+}
+
+func isNotFoundResult(res *kube.Result, err error) bool {
+	if apierrors.IsNotFound(err) {
+		return true
+	}
+
+	if res == nil || len(res.Errors) == 0 {
+		return false
+	}
+	for _, resErr := range res.Errors {
+		if !apierrors.IsNotFound(resErr.Err) {
+			return false
+		}
+	}
+
+	return true
+}
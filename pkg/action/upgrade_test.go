@@ -18,17 +18,26 @@ package action
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"reflect"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/werf/3p-helm-for-werf-helm/pkg/chart"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/events"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/storage"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/storage/driver"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
 
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
 	kubefake "github.com/werf/3p-helm-for-werf-helm/pkg/kube/fake"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/release"
 	helmtime "github.com/werf/3p-helm-for-werf-helm/pkg/time"
@@ -69,6 +78,65 @@ func TestUpgradeRelease_Success(t *testing.T) {
 	is.Equal(lastRelease.Info.Status, release.StatusDeployed)
 }
 
+func TestUpgradeRelease_Locked(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	upAction := upgradeAction(t)
+	upAction.cfg.ReleaseLock = &storage.LockOptions{Holder: "ci-1"}
+	rel := releaseStub()
+	rel.Name = "locked-release"
+	rel.Info.Status = release.StatusDeployed
+	req.NoError(upAction.cfg.Releases.Create(rel))
+
+	lock, err := upAction.cfg.Releases.Lock(context.Background(), rel.Name, storage.LockOptions{Holder: "ci-2"})
+	req.NoError(err)
+
+	_, err = upAction.Run(rel.Name, buildChart(), map[string]interface{}{})
+	req.ErrorIs(err, driver.ErrReleaseLocked)
+	lastRelease, err := upAction.cfg.Releases.Last(rel.Name)
+	req.NoError(err)
+	is.Equal(1, lastRelease.Version)
+
+	req.NoError(lock.Unlock())
+	res, err := upAction.Run(rel.Name, buildChart(), map[string]interface{}{})
+	req.NoError(err)
+	is.Equal(release.StatusDeployed, res.Info.Status)
+
+	_, err = upAction.cfg.Releases.Lock(context.Background(), rel.Name, storage.LockOptions{Holder: "ci-2"})
+	req.NoError(err, "the lock is released after the upgrade")
+}
+
+func TestUpgradeRelease_AtomicLocked(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	upAction := upgradeAction(t)
+	upAction.cfg.ReleaseLock = &storage.LockOptions{}
+	rel := releaseStub()
+	rel.Name = "locked-nuketown"
+	rel.Info.Status = release.StatusDeployed
+	req.NoError(upAction.cfg.Releases.Create(rel))
+
+	failer := upAction.cfg.KubeClient.(*kubefake.FailingKubeClient)
+	failer.WatchUntilReadyError = fmt.Errorf("arming key removed")
+	upAction.cfg.KubeClient = failer
+	upAction.Atomic = true
+
+	_, err := upAction.Run(rel.Name, buildChart(), map[string]interface{}{})
+	req.Error(err)
+	is.NotErrorIs(err, driver.ErrReleaseLocked, "the rollback runs under the lock of the upgrade")
+	is.Contains(err.Error(), "has been rolled back")
+
+	lastRelease, err := upAction.cfg.Releases.Last(rel.Name)
+	req.NoError(err)
+	is.Equal(3, lastRelease.Version)
+	is.Equal(release.StatusDeployed, lastRelease.Info.Status)
+
+	_, err = upAction.cfg.Releases.Lock(context.Background(), rel.Name, storage.LockOptions{Holder: "ci-2"})
+	req.NoError(err, "the lock is released after the upgrade")
+}
+
 func TestUpgradeRelease_Wait(t *testing.T) {
 	is := assert.New(t)
 	req := require.New(t)
@@ -139,6 +207,35 @@ func TestUpgradeRelease_CleanupOnFail(t *testing.T) {
 	is.Equal(res.Info.Status, release.StatusFailed)
 }
 
+func TestUpgradeRelease_RollbackStagesOnFail(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	upAction := upgradeAction(t)
+	rel := releaseStub()
+	rel.Name = "come-fail-away"
+	rel.Info.Status = release.StatusDeployed
+	upAction.cfg.Releases.Create(rel)
+
+	var logged []string
+	upAction.cfg.Log = func(format string, _ ...interface{}) {
+		logged = append(logged, format)
+	}
+
+	failer := upAction.cfg.KubeClient.(*kubefake.FailingKubeClient)
+	failer.WaitError = fmt.Errorf("I timed out")
+	upAction.cfg.KubeClient = failer
+	upAction.Wait = true
+	upAction.RollbackStagesOnFail = true
+	vals := map[string]interface{}{}
+
+	res, err := upAction.Run(rel.Name, buildChart(), vals)
+	req.Error(err)
+	is.Contains(res.Info.Description, "I timed out")
+	is.Equal(res.Info.Status, release.StatusFailed)
+	is.Contains(logged, "applied stages have been rolled back")
+}
+
 func TestUpgradeRelease_Atomic(t *testing.T) {
 	is := assert.New(t)
 	req := require.New(t)
@@ -381,6 +478,105 @@ func TestUpgradeRelease_Pending(t *testing.T) {
 	req.Contains(err.Error(), "progress", err)
 }
 
+func TestUpgradeRelease_Resume(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	upAction := upgradeAction(t)
+	rel := releaseStub()
+	rel.Name = "resumed-release"
+	rel.Info.Status = release.StatusDeployed
+	req.NoError(upAction.cfg.Releases.Create(rel))
+
+	vals := map[string]interface{}{}
+
+	// Manifests depending on the revision are resumed as well.
+	withRevision := func(opts *chartOptions) {
+		opts.Templates = append(opts.Templates, &chart.File{Name: "templates/revision", Data: []byte("revision: {{ .Release.Revision }}")})
+	}
+
+	res, err := upAction.Run(rel.Name, buildChart(withRevision), vals)
+	req.NoError(err)
+
+	// Pretend the upgrade was interrupted right after the first stage was applied.
+	interrupted, err := upAction.cfg.Releases.Get(rel.Name, res.Version)
+	req.NoError(err)
+	interrupted.Info.Status = release.StatusPendingUpgrade
+	release.SetRolloutPhaseStageInfo(interrupted, 0)
+	req.NoError(upAction.cfg.Releases.Update(interrupted))
+
+	upAction.Resume = true
+	res, err = upAction.Run(rel.Name, buildChart(withRevision), vals)
+	req.NoError(err)
+	is.Equal(interrupted.Version, res.Version)
+	is.Equal(release.StatusDeployed, res.Info.Status)
+
+	history, err := upAction.cfg.Releases.History(rel.Name)
+	req.NoError(err)
+	is.Len(history, 2)
+
+	// A release with different manifests can't be resumed.
+	interrupted.Info.Status = release.StatusPendingUpgrade
+	req.NoError(upAction.cfg.Releases.Update(interrupted))
+
+	_, err = upAction.Run(rel.Name, buildChart(withSampleTemplates()), vals)
+	req.Error(err)
+	is.Contains(err.Error(), "differ from the rendered ones")
+}
+
+func TestUpgradeRelease_ServerDryRunDiff(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	upAction := upgradeAction(t)
+	rel := releaseStub()
+	rel.Name = "diffed-release"
+	rel.Info.Status = release.StatusDeployed
+	req.NoError(upAction.cfg.Releases.Create(rel))
+
+	upAction.ServerDryRunDiff = true
+	res, err := upAction.Run(rel.Name, buildChart(), map[string]interface{}{})
+	req.NoError(err)
+	is.Equal("Dry run complete", res.Info.Description)
+
+	req.NotNil(upAction.Diff)
+	is.Len(upAction.Diff.Stages, 1)
+	is.Empty(upAction.Diff.Orphaned)
+
+	history, err := upAction.cfg.Releases.History(rel.Name)
+	req.NoError(err)
+	is.Len(history, 1)
+}
+
+func TestUpgradeRelease_Events(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	upAction := upgradeAction(t)
+	rel := releaseStub()
+	rel.Name = "observed-release"
+	rel.Info.Status = release.StatusDeployed
+	req.NoError(upAction.cfg.Releases.Create(rel))
+
+	var mux sync.Mutex
+	var types []string
+	recorder := events.RecorderFunc(func(event events.Event) {
+		mux.Lock()
+		defer mux.Unlock()
+		types = append(types, event.Type())
+	})
+	upAction.cfg.Events = recorder
+	upAction.cfg.Releases.Events = recorder
+
+	_, err := upAction.Run(rel.Name, buildChart(), map[string]interface{}{})
+	req.NoError(err)
+
+	is.Contains(types, events.TypeStageStarted)
+	is.Contains(types, events.TypeHookStarted)
+	is.Contains(types, events.TypeReleaseRecorded)
+	is.NotContains(types, events.TypeHookFailed)
+}
+
 func TestUpgradeRelease_Interrupted_Wait(t *testing.T) {
 
 	is := assert.New(t)
@@ -535,3 +731,26 @@ func TestUpgradeRelease_SystemLabels(t *testing.T) {
 
 	is.Equal(fmt.Errorf("user suplied labels contains system reserved label name. System labels: %+v", driver.GetSystemLabels()), err)
 }
+
+func TestDryRunOneByOne(t *testing.T) {
+	is := assert.New(t)
+	req := require.New(t)
+
+	namespaced := &resource.Info{Name: "app", Namespace: "new", Object: &unstructured.Unstructured{Object: map[string]interface{}{"kind": "ConfigMap"}}}
+	existing := &resource.Info{Name: "settings", Namespace: "default", Object: &unstructured.Unstructured{Object: map[string]interface{}{"kind": "ConfigMap"}}}
+
+	result, unverified, err := dryRunOneByOne(kube.ResourceList{namespaced, existing}, func(resources kube.ResourceList) (*kube.Result, error) {
+		if resources[0] == namespaced {
+			return &kube.Result{}, apierrors.NewNotFound(schema.GroupResource{Resource: "namespaces"}, "new")
+		}
+		return &kube.Result{Updated: resources}, nil
+	})
+	req.NoError(err)
+	is.Equal([]string{"new:ConfigMap/app"}, unverified)
+	is.Equal(kube.ResourceList{existing}, result.Updated)
+
+	_, _, err = dryRunOneByOne(kube.ResourceList{existing}, func(kube.ResourceList) (*kube.Result, error) {
+		return nil, errors.New("invalid resource")
+	})
+	is.Error(err)
+}
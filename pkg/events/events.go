@@ -0,0 +1,172 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	TypeStageStarted    = "StageStarted"
+	TypeResourceApplied = "ResourceApplied"
+	TypeResourceReady   = "ResourceReady"
+	TypeHookStarted     = "HookStarted"
+	TypeHookFailed      = "HookFailed"
+	TypeOrphanDeleted   = "OrphanDeleted"
+	TypeReleaseRecorded = "ReleaseRecorded"
+)
+
+// Recorder receives rollout progress events. Implementations must be safe for
+// concurrent use, since resources and stages can be deployed in parallel.
+type Recorder interface {
+	Record(event Event)
+}
+
+// RecorderFunc is a function used as a Recorder.
+type RecorderFunc func(event Event)
+
+func (f RecorderFunc) Record(event Event) {
+	f(event)
+}
+
+// Record passes the event to the recorder, if there is one.
+func Record(recorder Recorder, event Event) {
+	if recorder != nil {
+		recorder.Record(event)
+	}
+}
+
+type Event interface {
+	Type() string
+	Timestamp() time.Time
+}
+
+// Meta holds the fields common for all events.
+type Meta struct {
+	Time time.Time `json:"time"`
+}
+
+func (m Meta) Timestamp() time.Time {
+	return m.Time
+}
+
+func newMeta() Meta {
+	return Meta{Time: time.Now()}
+}
+
+// StageStarted is emitted before the resources of a rollout stage are applied.
+type StageStarted struct {
+	Meta
+	StageIndex int `json:"stageIndex"`
+	Weight     int `json:"weight"`
+	Resources  int `json:"resources"`
+}
+
+func NewStageStarted(stageIndex, weight, resources int) *StageStarted {
+	return &StageStarted{Meta: newMeta(), StageIndex: stageIndex, Weight: weight, Resources: resources}
+}
+
+func (e *StageStarted) Type() string { return TypeStageStarted }
+
+// ResourceApplied is emitted when a resource is created or updated.
+type ResourceApplied struct {
+	Meta
+	Resource  string `json:"resource"`
+	Operation string `json:"operation"`
+}
+
+const (
+	OperationCreate = "create"
+	OperationUpdate = "update"
+)
+
+func NewResourceApplied(resource, operation string) *ResourceApplied {
+	return &ResourceApplied{Meta: newMeta(), Resource: resource, Operation: operation}
+}
+
+func (e *ResourceApplied) Type() string { return TypeResourceApplied }
+
+// ResourceReady is emitted when a waited resource becomes ready.
+type ResourceReady struct {
+	Meta
+	Resource string `json:"resource"`
+}
+
+func NewResourceReady(resource string) *ResourceReady {
+	return &ResourceReady{Meta: newMeta(), Resource: resource}
+}
+
+func (e *ResourceReady) Type() string { return TypeResourceReady }
+
+// HookStarted is emitted before a hook resource is created.
+type HookStarted struct {
+	Meta
+	Hook  string `json:"hook"`
+	Event string `json:"event"`
+}
+
+func NewHookStarted(hook, event string) *HookStarted {
+	return &HookStarted{Meta: newMeta(), Hook: hook, Event: event}
+}
+
+func (e *HookStarted) Type() string { return TypeHookStarted }
+
+// HookFailed is emitted when a hook couldn't be created or didn't succeed.
+type HookFailed struct {
+	Meta
+	Hook  string `json:"hook"`
+	Event string `json:"event"`
+	Error string `json:"error"`
+}
+
+func NewHookFailed(hook, event string, err error) *HookFailed {
+	return &HookFailed{Meta: newMeta(), Hook: hook, Event: event, Error: err.Error()}
+}
+
+func (e *HookFailed) Type() string { return TypeHookFailed }
+
+// OrphanDeleted is emitted when a previously deployed resource, which is not in
+// the release anymore, is deleted.
+type OrphanDeleted struct {
+	Meta
+	Resource string `json:"resource"`
+}
+
+func NewOrphanDeleted(resource string) *OrphanDeleted {
+	return &OrphanDeleted{Meta: newMeta(), Resource: resource}
+}
+
+func (e *OrphanDeleted) Type() string { return TypeOrphanDeleted }
+
+// ReleaseRecorded is emitted when a release is saved to the storage.
+type ReleaseRecorded struct {
+	Meta
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Revision  int    `json:"revision"`
+	Status    string `json:"status"`
+}
+
+func NewReleaseRecorded(name, namespace string, revision int, status string) *ReleaseRecorded {
+	return &ReleaseRecorded{Meta: newMeta(), Name: name, Namespace: namespace, Revision: revision, Status: status}
+}
+
+func (e *ReleaseRecorded) Type() string { return TypeReleaseRecorded }
+
+// NewJSONLinesRecorder returns a Recorder which writes every event to w as a
+// separate line of JSON.
+func NewJSONLinesRecorder(w io.Writer) Recorder {
+	var mux sync.Mutex
+	encoder := json.NewEncoder(w)
+
+	return RecorderFunc(func(event Event) {
+		mux.Lock()
+		defer mux.Unlock()
+
+		_ = encoder.Encode(struct {
+			Type  string `json:"type"`
+			Event Event  `json:"event"`
+		}{Type: event.Type(), Event: event})
+	})
+}
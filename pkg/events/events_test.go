@@ -0,0 +1,39 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONLinesRecorder(t *testing.T) {
+	var buf bytes.Buffer
+	recorder := NewJSONLinesRecorder(&buf)
+
+	recorder.Record(NewStageStarted(1, 10, 3))
+	recorder.Record(NewResourceApplied("default:Deployment/web", OperationUpdate))
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines, got %d: %q", len(lines), buf.String())
+	}
+
+	var line struct {
+		Type  string          `json:"type"`
+		Event ResourceApplied `json:"event"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &line); err != nil {
+		t.Fatal(err)
+	}
+
+	if line.Type != TypeResourceApplied {
+		t.Errorf("expected type %q, got %q", TypeResourceApplied, line.Type)
+	}
+	if line.Event.Resource != "default:Deployment/web" || line.Event.Operation != OperationUpdate {
+		t.Errorf("unexpected event %+v", line.Event)
+	}
+	if line.Event.Time.IsZero() {
+		t.Error("expected event time to be set")
+	}
+}
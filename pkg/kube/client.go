@@ -37,6 +37,7 @@ import (
 	apiextv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 
+	"github.com/werf/3p-helm-for-werf-helm/pkg/events"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/releaseutil"
 
 	multierror "github.com/hashicorp/go-multierror"
@@ -66,6 +67,10 @@ var ErrNoObjectsVisited = errors.New("no objects visited")
 
 var metadataAccessor = meta.NewAccessor()
 
+// defaultDeleteWaitTimeout limits waiting for deleted resources if
+// DeleteOptions has no WaitTimeout.
+const defaultDeleteWaitTimeout = 5 * time.Minute
+
 // ManagedFieldsManager is the name of the manager of Kubernetes managedFields
 // first introduced in Kubernetes 1.18
 var ManagedFieldsManager string
@@ -89,6 +94,12 @@ type Client struct {
 
 	ResourcesWaiter ResourcesWaiter
 	Extender        ClientExtender
+	// ReadinessRules are used by Wait and WaitWithJobs instead of the
+	// built-in readiness checks of the kinds they are registered for, and
+	// for custom resources, which have no built-in checks.
+	ReadinessRules *ReadinessRules
+	// Events receives ResourceApplied and ResourceReady events.
+	Events events.Recorder
 }
 
 var addToScheme sync.Once
@@ -145,6 +156,12 @@ func (c *Client) IsReachable() error {
 
 // Create creates Kubernetes resources specified in the resource list.
 func (c *Client) Create(resources ResourceList, opts CreateOptions) (*Result, error) {
+	return c.CreateWithContext(context.Background(), resources, opts)
+}
+
+// CreateWithContext creates Kubernetes resources specified in the resource
+// list. Resources are not created anymore once ctx is done.
+func (c *Client) CreateWithContext(ctx context.Context, resources ResourceList, opts CreateOptions) (*Result, error) {
 	if c.Extender != nil {
 		if err := perform(resources, c.Extender.BeforeCreateResource); err != nil {
 			return &Result{}, err
@@ -153,14 +170,42 @@ func (c *Client) Create(resources ResourceList, opts CreateOptions) (*Result, er
 
 	c.Log("creating %d resource(s)", len(resources))
 
+	create := func(info *resource.Info) (performResourceStatus, error) {
+		return createResource(info, opts.DryRun)
+	}
+	createNotExisting := create
+	if opts.ServerSideApply {
+		create = func(info *resource.Info) (performResourceStatus, error) {
+			if err := applyNewResource(info, opts.ForceConflicts, opts.DryRun); err != nil {
+				return resourceStatusUnknown, err
+			}
+
+			return resourceStatusCreated, nil
+		}
+		// The resource is already checked not to exist.
+		createNotExisting = func(info *resource.Info) (performResourceStatus, error) {
+			if err := applyResource(info, opts.ForceConflicts, opts.DryRun); err != nil {
+				return resourceStatusUnknown, err
+			}
+
+			return resourceStatusCreated, nil
+		}
+	}
+
 	var fn func(*resource.Info) (performResourceStatus, error)
 	if opts.SkipIfAlreadyExists {
-		fn = createResourceSkipIfExists
+		fn = func(info *resource.Info) (performResourceStatus, error) {
+			return createResourceSkipIfExists(info, createNotExisting)
+		}
 	} else {
-		fn = createResource
+		fn = create
 	}
 
-	return performWithResult(resources, fn)
+	if !opts.DryRun {
+		fn = c.withAppliedEvents(fn)
+	}
+
+	return performWithResult(resources, opts.Concurrency, withContextCheck(ctx, fn), nil)
 }
 
 func transformRequests(req *rest.Request) {
@@ -299,41 +344,80 @@ func getResource(info *resource.Info) (runtime.Object, error) {
 }
 
 // Wait waits up to the given timeout for the specified resources to be ready.
+// The timeout and the way of waiting can be overridden for a resource with the
+// WaitTimeoutAnnotation and WaitPolicyAnnotation annotations.
 func (c *Client) Wait(resources ResourceList, timeout time.Duration) error {
-	if c.ResourcesWaiter != nil {
-		return c.ResourcesWaiter.Wait(context.Background(), resources, timeout)
-	}
+	return c.WaitWithContext(context.Background(), resources, timeout)
+}
 
-	cs, err := c.getKubeClient()
-	if err != nil {
-		return err
-	}
-	checker := NewReadyChecker(cs, c.Log, PausedAsReady(true))
-	w := waiter{
-		c:       checker,
-		log:     c.Log,
-		timeout: timeout,
-	}
-	return w.waitForResources(resources)
+// WaitWithContext is Wait which stops waiting once ctx is done.
+func (c *Client) WaitWithContext(ctx context.Context, resources ResourceList, timeout time.Duration) error {
+	return c.wait(ctx, resources, timeout, PausedAsReady(true), WithReadinessRules(c.ReadinessRules))
 }
 
 // WaitWithJobs wait up to the given timeout for the specified resources to be ready, including jobs.
 func (c *Client) WaitWithJobs(resources ResourceList, timeout time.Duration) error {
-	if c.ResourcesWaiter != nil {
-		return c.ResourcesWaiter.Wait(context.Background(), resources, timeout)
+	return c.WaitWithJobsWithContext(context.Background(), resources, timeout)
+}
+
+// WaitWithJobsWithContext is WaitWithJobs which stops waiting once ctx is done.
+func (c *Client) WaitWithJobsWithContext(ctx context.Context, resources ResourceList, timeout time.Duration) error {
+	return c.wait(ctx, resources, timeout, PausedAsReady(true), CheckJobs(true), WithReadinessRules(c.ReadinessRules))
+}
+
+func (c *Client) wait(ctx context.Context, resources ResourceList, timeout time.Duration, opts ...ReadyCheckerOption) error {
+	var checker ReadyChecker
+	if c.ResourcesWaiter == nil {
+		cs, err := c.getKubeClient()
+		if err != nil {
+			return err
+		}
+		checker = NewReadyChecker(cs, c.Log, opts...)
 	}
 
-	cs, err := c.getKubeClient()
-	if err != nil {
+	return waitGroups(groupByWaitAnnotations(resources, timeout, c.Log), func(group *waitGroup) error {
+		w := waiter{
+			log:     c.Log,
+			timeout: group.timeout,
+			events:  c.Events,
+		}
+
+		if group.policy == WaitPolicyPresent {
+			return w.waitForPresentResources(ctx, group.resources)
+		}
+
+		if c.ResourcesWaiter != nil {
+			return c.waitWithResourcesWaiter(ctx, group.resources, group.timeout)
+		}
+
+		w.c = checker
+
+		return w.waitForResources(ctx, group.resources)
+	})
+}
+
+func (c *Client) waitWithResourcesWaiter(ctx context.Context, resources ResourceList, timeout time.Duration) error {
+	if err := c.ResourcesWaiter.Wait(ctx, resources, timeout); err != nil {
 		return err
 	}
-	checker := NewReadyChecker(cs, c.Log, PausedAsReady(true), CheckJobs(true))
-	w := waiter{
-		c:       checker,
-		log:     c.Log,
-		timeout: timeout,
+
+	for _, info := range resources {
+		events.Record(c.Events, events.NewResourceReady(ResourceNameNamespaceKind(info)))
 	}
-	return w.waitForResources(resources)
+
+	return nil
+}
+
+// NewWatchWaiter creates a WatchWaiter for the cluster of the client, which can
+// be set as the ResourcesWaiter of the client to wait for resources using
+// watches instead of polling.
+func (c *Client) NewWatchWaiter() (*WatchWaiter, error) {
+	dynamicClient, err := c.Factory.DynamicClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewWatchWaiter(dynamicClient, c.Log, PausedAsReady(true), CheckJobs(true), WithReadinessRules(c.ReadinessRules)), nil
 }
 
 // WaitForDelete wait up to the given timeout for the specified resources to be deleted.
@@ -342,7 +426,7 @@ func (c *Client) WaitForDelete(resources ResourceList, timeout time.Duration) er
 		log:     c.Log,
 		timeout: timeout,
 	}
-	return w.waitForDeletedResources(resources)
+	return w.waitForDeletedResources(context.Background(), resources)
 }
 
 func (c *Client) namespace() string {
@@ -411,69 +495,57 @@ func (c *Client) BuildTable(reader io.Reader, validate bool) (ResourceList, erro
 // occurs, a Result will still be returned with the error, containing all
 // resource updates, creations, and deletions that were attempted. These can be
 // used for cleanup or other logging purposes.
+//
+// Resources are created and updated one by one, unless opts.Concurrency is
+// above one. Then resources of the same kind are processed in parallel, at
+// most opts.Concurrency at once, and the Extender must be safe for concurrent
+// use. Failing to update an existing resource doesn't stop the other resources from
+// being processed, while other errors stop the processing after the resources
+// of the same kind.
 func (c *Client) Update(original, target ResourceList, force bool, opts UpdateOptions) (*Result, error) {
+	return c.UpdateWithContext(context.Background(), original, target, force, opts)
+}
+
+// UpdateWithContext is Update which doesn't create, update or delete resources
+// anymore once ctx is done.
+func (c *Client) UpdateWithContext(ctx context.Context, original, target ResourceList, force bool, opts UpdateOptions) (*Result, error) {
 	updateErrors := []string{}
 	res := &Result{}
 
 	c.Log("checking %d resources for changes", len(target))
-	err := target.Visit(func(info *resource.Info, err error) error {
-		if err != nil {
-			return err
-		}
 
-		helper := resource.NewHelper(info.Client, info.Mapping).WithFieldManager(getManagedFieldsManager())
-		if _, err := helper.Get(info.Namespace, info.Name); err != nil {
-			if !apierrors.IsNotFound(err) {
-				return errors.Wrap(err, "could not get information about the resource")
-			}
-
-			if c.Extender != nil {
-				if err := c.Extender.BeforeCreateResource(info); err != nil {
-					return err
-				}
-			}
-			// Since the resource does not exist, create it.
-			if _, err := createResource(info); err != nil {
-				return errors.Wrap(err, "failed to create resource")
-			}
-
-			res.Created = append(res.Created, info)
-
-			kind := info.Mapping.GroupVersionKind.Kind
-			c.Log("Created a new %s called %q in %s\n", kind, info.Name, info.Namespace)
-			return nil
-		}
+	createOrUpdate := func(info *resource.Info) (performResourceStatus, error) {
+		return c.createOrUpdateResource(info, original, force, opts)
+	}
+	if !opts.DryRun {
+		createOrUpdate = c.withAppliedEvents(createOrUpdate)
+	}
+	createOrUpdate = withContextCheck(ctx, createOrUpdate)
 
-		originalInfo := original.Get(info)
-		if originalInfo == nil {
-			kind := info.Mapping.GroupVersionKind.Kind
-			return errors.Errorf("no %s with the name %q found", kind, info.Name)
+	if len(target) > 0 {
+		var err error
+		res, err = performWithResult(target, opts.Concurrency, createOrUpdate, isUpdateResourceError)
+		if err != nil {
+			return res, err
 		}
 
-		if c.Extender != nil {
-			if err := c.Extender.BeforeUpdateResource(info); err != nil {
-				return err
-			}
+		for _, resErr := range res.Errors {
+			updateErrors = append(updateErrors, resErr.Err.Error())
 		}
-
-		if err := updateResource(c, info, originalInfo.Object, force); err != nil {
-			c.Log("error updating the resource %q:\n\t %v", info.Name, err)
-			updateErrors = append(updateErrors, err.Error())
-		} else {
-			res.Updated = append(res.Updated, info)
+		if len(updateErrors) != 0 {
+			return res, errors.New(strings.Join(updateErrors, " && "))
 		}
+	}
 
-		return nil
-	})
-
-	switch {
-	case err != nil:
-		return res, err
-	case len(updateErrors) != 0:
-		return res, errors.Errorf(strings.Join(updateErrors, " && "))
+	if opts.DryRun {
+		return res, nil
 	}
 
 	for _, info := range original.Difference(target) {
+		if err := ctx.Err(); err != nil {
+			return res, err
+		}
+
 		c.Log("Deleting %s %q in namespace %s...", info.Mapping.GroupVersionKind.Kind, info.Name, info.Namespace)
 
 		if err := info.Get(); err != nil {
@@ -510,12 +582,134 @@ func (c *Client) Update(original, target ResourceList, force bool, opts UpdateOp
 	return res, nil
 }
 
+// withAppliedEvents wraps fn to emit a ResourceApplied event for every
+// resource it creates or updates.
+func (c *Client) withAppliedEvents(fn func(*resource.Info) (performResourceStatus, error)) func(*resource.Info) (performResourceStatus, error) {
+	return func(info *resource.Info) (performResourceStatus, error) {
+		status, err := fn(info)
+		if err != nil {
+			return status, err
+		}
+
+		switch status {
+		case resourceStatusCreated:
+			events.Record(c.Events, events.NewResourceApplied(ResourceNameNamespaceKind(info), events.OperationCreate))
+		case resourceStatusUpdated:
+			events.Record(c.Events, events.NewResourceApplied(ResourceNameNamespaceKind(info), events.OperationUpdate))
+		}
+
+		return status, nil
+	}
+}
+
+// updateResourceError is returned by createOrUpdateResource if the resource
+// exists, but patching it failed. Update goes on with other resources then.
+type updateResourceError struct {
+	err error
+}
+
+func isUpdateResourceError(err error) bool {
+	var updateErr *updateResourceError
+	return errors.As(err, &updateErr)
+}
+
+func (e *updateResourceError) Error() string {
+	return e.err.Error()
+}
+
+func (e *updateResourceError) Unwrap() error {
+	return e.err
+}
+
+// createOrUpdateResource creates the target resource if it doesn't exist in
+// the cluster, or updates it from its original state otherwise.
+func (c *Client) createOrUpdateResource(info *resource.Info, original ResourceList, force bool, opts UpdateOptions) (performResourceStatus, error) {
+	helper := resource.NewHelper(info.Client, info.Mapping).WithFieldManager(getManagedFieldsManager())
+	if _, err := helper.Get(info.Namespace, info.Name); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return resourceStatusUnknown, errors.Wrap(err, "could not get information about the resource")
+		}
+
+		if c.Extender != nil {
+			if err := c.Extender.BeforeCreateResource(info); err != nil {
+				return resourceStatusUnknown, err
+			}
+		}
+		// Since the resource does not exist, create it.
+		if opts.ServerSideApply {
+			if err := applyResource(info, opts.ForceConflicts, opts.DryRun); err != nil {
+				return resourceStatusUnknown, err
+			}
+		} else if _, err := createResource(info, opts.DryRun); err != nil {
+			return resourceStatusUnknown, errors.Wrap(err, "failed to create resource")
+		}
+
+		kind := info.Mapping.GroupVersionKind.Kind
+		c.Log("Created a new %s called %q in %s\n", kind, info.Name, info.Namespace)
+		return resourceStatusCreated, nil
+	}
+
+	originalInfo := original.Get(info)
+	if originalInfo == nil {
+		kind := info.Mapping.GroupVersionKind.Kind
+		return resourceStatusUnknown, errors.Errorf("no %s with the name %q found", kind, info.Name)
+	}
+
+	if c.Extender != nil {
+		if err := c.Extender.BeforeUpdateResource(info); err != nil {
+			return resourceStatusUnknown, err
+		}
+	}
+
+	if opts.ServerSideApply {
+		c.Log("Apply %s %q in namespace %s on the server side", info.Mapping.GroupVersionKind.Kind, info.Name, info.Namespace)
+		if err := applyResource(info, opts.ForceConflicts, opts.DryRun); err != nil {
+			c.Log("error updating the resource %q:\n\t %v", info.Name, err)
+			return resourceStatusUnknown, &updateResourceError{err: err}
+		}
+	} else if err := updateResource(c, info, originalInfo.Object, force, opts.DryRun); err != nil {
+		c.Log("error updating the resource %q:\n\t %v", info.Name, err)
+		return resourceStatusUnknown, &updateResourceError{err: err}
+	}
+
+	return resourceStatusUpdated, nil
+}
+
+// GetLive returns copies of the resources with their objects fetched from the
+// cluster. Resources that don't exist in the cluster are omitted.
+func (c *Client) GetLive(resources ResourceList) (ResourceList, error) {
+	var live ResourceList
+	for _, info := range resources {
+		obj, err := resource.NewHelper(info.Client, info.Mapping).Get(info.Namespace, info.Name)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return nil, errors.Wrapf(err, "could not get %s", ResourceNameNamespaceKind(info))
+		}
+
+		liveInfo := *info
+		if err := liveInfo.Refresh(obj, true); err != nil {
+			return nil, errors.Wrapf(err, "could not refresh %s", ResourceNameNamespaceKind(info))
+		}
+		live = append(live, &liveInfo)
+	}
+
+	return live, nil
+}
+
 // Delete deletes Kubernetes resources specified in the resources list with
 // background cascade deletion. It will attempt to delete all resources even
 // if one or more fail and collect any errors. All successfully deleted items
 // will be returned in the `Deleted` ResourceList that is part of the result.
 func (c *Client) Delete(resources ResourceList, opts DeleteOptions) (*Result, []error) {
-	return rdelete(c, resources, metav1.DeletePropagationBackground, opts)
+	return c.DeleteWithContext(context.Background(), resources, opts)
+}
+
+// DeleteWithContext is Delete which doesn't delete resources and stops waiting
+// for their deletion once ctx is done.
+func (c *Client) DeleteWithContext(ctx context.Context, resources ResourceList, opts DeleteOptions) (*Result, []error) {
+	return rdelete(ctx, c, resources, metav1.DeletePropagationBackground, opts)
 }
 
 // Delete deletes Kubernetes resources specified in the resources list with
@@ -523,14 +717,27 @@ func (c *Client) Delete(resources ResourceList, opts DeleteOptions) (*Result, []
 // if one or more fail and collect any errors. All successfully deleted items
 // will be returned in the `Deleted` ResourceList that is part of the result.
 func (c *Client) DeleteWithPropagationPolicy(resources ResourceList, policy metav1.DeletionPropagation, opts DeleteOptions) (*Result, []error) {
-	return rdelete(c, resources, policy, opts)
+	return rdelete(context.Background(), c, resources, policy, opts)
+}
+
+// DeleteWithPropagationPolicyWithContext is DeleteWithPropagationPolicy which
+// stops deleting and waiting once ctx is done.
+func (c *Client) DeleteWithPropagationPolicyWithContext(ctx context.Context, resources ResourceList, policy metav1.DeletionPropagation, opts DeleteOptions) (*Result, []error) {
+	return rdelete(ctx, c, resources, policy, opts)
 }
 
-func rdelete(c *Client, resources ResourceList, propagation metav1.DeletionPropagation, opts DeleteOptions) (*Result, []error) {
+func rdelete(ctx context.Context, c *Client, resources ResourceList, propagation metav1.DeletionPropagation, opts DeleteOptions) (*Result, []error) {
 	var errs []error
 	res := &Result{}
 	mtx := sync.Mutex{}
 	err := perform(resources, func(info *resource.Info) error {
+		if err := ctx.Err(); err != nil {
+			mtx.Lock()
+			defer mtx.Unlock()
+			errs = append(errs, err)
+			return nil
+		}
+
 		if opts.SkipIfInvalidOwnership {
 			if err := info.Get(); err != nil {
 				c.Log("Skipping delete of %q due to inability to get the object from cluster: %s", info.Name, err)
@@ -580,7 +787,18 @@ func rdelete(c *Client, resources ResourceList, propagation metav1.DeletionPropa
 		return res, errs
 	}
 
-	if opts.Wait {
+	if opts.Wait && c.ResourcesWaiter == nil {
+		w := waiter{
+			log:     c.Log,
+			timeout: opts.WaitTimeout,
+		}
+		if w.timeout == 0 {
+			w.timeout = defaultDeleteWaitTimeout
+		}
+		if err := w.waitForDeletedResources(ctx, res.Deleted); err != nil {
+			return res, []error{fmt.Errorf("waiting until resources are deleted failed: %s", err)}
+		}
+	} else if opts.Wait {
 		var specs []*ResourcesWaiterDeleteResourceSpec
 		for _, resource := range res.Deleted {
 			specs = append(specs, &ResourcesWaiterDeleteResourceSpec{
@@ -590,7 +808,7 @@ func rdelete(c *Client, resources ResourceList, propagation metav1.DeletionPropa
 			})
 		}
 
-		if err := c.ResourcesWaiter.WaitUntilDeleted(context.Background(), specs, opts.WaitTimeout); err != nil {
+		if err := c.ResourcesWaiter.WaitUntilDeleted(ctx, specs, opts.WaitTimeout); err != nil {
 			return res, []error{fmt.Errorf("waiting until resources are deleted failed: %s", err)}
 		}
 	}
@@ -598,9 +816,9 @@ func rdelete(c *Client, resources ResourceList, propagation metav1.DeletionPropa
 	return res, nil
 }
 
-func (c *Client) watchTimeout(t time.Duration) func(*resource.Info) error {
+func (c *Client) watchTimeout(ctx context.Context, t time.Duration) func(*resource.Info) error {
 	return func(info *resource.Info) error {
-		return c.watchUntilReady(t, info)
+		return c.watchUntilReady(ctx, t, info)
 	}
 }
 
@@ -619,13 +837,19 @@ func (c *Client) watchTimeout(t time.Duration) func(*resource.Info) error {
 //
 // Handling for other kinds will be added as necessary.
 func (c *Client) WatchUntilReady(resources ResourceList, timeout time.Duration) error {
+	return c.WatchUntilReadyWithContext(context.Background(), resources, timeout)
+}
+
+// WatchUntilReadyWithContext is WatchUntilReady which stops watching once ctx
+// is done.
+func (c *Client) WatchUntilReadyWithContext(ctx context.Context, resources ResourceList, timeout time.Duration) error {
 	if c.ResourcesWaiter != nil {
-		return c.ResourcesWaiter.WatchUntilReady(context.Background(), resources, timeout)
+		return c.ResourcesWaiter.WatchUntilReady(ctx, resources, timeout)
 	}
 
 	// For jobs, there's also the option to do poll c.Jobs(namespace).Get():
 	// https://github.com/adamreese/kubernetes/blob/master/test/e2e/job.go#L291-L300
-	return perform(resources, c.watchTimeout(timeout))
+	return perform(resources, c.watchTimeout(ctx, timeout))
 }
 
 func perform(infos ResourceList, fn func(*resource.Info) error) error {
@@ -685,8 +909,8 @@ func batchPerform(infos ResourceList, fn func(*resource.Info) error, errs chan<-
 	}
 }
 
-func createResource(info *resource.Info) (performResourceStatus, error) {
-	obj, err := resource.NewHelper(info.Client, info.Mapping).WithFieldManager(getManagedFieldsManager()).Create(info.Namespace, true, info.Object)
+func createResource(info *resource.Info, dryRun bool) (performResourceStatus, error) {
+	obj, err := resource.NewHelper(info.Client, info.Mapping).WithFieldManager(getManagedFieldsManager()).DryRun(dryRun).Create(info.Namespace, true, info.Object)
 	if err != nil {
 		return resourceStatusUnknown, err
 	}
@@ -694,10 +918,10 @@ func createResource(info *resource.Info) (performResourceStatus, error) {
 	return resourceStatusCreated, info.Refresh(obj, true)
 }
 
-func createResourceSkipIfExists(info *resource.Info) (performResourceStatus, error) {
+func createResourceSkipIfExists(info *resource.Info, create func(*resource.Info) (performResourceStatus, error)) (performResourceStatus, error) {
 	_, err := resource.NewHelper(info.Client, info.Mapping).Get(info.Namespace, info.Name)
 	if apierrors.IsNotFound(err) {
-		return createResource(info)
+		return create(info)
 	} else if err != nil {
 		return resourceStatusUnknown, err
 	}
@@ -761,10 +985,10 @@ func createPatch(target *resource.Info, current runtime.Object) ([]byte, types.P
 	return patch, types.StrategicMergePatchType, err
 }
 
-func updateResource(c *Client, target *resource.Info, currentObj runtime.Object, force bool) error {
+func updateResource(c *Client, target *resource.Info, currentObj runtime.Object, force, dryRun bool) error {
 	var (
 		obj    runtime.Object
-		helper = resource.NewHelper(target.Client, target.Mapping).WithFieldManager(getManagedFieldsManager())
+		helper = resource.NewHelper(target.Client, target.Mapping).WithFieldManager(getManagedFieldsManager()).DryRun(dryRun)
 		kind   = target.Mapping.GroupVersionKind.Kind
 	)
 
@@ -803,7 +1027,7 @@ func updateResource(c *Client, target *resource.Info, currentObj runtime.Object,
 	return nil
 }
 
-func (c *Client) watchUntilReady(timeout time.Duration, info *resource.Info) error {
+func (c *Client) watchUntilReady(ctx context.Context, timeout time.Duration, info *resource.Info) error {
 	kind := info.Mapping.GroupVersionKind.Kind
 	switch kind {
 	case "Job", "Pod":
@@ -827,7 +1051,7 @@ func (c *Client) watchUntilReady(timeout time.Duration, info *resource.Info) err
 	// In the future, we might want to add some special logic for types
 	// like Ingress, Volume, etc.
 
-	ctx, cancel := watchtools.ContextWithOptionalTimeout(context.Background(), timeout)
+	ctx, cancel := watchtools.ContextWithOptionalTimeout(ctx, timeout)
 	defer cancel()
 	_, err = watchtools.UntilWithSync(ctx, lw, &unstructured.Unstructured{}, nil, func(e watch.Event) (bool, error) {
 		// Make sure the incoming object is versioned as we use unstructured
@@ -922,12 +1146,18 @@ func scrubValidationError(err error) error {
 // WaitAndGetCompletedPodPhase waits up to a timeout until a pod enters a completed phase
 // and returns said phase (PodSucceeded or PodFailed qualify).
 func (c *Client) WaitAndGetCompletedPodPhase(name string, timeout time.Duration) (v1.PodPhase, error) {
+	return c.WaitAndGetCompletedPodPhaseWithContext(context.Background(), name, timeout)
+}
+
+// WaitAndGetCompletedPodPhaseWithContext is WaitAndGetCompletedPodPhase which
+// stops waiting once ctx is done.
+func (c *Client) WaitAndGetCompletedPodPhaseWithContext(ctx context.Context, name string, timeout time.Duration) (v1.PodPhase, error) {
 	client, err := c.getKubeClient()
 	if err != nil {
 		return v1.PodUnknown, err
 	}
 	to := int64(timeout)
-	watcher, err := client.CoreV1().Pods(c.namespace()).Watch(context.Background(), metav1.ListOptions{
+	watcher, err := client.CoreV1().Pods(c.namespace()).Watch(ctx, metav1.ListOptions{
 		FieldSelector:  fmt.Sprintf("metadata.name=%s", name),
 		TimeoutSeconds: &to,
 	})
@@ -960,7 +1190,14 @@ const (
 	resourceStatusDeleted
 )
 
-func performWithResult(infos ResourceList, fn func(*resource.Info) (performResourceStatus, error)) (*Result, error) {
+// performWithResult calls fn for every resource, grouped by GroupKind. Groups
+// are processed one after another, while resources of the same group are
+// processed in parallel, at most concurrency at once. A concurrency below two
+// processes the resources one by one.
+// The error of each failed resource is added to the Result. Processing stops
+// after the first group with an error that tolerate doesn't accept, and that
+// error is returned. A nil tolerate accepts no errors.
+func performWithResult(infos ResourceList, concurrency int, fn func(*resource.Info) (performResourceStatus, error), tolerate func(error) bool) (*Result, error) {
 	if len(infos) == 0 {
 		return &Result{}, ErrNoObjectsVisited
 	}
@@ -975,26 +1212,41 @@ func performWithResult(infos ResourceList, fn func(*resource.Info) (performResou
 
 	result := &Result{}
 
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
 	for _, resList := range infosByGK {
+		sem := make(chan struct{}, concurrency)
+
 		performResultsCh := make(chan performResult, len(resList))
-		for _, res := range resList {
-			resC := res
-			go func() {
-				status, err := fn(resC)
-				performResultsCh <- performResult{
-					resource: resC,
-					status:   status,
-					error:    err,
-				}
-			}()
-		}
+		go func(resList ResourceList) {
+			for _, res := range resList {
+				sem <- struct{}{}
+
+				resC := res
+				go func() {
+					status, err := fn(resC)
+					<-sem
+
+					performResultsCh <- performResult{
+						resource: resC,
+						status:   status,
+						error:    err,
+					}
+				}()
+			}
+		}(resList)
 
-		var errs []error
+		var fatalErr error
 		for range resList {
 			perfRes := <-performResultsCh
 
 			if perfRes.error != nil {
-				errs = append(errs, perfRes.error)
+				if fatalErr == nil && (tolerate == nil || !tolerate(perfRes.error)) {
+					fatalErr = perfRes.error
+				}
+				result.Errors = append(result.Errors, &ResourceError{Resource: perfRes.resource, Err: perfRes.error})
 				continue
 			}
 
@@ -1011,14 +1263,25 @@ func performWithResult(infos ResourceList, fn func(*resource.Info) (performResou
 			}
 		}
 
-		if len(errs) > 0 {
-			return result, errs[0]
+		if fatalErr != nil {
+			return result, fatalErr
 		}
 	}
 
 	return result, nil
 }
 
+// withContextCheck wraps fn to fail without calling it once ctx is done.
+func withContextCheck(ctx context.Context, fn func(*resource.Info) (performResourceStatus, error)) func(*resource.Info) (performResourceStatus, error) {
+	return func(info *resource.Info) (performResourceStatus, error) {
+		if err := ctx.Err(); err != nil {
+			return resourceStatusUnknown, err
+		}
+
+		return fn(info)
+	}
+}
+
 func groupInfosByGK(infos ResourceList) []ResourceList {
 	var infosByGK []ResourceList
 	var lastGK schema.GroupKind
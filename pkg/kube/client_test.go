@@ -18,14 +18,20 @@ package kube
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net/http"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/cli-runtime/pkg/resource"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest/fake"
@@ -166,7 +172,7 @@ func TestUpdate(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	result, err := c.Update(first, second, false, UpdateOptions{})
+	result, err := c.Update(first, second, false, UpdateOptions{Concurrency: 1})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -558,3 +564,252 @@ spec:
         ports:
         - containerPort: 80
 `
+
+func TestPerformWithResultConcurrency(t *testing.T) {
+	c := newTestClient(t)
+	infos, err := c.Build(objBody(newPodListPtr("starfish", "otter", "squid", "dolphin", "whale")), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mux sync.Mutex
+	var running, maxRunning int
+	fn := func(info *resource.Info) (performResourceStatus, error) {
+		mux.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		mux.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mux.Lock()
+		running--
+		mux.Unlock()
+
+		if info.Name == "squid" {
+			return resourceStatusUnknown, errors.New("boom")
+		}
+		return resourceStatusCreated, nil
+	}
+
+	result, err := performWithResult(infos, 2, fn, nil)
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	if maxRunning > 2 {
+		t.Errorf("expected at most 2 resources processed at once, got %d", maxRunning)
+	}
+
+	if len(result.Created) != 4 {
+		t.Errorf("expected 4 resources created, got %d", len(result.Created))
+	}
+
+	if len(result.Errors) != 1 || result.Errors[0].Resource.Name != "squid" {
+		t.Errorf("expected single error for resource \"squid\", got %v", result.Errors)
+	}
+}
+
+func TestPerformWithResultSerialByDefault(t *testing.T) {
+	c := newTestClient(t)
+	infos, err := c.Build(objBody(newPodListPtr("starfish", "otter", "squid")), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mux sync.Mutex
+	var running, maxRunning int
+	var order []string
+	fn := func(info *resource.Info) (performResourceStatus, error) {
+		mux.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		order = append(order, info.Name)
+		mux.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mux.Lock()
+		running--
+		mux.Unlock()
+
+		return resourceStatusCreated, nil
+	}
+
+	if _, err := performWithResult(infos, 0, fn, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if maxRunning != 1 {
+		t.Errorf("expected resources to be processed one by one, got %d at once", maxRunning)
+	}
+	if expected := []string{"starfish", "otter", "squid"}; !reflect.DeepEqual(expected, order) {
+		t.Errorf("expected resources to be processed in order %v, got %v", expected, order)
+	}
+}
+
+func newPodListPtr(names ...string) *v1.PodList {
+	list := newPodList(names...)
+	return &list
+}
+
+func TestCreateServerSideApplyConflict(t *testing.T) {
+	c := newTestClient(t)
+	c.Factory.(*cmdtesting.TestFactory).UnstructuredClient = &fake.RESTClient{
+		NegotiatedSerializer: unstructuredSerializer,
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			p, m := req.URL.Path, req.Method
+			if p == "/namespaces/default/pods/starfish" && m == "GET" {
+				return newResponse(404, notFoundBody())
+			}
+			if p != "/namespaces/default/pods/starfish" || m != "PATCH" {
+				t.Fatalf("unexpected request: %s %s", m, p)
+			}
+
+			if contentType := req.Header.Get("Content-Type"); contentType != string(types.ApplyPatchType) {
+				t.Errorf("expected apply patch, got %q", contentType)
+			}
+			if force := req.URL.Query().Get("force"); force != "false" {
+				t.Errorf("expected conflicts not to be forced, got force=%q", force)
+			}
+
+			return newResponse(409, &metav1.Status{
+				Status: metav1.StatusFailure,
+				Reason: metav1.StatusReasonConflict,
+				Code:   409,
+				Details: &metav1.StatusDetails{
+					Causes: []metav1.StatusCause{{
+						Type:    metav1.CauseTypeFieldManagerConflict,
+						Message: `conflict with "kube-controller-manager" using v1`,
+						Field:   ".spec.containers",
+					}},
+				},
+			})
+		}),
+	}
+
+	resources, err := c.Build(objBody(newPodListPtr("starfish")), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := c.Create(resources, CreateOptions{ServerSideApply: true})
+	if err == nil {
+		t.Fatal("expected error, got nil")
+	}
+
+	var conflictErr *ApplyConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ApplyConflictError, got %T: %s", err, err)
+	}
+
+	expected := []ApplyConflict{{
+		Manager: "kube-controller-manager",
+		Field:   ".spec.containers",
+		Message: `conflict with "kube-controller-manager" using v1`,
+	}}
+	if !reflect.DeepEqual(conflictErr.Conflicts, expected) {
+		t.Errorf("expected conflicts %v, got %v", expected, conflictErr.Conflicts)
+	}
+
+	if len(result.Errors) != 1 {
+		t.Errorf("expected 1 resource error, got %d", len(result.Errors))
+	}
+}
+
+func TestCreateServerSideApplyAlreadyExists(t *testing.T) {
+	c := newTestClient(t)
+	pod := newPod("starfish")
+	c.Factory.(*cmdtesting.TestFactory).UnstructuredClient = &fake.RESTClient{
+		NegotiatedSerializer: unstructuredSerializer,
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			p, m := req.URL.Path, req.Method
+			if p != "/namespaces/default/pods/starfish" || m != "GET" {
+				t.Fatalf("unexpected request: %s %s", m, p)
+			}
+
+			return newResponse(200, &pod)
+		}),
+	}
+
+	resources, err := c.Build(objBody(newPodListPtr("starfish")), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Create(resources, CreateOptions{ServerSideApply: true}); !apierrors.IsAlreadyExists(err) {
+		t.Fatalf("expected AlreadyExists error, got %v", err)
+	}
+}
+
+func TestPerformWithResultTolerate(t *testing.T) {
+	c := newTestClient(t)
+	pods, err := c.Build(objBody(newPodListPtr("starfish", "otter")), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services, err := c.Build(strings.NewReader("apiVersion: v1\nkind: Service\nmetadata:\n  name: whale\n  namespace: default\n"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tolerated := errors.New("tolerated")
+	fn := func(info *resource.Info) (performResourceStatus, error) {
+		if info.Name == "otter" {
+			return resourceStatusUnknown, tolerated
+		}
+		return resourceStatusUpdated, nil
+	}
+
+	result, err := performWithResult(append(pods, services...), 1, fn, func(err error) bool { return err == tolerated })
+	if err != nil {
+		t.Fatalf("expected tolerated error not to be returned, got %v", err)
+	}
+	if len(result.Updated) != 2 || len(result.Errors) != 1 {
+		t.Errorf("expected the following kinds to be processed after the tolerated error, got %d updated and %d errors", len(result.Updated), len(result.Errors))
+	}
+
+	if _, err := performWithResult(append(pods, services...), 1, fn, nil); err != tolerated {
+		t.Errorf("expected error to stop processing without tolerate, got %v", err)
+	}
+}
+
+func TestDeleteWaitWithoutTimeout(t *testing.T) {
+	var deleted bool
+	pod := newPod("starfish")
+	c := newTestClient(t)
+	c.Factory.(*cmdtesting.TestFactory).UnstructuredClient = &fake.RESTClient{
+		NegotiatedSerializer: unstructuredSerializer,
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			switch req.Method {
+			case "DELETE":
+				deleted = true
+				return newResponse(200, &metav1.Status{Status: metav1.StatusSuccess})
+			case "GET":
+				if deleted {
+					return newResponse(404, notFoundBody())
+				}
+				return newResponse(200, &pod)
+			}
+			t.Fatalf("unexpected request: %s %s", req.Method, req.URL.Path)
+			return nil, nil
+		}),
+	}
+
+	resources, err := c.Build(objBody(&pod), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, errs := c.Delete(resources, DeleteOptions{Wait: true})
+	if errs != nil {
+		t.Fatalf("expected deleted resources to be waited for with the default timeout, got %v", errs)
+	}
+	if len(result.Deleted) != 1 {
+		t.Errorf("expected 1 resource to be deleted, got %d", len(result.Deleted))
+	}
+}
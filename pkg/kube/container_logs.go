@@ -0,0 +1,119 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// containerLogsLimitBytes limits the logs of a single container, so the
+// captured logs stay small enough to be stored in the release.
+const containerLogsLimitBytes = 64 * 1024
+
+// ContainerLogs are the logs of a single container of a pod.
+type ContainerLogs struct {
+	Pod       string
+	Container string
+	Logs      string
+}
+
+// GetContainerLogs returns the last tailLines lines of the logs of all
+// containers of the given Pods and of the pods of the given Jobs. Other
+// resources are ignored. Logs that couldn't be fetched are skipped, and the
+// errors are returned along with the fetched logs.
+func (c *Client) GetContainerLogs(resources ResourceList, tailLines int64) ([]ContainerLogs, error) {
+	client, err := c.getKubeClient()
+	if err != nil {
+		return nil, fmt.Errorf("error getting kubernetes client: %w", err)
+	}
+
+	return getResourcesContainerLogs(client, resources, tailLines)
+}
+
+func getResourcesContainerLogs(client kubernetes.Interface, resources ResourceList, tailLines int64) ([]ContainerLogs, error) {
+	var logs []ContainerLogs
+	var errs []string
+	for _, info := range resources {
+		pods, err := podsOfResource(client, info.Namespace, info.Name, info.Mapping.GroupVersionKind.GroupKind().String())
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", ResourceNameNamespaceKind(info), err))
+			continue
+		}
+
+		for _, pod := range pods {
+			containers := append(append([]v1.Container{}, pod.Spec.InitContainers...), pod.Spec.Containers...)
+			for _, container := range containers {
+				containerLogs, err := getContainerLogs(client, pod.Namespace, pod.Name, container.Name, tailLines)
+				if err != nil {
+					errs = append(errs, fmt.Sprintf("pod %s container %s: %s", pod.Name, container.Name, err))
+					continue
+				}
+
+				logs = append(logs, ContainerLogs{Pod: pod.Name, Container: container.Name, Logs: containerLogs})
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return logs, fmt.Errorf("error getting container logs: %s", strings.Join(errs, "; "))
+	}
+
+	return logs, nil
+}
+
+func podsOfResource(client kubernetes.Interface, namespace, name, groupKind string) ([]v1.Pod, error) {
+	switch groupKind {
+	case "Pod":
+		pod, err := client.CoreV1().Pods(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		return []v1.Pod{*pod}, nil
+	case "Job.batch":
+		job, err := client.BatchV1().Jobs(namespace).Get(context.Background(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+
+		if job.Spec.Selector == nil {
+			return nil, nil
+		}
+
+		pods, err := client.CoreV1().Pods(namespace).List(context.Background(), metav1.ListOptions{
+			LabelSelector: metav1.FormatLabelSelector(job.Spec.Selector),
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		return pods.Items, nil
+	default:
+		return nil, nil
+	}
+}
+
+func getContainerLogs(client kubernetes.Interface, namespace, pod, container string, tailLines int64) (string, error) {
+	limitBytes := int64(containerLogsLimitBytes)
+	stream, err := client.CoreV1().Pods(namespace).GetLogs(pod, &v1.PodLogOptions{
+		Container:  container,
+		TailLines:  &tailLines,
+		LimitBytes: &limitBytes,
+	}).Stream(context.Background())
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+
+	data, err := io.ReadAll(stream)
+	if err != nil {
+		return "", err
+	}
+
+	return string(data), nil
+}
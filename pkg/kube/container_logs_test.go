@@ -0,0 +1,81 @@
+package kube
+
+import (
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestGetResourcesContainerLogs(t *testing.T) {
+	hookPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "hook-pod", Namespace: "default"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "init"}},
+			Containers:     []corev1.Container{{Name: "main"}},
+		},
+	}
+	jobPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "job-pod", Namespace: "default", Labels: map[string]string{"job-name": "hook-job"}},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "job"}}},
+	}
+	otherPod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "other-pod", Namespace: "default"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "other"}}},
+	}
+	job := &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{Name: "hook-job", Namespace: "default"},
+		Spec: batchv1.JobSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"job-name": "hook-job"}},
+		},
+	}
+	client := fake.NewSimpleClientset(hookPod, jobPod, otherPod, job)
+
+	info := func(gvk schema.GroupVersionKind, name string) *resource.Info {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		obj.SetNamespace("default")
+		obj.SetName(name)
+		return &resource.Info{Name: name, Namespace: "default", Object: obj, Mapping: &meta.RESTMapping{GroupVersionKind: gvk}}
+	}
+	podGVK := schema.GroupVersionKind{Version: "v1", Kind: "Pod"}
+	jobGVK := schema.GroupVersionKind{Group: "batch", Version: "v1", Kind: "Job"}
+	configMapGVK := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+
+	logs, err := getResourcesContainerLogs(client, ResourceList{
+		info(podGVK, "hook-pod"),
+		info(jobGVK, "hook-job"),
+		info(configMapGVK, "settings"),
+	}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []ContainerLogs{
+		{Pod: "hook-pod", Container: "init", Logs: "fake logs"},
+		{Pod: "hook-pod", Container: "main", Logs: "fake logs"},
+		{Pod: "job-pod", Container: "job", Logs: "fake logs"},
+	}
+	if len(logs) != len(expected) {
+		t.Fatalf("expected logs %v, got %v", expected, logs)
+	}
+	for i := range expected {
+		if logs[i] != expected[i] {
+			t.Errorf("expected logs %v, got %v", expected[i], logs[i])
+		}
+	}
+
+	logs, err = getResourcesContainerLogs(client, ResourceList{info(podGVK, "missing"), info(podGVK, "hook-pod")}, 10)
+	if err == nil {
+		t.Error("expected error for missing pod")
+	}
+	if len(logs) != 2 {
+		t.Errorf("expected logs of the existing pod to be returned along with the error, got %v", logs)
+	}
+}
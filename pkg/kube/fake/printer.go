@@ -57,6 +57,11 @@ func (p *PrintingKubeClient) Get(resources kube.ResourceList, _ bool) (map[strin
 	return make(map[string][]runtime.Object), nil
 }
 
+// GetLive returns the given resources as if they matched the cluster state.
+func (p *PrintingKubeClient) GetLive(resources kube.ResourceList) (kube.ResourceList, error) {
+	return resources, nil
+}
+
 func (p *PrintingKubeClient) Wait(resources kube.ResourceList, _ time.Duration) error {
 	_, err := io.Copy(p.Out, bufferize(resources))
 	return err
@@ -90,6 +90,13 @@ type InterfaceDeletionPropagation interface {
 	DeleteWithPropagationPolicy(resources ResourceList, policy metav1.DeletionPropagation, opts DeleteOptions) (*Result, []error)
 }
 
+// InterfaceDeletionPropagationContext is introduced to avoid breaking backwards compatibility for Interface implementers.
+type InterfaceDeletionPropagationContext interface {
+	// DeleteWithPropagationPolicyWithContext is DeleteWithPropagationPolicy
+	// which stops its API calls and waiting once ctx is done.
+	DeleteWithPropagationPolicyWithContext(ctx context.Context, resources ResourceList, policy metav1.DeletionPropagation, opts DeleteOptions) (*Result, []error)
+}
+
 // InterfaceResources is introduced to avoid breaking backwards compatibility for Interface implementers.
 //
 // TODO Helm 4: Remove InterfaceResources and integrate its method(s) into the Interface.
@@ -112,23 +119,83 @@ type InterfaceResources interface {
 	BuildTable(reader io.Reader, validate bool) (ResourceList, error)
 }
 
+// InterfaceLive is introduced to avoid breaking backwards compatibility for Interface implementers.
+type InterfaceLive interface {
+	// GetLive returns copies of the resources with their objects fetched from
+	// the cluster. Resources that don't exist in the cluster are omitted.
+	GetLive(resources ResourceList) (ResourceList, error)
+}
+
+// InterfaceLogs is introduced to avoid breaking backwards compatibility for Interface implementers.
+type InterfaceLogs interface {
+	// GetContainerLogs returns the last tailLines lines of the logs of all
+	// containers of the given Pods and of the pods of the given Jobs.
+	GetContainerLogs(resources ResourceList, tailLines int64) ([]ContainerLogs, error)
+}
+
+// InterfaceContext is introduced to avoid breaking backwards compatibility for Interface implementers.
+//
+// Its methods are the ones of Interface which stop their API calls and waiting
+// once the context is done. Use AsInterfaceContext to get it for any Interface.
+type InterfaceContext interface {
+	CreateWithContext(ctx context.Context, resources ResourceList, opts CreateOptions) (*Result, error)
+	UpdateWithContext(ctx context.Context, original, target ResourceList, force bool, opts UpdateOptions) (*Result, error)
+	DeleteWithContext(ctx context.Context, resources ResourceList, opts DeleteOptions) (*Result, []error)
+	WaitWithContext(ctx context.Context, resources ResourceList, timeout time.Duration) error
+	WaitWithJobsWithContext(ctx context.Context, resources ResourceList, timeout time.Duration) error
+	WatchUntilReadyWithContext(ctx context.Context, resources ResourceList, timeout time.Duration) error
+	WaitAndGetCompletedPodPhaseWithContext(ctx context.Context, name string, timeout time.Duration) (v1.PodPhase, error)
+}
+
 var _ Interface = (*Client)(nil)
 var _ InterfaceExt = (*Client)(nil)
 var _ InterfaceDeletionPropagation = (*Client)(nil)
+var _ InterfaceDeletionPropagationContext = (*Client)(nil)
 var _ InterfaceResources = (*Client)(nil)
+var _ InterfaceLive = (*Client)(nil)
+var _ InterfaceLogs = (*Client)(nil)
+var _ InterfaceContext = (*Client)(nil)
 
 type CreateOptions struct {
 	SkipIfAlreadyExists bool
+	// Concurrency is the number of resources of the same kind created at
+	// once. Resources are created one by one if it is below two.
+	Concurrency int
+	// ServerSideApply makes resources to be created with server-side apply.
+	ServerSideApply bool
+	// ForceConflicts makes server-side apply take ownership of fields managed
+	// by other field managers instead of failing with ApplyConflictError.
+	ForceConflicts bool
+	// DryRun sends the requests as server-side dry-run, so nothing is
+	// persisted and the resources are refreshed with what would be created.
+	DryRun bool
 }
 
 type UpdateOptions struct {
 	SkipDeleteIfInvalidOwnership bool
 	ReleaseName                  string // Required if SkipDeleteIfInvalidOwnership == true
 	ReleaseNamespace             string // Required if SkipDeleteIfInvalidOwnership == true
+	// Concurrency is the number of resources of the same kind created or
+	// updated at once. Resources are processed one by one if it is below two.
+	Concurrency int
+	// ServerSideApply makes resources to be created and updated with
+	// server-side apply instead of patches computed on the client. The force
+	// argument of Update is ignored in this mode.
+	ServerSideApply bool
+	// ForceConflicts makes server-side apply take ownership of fields managed
+	// by other field managers instead of failing with ApplyConflictError.
+	ForceConflicts bool
+	// DryRun sends the requests as server-side dry-run, so nothing is
+	// persisted and the resources are refreshed with what would be applied.
+	// Resources absent in the target list aren't deleted then.
+	DryRun bool
 }
 
 type DeleteOptions struct {
-	Wait                   bool
+	Wait bool
+	// WaitTimeout limits waiting for the resources to be deleted. Zero means
+	// the default timeout of five minutes, unless the client has a
+	// ResourcesWaiter, which gets the timeout as is.
 	WaitTimeout            time.Duration
 	SkipIfInvalidOwnership bool
 	ReleaseName            string // Required if SkipIfInvalidOwnership == true
@@ -0,0 +1,80 @@
+package kube
+
+import (
+	"context"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// AsInterfaceContext returns the client itself if it implements
+// InterfaceContext. Otherwise, it returns an InterfaceContext which calls the
+// methods of Interface only while the context is not done, so a cancelled
+// context at least prevents the following API calls.
+func AsInterfaceContext(client Interface) InterfaceContext {
+	if ctxClient, ok := client.(InterfaceContext); ok {
+		return ctxClient
+	}
+
+	return &contextCheckingClient{client: client}
+}
+
+type contextCheckingClient struct {
+	client Interface
+}
+
+func (c *contextCheckingClient) CreateWithContext(ctx context.Context, resources ResourceList, opts CreateOptions) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return &Result{}, err
+	}
+
+	return c.client.Create(resources, opts)
+}
+
+func (c *contextCheckingClient) UpdateWithContext(ctx context.Context, original, target ResourceList, force bool, opts UpdateOptions) (*Result, error) {
+	if err := ctx.Err(); err != nil {
+		return &Result{}, err
+	}
+
+	return c.client.Update(original, target, force, opts)
+}
+
+func (c *contextCheckingClient) DeleteWithContext(ctx context.Context, resources ResourceList, opts DeleteOptions) (*Result, []error) {
+	if err := ctx.Err(); err != nil {
+		return &Result{}, []error{err}
+	}
+
+	return c.client.Delete(resources, opts)
+}
+
+func (c *contextCheckingClient) WaitWithContext(ctx context.Context, resources ResourceList, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.client.Wait(resources, timeout)
+}
+
+func (c *contextCheckingClient) WaitWithJobsWithContext(ctx context.Context, resources ResourceList, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.client.WaitWithJobs(resources, timeout)
+}
+
+func (c *contextCheckingClient) WatchUntilReadyWithContext(ctx context.Context, resources ResourceList, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return c.client.WatchUntilReady(resources, timeout)
+}
+
+func (c *contextCheckingClient) WaitAndGetCompletedPodPhaseWithContext(ctx context.Context, name string, timeout time.Duration) (v1.PodPhase, error) {
+	if err := ctx.Err(); err != nil {
+		return v1.PodUnknown, err
+	}
+
+	return c.client.WaitAndGetCompletedPodPhase(name, timeout)
+}
@@ -0,0 +1,129 @@
+package kube
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// ReadinessRule decides whether a resource is ready by its live state.
+type ReadinessRule interface {
+	IsReady(obj *unstructured.Unstructured) (bool, error)
+}
+
+// ReadinessRuleFunc is a function used as a ReadinessRule.
+type ReadinessRuleFunc func(obj *unstructured.Unstructured) (bool, error)
+
+func (f ReadinessRuleFunc) IsReady(obj *unstructured.Unstructured) (bool, error) {
+	return f(obj)
+}
+
+// ReadinessRules is a registry of readiness rules keyed by GroupKind. A
+// resource is ready when all of the rules registered for its GroupKind are
+// satisfied. Rules registered for a GroupKind override the built-in checks of
+// ReadyChecker. Default rules apply to custom resources, i.e. the kinds
+// unknown to the Kubernetes native scheme, which have no registered rules.
+type ReadinessRules struct {
+	rules        map[schema.GroupKind][]ReadinessRule
+	defaultRules []ReadinessRule
+}
+
+func NewReadinessRules() *ReadinessRules {
+	return &ReadinessRules{
+		rules: map[schema.GroupKind][]ReadinessRule{},
+	}
+}
+
+// Register adds rules for the resources of the GroupKind.
+func (r *ReadinessRules) Register(gk schema.GroupKind, rules ...ReadinessRule) *ReadinessRules {
+	r.rules[gk] = append(r.rules[gk], rules...)
+
+	return r
+}
+
+// RegisterDefault adds rules for the custom resources without registered
+// rules.
+func (r *ReadinessRules) RegisterDefault(rules ...ReadinessRule) *ReadinessRules {
+	r.defaultRules = append(r.defaultRules, rules...)
+
+	return r
+}
+
+// Rules returns the rules registered for the GroupKind.
+func (r *ReadinessRules) Rules(gk schema.GroupKind) []ReadinessRule {
+	if r == nil {
+		return nil
+	}
+
+	return r.rules[gk]
+}
+
+// DefaultRules returns the rules for the custom resources without registered
+// rules.
+func (r *ReadinessRules) DefaultRules() []ReadinessRule {
+	if r == nil {
+		return nil
+	}
+
+	return r.defaultRules
+}
+
+// ConditionRule is satisfied when the status.conditions entry of the Type has
+// the "True" status. If there is no such condition, the rule is satisfied only
+// if AllowMissing is set, which makes it usable as a default rule for the
+// resources that may not report conditions at all.
+type ConditionRule struct {
+	Type         string
+	AllowMissing bool
+}
+
+func (r ConditionRule) IsReady(obj *unstructured.Unstructured) (bool, error) {
+	conditions, found, err := unstructured.NestedSlice(obj.Object, "status", "conditions")
+	if err != nil {
+		return false, fmt.Errorf("error getting status conditions: %w", err)
+	}
+
+	if found {
+		for _, c := range conditions {
+			condition, ok := c.(map[string]interface{})
+			if !ok || condition["type"] != r.Type {
+				continue
+			}
+
+			return condition["status"] == "True", nil
+		}
+	}
+
+	return r.AllowMissing, nil
+}
+
+// ObservedGenerationRule is satisfied when status.observedGeneration is not
+// less than metadata.generation, which means that the controller has seen the
+// last changes of the resource. Resources without status.observedGeneration
+// satisfy the rule.
+type ObservedGenerationRule struct{}
+
+func (ObservedGenerationRule) IsReady(obj *unstructured.Unstructured) (bool, error) {
+	observedGeneration, found, err := unstructured.NestedInt64(obj.Object, "status", "observedGeneration")
+	if err != nil {
+		return false, fmt.Errorf("error getting status observedGeneration: %w", err)
+	}
+
+	if !found {
+		return true, nil
+	}
+
+	return observedGeneration >= obj.GetGeneration(), nil
+}
+
+// rulesReady returns true if the object satisfies all of the rules.
+func rulesReady(obj *unstructured.Unstructured, rules []ReadinessRule) (bool, error) {
+	for _, rule := range rules {
+		if ready, err := rule.IsReady(obj); !ready || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,181 @@
+package kube
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/rest/fake"
+)
+
+func newCustomResource(generation int64, status map[string]interface{}) *unstructured.Unstructured {
+	obj := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "example.com/v1",
+		"kind":       "Database",
+		"metadata": map[string]interface{}{
+			"name":       "db",
+			"generation": generation,
+		},
+	}}
+	if status != nil {
+		obj.Object["status"] = status
+	}
+
+	return obj
+}
+
+func TestReadinessRules(t *testing.T) {
+	gk := schema.GroupKind{Group: "example.com", Kind: "Database"}
+	ready := ReadinessRuleFunc(func(_ *unstructured.Unstructured) (bool, error) { return true, nil })
+
+	rules := NewReadinessRules().Register(gk, ready, ConditionRule{Type: "Ready"}).RegisterDefault(ObservedGenerationRule{})
+
+	if got := len(rules.Rules(gk)); got != 2 {
+		t.Errorf("expected 2 rules for %s, got %d", gk, got)
+	}
+	if got := len(rules.Rules(schema.GroupKind{Kind: "ConfigMap"})); got != 0 {
+		t.Errorf("expected no rules for ConfigMap, got %d", got)
+	}
+	if got := len(rules.DefaultRules()); got != 1 {
+		t.Errorf("expected 1 default rule, got %d", got)
+	}
+
+	var nilRules *ReadinessRules
+	if nilRules.Rules(gk) != nil || nilRules.DefaultRules() != nil {
+		t.Error("expected no rules in nil registry")
+	}
+}
+
+func TestRulesReady(t *testing.T) {
+	conditions := func(status string) map[string]interface{} {
+		return map[string]interface{}{
+			"observedGeneration": int64(2),
+			"conditions": []interface{}{
+				map[string]interface{}{"type": "Synced", "status": "True"},
+				map[string]interface{}{"type": "Ready", "status": status},
+			},
+		}
+	}
+
+	tests := []struct {
+		name  string
+		obj   *unstructured.Unstructured
+		rules []ReadinessRule
+		want  bool
+	}{
+		{
+			name:  "ready condition is true",
+			obj:   newCustomResource(2, conditions("True")),
+			rules: []ReadinessRule{ConditionRule{Type: "Ready"}, ObservedGenerationRule{}},
+			want:  true,
+		},
+		{
+			name:  "ready condition is false",
+			obj:   newCustomResource(2, conditions("False")),
+			rules: []ReadinessRule{ConditionRule{Type: "Ready"}},
+			want:  false,
+		},
+		{
+			name:  "ready condition is missing",
+			obj:   newCustomResource(1, nil),
+			rules: []ReadinessRule{ConditionRule{Type: "Ready"}},
+			want:  false,
+		},
+		{
+			name:  "missing ready condition is allowed",
+			obj:   newCustomResource(1, nil),
+			rules: []ReadinessRule{ConditionRule{Type: "Ready", AllowMissing: true}, ObservedGenerationRule{}},
+			want:  true,
+		},
+		{
+			name:  "generation is not observed yet",
+			obj:   newCustomResource(3, conditions("True")),
+			rules: []ReadinessRule{ConditionRule{Type: "Ready"}, ObservedGenerationRule{}},
+			want:  false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rulesReady(tt.obj, tt.rules)
+			if err != nil {
+				t.Fatalf("rulesReady() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("rulesReady() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadyCheckerDefaultRules(t *testing.T) {
+	live := newCustomResource(3, map[string]interface{}{"observedGeneration": int64(2)})
+	applied := newCustomResource(3, nil)
+
+	restClient := &fake.RESTClient{
+		NegotiatedSerializer: unstructuredSerializer,
+		Client: fake.CreateHTTPClient(func(req *http.Request) (*http.Response, error) {
+			if req.URL.Path != "/namespaces/default/databases/db" {
+				t.Errorf("unexpected request: %s %s", req.Method, req.URL.Path)
+				return newResponse(404, notFoundBody())
+			}
+
+			body, err := live.MarshalJSON()
+			if err != nil {
+				return nil, err
+			}
+			header := http.Header{}
+			header.Set("Content-Type", runtime.ContentTypeJSON)
+			return &http.Response{StatusCode: 200, Header: header, Body: io.NopCloser(bytes.NewReader(body))}, nil
+		}),
+	}
+
+	checker := NewReadyChecker(nil, nil, WithReadinessRules(NewReadinessRules().RegisterDefault(ObservedGenerationRule{})))
+
+	customResource := &resource.Info{
+		Client:    restClient,
+		Namespace: "default",
+		Name:      "db",
+		Object:    applied,
+		Mapping: &meta.RESTMapping{
+			Resource:         schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "databases"},
+			GroupVersionKind: schema.GroupVersionKind{Group: "example.com", Version: "v1", Kind: "Database"},
+			Scope:            meta.RESTScopeNamespace,
+		},
+	}
+	ready, err := checker.IsReady(context.Background(), customResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ready {
+		t.Error("expected custom resource with not observed generation not to be ready")
+	}
+	if customResource.Object != applied {
+		t.Error("expected object of the resource not to be replaced with the live one")
+	}
+
+	configMap := &unstructured.Unstructured{}
+	configMap.SetAPIVersion("v1")
+	configMap.SetKind("ConfigMap")
+	configMap.SetName("settings")
+	ready, err = checker.IsReady(context.Background(), &resource.Info{
+		Client:    restClient,
+		Namespace: "default",
+		Name:      "settings",
+		Object:    configMap,
+		Mapping: &meta.RESTMapping{
+			Resource:         schema.GroupVersionResource{Version: "v1", Resource: "configmaps"},
+			GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+			Scope:            meta.RESTScopeNamespace,
+		},
+	})
+	if err != nil || !ready {
+		t.Errorf("expected ConfigMap to be ready without default rules, got %t, %v", ready, err)
+	}
+}
@@ -29,11 +29,11 @@ import (
 	apiextv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	apiextv1beta1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1beta1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/cli-runtime/pkg/resource"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/kubernetes/scheme"
 
 	deploymentutil "github.com/werf/3p-helm-for-werf-helm/internal/third_party/k8s.io/kubernetes/deployment/util"
 )
@@ -58,12 +58,29 @@ func CheckJobs(checkJobs bool) ReadyCheckerOption {
 	}
 }
 
+// WithReadinessRules returns a ReadyCheckerOption that configures a
+// ReadyChecker to check readiness of resources with the registered rules.
+func WithReadinessRules(rules *ReadinessRules) ReadyCheckerOption {
+	return func(c *ReadyChecker) {
+		c.rules = rules
+	}
+}
+
+// withReadyLister returns a ReadyCheckerOption that configures a ReadyChecker
+// to list pods and replica sets with the lister instead of the client.
+func withReadyLister(lister readyLister) ReadyCheckerOption {
+	return func(c *ReadyChecker) {
+		c.lister = lister
+	}
+}
+
 // NewReadyChecker creates a new checker. Passed ReadyCheckerOptions can
 // be used to override defaults.
 func NewReadyChecker(cl kubernetes.Interface, log func(string, ...interface{}), opts ...ReadyCheckerOption) ReadyChecker {
 	c := ReadyChecker{
 		client: cl,
 		log:    log,
+		lister: clientReadyLister{client: cl},
 	}
 	if c.log == nil {
 		c.log = nopLogger
@@ -80,123 +97,258 @@ type ReadyChecker struct {
 	log           func(string, ...interface{})
 	checkJobs     bool
 	pausedAsReady bool
+	rules         *ReadinessRules
+	lister        readyLister
+}
+
+// readyLister lists the pods and replica sets which readiness of resources
+// depends on.
+type readyLister interface {
+	listPods(ctx context.Context, namespace, selector string) ([]corev1.Pod, error)
+	listReplicaSets(ctx context.Context, namespace, selector string) ([]*appsv1.ReplicaSet, error)
+}
+
+// clientReadyLister lists pods and replica sets from the server.
+type clientReadyLister struct {
+	client kubernetes.Interface
+}
+
+func (l clientReadyLister) listPods(ctx context.Context, namespace, selector string) ([]corev1.Pod, error) {
+	return getPods(ctx, l.client, namespace, selector)
+}
+
+func (l clientReadyLister) listReplicaSets(ctx context.Context, namespace, selector string) ([]*appsv1.ReplicaSet, error) {
+	list, err := l.client.AppsV1().ReplicaSets(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	replicaSets := make([]*appsv1.ReplicaSet, 0, len(list.Items))
+	for i := range list.Items {
+		replicaSets = append(replicaSets, &list.Items[i])
+	}
+
+	return replicaSets, nil
 }
 
 // IsReady checks if v is ready. It supports checking readiness for pods,
 // deployments, persistent volume claims, services, daemon sets, custom
 // resource definitions, stateful sets, replication controllers, jobs (optional),
-// and replica sets. All other resource kinds are always considered ready.
+// and replica sets. Custom resources are checked with the default readiness
+// rules, and all other resource kinds are considered ready.
+//
+// If a resource, or a pod of it, is in a state from which it won't become
+// ready, such as ImagePullBackOff or ProgressDeadlineExceeded, IsReady returns
+// an UnrecoverableError.
+//
+// If readiness rules are registered for the GroupKind of v, they are used
+// instead of the built-in checks.
 //
 // IsReady will fetch the latest state of the object from the server prior to
 // performing readiness checks, and it will return any error encountered.
 func (c *ReadyChecker) IsReady(ctx context.Context, v *resource.Info) (bool, error) {
-	switch value := AsVersioned(v).(type) {
+	live, err := c.getLive(ctx, v)
+	if err != nil {
+		return false, err
+	}
+	if live == nil {
+		return true, nil
+	}
+
+	return c.isLiveReady(ctx, v, live)
+}
+
+// newLive returns an empty object of the type the latest state of v is checked
+// as, or nil if there are no readiness checks for v.
+func (c *ReadyChecker) newLive(v *resource.Info) runtime.Object {
+	if rules := c.rules.Rules(v.Object.GetObjectKind().GroupVersionKind().GroupKind()); len(rules) > 0 {
+		return &unstructured.Unstructured{}
+	}
+
+	switch AsVersioned(v).(type) {
 	case *corev1.Pod:
-		pod, err := c.client.CoreV1().Pods(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
-		if err != nil || !c.isPodReady(pod) {
-			return false, err
-		}
+		return &corev1.Pod{}
 	case *batchv1.Job:
 		if c.checkJobs {
-			job, err := c.client.BatchV1().Jobs(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
-			if err != nil {
-				return false, err
-			}
-			ready, err := c.jobReady(job)
-			return ready, err
+			return &batchv1.Job{}
 		}
 	case *appsv1.Deployment, *appsv1beta1.Deployment, *appsv1beta2.Deployment, *extensionsv1beta1.Deployment:
-		currentDeployment, err := c.client.AppsV1().Deployments(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
+		return &appsv1.Deployment{}
+	case *corev1.PersistentVolumeClaim:
+		return &corev1.PersistentVolumeClaim{}
+	case *corev1.Service:
+		return &corev1.Service{}
+	case *extensionsv1beta1.DaemonSet, *appsv1.DaemonSet, *appsv1beta2.DaemonSet:
+		return &appsv1.DaemonSet{}
+	case *apiextv1beta1.CustomResourceDefinition:
+		return &apiextv1beta1.CustomResourceDefinition{}
+	case *apiextv1.CustomResourceDefinition:
+		return &apiextv1.CustomResourceDefinition{}
+	case *appsv1.StatefulSet, *appsv1beta1.StatefulSet, *appsv1beta2.StatefulSet:
+		return &appsv1.StatefulSet{}
+	case *corev1.ReplicationController:
+		return &corev1.ReplicationController{}
+	case *extensionsv1beta1.ReplicaSet, *appsv1beta2.ReplicaSet, *appsv1.ReplicaSet:
+		return &appsv1.ReplicaSet{}
+	default:
+		if rules := c.rules.DefaultRules(); len(rules) > 0 && isCustomResource(v) {
+			return &unstructured.Unstructured{}
+		}
+	}
+
+	return nil
+}
+
+// getLive fetches the latest state of v from the server as the type returned
+// by newLive, or returns nil if there are no readiness checks for v.
+func (c *ReadyChecker) getLive(ctx context.Context, v *resource.Info) (runtime.Object, error) {
+	switch c.newLive(v).(type) {
+	case nil:
+		return nil, nil
+	case *corev1.Pod:
+		return c.client.CoreV1().Pods(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
+	case *batchv1.Job:
+		return c.client.BatchV1().Jobs(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
+	case *appsv1.Deployment:
+		return c.client.AppsV1().Deployments(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
+	case *corev1.PersistentVolumeClaim:
+		return c.client.CoreV1().PersistentVolumeClaims(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
+	case *corev1.Service:
+		return c.client.CoreV1().Services(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
+	case *appsv1.DaemonSet:
+		return c.client.AppsV1().DaemonSets(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
+	case *appsv1.StatefulSet:
+		return c.client.AppsV1().StatefulSets(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
+	case *corev1.ReplicationController:
+		return c.client.CoreV1().ReplicationControllers(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
+	case *appsv1.ReplicaSet:
+		return c.client.AppsV1().ReplicaSets(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
+	default:
+		obj, err := c.getUnstructured(ctx, v)
 		if err != nil {
-			return false, err
+			return nil, err
+		}
+
+		return c.liveFromUnstructured(v, obj)
+	}
+}
+
+// getUnstructured fetches the latest state of v from the server. The object
+// of v is left as is.
+func (c *ReadyChecker) getUnstructured(ctx context.Context, v *resource.Info) (*unstructured.Unstructured, error) {
+	raw, err := v.Client.Get().
+		NamespaceIfScoped(v.Namespace, v.Namespaced()).
+		Resource(v.Mapping.Resource.Resource).
+		Name(v.Name).
+		Do(ctx).
+		Raw()
+	if err != nil {
+		return nil, err
+	}
+
+	obj := &unstructured.Unstructured{}
+	if err := obj.UnmarshalJSON(raw); err != nil {
+		return nil, fmt.Errorf("error decoding %s: %w", ResourceNameNamespaceKind(v), err)
+	}
+
+	return obj, nil
+}
+
+// liveFromUnstructured converts the latest state of v to the type returned by
+// newLive, or returns nil if there are no readiness checks for v.
+func (c *ReadyChecker) liveFromUnstructured(v *resource.Info, obj *unstructured.Unstructured) (runtime.Object, error) {
+	switch live := c.newLive(v).(type) {
+	case nil:
+		return nil, nil
+	case *unstructured.Unstructured:
+		return obj, nil
+	default:
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, live); err != nil {
+			return nil, fmt.Errorf("error converting %s: %w", ResourceNameNamespaceKind(v), err)
+		}
+
+		return live, nil
+	}
+}
+
+// isLiveReady checks if v is ready by its latest state, which has the type
+// returned by newLive.
+func (c *ReadyChecker) isLiveReady(ctx context.Context, v *resource.Info, live runtime.Object) (bool, error) {
+	switch live := live.(type) {
+	case *unstructured.Unstructured:
+		rules := c.rules.Rules(v.Object.GetObjectKind().GroupVersionKind().GroupKind())
+		if len(rules) == 0 {
+			rules = c.rules.DefaultRules()
+		}
+		return c.rulesReady(v, live, rules)
+	case *corev1.Pod:
+		if !c.isPodReady(live) {
+			if failure := podFailure(ResourceNameNamespaceKind(v), live); failure != nil {
+				return false, failure
+			}
+			return false, nil
 		}
+	case *batchv1.Job:
+		return c.jobReady(ResourceNameNamespaceKind(v), live)
+	case *appsv1.Deployment:
 		// If paused deployment will never be ready
-		if currentDeployment.Spec.Paused {
+		if live.Spec.Paused {
 			return c.pausedAsReady, nil
 		}
+		// Conditions of a generation not observed yet are stale
+		if live.Status.ObservedGeneration != live.Generation {
+			c.log("Deployment is not ready: %s/%s. observedGeneration (%d) does not match spec generation (%d).", live.Namespace, live.Name, live.Status.ObservedGeneration, live.Generation)
+			return false, nil
+		}
+		if failure := deploymentFailure(ResourceNameNamespaceKind(v), live); failure != nil {
+			return false, failure
+		}
 		// Find RS associated with deployment
-		newReplicaSet, err := deploymentutil.GetNewReplicaSet(currentDeployment, c.client.AppsV1())
+		newReplicaSet, err := c.newReplicaSet(ctx, live)
 		if err != nil || newReplicaSet == nil {
 			return false, err
 		}
-		if !c.deploymentReady(newReplicaSet, currentDeployment) {
-			return false, nil
+		if !c.deploymentReady(newReplicaSet, live) {
+			// Pods of the old replica sets may fail while they are replaced
+			return false, c.podsFailure(ctx, ResourceNameNamespaceKind(v), v.Namespace, newReplicaSet)
 		}
 	case *corev1.PersistentVolumeClaim:
-		claim, err := c.client.CoreV1().PersistentVolumeClaims(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		if !c.volumeReady(claim) {
+		if !c.volumeReady(live) {
 			return false, nil
 		}
 	case *corev1.Service:
-		svc, err := c.client.CoreV1().Services(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		if !c.serviceReady(svc) {
+		if !c.serviceReady(live) {
 			return false, nil
 		}
-	case *extensionsv1beta1.DaemonSet, *appsv1.DaemonSet, *appsv1beta2.DaemonSet:
-		ds, err := c.client.AppsV1().DaemonSets(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		if !c.daemonSetReady(ds) {
-			return false, nil
+	case *appsv1.DaemonSet:
+		if !c.daemonSetReady(live) {
+			return false, c.podsFailure(ctx, ResourceNameNamespaceKind(v), v.Namespace, live)
 		}
 	case *apiextv1beta1.CustomResourceDefinition:
-		if err := v.Get(); err != nil {
-			return false, err
-		}
-		crd := &apiextv1beta1.CustomResourceDefinition{}
-		if err := scheme.Scheme.Convert(v.Object, crd, nil); err != nil {
-			return false, err
-		}
-		if !c.crdBetaReady(*crd) {
+		if !c.crdBetaReady(*live) {
 			return false, nil
 		}
 	case *apiextv1.CustomResourceDefinition:
-		if err := v.Get(); err != nil {
-			return false, err
-		}
-		crd := &apiextv1.CustomResourceDefinition{}
-		if err := scheme.Scheme.Convert(v.Object, crd, nil); err != nil {
-			return false, err
-		}
-		if !c.crdReady(*crd) {
+		if !c.crdReady(*live) {
 			return false, nil
 		}
-	case *appsv1.StatefulSet, *appsv1beta1.StatefulSet, *appsv1beta2.StatefulSet:
-		sts, err := c.client.AppsV1().StatefulSets(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		if !c.statefulSetReady(sts) {
-			return false, nil
+	case *appsv1.StatefulSet:
+		if !c.statefulSetReady(live) {
+			return false, c.podsFailure(ctx, ResourceNameNamespaceKind(v), v.Namespace, live)
 		}
 	case *corev1.ReplicationController:
-		rc, err := c.client.CoreV1().ReplicationControllers(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		if !c.replicationControllerReady(rc) {
+		if !c.replicationControllerReady(live) {
 			return false, nil
 		}
-		ready, err := c.podsReadyForObject(ctx, v.Namespace, value)
+		ready, err := c.podsReadyForObject(ctx, v.Namespace, live)
 		if !ready || err != nil {
 			return false, err
 		}
-	case *extensionsv1beta1.ReplicaSet, *appsv1beta2.ReplicaSet, *appsv1.ReplicaSet:
-		rs, err := c.client.AppsV1().ReplicaSets(v.Namespace).Get(ctx, v.Name, metav1.GetOptions{})
-		if err != nil {
-			return false, err
-		}
-		if !c.replicaSetReady(rs) {
+	case *appsv1.ReplicaSet:
+		if !c.replicaSetReady(live) {
 			return false, nil
 		}
-		ready, err := c.podsReadyForObject(ctx, v.Namespace, value)
+		ready, err := c.podsReadyForObject(ctx, v.Namespace, live)
 		if !ready || err != nil {
 			return false, err
 		}
@@ -204,6 +356,38 @@ func (c *ReadyChecker) IsReady(ctx context.Context, v *resource.Info) (bool, err
 	return true, nil
 }
 
+// newReplicaSet returns the replica set of the current pod template of the
+// deployment, or nil if it is not created yet.
+func (c *ReadyChecker) newReplicaSet(ctx context.Context, dep *appsv1.Deployment) (*appsv1.ReplicaSet, error) {
+	replicaSets, err := deploymentutil.ListReplicaSets(dep, func(namespace string, options metav1.ListOptions) ([]*appsv1.ReplicaSet, error) {
+		return c.lister.listReplicaSets(ctx, namespace, options.LabelSelector)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deploymentutil.FindNewReplicaSet(dep, replicaSets), nil
+}
+
+// rulesReady checks the latest state of v with the readiness rules.
+func (c *ReadyChecker) rulesReady(v *resource.Info, live *unstructured.Unstructured, rules []ReadinessRule) (bool, error) {
+	ready, err := rulesReady(live, rules)
+	if err != nil {
+		return false, fmt.Errorf("error checking readiness of %s: %w", ResourceNameNamespaceKind(v), err)
+	}
+	if !ready {
+		c.log("Resource is not ready: %s", ResourceNameNamespaceKind(v))
+	}
+
+	return ready, nil
+}
+
+// isCustomResource returns true if the kind of v is unknown to the Kubernetes
+// native scheme.
+func isCustomResource(v *resource.Info) bool {
+	return !kubernetesNativeScheme().Recognizes(v.Object.GetObjectKind().GroupVersionKind())
+}
+
 func (c *ReadyChecker) podsReadyForObject(ctx context.Context, namespace string, obj runtime.Object) (bool, error) {
 	pods, err := c.podsforObject(ctx, namespace, obj)
 	if err != nil {
@@ -222,8 +406,7 @@ func (c *ReadyChecker) podsforObject(ctx context.Context, namespace string, obj
 	if err != nil {
 		return nil, err
 	}
-	list, err := getPods(ctx, c.client, namespace, selector.String())
-	return list, err
+	return c.lister.listPods(ctx, namespace, selector.String())
 }
 
 // isPodReady returns true if a pod is ready; false otherwise.
@@ -237,11 +420,11 @@ func (c *ReadyChecker) isPodReady(pod *corev1.Pod) bool {
 	return false
 }
 
-func (c *ReadyChecker) jobReady(job *batchv1.Job) (bool, error) {
-	if job.Status.Failed > *job.Spec.BackoffLimit {
+func (c *ReadyChecker) jobReady(resource string, job *batchv1.Job) (bool, error) {
+	if failure := jobFailure(resource, job); failure != nil {
 		c.log("Job is failed: %s/%s", job.GetNamespace(), job.GetName())
 		// If a job is failed, it can't recover, so throw an error
-		return false, fmt.Errorf("job is failed: %s/%s", job.GetNamespace(), job.GetName())
+		return false, failure
 	}
 	if job.Spec.Completions != nil && job.Status.Succeeded < *job.Spec.Completions {
 		c.log("Job is not completed: %s/%s", job.GetNamespace(), job.GetName())
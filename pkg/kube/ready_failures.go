@@ -0,0 +1,134 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// crashLoopRestartsThreshold is the number of container restarts after which
+// a container in CrashLoopBackOff is considered failed. A few restarts are
+// tolerated, since containers often crash while their dependencies start.
+const crashLoopRestartsThreshold = 3
+
+// unrecoverableWaitingReasons are the reasons of waiting containers which
+// won't recover without changes to the resource.
+var unrecoverableWaitingReasons = map[string]bool{
+	"ImagePullBackOff":  true,
+	"InvalidImageName":  true,
+	"ErrImageNeverPull": true,
+}
+
+// UnrecoverableError is returned by ReadyChecker when a resource is in a state
+// from which it won't become ready, so there is no point in waiting for it
+// until the timeout.
+type UnrecoverableError struct {
+	// Resource is the name, namespace and kind of the waited resource.
+	Resource string
+	// Pod and Container are set if the failure is caused by a container.
+	Pod       string
+	Container string
+	Reason    string
+	Message   string
+}
+
+func (e *UnrecoverableError) Error() string {
+	msg := fmt.Sprintf("%s failed with %s", e.Resource, e.Reason)
+	if e.Container != "" {
+		msg = fmt.Sprintf("%s: container %q of pod %q is in %s", e.Resource, e.Container, e.Pod, e.Reason)
+	}
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+
+	return msg
+}
+
+// podFailure returns an UnrecoverableError if any container of the pod is in an
+// unrecoverable state.
+func podFailure(resource string, pod *corev1.Pod) *UnrecoverableError {
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		waiting := status.State.Waiting
+		if waiting == nil {
+			continue
+		}
+
+		if unrecoverableWaitingReasons[waiting.Reason] ||
+			waiting.Reason == "CrashLoopBackOff" && status.RestartCount >= crashLoopRestartsThreshold {
+			return &UnrecoverableError{
+				Resource:  resource,
+				Pod:       pod.Name,
+				Container: status.Name,
+				Reason:    waiting.Reason,
+				Message:   waiting.Message,
+			}
+		}
+	}
+
+	return nil
+}
+
+// podsFailure returns an UnrecoverableError if any pod of the object is in an
+// unrecoverable state.
+func (c *ReadyChecker) podsFailure(ctx context.Context, resource, namespace string, obj runtime.Object) error {
+	pods, err := c.podsforObject(ctx, namespace, obj)
+	if err != nil {
+		return err
+	}
+
+	for i := range pods {
+		if failure := podFailure(resource, &pods[i]); failure != nil {
+			c.log("Resource is failed: %s", failure)
+			return failure
+		}
+	}
+
+	return nil
+}
+
+// deploymentFailure returns an UnrecoverableError if the deployment exceeded
+// its progress deadline.
+func deploymentFailure(resource string, dep *appsv1.Deployment) *UnrecoverableError {
+	for _, condition := range dep.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing &&
+			condition.Status == corev1.ConditionFalse &&
+			condition.Reason == "ProgressDeadlineExceeded" {
+			return &UnrecoverableError{
+				Resource: resource,
+				Reason:   condition.Reason,
+				Message:  condition.Message,
+			}
+		}
+	}
+
+	return nil
+}
+
+// jobFailure returns an UnrecoverableError if the job is failed, e.g. its
+// backoff limit is reached.
+func jobFailure(resource string, job *batchv1.Job) *UnrecoverableError {
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return &UnrecoverableError{
+				Resource: resource,
+				Reason:   condition.Reason,
+				Message:  condition.Message,
+			}
+		}
+	}
+
+	if job.Spec.BackoffLimit != nil && job.Status.Failed > *job.Spec.BackoffLimit {
+		return &UnrecoverableError{
+			Resource: resource,
+			Reason:   "BackoffLimitExceeded",
+			Message:  fmt.Sprintf("%d pods failed, backoff limit is %d", job.Status.Failed, *job.Spec.BackoffLimit),
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,127 @@
+package kube
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func newPodWithWaitingContainer(name, reason string, restarts int32) *corev1.Pod {
+	pod := newPodWithCondition(name, corev1.ConditionFalse)
+	pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+		{
+			Name:         "app",
+			RestartCount: restarts,
+			State: corev1.ContainerState{
+				Waiting: &corev1.ContainerStateWaiting{Reason: reason, Message: "back-off pulling image"},
+			},
+		},
+	}
+
+	return pod
+}
+
+func Test_podFailure(t *testing.T) {
+	tests := []struct {
+		name    string
+		pod     *corev1.Pod
+		wantErr bool
+	}{
+		{name: "image pull back-off", pod: newPodWithWaitingContainer("foo", "ImagePullBackOff", 0), wantErr: true},
+		{name: "container is creating", pod: newPodWithWaitingContainer("foo", "ContainerCreating", 0)},
+		{name: "crash loop within restarts threshold", pod: newPodWithWaitingContainer("foo", "CrashLoopBackOff", 1)},
+		{name: "crash loop beyond restarts threshold", pod: newPodWithWaitingContainer("foo", "CrashLoopBackOff", crashLoopRestartsThreshold), wantErr: true},
+		{name: "pod is ready", pod: newPodWithCondition("foo", corev1.ConditionTrue)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			failure := podFailure("default:Pod/foo", tt.pod)
+			if (failure != nil) != tt.wantErr {
+				t.Fatalf("podFailure() = %v, wantErr %v", failure, tt.wantErr)
+			}
+			if failure != nil && (failure.Pod != "foo" || failure.Container != "app") {
+				t.Errorf("podFailure() pod = %q, container = %q, want foo and app", failure.Pod, failure.Container)
+			}
+		})
+	}
+}
+
+func Test_ReadyChecker_podsFailure(t *testing.T) {
+	dep := newDeployment("foo", 1, 1, 0, true)
+	c := NewReadyChecker(fake.NewSimpleClientset(newPodWithWaitingContainer("foo", "ImagePullBackOff", 0)), nil)
+
+	err := c.podsFailure(context.TODO(), "default:Deployment/foo", defaultNamespace, dep)
+
+	var failure *UnrecoverableError
+	if !errors.As(err, &failure) {
+		t.Fatalf("podsFailure() error = %v, want UnrecoverableError", err)
+	}
+	if want := `default:Deployment/foo: container "app" of pod "foo" is in ImagePullBackOff: back-off pulling image`; failure.Error() != want {
+		t.Errorf("podsFailure() error = %q, want %q", failure.Error(), want)
+	}
+}
+
+func Test_deploymentFailure(t *testing.T) {
+	dep := newDeployment("foo", 1, 1, 0, true)
+	if failure := deploymentFailure("default:Deployment/foo", dep); failure != nil {
+		t.Errorf("deploymentFailure() = %v, want nil", failure)
+	}
+
+	dep.Status.Conditions = []appsv1.DeploymentCondition{
+		{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+	}
+	if failure := deploymentFailure("default:Deployment/foo", dep); failure == nil || failure.Reason != "ProgressDeadlineExceeded" {
+		t.Errorf("deploymentFailure() = %v, want ProgressDeadlineExceeded", failure)
+	}
+}
+
+func Test_jobFailure(t *testing.T) {
+	job := newJob("foo", 1, intToInt32(1), 0, 1)
+	if failure := jobFailure("default:Job/foo", job); failure != nil {
+		t.Errorf("jobFailure() = %v, want nil", failure)
+	}
+
+	job.Status.Conditions = []batchv1.JobCondition{
+		{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"},
+	}
+	if failure := jobFailure("default:Job/foo", job); failure == nil || failure.Reason != "BackoffLimitExceeded" {
+		t.Errorf("jobFailure() = %v, want BackoffLimitExceeded", failure)
+	}
+}
+
+func Test_ReadyChecker_IsReady_deploymentFailure(t *testing.T) {
+	t.Run("stale progress deadline condition", func(t *testing.T) {
+		dep := newDeployment("foo", 1, 1, 0, false)
+		dep.Status.Conditions = []appsv1.DeploymentCondition{
+			{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: "ProgressDeadlineExceeded"},
+		}
+		c := NewReadyChecker(fake.NewSimpleClientset(dep), nil)
+
+		ready, err := c.IsReady(context.TODO(), &resource.Info{Name: "foo", Namespace: defaultNamespace, Object: dep})
+		if ready || err != nil {
+			t.Errorf("IsReady() = %v, %v, want not ready without error", ready, err)
+		}
+	})
+
+	t.Run("failed pod of old replica set", func(t *testing.T) {
+		dep := newDeployment("foo", 1, 1, 0, true)
+		rs := newReplicaSet("foo", 1, 0, true)
+		rs.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"name": "foo", "pod-template-hash": "new"}}
+		rs.Spec.Template.Labels = rs.Spec.Selector.MatchLabels
+		oldPod := newPodWithWaitingContainer("foo-old", "ImagePullBackOff", 0)
+		oldPod.Labels = map[string]string{"name": "foo", "pod-template-hash": "old"}
+		c := NewReadyChecker(fake.NewSimpleClientset(dep, rs, oldPod), nil)
+
+		ready, err := c.IsReady(context.TODO(), &resource.Info{Name: "foo", Namespace: defaultNamespace, Object: dep})
+		if ready || err != nil {
+			t.Errorf("IsReady() = %v, %v, want not ready without error", ready, err)
+		}
+	})
+}
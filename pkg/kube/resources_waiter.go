@@ -7,6 +7,10 @@ import (
 	"k8s.io/apimachinery/pkg/runtime/schema"
 )
 
+// ResourcesWaiter waits for resources instead of the built-in polling waiter
+// of Client. Client calls Wait separately for every group of resources with
+// the same wait timeout annotation and doesn't pass the resources which are
+// not waited until ready according to their wait policy annotation.
 type ResourcesWaiter interface {
 	Wait(ctx context.Context, resources ResourceList, timeout time.Duration) error
 	WatchUntilReady(ctx context.Context, resources ResourceList, timeout time.Duration) error
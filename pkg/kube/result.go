@@ -16,6 +16,12 @@ limitations under the License.
 
 package kube
 
+import (
+	"fmt"
+
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
 // Result contains the information of created, updated, and deleted resources
 // for various kube API calls along with helper methods for using those
 // resources
@@ -23,6 +29,22 @@ type Result struct {
 	Created ResourceList
 	Updated ResourceList
 	Deleted ResourceList
+	// Errors contains an error for each resource the operation failed for.
+	Errors []*ResourceError
+}
+
+// ResourceError is an error of a kube API call for a single resource.
+type ResourceError struct {
+	Resource *resource.Info
+	Err      error
+}
+
+func (e *ResourceError) Error() string {
+	return fmt.Sprintf("%s: %s", ResourceNameNamespaceKind(e.Resource), e.Err)
+}
+
+func (e *ResourceError) Unwrap() error {
+	return e.Err
 }
 
 // If needed, we can add methods to the Result type for things like diffing
@@ -0,0 +1,139 @@
+package kube
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+var applyConflictManagerRegexp = regexp.MustCompile(`conflict with "([^"]*)"`)
+
+// ApplyConflict is a field of the applied resource owned by another field
+// manager.
+type ApplyConflict struct {
+	Manager string
+	Field   string
+	Message string
+}
+
+// ApplyConflictError is returned in the server-side apply mode if the applied
+// resource has fields owned by other field managers and conflicts aren't
+// forced.
+type ApplyConflictError struct {
+	Resource  *resource.Info
+	Conflicts []ApplyConflict
+	Err       error
+}
+
+func (e *ApplyConflictError) Error() string {
+	fields := make([]string, 0, len(e.Conflicts))
+	for _, conflict := range e.Conflicts {
+		fields = append(fields, fmt.Sprintf("%s (owned by %q)", conflict.Field, conflict.Manager))
+	}
+
+	return fmt.Sprintf("server-side apply of %s conflicts with other field managers on %d field(s): %s", ResourceNameNamespaceKind(e.Resource), len(e.Conflicts), strings.Join(fields, ", "))
+}
+
+func (e *ApplyConflictError) Unwrap() error {
+	return e.Err
+}
+
+// applyResource applies the resource with server-side apply, creating it if it
+// doesn't exist.
+func applyResource(info *resource.Info, forceConflicts, dryRun bool) error {
+	obj, err := runtimeObjectToApplyPatch(info)
+	if err != nil {
+		return err
+	}
+
+	helper := resource.NewHelper(info.Client, info.Mapping).WithFieldManager(getManagedFieldsManager()).DryRun(dryRun)
+	result, err := helper.Patch(info.Namespace, info.Name, types.ApplyPatchType, obj, &metav1.PatchOptions{Force: &forceConflicts})
+	if err != nil {
+		if conflictErr := newApplyConflictError(info, err); conflictErr != nil {
+			return conflictErr
+		}
+
+		return fmt.Errorf("error applying %s on the server side: %w", ResourceNameNamespaceKind(info), err)
+	}
+
+	return info.Refresh(result, true)
+}
+
+// applyNewResource creates the resource with server-side apply. Like a regular
+// create, it fails with an AlreadyExists error if the resource exists, so
+// resources outside of the release are not taken over.
+func applyNewResource(info *resource.Info, forceConflicts, dryRun bool) error {
+	_, err := resource.NewHelper(info.Client, info.Mapping).Get(info.Namespace, info.Name)
+	if err == nil {
+		return apierrors.NewAlreadyExists(info.Mapping.Resource.GroupResource(), info.Name)
+	} else if !apierrors.IsNotFound(err) {
+		return fmt.Errorf("error checking whether %s exists: %w", ResourceNameNamespaceKind(info), err)
+	}
+
+	return applyResource(info, forceConflicts, dryRun)
+}
+
+func runtimeObjectToApplyPatch(info *resource.Info) ([]byte, error) {
+	resourceVersion, err := metadataAccessor.ResourceVersion(info.Object)
+	if err != nil {
+		return nil, fmt.Errorf("error getting resource version of %s: %w", ResourceNameNamespaceKind(info), err)
+	}
+
+	// Applied configuration must not contain a resource version, otherwise the
+	// server does an optimistic lock against it.
+	if resourceVersion != "" {
+		obj := info.Object.DeepCopyObject()
+		if err := metadataAccessor.SetResourceVersion(obj, ""); err != nil {
+			return nil, fmt.Errorf("error resetting resource version of %s: %w", ResourceNameNamespaceKind(info), err)
+		}
+
+		return json.Marshal(obj)
+	}
+
+	return json.Marshal(info.Object)
+}
+
+func newApplyConflictError(info *resource.Info, err error) *ApplyConflictError {
+	if !apierrors.IsConflict(err) {
+		return nil
+	}
+
+	var statusErr apierrors.APIStatus
+	if !errors.As(err, &statusErr) || statusErr.Status().Details == nil {
+		return nil
+	}
+
+	var conflicts []ApplyConflict
+	for _, cause := range statusErr.Status().Details.Causes {
+		if cause.Type != metav1.CauseTypeFieldManagerConflict {
+			continue
+		}
+
+		conflict := ApplyConflict{
+			Field:   cause.Field,
+			Message: cause.Message,
+		}
+		if matches := applyConflictManagerRegexp.FindStringSubmatch(cause.Message); matches != nil {
+			conflict.Manager = matches[1]
+		}
+
+		conflicts = append(conflicts, conflict)
+	}
+
+	if len(conflicts) == 0 {
+		return nil
+	}
+
+	return &ApplyConflictError{
+		Resource:  info,
+		Conflicts: conflicts,
+		Err:       err,
+	}
+}
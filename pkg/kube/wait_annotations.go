@@ -0,0 +1,153 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/events"
+)
+
+// WaitTimeoutAnnotation overrides the wait timeout for the annotated resource,
+// e.g. "20m" for a big StatefulSet.
+const WaitTimeoutAnnotation = "werf.io/wait-timeout"
+
+// WaitPolicyAnnotation sets how the annotated resource is waited for. See the
+// WaitPolicy constants for the possible values.
+const WaitPolicyAnnotation = "werf.io/wait-policy"
+
+type WaitPolicy string
+
+const (
+	// WaitPolicyReady waits until the resource is ready. It is the default.
+	WaitPolicyReady WaitPolicy = "ready"
+	// WaitPolicySkip doesn't wait for the resource at all.
+	WaitPolicySkip WaitPolicy = "skip"
+	// WaitPolicyPresent waits only until the resource exists.
+	WaitPolicyPresent WaitPolicy = "present"
+)
+
+// waitGroup is a group of resources waited with the same policy and timeout.
+type waitGroup struct {
+	policy    WaitPolicy
+	timeout   time.Duration
+	resources ResourceList
+}
+
+// groupByWaitAnnotations groups the resources by their wait policy and wait
+// timeout annotations. Resources without the annotations use the ready policy
+// and the given timeout. Skipped resources are left out. Invalid annotation
+// values are logged and ignored.
+func groupByWaitAnnotations(resources ResourceList, timeout time.Duration, log func(string, ...interface{})) []*waitGroup {
+	var groups []*waitGroup
+	for _, info := range resources {
+		policy := WaitPolicyReady
+		resTimeout := timeout
+
+		annotations, err := metadataAccessor.Annotations(info.Object)
+		if err != nil {
+			log("unable to get annotations of %s, using default wait settings: %s", ResourceNameNamespaceKind(info), err)
+		}
+
+		if value, found := annotations[WaitPolicyAnnotation]; found {
+			switch p := WaitPolicy(value); p {
+			case WaitPolicyReady, WaitPolicySkip, WaitPolicyPresent:
+				policy = p
+			default:
+				log("ignoring invalid %s annotation %q of %s", WaitPolicyAnnotation, value, ResourceNameNamespaceKind(info))
+			}
+		}
+
+		if value, found := annotations[WaitTimeoutAnnotation]; found {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				log("ignoring invalid %s annotation %q of %s", WaitTimeoutAnnotation, value, ResourceNameNamespaceKind(info))
+			} else {
+				resTimeout = d
+			}
+		}
+
+		if policy == WaitPolicySkip {
+			continue
+		}
+
+		var group *waitGroup
+		for _, g := range groups {
+			if g.policy == policy && g.timeout == resTimeout {
+				group = g
+				break
+			}
+		}
+		if group == nil {
+			group = &waitGroup{policy: policy, timeout: resTimeout}
+			groups = append(groups, group)
+		}
+		group.resources = append(group.resources, info)
+	}
+
+	return groups
+}
+
+// waitGroups waits for all of the groups concurrently, so every group has its
+// own timeout.
+func waitGroups(groups []*waitGroup, waitFn func(group *waitGroup) error) error {
+	if len(groups) == 1 {
+		return waitFn(groups[0])
+	}
+
+	var mux sync.Mutex
+	var errs []string
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group *waitGroup) {
+			defer wg.Done()
+
+			if err := waitFn(group); err != nil {
+				mux.Lock()
+				defer mux.Unlock()
+				errs = append(errs, err.Error())
+			}
+		}(group)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return fmt.Errorf("waiting for resources got %d error(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// waitForPresentResources polls until all of the resources exist or a timeout
+// is reached.
+func (w *waiter) waitForPresentResources(ctx context.Context, resources ResourceList) error {
+	w.log("beginning wait for %d resources to be present with timeout of %v", len(resources), w.timeout)
+
+	ctx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	reported := make(map[int]bool, len(resources))
+	return wait.PollUntilContextCancel(ctx, 2*time.Second, true, func(ctx context.Context) (bool, error) {
+		for i, v := range resources {
+			if reported[i] {
+				continue
+			}
+
+			if err := v.Get(); err != nil {
+				if apierrors.IsNotFound(err) {
+					return false, nil
+				}
+				return false, err
+			}
+
+			reported[i] = true
+			events.Record(w.events, events.NewResourceReady(ResourceNameNamespaceKind(v)))
+		}
+		return true, nil
+	})
+}
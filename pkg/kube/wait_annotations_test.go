@@ -0,0 +1,79 @@
+package kube
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/cli-runtime/pkg/resource"
+)
+
+func newAnnotatedConfigMap(name string, annotations map[string]string) *resource.Info {
+	cm := &corev1.ConfigMap{}
+	cm.Name = name
+	cm.Namespace = defaultNamespace
+	cm.Annotations = annotations
+
+	return &resource.Info{Name: name, Namespace: defaultNamespace, Object: cm}
+}
+
+func TestGroupByWaitAnnotations(t *testing.T) {
+	resources := ResourceList{
+		newAnnotatedConfigMap("default", nil),
+		newAnnotatedConfigMap("slow", map[string]string{WaitTimeoutAnnotation: "20m"}),
+		newAnnotatedConfigMap("skipped", map[string]string{WaitPolicyAnnotation: "skip"}),
+		newAnnotatedConfigMap("present", map[string]string{WaitPolicyAnnotation: "present"}),
+		newAnnotatedConfigMap("invalid", map[string]string{WaitPolicyAnnotation: "never", WaitTimeoutAnnotation: "soon"}),
+	}
+
+	groups := groupByWaitAnnotations(resources, 5*time.Minute, nopLogger)
+
+	type group struct {
+		policy    WaitPolicy
+		timeout   time.Duration
+		resources []string
+	}
+	want := []group{
+		{policy: WaitPolicyReady, timeout: 5 * time.Minute, resources: []string{"default", "invalid"}},
+		{policy: WaitPolicyReady, timeout: 20 * time.Minute, resources: []string{"slow"}},
+		{policy: WaitPolicyPresent, timeout: 5 * time.Minute, resources: []string{"present"}},
+	}
+
+	if len(groups) != len(want) {
+		t.Fatalf("expected %d groups, got %d", len(want), len(groups))
+	}
+	for i, g := range groups {
+		var names []string
+		for _, info := range g.resources {
+			names = append(names, info.Name)
+		}
+
+		if g.policy != want[i].policy || g.timeout != want[i].timeout || strings.Join(names, ",") != strings.Join(want[i].resources, ",") {
+			t.Errorf("group %d: got %s/%s/%v, want %s/%s/%v", i, g.policy, g.timeout, names, want[i].policy, want[i].timeout, want[i].resources)
+		}
+	}
+}
+
+func TestWaitGroups(t *testing.T) {
+	groups := []*waitGroup{
+		{timeout: time.Minute},
+		{timeout: 2 * time.Minute},
+		{timeout: 3 * time.Minute},
+	}
+
+	err := waitGroups(groups, func(group *waitGroup) error {
+		if group.timeout == time.Minute {
+			return nil
+		}
+		return errors.New("timed out")
+	})
+	if err == nil || !strings.Contains(err.Error(), "got 2 error(s)") {
+		t.Errorf("expected 2 errors, got %v", err)
+	}
+
+	if err := waitGroups(nil, nil); err != nil {
+		t.Errorf("expected no error for no groups, got %v", err)
+	}
+}
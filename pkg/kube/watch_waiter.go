@@ -0,0 +1,520 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+)
+
+// podsGroupVersionResource is watched for the resources which readiness
+// depends on the state of their pods.
+var podsGroupVersionResource = corev1.SchemeGroupVersion.WithResource("pods")
+
+// replicaSetsGroupVersionResource is watched for Deployments, which readiness
+// depends on the state of their new replica set.
+var replicaSetsGroupVersionResource = appsv1.SchemeGroupVersion.WithResource("replicasets")
+
+// podOwnerKinds are the kinds which readiness depends on the state of their
+// pods.
+var podOwnerKinds = map[string]bool{
+	"Deployment":            true,
+	"StatefulSet":           true,
+	"DaemonSet":             true,
+	"ReplicaSet":            true,
+	"ReplicationController": true,
+}
+
+// WatchWaiter is a ResourcesWaiter which watches the tracked resources and
+// their pods and re-evaluates readiness of a resource from the watch cache
+// only when it or its pods change, instead of polling all of the resources on
+// an interval.
+//
+// The resources are watched namespace-wide, so WatchWaiter needs permissions
+// to list and watch the kinds of the waited resources in their namespaces. Wait
+// also lists and watches Pods and ReplicaSets in the namespaces of the
+// resources which have pods.
+type WatchWaiter struct {
+	dynamicClient dynamic.Interface
+	log           func(string, ...interface{})
+	checkerOpts   []ReadyCheckerOption
+
+	// ResyncPeriod is the period of re-evaluating readiness of all of the
+	// pending resources regardless of the events. Zero disables periodic
+	// re-evaluation.
+	ResyncPeriod time.Duration
+}
+
+var _ ResourcesWaiter = (*WatchWaiter)(nil)
+
+// NewWatchWaiter creates a new WatchWaiter. The ReadyCheckerOptions configure
+// the ReadyChecker used to check the readiness of resources in Wait.
+func NewWatchWaiter(dynamicClient dynamic.Interface, log func(string, ...interface{}), opts ...ReadyCheckerOption) *WatchWaiter {
+	if log == nil {
+		log = nopLogger
+	}
+
+	return &WatchWaiter{
+		dynamicClient: dynamicClient,
+		log:           log,
+		checkerOpts:   opts,
+		ResyncPeriod:  time.Minute,
+	}
+}
+
+// watchTarget is a single resource waited by WatchWaiter.
+type watchTarget struct {
+	gvr       schema.GroupVersionResource
+	kind      string
+	namespace string
+	name      string
+}
+
+func (t watchTarget) String() string {
+	return fmt.Sprint(t.namespace, ":", t.kind, "/", t.name)
+}
+
+// watchTargetDone reports whether waiting for the target is done. obj is the
+// latest state of the target from the watch cache or nil if it doesn't exist.
+type watchTargetDone func(ctx context.Context, i int, obj *unstructured.Unstructured) (bool, error)
+
+// Wait waits until all of the resources are ready as reported by ReadyChecker.
+// Readiness is checked by the state of the resources, their pods and replica
+// sets in the watch cache, without requests to the server.
+func (w *WatchWaiter) Wait(ctx context.Context, resources ResourceList, timeout time.Duration) error {
+	watched := newWatchCache(w.dynamicClient)
+	checker := NewReadyChecker(nil, w.log, append(append([]ReadyCheckerOption{}, w.checkerOpts...), withReadyLister(watched))...)
+
+	targets := make([]watchTarget, 0, len(resources))
+	for _, info := range resources {
+		targets = append(targets, newWatchTarget(info))
+	}
+
+	w.log("beginning watch for %d resources with timeout of %v", len(targets), timeout)
+
+	return w.waitFor(ctx, timeout, watched, targets, true, func(ctx context.Context, i int, obj *unstructured.Unstructured) (bool, error) {
+		if obj == nil {
+			return false, nil
+		}
+
+		live, err := checker.liveFromUnstructured(resources[i], obj)
+		if err != nil {
+			return false, err
+		}
+		if live == nil {
+			return true, nil
+		}
+
+		return checker.isLiveReady(ctx, resources[i], live)
+	})
+}
+
+// WatchUntilReady waits until the Jobs are completed and the Pods are
+// succeeded. Resources of other kinds are ready once they exist.
+func (w *WatchWaiter) WatchUntilReady(ctx context.Context, resources ResourceList, timeout time.Duration) error {
+	if len(resources) == 0 {
+		return ErrNoObjectsVisited
+	}
+
+	targets := make([]watchTarget, 0, len(resources))
+	for _, info := range resources {
+		targets = append(targets, newWatchTarget(info))
+	}
+
+	return w.waitFor(ctx, timeout, newWatchCache(w.dynamicClient), targets, false, func(_ context.Context, i int, obj *unstructured.Unstructured) (bool, error) {
+		if obj == nil {
+			return false, nil
+		}
+
+		return completed(targets[i], obj)
+	})
+}
+
+// WaitUntilDeleted waits until none of the resources exist.
+func (w *WatchWaiter) WaitUntilDeleted(ctx context.Context, specs []*ResourcesWaiterDeleteResourceSpec, timeout time.Duration) error {
+	targets := make([]watchTarget, 0, len(specs))
+	for _, spec := range specs {
+		targets = append(targets, watchTarget{
+			gvr:       spec.GroupVersionResource,
+			kind:      spec.GroupVersionResource.Resource,
+			namespace: spec.Namespace,
+			name:      spec.ResourceName,
+		})
+	}
+
+	w.log("beginning watch for %d resources to be deleted with timeout of %v", len(targets), timeout)
+
+	return w.waitFor(ctx, timeout, newWatchCache(w.dynamicClient), targets, false, func(_ context.Context, _ int, obj *unstructured.Unstructured) (bool, error) {
+		return obj == nil, nil
+	})
+}
+
+func newWatchTarget(info *resource.Info) watchTarget {
+	return watchTarget{
+		gvr:       info.Mapping.Resource,
+		kind:      info.Mapping.GroupVersionKind.Kind,
+		namespace: info.Namespace,
+		name:      info.Name,
+	}
+}
+
+// waitFor watches the targets, and the pods and replica sets in their
+// namespaces if watchOwned is set, and checks a target with done every time it
+// or an object it controls changes, until done returns true for all of the
+// targets.
+func (w *WatchWaiter) waitFor(ctx context.Context, timeout time.Duration, watched *watchCache, targets []watchTarget, watchOwned bool, done watchTargetDone) error {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// Informers are stopped on return, even if the parent context is not done.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+
+	var mux sync.Mutex
+	dirty := make(map[int]bool, len(targets))
+	notify := make(chan struct{}, 1)
+	markDirty := func(match func(target watchTarget) bool) {
+		mux.Lock()
+		defer mux.Unlock()
+
+		for i, target := range targets {
+			if match(target) {
+				dirty[i] = true
+			}
+		}
+
+		select {
+		case notify <- struct{}{}:
+		default:
+		}
+	}
+
+	// An event marks the target it is about and the targets controlling the
+	// changed object, e.g. the Deployment of a changed pod of its replica set.
+	handler := func(gvr schema.GroupVersionResource, obj *unstructured.Unstructured) {
+		controllers := watched.controllers(obj)
+		markDirty(func(t watchTarget) bool {
+			if t.namespace != obj.GetNamespace() {
+				return false
+			}
+
+			if t.gvr == gvr && t.name == obj.GetName() {
+				return true
+			}
+
+			for _, ref := range controllers {
+				if t.kind == ref.Kind && t.name == ref.Name {
+					return true
+				}
+			}
+
+			return false
+		})
+	}
+
+	targetInformers := make([]cache.SharedIndexInformer, len(targets))
+	for i, target := range targets {
+		targetInformers[i] = watched.addInformer(target.gvr, target.namespace, handler)
+
+		if watchOwned && podOwnerKinds[target.kind] {
+			watched.addInformer(podsGroupVersionResource, target.namespace, handler)
+		}
+		if watchOwned && target.kind == "Deployment" {
+			watched.addInformer(replicaSetsGroupVersionResource, target.namespace, handler)
+		}
+	}
+
+	pending := make(map[int]bool, len(targets))
+	for i := range targets {
+		pending[i] = true
+	}
+
+	if !watched.run(ctx) {
+		return newWatchTimeoutError(targets, pending, ctx.Err())
+	}
+
+	var resync <-chan time.Time
+	if w.ResyncPeriod > 0 {
+		ticker := time.NewTicker(w.ResyncPeriod)
+		defer ticker.Stop()
+		resync = ticker.C
+	}
+
+	markDirty(func(watchTarget) bool { return true })
+
+	for {
+		select {
+		case <-notify:
+		case <-resync:
+			markDirty(func(watchTarget) bool { return true })
+			continue
+		case <-ctx.Done():
+			return newWatchTimeoutError(targets, pending, ctx.Err())
+		}
+
+		mux.Lock()
+		checked := dirty
+		dirty = make(map[int]bool, len(targets))
+		mux.Unlock()
+
+		for i := range checked {
+			if !pending[i] {
+				continue
+			}
+
+			obj, err := cachedObject(targetInformers[i], targets[i])
+			if err != nil {
+				return err
+			}
+
+			ok, err := done(ctx, i, obj)
+			if err != nil {
+				return err
+			}
+			if ok {
+				delete(pending, i)
+			}
+		}
+
+		if len(pending) == 0 {
+			return nil
+		}
+	}
+}
+
+func handleWatchEvent(gvr schema.GroupVersionResource, obj interface{}, handler func(gvr schema.GroupVersionResource, obj *unstructured.Unstructured)) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		obj = tombstone.Obj
+	}
+
+	if u, ok := obj.(*unstructured.Unstructured); ok {
+		handler(gvr, u)
+	}
+}
+
+// watchCache holds the informers of a single wait, one per watched resource
+// type and namespace. It lists pods and replica sets for ReadyChecker from the
+// cache.
+type watchCache struct {
+	dynamicClient dynamic.Interface
+	informers     map[watchTarget]cache.SharedIndexInformer
+}
+
+var _ readyLister = (*watchCache)(nil)
+
+func newWatchCache(dynamicClient dynamic.Interface) *watchCache {
+	return &watchCache{
+		dynamicClient: dynamicClient,
+		informers:     map[watchTarget]cache.SharedIndexInformer{},
+	}
+}
+
+// addInformer returns the informer of the resource type in the namespace,
+// creating it if it doesn't exist yet. Informers can only be added before run.
+func (c *watchCache) addInformer(gvr schema.GroupVersionResource, namespace string, handler func(gvr schema.GroupVersionResource, obj *unstructured.Unstructured)) cache.SharedIndexInformer {
+	key := watchTarget{gvr: gvr, namespace: namespace}
+	if informer, found := c.informers[key]; found {
+		return informer
+	}
+
+	informer := dynamicinformer.NewFilteredDynamicInformer(c.dynamicClient, gvr, namespace, 0, cache.Indexers{}, nil).Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    func(obj interface{}) { handleWatchEvent(gvr, obj, handler) },
+		UpdateFunc: func(_, obj interface{}) { handleWatchEvent(gvr, obj, handler) },
+		DeleteFunc: func(obj interface{}) { handleWatchEvent(gvr, obj, handler) },
+	})
+	c.informers[key] = informer
+
+	return informer
+}
+
+// run starts the informers until ctx is done and waits for their caches to
+// sync. It returns false if ctx is done before the caches are synced.
+func (c *watchCache) run(ctx context.Context) bool {
+	for _, informer := range c.informers {
+		go informer.Run(ctx.Done())
+	}
+	for _, informer := range c.informers {
+		if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// controllers returns the controller of the object and, if it is a replica
+// set, the controller of the replica set, e.g. the Deployment of a pod.
+func (c *watchCache) controllers(obj metav1.Object) []metav1.OwnerReference {
+	ref := metav1.GetControllerOf(obj)
+	if ref == nil {
+		return nil
+	}
+	refs := []metav1.OwnerReference{*ref}
+
+	if ref.Kind != "ReplicaSet" {
+		return refs
+	}
+
+	informer, found := c.informers[watchTarget{gvr: replicaSetsGroupVersionResource, namespace: obj.GetNamespace()}]
+	if !found {
+		return refs
+	}
+
+	rs, err := cachedObject(informer, watchTarget{namespace: obj.GetNamespace(), name: ref.Name})
+	if err != nil || rs == nil {
+		return refs
+	}
+	if ref := metav1.GetControllerOf(rs); ref != nil {
+		refs = append(refs, *ref)
+	}
+
+	return refs
+}
+
+func (c *watchCache) listPods(_ context.Context, namespace, selector string) ([]corev1.Pod, error) {
+	var pods []corev1.Pod
+	err := c.list(podsGroupVersionResource, namespace, selector, func(obj *unstructured.Unstructured) error {
+		pod := corev1.Pod{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, &pod); err != nil {
+			return fmt.Errorf("error converting pod %s: %w", obj.GetName(), err)
+		}
+		pods = append(pods, pod)
+
+		return nil
+	})
+
+	return pods, err
+}
+
+func (c *watchCache) listReplicaSets(_ context.Context, namespace, selector string) ([]*appsv1.ReplicaSet, error) {
+	var replicaSets []*appsv1.ReplicaSet
+	err := c.list(replicaSetsGroupVersionResource, namespace, selector, func(obj *unstructured.Unstructured) error {
+		rs := &appsv1.ReplicaSet{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, rs); err != nil {
+			return fmt.Errorf("error converting replica set %s: %w", obj.GetName(), err)
+		}
+		replicaSets = append(replicaSets, rs)
+
+		return nil
+	})
+
+	return replicaSets, err
+}
+
+// list calls add for every cached object of the resource type in the
+// namespace which matches the label selector.
+func (c *watchCache) list(gvr schema.GroupVersionResource, namespace, selector string, add func(obj *unstructured.Unstructured) error) error {
+	informer, found := c.informers[watchTarget{gvr: gvr, namespace: namespace}]
+	if !found {
+		return fmt.Errorf("%s in namespace %q are not watched", gvr.Resource, namespace)
+	}
+
+	sel, err := labels.Parse(selector)
+	if err != nil {
+		return fmt.Errorf("error parsing label selector %q: %w", selector, err)
+	}
+
+	for _, obj := range informer.GetStore().List() {
+		u, ok := obj.(*unstructured.Unstructured)
+		if !ok || !sel.Matches(labels.Set(u.GetLabels())) {
+			continue
+		}
+
+		if err := add(u); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// cachedObject returns the latest state of the target from the watch cache or
+// nil if it doesn't exist.
+func cachedObject(informer cache.SharedIndexInformer, target watchTarget) (*unstructured.Unstructured, error) {
+	key := target.name
+	if target.namespace != "" {
+		key = target.namespace + "/" + target.name
+	}
+
+	obj, exists, err := informer.GetStore().GetByKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("error getting %s from watch cache: %w", target, err)
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	u, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return nil, fmt.Errorf("expected %s to be *unstructured.Unstructured, got %T", target, obj)
+	}
+
+	return u, nil
+}
+
+// completed reports whether the Job is completed or the Pod is succeeded and
+// returns an error if they failed.
+func completed(target watchTarget, obj *unstructured.Unstructured) (bool, error) {
+	switch target.kind {
+	case "Job":
+		job := &batchv1.Job{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, job); err != nil {
+			return false, fmt.Errorf("error converting %s: %w", target, err)
+		}
+
+		for _, c := range job.Status.Conditions {
+			if c.Type == batchv1.JobComplete && c.Status == corev1.ConditionTrue {
+				return true, nil
+			} else if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+				return false, fmt.Errorf("job %s failed: %s", target.name, c.Reason)
+			}
+		}
+
+		return false, nil
+	case "Pod":
+		pod := &corev1.Pod{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, pod); err != nil {
+			return false, fmt.Errorf("error converting %s: %w", target, err)
+		}
+
+		switch pod.Status.Phase {
+		case corev1.PodSucceeded:
+			return true, nil
+		case corev1.PodFailed:
+			return false, fmt.Errorf("pod %s failed", target.name)
+		}
+
+		return false, nil
+	default:
+		return true, nil
+	}
+}
+
+func newWatchTimeoutError(targets []watchTarget, pending map[int]bool, err error) error {
+	var names []string
+	for i, target := range targets {
+		if pending[i] {
+			names = append(names, target.String())
+		}
+	}
+
+	return fmt.Errorf("waiting for %s: %w", strings.Join(names, ", "), err)
+}
@@ -0,0 +1,208 @@
+package kube
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+func newWatchWaiterFixture(t *testing.T, pod *corev1.Pod) (*WatchWaiter, *dynamicfake.FakeDynamicClient, ResourceList) {
+	t.Helper()
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{podsGroupVersionResource: "PodList"}, toUnstructured(t, pod, corev1.SchemeGroupVersion.WithKind("Pod")))
+
+	resources := ResourceList{&resource.Info{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+		Object:    pod.DeepCopy(),
+		Mapping: &meta.RESTMapping{
+			Resource:         podsGroupVersionResource,
+			GroupVersionKind: corev1.SchemeGroupVersion.WithKind("Pod"),
+			Scope:            meta.RESTScopeNamespace,
+		},
+	}}
+
+	return NewWatchWaiter(dynamicClient, t.Logf), dynamicClient, resources
+}
+
+func toUnstructured(t *testing.T, obj runtime.Object, gvk schema.GroupVersionKind) *unstructured.Unstructured {
+	t.Helper()
+
+	content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &unstructured.Unstructured{}
+	u.SetUnstructuredContent(content)
+	u.SetGroupVersionKind(gvk)
+
+	return u
+}
+
+func TestWatchWaiter_Wait(t *testing.T) {
+	pod := newPodWithCondition("foo", corev1.ConditionFalse)
+	waiter, dynamicClient, resources := newWatchWaiterFixture(t, pod)
+
+	done := make(chan error)
+	go func() {
+		done <- waiter.Wait(context.Background(), resources, time.Minute)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Wait() returned before the pod is ready: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	readyPod := newPodWithCondition("foo", corev1.ConditionTrue)
+	if _, err := dynamicClient.Resource(podsGroupVersionResource).Namespace(defaultNamespace).
+		Update(context.Background(), toUnstructured(t, readyPod, corev1.SchemeGroupVersion.WithKind("Pod")), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() didn't return after the pod became ready")
+	}
+}
+
+func TestWatchWaiter_WaitTimeout(t *testing.T) {
+	waiter, _, resources := newWatchWaiterFixture(t, newPodWithCondition("foo", corev1.ConditionFalse))
+
+	err := waiter.Wait(context.Background(), resources, 100*time.Millisecond)
+	if err == nil {
+		t.Fatal("expected timeout error")
+	}
+	if want := "waiting for default:Pod/foo: context deadline exceeded"; err.Error() != want {
+		t.Errorf("Wait() error = %q, want %q", err, want)
+	}
+}
+
+func TestWatchWaiter_WaitUntilDeleted(t *testing.T) {
+	pod := newPodWithCondition("foo", corev1.ConditionTrue)
+	waiter, dynamicClient, _ := newWatchWaiterFixture(t, pod)
+	specs := []*ResourcesWaiterDeleteResourceSpec{
+		{ResourceName: pod.Name, Namespace: pod.Namespace, GroupVersionResource: podsGroupVersionResource},
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- waiter.WaitUntilDeleted(context.Background(), specs, time.Minute)
+	}()
+
+	if err := dynamicClient.Resource(podsGroupVersionResource).Namespace(defaultNamespace).
+		Delete(context.Background(), pod.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("WaitUntilDeleted() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WaitUntilDeleted() didn't return after the pod was deleted")
+	}
+}
+
+func TestWatchWaiter_WaitDeployment(t *testing.T) {
+	deploymentGVK := appsv1.SchemeGroupVersion.WithKind("Deployment")
+	deploymentsGVR := appsv1.SchemeGroupVersion.WithResource("deployments")
+
+	dep := newDeployment("foo", 1, 0, 0, true)
+	rs := newReplicaSet("foo", 1, 0, true)
+	rs.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(dep, deploymentGVK)}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{
+			podsGroupVersionResource:        "PodList",
+			replicaSetsGroupVersionResource: "ReplicaSetList",
+			deploymentsGVR:                  "DeploymentList",
+		},
+		toUnstructured(t, dep, deploymentGVK),
+		toUnstructured(t, rs, appsv1.SchemeGroupVersion.WithKind("ReplicaSet")),
+	)
+	resources := ResourceList{&resource.Info{
+		Name:      dep.Name,
+		Namespace: dep.Namespace,
+		Object:    dep.DeepCopy(),
+		Mapping: &meta.RESTMapping{
+			Resource:         deploymentsGVR,
+			GroupVersionKind: deploymentGVK,
+			Scope:            meta.RESTScopeNamespace,
+		},
+	}}
+
+	// Without a typed client any request to the server would fail the wait.
+	waiter := NewWatchWaiter(dynamicClient, t.Logf)
+	waiter.ResyncPeriod = 0
+
+	done := make(chan error)
+	go func() {
+		done <- waiter.Wait(context.Background(), resources, time.Minute)
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("Wait() returned before the replica set is ready: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// The update of the replica set alone has to re-evaluate the Deployment.
+	rs.Status.ReadyReplicas = 1
+	if _, err := dynamicClient.Resource(replicaSetsGroupVersionResource).Namespace(defaultNamespace).
+		Update(context.Background(), toUnstructured(t, rs, appsv1.SchemeGroupVersion.WithKind("ReplicaSet")), metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("Wait() error = %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() didn't return after the replica set became ready")
+	}
+}
+
+func TestWatchCache_controllers(t *testing.T) {
+	dep := newDeployment("foo", 1, 0, 0, true)
+	rs := newReplicaSet("foo-abc", 1, 0, true)
+	rs.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(dep, appsv1.SchemeGroupVersion.WithKind("Deployment"))}
+	pod := newPodWithCondition("foo-abc-xyz", corev1.ConditionFalse)
+	pod.OwnerReferences = []metav1.OwnerReference{*metav1.NewControllerRef(rs, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"))}
+
+	watched := newWatchCache(dynamicfake.NewSimpleDynamicClient(runtime.NewScheme()))
+	replicaSets := watched.addInformer(replicaSetsGroupVersionResource, defaultNamespace, func(schema.GroupVersionResource, *unstructured.Unstructured) {})
+	if err := replicaSets.GetStore().Add(toUnstructured(t, rs, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"))); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, ref := range watched.controllers(pod) {
+		got = append(got, ref.Kind+"/"+ref.Name)
+	}
+	if want := []string{"ReplicaSet/foo-abc", "Deployment/foo"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("controllers() = %v, want %v", got, want)
+	}
+
+	if refs := watched.controllers(newPodWithCondition("bar", corev1.ConditionFalse)); refs != nil {
+		t.Errorf("controllers() of a pod without owners = %v, want none", refs)
+	}
+}
@@ -0,0 +1,124 @@
+package phases
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/cli-runtime/pkg/resource"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages/externaldeps"
+)
+
+const (
+	ExternalDependencyResourceAnnoSuffix  = "external-dependency.werf.io/resource"
+	ExternalDependencyNamespaceAnnoSuffix = "external-dependency.werf.io/namespace"
+)
+
+var externalDependencyResourceAnnoRegexp = regexp.MustCompile(`^([^./]+)\.` + regexp.QuoteMeta(ExternalDependencyResourceAnnoSuffix) + `$`)
+
+func NewAnnotationExternalDepsGenerator(mapper meta.RESTMapper) *AnnotationExternalDepsGenerator {
+	return &AnnotationExternalDepsGenerator{
+		gvkBuilder:   externaldeps.NewRESTMapperGVKBuilder(mapper),
+		metaAccessor: meta.NewAccessor(),
+		mapper:       mapper,
+	}
+}
+
+// AnnotationExternalDepsGenerator reads external dependencies of stage
+// resources from annotations like:
+//
+//	<name>.external-dependency.werf.io/resource: deployment/foo
+//	<name>.external-dependency.werf.io/namespace: bar
+//
+// If the namespace annotation is omitted, the namespace of the annotated
+// resource is used.
+type AnnotationExternalDepsGenerator struct {
+	gvkBuilder   externaldeps.GVKBuilder
+	metaAccessor meta.MetadataAccessor
+	mapper       meta.RESTMapper
+}
+
+func (g *AnnotationExternalDepsGenerator) Generate(stageList stages.SortedStageList) error {
+	for _, stage := range stageList {
+		if err := stage.DesiredResources.Visit(func(res *resource.Info, err error) error {
+			if err != nil {
+				return err
+			}
+
+			extDeps, err := g.resourceExternalDeps(res)
+			if err != nil {
+				return fmt.Errorf("error generating external dependencies for %q: %w", kube.ResourceNameNamespaceKind(res), err)
+			}
+
+			for _, extDep := range extDeps {
+				if stageHasExternalDep(stage, extDep) {
+					continue
+				}
+
+				stage.ExternalDependencies = append(stage.ExternalDependencies, extDep)
+			}
+
+			return nil
+		}); err != nil {
+			return fmt.Errorf("error visiting resources list: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (g *AnnotationExternalDepsGenerator) resourceExternalDeps(res *resource.Info) (externaldeps.ExternalDependencyList, error) {
+	annotations, err := g.metaAccessor.Annotations(res.Object)
+	if err != nil {
+		return nil, fmt.Errorf("error getting annotations: %w", err)
+	}
+
+	var annoKeys []string
+	for key := range annotations {
+		annoKeys = append(annoKeys, key)
+	}
+	sort.Strings(annoKeys)
+
+	var extDeps externaldeps.ExternalDependencyList
+	for _, key := range annoKeys {
+		matches := externalDependencyResourceAnnoRegexp.FindStringSubmatch(key)
+		if matches == nil {
+			continue
+		}
+		name := matches[1]
+
+		resourceType, resourceName, found := strings.Cut(annotations[key], "/")
+		if !found || resourceType == "" || resourceName == "" {
+			return nil, fmt.Errorf("invalid value %q for annotation %q: expected format <type>/<name>", annotations[key], key)
+		}
+
+		extDep := externaldeps.NewExternalDependency(name, resourceType, resourceName)
+		extDep.Namespace = res.Namespace
+		if namespace, ok := annotations[fmt.Sprintf("%s.%s", name, ExternalDependencyNamespaceAnnoSuffix)]; ok && namespace != "" {
+			extDep.Namespace = namespace
+		}
+
+		if err := extDep.GenerateInfo(g.gvkBuilder, g.metaAccessor, g.mapper); err != nil {
+			return nil, fmt.Errorf("error generating info for external dependency %q: %w", name, err)
+		}
+
+		extDeps = append(extDeps, extDep)
+	}
+
+	return extDeps, nil
+}
+
+func stageHasExternalDep(stage *stages.Stage, extDep *externaldeps.ExternalDependency) bool {
+	for _, existing := range stage.ExternalDependencies {
+		if kube.ResourceNameNamespaceKind(existing.Info) == kube.ResourceNameNamespaceKind(extDep.Info) {
+			return true
+		}
+	}
+
+	return false
+}
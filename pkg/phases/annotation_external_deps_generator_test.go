@@ -0,0 +1,67 @@
+package phases
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
+)
+
+func TestAnnotationExternalDepsGenerator(t *testing.T) {
+	deploymentGVK := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	configMapGVK := schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}
+
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{deploymentGVK.GroupVersion(), configMapGVK.GroupVersion()})
+	mapper.Add(deploymentGVK, meta.RESTScopeNamespace)
+	mapper.Add(configMapGVK, meta.RESTScopeNamespace)
+
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(configMapGVK)
+	obj.SetName("app-config")
+	obj.SetNamespace("app")
+	obj.SetAnnotations(map[string]string{
+		"db.external-dependency.werf.io/resource":     "deployment/postgres",
+		"db.external-dependency.werf.io/namespace":    "database",
+		"cache.external-dependency.werf.io/resource":  "deployments.apps/redis",
+		"unrelated.external-dependency.werf.io/other": "ignored",
+	})
+
+	cmMapping, err := mapper.RESTMapping(configMapGVK.GroupKind(), configMapGVK.Version)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stage := &stages.Stage{
+		DesiredResources: kube.ResourceList{{Name: "app-config", Namespace: "app", Mapping: cmMapping, Object: obj}},
+	}
+
+	if err := NewAnnotationExternalDepsGenerator(mapper).Generate(stages.SortedStageList{stage}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stage.ExternalDependencies) != 2 {
+		t.Fatalf("expected 2 external dependencies, got %d", len(stage.ExternalDependencies))
+	}
+
+	expected := map[string]string{
+		"cache": "app:Deployment/redis",
+		"db":    "database:Deployment/postgres",
+	}
+	for _, extDep := range stage.ExternalDependencies {
+		if got := kube.ResourceNameNamespaceKind(extDep.Info); got != expected[extDep.Name] {
+			t.Errorf("expected external dependency %q to be %q, got %q", extDep.Name, expected[extDep.Name], got)
+		}
+	}
+
+	obj.SetAnnotations(map[string]string{"db.external-dependency.werf.io/resource": "postgres"})
+	stage = &stages.Stage{
+		DesiredResources: kube.ResourceList{{Name: "app-config", Namespace: "app", Mapping: cmMapping, Object: obj}},
+	}
+	if err := NewAnnotationExternalDepsGenerator(mapper).Generate(stages.SortedStageList{stage}); err == nil {
+		t.Error("expected error for invalid external dependency annotation value")
+	}
+}
@@ -0,0 +1,245 @@
+package phases
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/cli-runtime/pkg/resource"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/releaseutil"
+)
+
+const DependsOnAnnoName = "werf.io/depends-on"
+
+// DependencyGraphSplitter puts every resource into its own stage and links the
+// stages with the dependencies listed in the DependsOnAnnoName annotation, e.g.:
+//
+//	werf.io/depends-on: deployment/postgres,configmap/settings
+//
+// Dependencies are looked up among the release resources by kind and name, in
+// the namespace of the annotated resource or among cluster-scoped resources.
+//
+// Resources also depend on the resources of the preceding kinds of
+// releaseutil.InstallOrder, e.g. Namespaces and CRDs are deployed before
+// workloads, unless this contradicts the annotations.
+//
+// Stages are sorted topologically, so they can also be deployed one by one.
+type DependencyGraphSplitter struct{}
+
+func (s *DependencyGraphSplitter) Split(resources kube.ResourceList) (stages.SortedStageList, error) {
+	var resStages []*stages.Stage
+	if err := resources.Visit(func(res *resource.Info, err error) error {
+		if err != nil {
+			return err
+		}
+
+		resStages = append(resStages, &stages.Stage{
+			DesiredResources: kube.ResourceList{res},
+			DependsOn:        []*stages.Stage{},
+		})
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("error visiting resources list: %w", err)
+	}
+
+	if len(resStages) == 0 {
+		return stages.SortedStageList{&stages.Stage{}}, nil
+	}
+
+	for _, stage := range resStages {
+		res := stage.DesiredResources[0]
+
+		refs, err := resourceDependencyRefs(res)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ref := range refs {
+			dependency, err := findDependencyStage(resStages, res, ref)
+			if err != nil {
+				return nil, err
+			}
+
+			stage.DependsOn = append(stage.DependsOn, dependency)
+		}
+	}
+
+	addKindOrderDependencies(resStages)
+
+	return sortStagesTopologically(resStages)
+}
+
+// addKindOrderDependencies makes every stage depend on the stages of the
+// nearest preceding kind of releaseutil.InstallOrder among the resources. Kinds
+// missing from it come last. A dependency is not added if the stage it would
+// depend on already depends on the stage.
+func addKindOrderDependencies(resStages []*stages.Stage) {
+	layers := map[int][]*stages.Stage{}
+	var ranks []int
+	for _, stage := range resStages {
+		rank := kindRank(stage.DesiredResources[0])
+		if _, found := layers[rank]; !found {
+			ranks = append(ranks, rank)
+		}
+		layers[rank] = append(layers[rank], stage)
+	}
+	sort.Ints(ranks)
+
+	dependents := map[*stages.Stage][]*stages.Stage{}
+	for _, stage := range resStages {
+		for _, dependency := range stage.DependsOn {
+			dependents[dependency] = append(dependents[dependency], stage)
+		}
+	}
+
+	for i := 1; i < len(ranks); i++ {
+		for _, stage := range layers[ranks[i]] {
+			// Adding dependencies to the stage doesn't change which stages
+			// depend on it, so they are collected once for all of them.
+			excluded := transitiveDependents(stage, dependents)
+			for _, dependency := range stage.DependsOn {
+				excluded[dependency] = true
+			}
+
+			for _, dependency := range layers[ranks[i-1]] {
+				if excluded[dependency] {
+					continue
+				}
+
+				stage.DependsOn = append(stage.DependsOn, dependency)
+				dependents[dependency] = append(dependents[dependency], stage)
+			}
+		}
+	}
+}
+
+// transitiveDependents returns the stages which depend on stage directly or
+// transitively.
+func transitiveDependents(stage *stages.Stage, dependents map[*stages.Stage][]*stages.Stage) map[*stages.Stage]bool {
+	found := map[*stages.Stage]bool{}
+	queue := []*stages.Stage{stage}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		for _, dependent := range dependents[current] {
+			if !found[dependent] {
+				found[dependent] = true
+				queue = append(queue, dependent)
+			}
+		}
+	}
+
+	return found
+}
+
+func kindRank(res *resource.Info) int {
+	kind := res.Object.GetObjectKind().GroupVersionKind().Kind
+	for i, k := range releaseutil.InstallOrder {
+		if k == kind {
+			return i
+		}
+	}
+
+	return len(releaseutil.InstallOrder)
+}
+
+func resourceDependencyRefs(res *resource.Info) ([]string, error) {
+	accessor, err := meta.Accessor(res.Object)
+	if err != nil {
+		return nil, fmt.Errorf("error getting metadata accessor for %q: %w", kube.ResourceNameNamespaceKind(res), err)
+	}
+
+	value, found := accessor.GetAnnotations()[DependsOnAnnoName]
+	if !found {
+		return nil, nil
+	}
+
+	var refs []string
+	for _, ref := range strings.Split(value, ",") {
+		if ref = strings.TrimSpace(ref); ref != "" {
+			refs = append(refs, ref)
+		}
+	}
+
+	return refs, nil
+}
+
+func findDependencyStage(resStages []*stages.Stage, dependent *resource.Info, ref string) (*stages.Stage, error) {
+	kind, name, found := strings.Cut(ref, "/")
+	if !found || kind == "" || name == "" {
+		return nil, fmt.Errorf("invalid dependency %q in annotation %q on %q: expected format <kind>/<name>", ref, DependsOnAnnoName, kube.ResourceNameNamespaceKind(dependent))
+	}
+
+	for _, stage := range resStages {
+		res := stage.DesiredResources[0]
+
+		if !strings.EqualFold(res.Object.GetObjectKind().GroupVersionKind().Kind, kind) || res.Name != name {
+			continue
+		}
+
+		if res.Namespace != dependent.Namespace && res.Namespace != "" {
+			continue
+		}
+
+		if res == dependent {
+			return nil, fmt.Errorf("resource %q can't depend on itself", kube.ResourceNameNamespaceKind(dependent))
+		}
+
+		return stage, nil
+	}
+
+	return nil, fmt.Errorf("dependency %q of %q not found among release resources", ref, kube.ResourceNameNamespaceKind(dependent))
+}
+
+// sortStagesTopologically orders stages so that every stage comes after its
+// dependencies, keeping the original order where possible, and assigns the
+// resulting positions as stage weights.
+func sortStagesTopologically(resStages []*stages.Stage) (stages.SortedStageList, error) {
+	pending := make(map[*stages.Stage]int, len(resStages))
+	dependents := make(map[*stages.Stage][]*stages.Stage, len(resStages))
+	for _, stage := range resStages {
+		pending[stage] = len(stage.DependsOn)
+		for _, dependency := range stage.DependsOn {
+			dependents[dependency] = append(dependents[dependency], stage)
+		}
+	}
+
+	sorted := make(stages.SortedStageList, 0, len(resStages))
+	done := make(map[*stages.Stage]bool, len(resStages))
+	for len(sorted) < len(resStages) {
+		var next *stages.Stage
+		for _, stage := range resStages {
+			if !done[stage] && pending[stage] == 0 {
+				next = stage
+				break
+			}
+		}
+
+		if next == nil {
+			var cycled []string
+			for _, stage := range resStages {
+				if !done[stage] {
+					cycled = append(cycled, kube.ResourceNameNamespaceKind(stage.DesiredResources[0]))
+				}
+			}
+
+			return nil, fmt.Errorf("dependency cycle detected between resources: %s", strings.Join(cycled, ", "))
+		}
+
+		next.Weight = len(sorted)
+		done[next] = true
+		sorted = append(sorted, next)
+
+		for _, dependent := range dependents[next] {
+			pending[dependent]--
+		}
+	}
+
+	return sorted, nil
+}
@@ -0,0 +1,112 @@
+package phases
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+)
+
+func TestDependencyGraphSplitter(t *testing.T) {
+	info := func(kind, name, dependsOn string) *resource.Info {
+		gvk := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: kind}
+
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(gvk)
+		obj.SetName(name)
+		obj.SetNamespace("default")
+		if dependsOn != "" {
+			obj.SetAnnotations(map[string]string{DependsOnAnnoName: dependsOn})
+		}
+
+		return &resource.Info{Name: name, Namespace: "default", Mapping: &meta.RESTMapping{GroupVersionKind: gvk}, Object: obj}
+	}
+
+	frontend := info("Deployment", "frontend", "deployment/backend, configmap/settings")
+	backend := info("Deployment", "backend", "statefulset/db")
+	db := info("StatefulSet", "db", "")
+	settings := info("ConfigMap", "settings", "")
+	worker := info("Deployment", "worker", "StatefulSet/db")
+
+	stageList, err := (&DependencyGraphSplitter{}).Split(kube.ResourceList{frontend, backend, db, settings, worker})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !stageList.IsDependencyGraph() {
+		t.Error("expected stages to form a dependency graph")
+	}
+
+	position := map[*resource.Info]int{}
+	for i, stage := range stageList {
+		if stage.Weight != i {
+			t.Errorf("expected stage %d to have weight %d, got %d", i, i, stage.Weight)
+		}
+		position[stage.DesiredResources[0]] = i
+	}
+
+	for _, dep := range [][2]*resource.Info{{frontend, backend}, {frontend, settings}, {backend, db}, {worker, db}} {
+		if position[dep[0]] <= position[dep[1]] {
+			t.Errorf("expected %q to be sorted after its dependency %q", dep[0].Name, dep[1].Name)
+		}
+	}
+
+	if len(stageList[position[db]].DependsOn) != 0 {
+		t.Errorf("expected db to have no dependencies")
+	}
+
+	if len(stageList[position[frontend]].DependsOn) != 2 {
+		t.Errorf("expected frontend to have 2 dependencies, got %d", len(stageList[position[frontend]].DependsOn))
+	}
+
+	if _, err := (&DependencyGraphSplitter{}).Split(kube.ResourceList{info("Deployment", "a", "deployment/b"), info("Deployment", "b", "deployment/a")}); err == nil {
+		t.Error("expected error for dependency cycle")
+	}
+
+	if _, err := (&DependencyGraphSplitter{}).Split(kube.ResourceList{info("Deployment", "a", "deployment/missing")}); err == nil {
+		t.Error("expected error for unknown dependency")
+	}
+
+	namespace := info("Namespace", "app", "")
+	crd := info("CustomResourceDefinition", "crontabs.stable.example.com", "")
+	app := info("Deployment", "app", "")
+	cron := info("CronTab", "cron", "")
+
+	stageList, err = (&DependencyGraphSplitter{}).Split(kube.ResourceList{cron, app, crd, namespace})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	position = map[*resource.Info]int{}
+	for i, stage := range stageList {
+		position[stage.DesiredResources[0]] = i
+	}
+
+	for _, dep := range [][2]*resource.Info{{crd, namespace}, {app, crd}, {cron, app}} {
+		if position[dep[0]] <= position[dep[1]] {
+			t.Errorf("expected %q to be sorted after %q by kind", dep[0].Name, dep[1].Name)
+		}
+	}
+
+	// Kind order is not enforced where it contradicts transitive annotations.
+	namespace = info("Namespace", "app", "crontab/cron")
+	stageList, err = (&DependencyGraphSplitter{}).Split(kube.ResourceList{cron, crd, namespace})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	position = map[*resource.Info]int{}
+	for i, stage := range stageList {
+		position[stage.DesiredResources[0]] = i
+	}
+
+	for _, dep := range [][2]*resource.Info{{namespace, cron}, {crd, namespace}} {
+		if position[dep[0]] <= position[dep[1]] {
+			t.Errorf("expected %q to be sorted after %q", dep[0].Name, dep[1].Name)
+		}
+	}
+}
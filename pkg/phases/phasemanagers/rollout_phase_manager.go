@@ -1,9 +1,13 @@
 package phasemanagers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
 
+	"github.com/werf/3p-helm-for-werf-helm/pkg/events"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/phases"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
@@ -18,6 +22,7 @@ func NewRolloutPhaseManager(rolloutPhase *phases.RolloutPhase, deployedResCalc *
 		Storage:                     storage,
 		deployedResourcesCalculator: deployedResCalc,
 		kubeClient:                  kubeClient,
+		ctx:                         context.Background(),
 	}
 }
 
@@ -28,9 +33,25 @@ type RolloutPhaseManager struct {
 
 	deployedResourcesCalculator *phases.DeployedResourcesCalculator
 	previouslyDeployedResources kube.ResourceList
+	resumedResources            kube.ResourceList
 	kubeClient                  kube.Interface
+	firstStageIndex             int
+	startedStages               map[int]bool
+	deletedOrphanedResources    kube.ResourceList
+	events                      events.Recorder
+	stageHooksFn                StageHooksFunc
+	concurrent                  bool
+	ctx                         context.Context
+	// storageMux serializes saving the release, since stages and their hooks
+	// can be deployed concurrently.
+	storageMux sync.Mutex
 }
 
+// StageHooksFunc executes the hooks of the stage hook event bound to the
+// stage. The record function saves the release and has to be called before
+// the hooks are started.
+type StageHooksFunc func(hook rel.HookEvent, stage *stages.Stage, record func() error) error
+
 func (m *RolloutPhaseManager) AddCalculatedPreviouslyDeployedResources() (*RolloutPhaseManager, error) {
 	resources, err := m.deployedResourcesCalculator.Calculate()
 	if err != nil {
@@ -48,41 +69,153 @@ func (m *RolloutPhaseManager) AddPreviouslyDeployedResources(resources kube.Reso
 	return m
 }
 
+// AddResumedResources adds the resources deployed by an interrupted run of the
+// same release revision. They are applied over as existing resources, but
+// unlike previously deployed resources they are neither restored by
+// RollbackStages nor considered orphaned.
+func (m *RolloutPhaseManager) AddResumedResources(resources kube.ResourceList) *RolloutPhaseManager {
+	m.resumedResources.Merge(resources)
+
+	return m
+}
+
+// SkipStagesBefore makes DoStage skip stages that were already deployed by an
+// interrupted run of the same release revision.
+func (m *RolloutPhaseManager) SkipStagesBefore(stageIndex int) *RolloutPhaseManager {
+	m.firstStageIndex = stageIndex
+
+	return m
+}
+
+// WithEvents makes the manager emit StageStarted and OrphanDeleted events.
+func (m *RolloutPhaseManager) WithEvents(recorder events.Recorder) *RolloutPhaseManager {
+	m.events = recorder
+
+	return m
+}
+
+// WithStageHooks makes the manager execute the pre-stage hooks before
+// applying and the post-stage hooks after tracking each stage.
+func (m *RolloutPhaseManager) WithStageHooks(stageHooksFn StageHooksFunc) *RolloutPhaseManager {
+	m.stageHooksFn = stageHooksFn
+
+	return m
+}
+
+// WithContext makes the manager stop starting stages and deleting resources
+// once ctx is done. The callbacks passed to DoStage are expected to honour the
+// same context.
+func (m *RolloutPhaseManager) WithContext(ctx context.Context) *RolloutPhaseManager {
+	m.ctx = ctx
+
+	return m
+}
+
 func (m *RolloutPhaseManager) DoStage(
 	extDepTrackFn func(stgIndex int, stage *stages.Stage) error,
 	applyFn func(stgIndex int, stage *stages.Stage, prevDeployedStgResources kube.ResourceList) error,
 	trackFn func(stgIndex int, stage *stages.Stage) error,
 ) error {
+	if err := m.validateStageHooks(); err != nil {
+		return err
+	}
+
 	for i, stg := range m.Phase.SortedStages {
-		if err := extDepTrackFn(i, stg); err != nil {
-			return fmt.Errorf("error tracking external dependencies: %w", err)
+		if i < m.firstStageIndex {
+			continue
 		}
 
-		if err := applyFn(i, stg, m.previouslyDeployedResources.Intersect(stg.DesiredResources)); err != nil {
-			return &ApplyError{StageIndex: i, Err: err}
+		m.markStageStarted(i)
+
+		if err := m.doSingleStage(i, stg, extDepTrackFn, applyFn, trackFn, func(stgIndex int) error {
+			rel.SetRolloutPhaseStageInfo(m.Release, stgIndex)
+			if err := m.Storage.Update(m.Release); err != nil {
+				return fmt.Errorf("error updating release in storage: %w", err)
+			}
+
+			return nil
+		}); err != nil {
+			return err
 		}
+	}
 
-		rel.SetRolloutPhaseStageInfo(m.Release, i)
-		if err := m.Storage.Update(m.Release); err != nil {
-			return fmt.Errorf("error updating release in storage: %w", err)
+	return nil
+}
+
+// markStageStarted records that the stage is started by this run, so
+// RollbackStages rolls it back.
+func (m *RolloutPhaseManager) markStageStarted(stgIndex int) {
+	if m.startedStages == nil {
+		m.startedStages = map[int]bool{}
+	}
+	m.startedStages[stgIndex] = true
+}
+
+// RollbackStages walks backwards over the stages that DoStage started to
+// apply, including partially applied ones, and calls rollbackFn for each of
+// them with the stage resources as they were deployed before this release.
+// Stages which were never started or were skipped by SkipStagesBefore are left
+// as is. All stages are processed even if some of them fail.
+func (m *RolloutPhaseManager) RollbackStages(
+	rollbackFn func(stgIndex int, stage *stages.Stage, prevDeployedStgResources kube.ResourceList) error,
+) error {
+	var errs []error
+	for i := len(m.Phase.SortedStages) - 1; i >= 0; i-- {
+		if !m.startedStages[i] {
+			continue
 		}
 
-		if err := trackFn(i, stg); err != nil {
-			return fmt.Errorf("error tracking resources: %w", err)
+		if err := rollbackFn(i, m.Phase.SortedStages[i], m.PreviouslyDeployedStageResources(i)); err != nil {
+			errs = append(errs, fmt.Errorf("stage %d: %w", i, err))
 		}
 	}
 
+	if len(errs) > 0 {
+		return fmt.Errorf("while rolling back stages got %d error(s): %s", len(errs), joinErrors(errs))
+	}
+
 	return nil
 }
 
+// PreviouslyDeployedStageResources returns the resources of the stage as they
+// were deployed before this release.
+func (m *RolloutPhaseManager) PreviouslyDeployedStageResources(stageIndex int) kube.ResourceList {
+	return m.previouslyDeployedResources.Intersect(m.Phase.SortedStages[stageIndex].DesiredResources)
+}
+
+// ExistingStageResources returns the resources of the stage which exist
+// before the stage is applied: the previously deployed ones and the ones
+// deployed by the resumed run.
+func (m *RolloutPhaseManager) ExistingStageResources(stageIndex int) kube.ResourceList {
+	existing := m.PreviouslyDeployedStageResources(stageIndex)
+	for _, res := range m.resumedResources.Intersect(m.Phase.SortedStages[stageIndex].DesiredResources) {
+		if !existing.Contains(res) {
+			existing = append(existing, res)
+		}
+	}
+
+	return existing
+}
+
+// OrphanedResources returns previously deployed resources which are not in
+// the release anymore.
+func (m *RolloutPhaseManager) OrphanedResources() kube.ResourceList {
+	return m.previouslyDeployedResources.Difference(m.Phase.AllResources())
+}
+
 func (m *RolloutPhaseManager) DeleteOrphanedResources() error {
-	orphanedResources := m.previouslyDeployedResources.Difference(m.Phase.AllResources())
-	_, errs := m.kubeClient.Delete(orphanedResources, kube.DeleteOptions{
+	result, errs := kube.AsInterfaceContext(m.kubeClient).DeleteWithContext(m.ctx, m.OrphanedResources(), kube.DeleteOptions{
 		Wait:                   true,
 		SkipIfInvalidOwnership: true,
 		ReleaseName:            m.Release.Name,
 		ReleaseNamespace:       m.Release.Namespace,
 	})
+	if result != nil {
+		m.deletedOrphanedResources = result.Deleted
+		for _, res := range result.Deleted {
+			events.Record(m.events, events.NewOrphanDeleted(kube.ResourceNameNamespaceKind(res)))
+		}
+	}
 	if len(errs) > 0 {
 		return fmt.Errorf("while deleting previously deployed but now orphaned resources got %d error(s): %s", len(errs), joinErrors(errs))
 	}
@@ -90,6 +223,88 @@ func (m *RolloutPhaseManager) DeleteOrphanedResources() error {
 	return nil
 }
 
+// execStageHooks executes the stage hooks of the event. When stages are
+// deployed one by one, the stage and the hook event are recorded in the
+// release, so resuming restarts the stage and pre-stage hooks don't mark the
+// stage as deployed. Concurrently deployed stages keep the stage recorded by
+// DoStagesConcurrently, which never points past a not yet deployed stage.
+func (m *RolloutPhaseManager) execStageHooks(hook rel.HookEvent, stgIndex int, stage *stages.Stage) error {
+	if m.stageHooksFn == nil {
+		return nil
+	}
+
+	return m.stageHooksFn(hook, stage, func() error {
+		m.storageMux.Lock()
+		defer m.storageMux.Unlock()
+
+		if !m.concurrent {
+			rel.SetHookPhaseStageInfo(m.Release, stgIndex, hook)
+		}
+
+		if err := m.Storage.Update(m.Release); err != nil {
+			return fmt.Errorf("error updating release in storage: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// validateStageHooks checks that every stage hook is bound to an existing
+// stage. Stages of a dependency graph have no weights of their own, so stage
+// hooks can't be bound to them.
+func (m *RolloutPhaseManager) validateStageHooks() error {
+	if m.stageHooksFn == nil {
+		return nil
+	}
+
+	for _, h := range m.Release.Hooks {
+		if !isStageHook(h) {
+			continue
+		}
+
+		if m.Phase.SortedStages.IsDependencyGraph() {
+			return fmt.Errorf("stage hook %q can't be used with stages split by dependencies", h.Name)
+		}
+
+		if m.Phase.SortedStages.StageByWeight(h.StageWeight) == nil {
+			return fmt.Errorf("stage hook %q is bound to stage weight %d, but there is no stage with this weight", h.Name, h.StageWeight)
+		}
+	}
+
+	return nil
+}
+
+func isStageHook(h *rel.Hook) bool {
+	for _, e := range h.Events {
+		if e == rel.HookPreStage || e == rel.HookPostStage {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CreatedResourcesOfFailedStages returns the resources created by the stages
+// which failed to apply. Several stages can fail when they are deployed
+// concurrently.
+func (m *RolloutPhaseManager) CreatedResourcesOfFailedStages() kube.ResourceList {
+	created := kube.ResourceList{}
+	for _, stage := range m.Phase.SortedStages {
+		var applyErr *ApplyError
+		if stage.Result != nil && errors.As(stage.Err, &applyErr) {
+			created = append(created, stage.Result.Created...)
+		}
+	}
+
+	return created
+}
+
+// DeletedOrphanedResources returns the orphaned resources deleted by
+// DeleteOrphanedResources.
+func (m *RolloutPhaseManager) DeletedOrphanedResources() kube.ResourceList {
+	return m.deletedOrphanedResources
+}
+
 func joinErrors(errs []error) string {
 	es := make([]string, 0, len(errs))
 	for _, e := range errs {
@@ -0,0 +1,212 @@
+package phasemanagers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/events"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
+	rel "github.com/werf/3p-helm-for-werf-helm/pkg/release"
+)
+
+// DoStagesConcurrently does the same as DoStage, but starts each stage as soon
+// as all of its dependencies are applied and tracked, so independent stages are
+// deployed in parallel. The callbacks must be safe for concurrent use. On the
+// first failure no more stages are started, and the error is returned after
+// the already started stages finish.
+//
+// The stage recorded in the release is the last one of the longest sequence of
+// applied stages starting from the first stage, so stages are never considered
+// deployed before they actually are. It is saved before each wave of stages is
+// started and once all stages are done.
+func (m *RolloutPhaseManager) DoStagesConcurrently(
+	extDepTrackFn func(stgIndex int, stage *stages.Stage) error,
+	applyFn func(stgIndex int, stage *stages.Stage, prevDeployedStgResources kube.ResourceList) error,
+	trackFn func(stgIndex int, stage *stages.Stage) error,
+) error {
+	if err := m.validateStageHooks(); err != nil {
+		return err
+	}
+
+	stageList := m.Phase.SortedStages
+
+	stageIndexes := make(map[*stages.Stage]int, len(stageList))
+	for i, stg := range stageList {
+		stageIndexes[stg] = i
+	}
+
+	started := make([]bool, len(stageList))
+	finished := make([]bool, len(stageList))
+	applied := make([]bool, len(stageList))
+	for i := 0; i < m.firstStageIndex && i < len(stageList); i++ {
+		started[i], finished[i], applied[i] = true, true, true
+	}
+
+	m.concurrent = true
+	recordApplied := func(stgIndex int) error {
+		m.storageMux.Lock()
+		defer m.storageMux.Unlock()
+
+		applied[stgIndex] = true
+
+		return nil
+	}
+
+	// The progress is saved once per wave of started stages instead of after
+	// every applied stage, since each save rewrites the whole release.
+	recordedPrefixEnd := m.firstStageIndex - 1
+	recordProgress := func() error {
+		m.storageMux.Lock()
+		defer m.storageMux.Unlock()
+
+		appliedPrefixEnd := recordedPrefixEnd
+		for appliedPrefixEnd+1 < len(applied) && applied[appliedPrefixEnd+1] {
+			appliedPrefixEnd++
+		}
+		if appliedPrefixEnd <= recordedPrefixEnd {
+			return nil
+		}
+
+		rel.SetRolloutPhaseStageInfo(m.Release, appliedPrefixEnd)
+		if err := m.Storage.Update(m.Release); err != nil {
+			return fmt.Errorf("error updating release in storage: %w", err)
+		}
+		recordedPrefixEnd = appliedPrefixEnd
+
+		return nil
+	}
+
+	dependenciesFinished := func(stgIndex int) bool {
+		for _, dependency := range stageList.Dependencies(stgIndex) {
+			if !finished[stageIndexes[dependency]] {
+				return false
+			}
+		}
+
+		return true
+	}
+
+	type stageResult struct {
+		index int
+		err   error
+	}
+	results := make(chan stageResult)
+
+	var running int
+	var firstErr error
+	handleResult := func(result stageResult) {
+		running--
+
+		if result.err != nil {
+			if firstErr == nil {
+				firstErr = result.err
+			}
+			return
+		}
+
+		finished[result.index] = true
+	}
+
+	for {
+		if firstErr == nil {
+			var wave []int
+			for i := range stageList {
+				if !started[i] && dependenciesFinished(i) {
+					wave = append(wave, i)
+				}
+			}
+
+			if len(wave) > 0 {
+				if err := recordProgress(); err != nil {
+					firstErr = err
+					wave = nil
+				}
+			}
+
+			for _, i := range wave {
+				started[i] = true
+				running++
+
+				m.markStageStarted(i)
+
+				go func(stgIndex int, stage *stages.Stage) {
+					results <- stageResult{
+						index: stgIndex,
+						err:   m.doSingleStage(stgIndex, stage, extDepTrackFn, applyFn, trackFn, recordApplied),
+					}
+				}(i, stageList[i])
+			}
+		}
+
+		if running == 0 {
+			break
+		}
+
+		handleResult(<-results)
+
+		// Collect the stages which finished meanwhile, so they start the next
+		// wave together.
+		for collected := false; !collected && running > 0; {
+			select {
+			case result := <-results:
+				handleResult(result)
+			default:
+				collected = true
+			}
+		}
+	}
+
+	if err := recordProgress(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
+
+func (m *RolloutPhaseManager) doSingleStage(
+	stgIndex int,
+	stage *stages.Stage,
+	extDepTrackFn func(stgIndex int, stage *stages.Stage) error,
+	applyFn func(stgIndex int, stage *stages.Stage, prevDeployedStgResources kube.ResourceList) error,
+	trackFn func(stgIndex int, stage *stages.Stage) error,
+	recordAppliedFn func(stgIndex int) error,
+) (err error) {
+	stage.StartedAt = time.Now()
+	defer func() {
+		stage.CompletedAt = time.Now()
+		stage.Err = err
+	}()
+
+	if err := m.ctx.Err(); err != nil {
+		return fmt.Errorf("stage not started: %w", err)
+	}
+
+	events.Record(m.events, events.NewStageStarted(stgIndex, stage.Weight, len(stage.DesiredResources)))
+
+	if err := extDepTrackFn(stgIndex, stage); err != nil {
+		return fmt.Errorf("error tracking external dependencies: %w", err)
+	}
+
+	if err := m.execStageHooks(rel.HookPreStage, stgIndex, stage); err != nil {
+		return fmt.Errorf("pre-stage hooks failed: %w", err)
+	}
+
+	if err := applyFn(stgIndex, stage, m.ExistingStageResources(stgIndex)); err != nil {
+		return &ApplyError{StageIndex: stgIndex, Err: err}
+	}
+
+	if err := recordAppliedFn(stgIndex); err != nil {
+		return err
+	}
+
+	if err := trackFn(stgIndex, stage); err != nil {
+		return fmt.Errorf("error tracking resources: %w", err)
+	}
+
+	if err := m.execStageHooks(rel.HookPostStage, stgIndex, stage); err != nil {
+		return fmt.Errorf("post-stage hooks failed: %w", err)
+	}
+
+	return nil
+}
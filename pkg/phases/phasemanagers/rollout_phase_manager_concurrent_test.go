@@ -0,0 +1,168 @@
+package phasemanagers
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
+	rel "github.com/werf/3p-helm-for-werf-helm/pkg/release"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/storage"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/storage/driver"
+)
+
+func TestDoStagesConcurrently(t *testing.T) {
+	release := rel.Mock(&rel.MockReleaseOptions{Name: "concurrent", Status: rel.StatusPendingInstall})
+	releases := storage.Init(driver.NewMemory())
+	if err := releases.Create(release); err != nil {
+		t.Fatal(err)
+	}
+
+	// first and second are independent, last depends on both of them.
+	first := &stages.Stage{Weight: 0, DependsOn: []*stages.Stage{}}
+	second := &stages.Stage{Weight: 1, DependsOn: []*stages.Stage{}}
+	last := &stages.Stage{Weight: 2, DependsOn: []*stages.Stage{first, second}}
+
+	phase := &phases.RolloutPhase{SortedStages: stages.SortedStageList{first, second, last}, Release: release}
+	manager := NewRolloutPhaseManager(phase, nil, release, releases, nil)
+
+	var mux sync.Mutex
+	var tracked []int
+	bothStarted := make(chan struct{})
+	var startedIndependent sync.WaitGroup
+	startedIndependent.Add(2)
+	go func() {
+		startedIndependent.Wait()
+		close(bothStarted)
+	}()
+
+	noop := func(int, *stages.Stage) error { return nil }
+
+	if err := manager.DoStagesConcurrently(
+		noop,
+		func(stgIndex int, _ *stages.Stage, _ kube.ResourceList) error {
+			if stgIndex == 2 {
+				return nil
+			}
+
+			startedIndependent.Done()
+			select {
+			case <-bothStarted:
+				return nil
+			case <-time.After(5 * time.Second):
+				t.Errorf("independent stage %d was not applied concurrently with the other one", stgIndex)
+				return nil
+			}
+		},
+		func(stgIndex int, _ *stages.Stage) error {
+			mux.Lock()
+			defer mux.Unlock()
+			tracked = append(tracked, stgIndex)
+			return nil
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tracked) != 3 || tracked[2] != 2 {
+		t.Errorf("expected dependent stage to be tracked last, got order %v", tracked)
+	}
+
+	if release.Info.LastStage == nil || *release.Info.LastStage != 2 {
+		t.Errorf("expected last stage 2 to be recorded, got %v", release.Info.LastStage)
+	}
+}
+
+type countingDriver struct {
+	*driver.Memory
+	updates int
+}
+
+func (d *countingDriver) Update(key string, rls *rel.Release) error {
+	d.updates++
+	return d.Memory.Update(key, rls)
+}
+
+func TestDoStagesConcurrentlyRecordsProgressPerWave(t *testing.T) {
+	release := rel.Mock(&rel.MockReleaseOptions{Name: "concurrent-waves", Status: rel.StatusPendingInstall})
+	counting := &countingDriver{Memory: driver.NewMemory()}
+	releases := storage.Init(counting)
+	if err := releases.Create(release); err != nil {
+		t.Fatal(err)
+	}
+
+	// A wave of independent stages followed by a stage depending on all of them.
+	var stageList stages.SortedStageList
+	for i := 0; i < 100; i++ {
+		stageList = append(stageList, &stages.Stage{Weight: i, DependsOn: []*stages.Stage{}})
+	}
+	stageList = append(stageList, &stages.Stage{Weight: 100, DependsOn: append([]*stages.Stage{}, stageList...)})
+
+	phase := &phases.RolloutPhase{SortedStages: stageList, Release: release}
+	manager := NewRolloutPhaseManager(phase, nil, release, releases, nil)
+
+	noop := func(int, *stages.Stage) error { return nil }
+	if err := manager.DoStagesConcurrently(
+		noop,
+		func(int, *stages.Stage, kube.ResourceList) error { return nil },
+		noop,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if counting.updates != 2 {
+		t.Errorf("expected progress to be saved before the second wave and at the end, got %d saves", counting.updates)
+	}
+
+	if release.Info.LastStage == nil || *release.Info.LastStage != 100 {
+		t.Errorf("expected last stage 100 to be recorded, got %v", release.Info.LastStage)
+	}
+}
+
+func TestRollbackStagesConcurrently(t *testing.T) {
+	release := rel.Mock(&rel.MockReleaseOptions{Name: "concurrent-rollback", Status: rel.StatusPendingUpgrade})
+	releases := storage.Init(driver.NewMemory())
+	if err := releases.Create(release); err != nil {
+		t.Fatal(err)
+	}
+
+	// skipped is deployed by an interrupted run, failed fails, dependent is
+	// never started and independent is deployed.
+	skipped := &stages.Stage{Weight: 0, DependsOn: []*stages.Stage{}}
+	failed := &stages.Stage{Weight: 1, DependsOn: []*stages.Stage{}}
+	dependent := &stages.Stage{Weight: 2, DependsOn: []*stages.Stage{failed}}
+	independent := &stages.Stage{Weight: 3, DependsOn: []*stages.Stage{}}
+
+	phase := &phases.RolloutPhase{SortedStages: stages.SortedStageList{skipped, failed, dependent, independent}, Release: release}
+	manager := NewRolloutPhaseManager(phase, nil, release, releases, nil).SkipStagesBefore(1)
+
+	noop := func(int, *stages.Stage) error { return nil }
+	if err := manager.DoStagesConcurrently(
+		noop,
+		func(stgIndex int, _ *stages.Stage, _ kube.ResourceList) error {
+			if stgIndex == 1 {
+				return errors.New("apply failed")
+			}
+			return nil
+		},
+		noop,
+	); err == nil {
+		t.Fatal("expected stage to fail")
+	}
+
+	var rolledBack []int
+	if err := manager.RollbackStages(func(stgIndex int, _ *stages.Stage, _ kube.ResourceList) error {
+		rolledBack = append(rolledBack, stgIndex)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []int{3, 1}; !reflect.DeepEqual(expected, rolledBack) {
+		t.Errorf("expected started stages %v to be rolled back, got %v", expected, rolledBack)
+	}
+}
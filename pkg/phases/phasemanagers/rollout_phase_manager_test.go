@@ -0,0 +1,180 @@
+package phasemanagers
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
+	rel "github.com/werf/3p-helm-for-werf-helm/pkg/release"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/storage"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/storage/driver"
+)
+
+func TestDoStageWithStageHooks(t *testing.T) {
+	release := rel.Mock(&rel.MockReleaseOptions{Name: "stage-hooks", Status: rel.StatusPendingInstall})
+	releases := storage.Init(driver.NewMemory())
+	if err := releases.Create(release); err != nil {
+		t.Fatal(err)
+	}
+
+	phase := &phases.RolloutPhase{SortedStages: stages.SortedStageList{{Weight: -5}, {Weight: 10}}, Release: release}
+
+	var steps []string
+	manager := NewRolloutPhaseManager(phase, nil, release, releases, nil).
+		WithStageHooks(func(hook rel.HookEvent, stage *stages.Stage, record func() error) error {
+			if err := record(); err != nil {
+				return err
+			}
+
+			steps = append(steps, fmt.Sprintf("%s %d recorded %s %d", hook, stage.Weight, *release.Info.LastPhase, *release.Info.LastStage))
+			return nil
+		})
+
+	if err := manager.DoStage(
+		func(int, *stages.Stage) error { return nil },
+		func(stgIndex int, _ *stages.Stage, _ kube.ResourceList) error {
+			steps = append(steps, fmt.Sprintf("apply %d", stgIndex))
+			return nil
+		},
+		func(stgIndex int, _ *stages.Stage) error {
+			steps = append(steps, fmt.Sprintf("track %d", stgIndex))
+			return nil
+		},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"pre-stage -5 recorded stage-hooks-pre 0",
+		"apply 0",
+		"track 0",
+		"post-stage -5 recorded stage-hooks-post 0",
+		"pre-stage 10 recorded stage-hooks-pre 1",
+		"apply 1",
+		"track 1",
+		"post-stage 10 recorded stage-hooks-post 1",
+	}
+	if !reflect.DeepEqual(expected, steps) {
+		t.Errorf("expected steps %v, got %v", expected, steps)
+	}
+}
+
+func TestDoStageFailedPreStageHooks(t *testing.T) {
+	release := rel.Mock(&rel.MockReleaseOptions{Name: "failed-stage-hooks", Status: rel.StatusPendingInstall})
+	releases := storage.Init(driver.NewMemory())
+	if err := releases.Create(release); err != nil {
+		t.Fatal(err)
+	}
+
+	phase := &phases.RolloutPhase{SortedStages: stages.SortedStageList{{Weight: 0}}, Release: release}
+	hookErr := errors.New("smoke test failed")
+	manager := NewRolloutPhaseManager(phase, nil, release, releases, nil).
+		WithStageHooks(func(rel.HookEvent, *stages.Stage, func() error) error {
+			return hookErr
+		})
+
+	err := manager.DoStage(
+		func(int, *stages.Stage) error { return nil },
+		func(int, *stages.Stage, kube.ResourceList) error {
+			t.Error("stage must not be applied if its pre-stage hooks fail")
+			return nil
+		},
+		func(int, *stages.Stage) error { return nil },
+	)
+	if !errors.Is(err, hookErr) {
+		t.Errorf("expected pre-stage hooks error, got %v", err)
+	}
+}
+
+func TestDoStageInvalidStageHooks(t *testing.T) {
+	for name, stageList := range map[string]stages.SortedStageList{
+		"missing weight":   {{Weight: 0}},
+		"dependency graph": {{Weight: 0, DependsOn: []*stages.Stage{}}, {Weight: 5, DependsOn: []*stages.Stage{}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			release := rel.Mock(&rel.MockReleaseOptions{Name: "invalid-stage-hooks", Status: rel.StatusPendingInstall})
+			release.Hooks = append(release.Hooks, &rel.Hook{Name: "smoke-test", Events: []rel.HookEvent{rel.HookPostStage}, StageWeight: 5})
+
+			phase := &phases.RolloutPhase{SortedStages: stageList, Release: release}
+			manager := NewRolloutPhaseManager(phase, nil, release, nil, nil).
+				WithStageHooks(func(rel.HookEvent, *stages.Stage, func() error) error {
+					t.Error("stage hooks must not be executed")
+					return nil
+				})
+
+			if err := manager.DoStage(
+				func(int, *stages.Stage) error { return nil },
+				func(int, *stages.Stage, kube.ResourceList) error {
+					t.Error("stages must not be applied")
+					return nil
+				},
+				func(int, *stages.Stage) error { return nil },
+			); err == nil {
+				t.Error("expected error for stage hook not bound to a stage")
+			}
+		})
+	}
+}
+
+func TestResumedResourcesAreNotRolledBack(t *testing.T) {
+	release := rel.Mock(&rel.MockReleaseOptions{Name: "resumed", Status: rel.StatusPendingUpgrade})
+	releases := storage.Init(driver.NewMemory())
+	if err := releases.Create(release); err != nil {
+		t.Fatal(err)
+	}
+
+	deployed := newTestResource("deployed")
+	resumed := newTestResource("resumed")
+	phase := &phases.RolloutPhase{
+		SortedStages: stages.SortedStageList{{Weight: 0, DesiredResources: kube.ResourceList{deployed, resumed}}},
+		Release:      release,
+	}
+	manager := NewRolloutPhaseManager(phase, nil, release, releases, nil).
+		AddPreviouslyDeployedResources(kube.ResourceList{deployed}).
+		AddResumedResources(kube.ResourceList{resumed})
+
+	var applied kube.ResourceList
+	if err := manager.DoStage(
+		func(int, *stages.Stage) error { return nil },
+		func(_ int, _ *stages.Stage, existing kube.ResourceList) error {
+			applied = existing
+			return nil
+		},
+		func(int, *stages.Stage) error { return nil },
+	); err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 2 {
+		t.Errorf("expected both resources to be applied over as existing, got %d", len(applied))
+	}
+
+	var restored kube.ResourceList
+	if err := manager.RollbackStages(func(_ int, _ *stages.Stage, prev kube.ResourceList) error {
+		restored = prev
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 1 || restored[0].Name != "deployed" {
+		t.Errorf("expected only the previously deployed resource to be restored, got %v", restored)
+	}
+	if orphaned := manager.OrphanedResources(); len(orphaned) != 0 {
+		t.Errorf("expected no orphaned resources, got %v", orphaned)
+	}
+}
+
+func newTestResource(name string) *resource.Info {
+	return &resource.Info{
+		Name:      name,
+		Namespace: "default",
+		Mapping:   &meta.RESTMapping{GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"}},
+	}
+}
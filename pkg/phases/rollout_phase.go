@@ -83,9 +83,19 @@ func (m *RolloutPhase) LastDeployedStageIndex() *int {
 	// Phase started but not completed.
 	if m.Release.Info.LastStage == nil {
 		return &lastStage
-	} else {
-		return m.Release.Info.LastStage
 	}
+
+	// Pre-stage hooks run before their stage is applied.
+	if m.Release.Info.LastPhase != nil && *m.Release.Info.LastPhase == rel.PhaseStageHooksPre {
+		if *m.Release.Info.LastStage == 0 {
+			return nil
+		}
+
+		previousStage := *m.Release.Info.LastStage - 1
+		return &previousStage
+	}
+
+	return m.Release.Info.LastStage
 }
 
 func (m *RolloutPhase) IsPhaseStarted() bool {
@@ -94,7 +104,7 @@ func (m *RolloutPhase) IsPhaseStarted() bool {
 	}
 
 	switch *m.Release.Info.LastPhase {
-	case rel.PhaseRollout, rel.PhaseUninstall, rel.PhaseHooksPost, rel.PhaseHooksPre:
+	case rel.PhaseRollout, rel.PhaseStageHooksPre, rel.PhaseStageHooksPost, rel.PhaseUninstall, rel.PhaseHooksPost, rel.PhaseHooksPre:
 		return true
 	default:
 		return false
@@ -0,0 +1,48 @@
+package externaldeps
+
+import (
+	"fmt"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func NewRESTMapperGVKBuilder(mapper meta.RESTMapper) *RESTMapperGVKBuilder {
+	return &RESTMapperGVKBuilder{
+		mapper: mapper,
+	}
+}
+
+// RESTMapperGVKBuilder resolves resource types in kubectl notation, e.g.
+// "deployment", "deployments.apps" or "deployment.v1.apps", to a fully
+// qualified GroupVersionKind.
+type RESTMapperGVKBuilder struct {
+	mapper meta.RESTMapper
+}
+
+func (b *RESTMapperGVKBuilder) BuildFromResource(resource string) (*schema.GroupVersionKind, error) {
+	fullySpecifiedGVR, groupResource := schema.ParseResourceArg(strings.ToLower(resource))
+
+	if fullySpecifiedGVR != nil {
+		if gvr, err := b.mapper.ResourceFor(*fullySpecifiedGVR); err == nil {
+			return b.kindFor(gvr)
+		}
+	}
+
+	gvr, err := b.mapper.ResourceFor(groupResource.WithVersion(""))
+	if err != nil {
+		return nil, fmt.Errorf("error mapping %q to api resource: %w", resource, err)
+	}
+
+	return b.kindFor(gvr)
+}
+
+func (b *RESTMapperGVKBuilder) kindFor(gvr schema.GroupVersionResource) (*schema.GroupVersionKind, error) {
+	gvk, err := b.mapper.KindFor(gvr)
+	if err != nil {
+		return nil, fmt.Errorf("error mapping %q to api kind: %w", gvr.String(), err)
+	}
+
+	return &gvk, nil
+}
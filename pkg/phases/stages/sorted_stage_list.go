@@ -28,6 +28,28 @@ func (l SortedStageList) StageByWeight(weight int) *Stage {
 	return nil
 }
 
+// IsDependencyGraph returns true if at least one stage declares its own
+// dependencies instead of depending on all stages sorted before it.
+func (l SortedStageList) IsDependencyGraph() bool {
+	for _, stg := range l {
+		if stg.DependsOn != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Dependencies returns the stages that have to be deployed before the stage
+// with the given index.
+func (l SortedStageList) Dependencies(index int) []*Stage {
+	if l[index].DependsOn != nil {
+		return l[index].DependsOn
+	}
+
+	return l[:index]
+}
+
 func (l SortedStageList) MergedCreatedResources() kube.ResourceList {
 	return l.MergedCreatedResourcesInStagesRange(0, len(l)-1)
 }
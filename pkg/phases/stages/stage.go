@@ -1,6 +1,8 @@
 package stages
 
 import (
+	"time"
+
 	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages/externaldeps"
 )
@@ -10,4 +12,12 @@ type Stage struct {
 	ExternalDependencies externaldeps.ExternalDependencyList
 	DesiredResources     kube.ResourceList
 	Result               *kube.Result
+	// DependsOn lists the only stages that have to be deployed before this
+	// one. If nil, the stage depends on all stages sorted before it.
+	DependsOn []*Stage
+	// StartedAt and CompletedAt are set when the stage is deployed. Err is
+	// the error the stage deployment failed with.
+	StartedAt   time.Time
+	CompletedAt time.Time
+	Err         error
 }
@@ -0,0 +1,73 @@
+package phases
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/cli-runtime/pkg/resource"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+	"github.com/werf/3p-helm-for-werf-helm/pkg/phases/stages"
+)
+
+const StageWeightAnnoName = "werf.io/weight"
+
+// WeightedStagesSplitter groups resources into stages by the value of the
+// StageWeightAnnoName annotation. Resources without the annotation get weight 0.
+type WeightedStagesSplitter struct{}
+
+func (s *WeightedStagesSplitter) Split(resources kube.ResourceList) (stages.SortedStageList, error) {
+	stageList := stages.SortedStageList{}
+
+	if err := resources.Visit(func(res *resource.Info, err error) error {
+		if err != nil {
+			return err
+		}
+
+		weight, err := resourceStageWeight(res)
+		if err != nil {
+			return err
+		}
+
+		stage := stageList.StageByWeight(weight)
+		if stage == nil {
+			stage = &stages.Stage{Weight: weight}
+			stageList = append(stageList, stage)
+		}
+
+		stage.DesiredResources.Append(res)
+
+		return nil
+	}); err != nil {
+		return nil, fmt.Errorf("error visiting resources list: %w", err)
+	}
+
+	if len(stageList) == 0 {
+		stageList = append(stageList, &stages.Stage{})
+	}
+
+	sort.Stable(stageList)
+
+	return stageList, nil
+}
+
+func resourceStageWeight(res *resource.Info) (int, error) {
+	accessor, err := meta.Accessor(res.Object)
+	if err != nil {
+		return 0, fmt.Errorf("error getting metadata accessor for %q: %w", kube.ResourceNameNamespaceKind(res), err)
+	}
+
+	value, found := accessor.GetAnnotations()[StageWeightAnnoName]
+	if !found {
+		return 0, nil
+	}
+
+	weight, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q for annotation %q on %q: %w", value, StageWeightAnnoName, kube.ResourceNameNamespaceKind(res), err)
+	}
+
+	return weight, nil
+}
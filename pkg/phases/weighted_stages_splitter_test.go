@@ -0,0 +1,64 @@
+package phases
+
+import (
+	"testing"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/cli-runtime/pkg/resource"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/kube"
+)
+
+func TestWeightedStagesSplitter(t *testing.T) {
+	mapping := &meta.RESTMapping{
+		GroupVersionKind: schema.GroupVersionKind{Version: "v1", Kind: "ConfigMap"},
+	}
+
+	info := func(name string, weight string) *resource.Info {
+		obj := &unstructured.Unstructured{}
+		obj.SetGroupVersionKind(mapping.GroupVersionKind)
+		obj.SetName(name)
+		if weight != "" {
+			obj.SetAnnotations(map[string]string{StageWeightAnnoName: weight})
+		}
+
+		return &resource.Info{Name: name, Mapping: mapping, Object: obj}
+	}
+
+	resources := kube.ResourceList{info("a", "10"), info("b", ""), info("c", "-5"), info("d", "10")}
+
+	stageList, err := (&WeightedStagesSplitter{}).Split(resources)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedWeights := []int{-5, 0, 10}
+	if len(stageList) != len(expectedWeights) {
+		t.Fatalf("expected %d stages, got %d", len(expectedWeights), len(stageList))
+	}
+
+	for i, weight := range expectedWeights {
+		if stageList[i].Weight != weight {
+			t.Errorf("expected stage %d to have weight %d, got %d", i, weight, stageList[i].Weight)
+		}
+	}
+
+	if len(stageList[2].DesiredResources) != 2 {
+		t.Errorf("expected 2 resources in stage with weight 10, got %d", len(stageList[2].DesiredResources))
+	}
+
+	if _, err := (&WeightedStagesSplitter{}).Split(kube.ResourceList{info("e", "heavy")}); err == nil {
+		t.Error("expected error for invalid weight annotation")
+	}
+
+	stageList, err = (&WeightedStagesSplitter{}).Split(kube.ResourceList{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stageList) != 1 {
+		t.Errorf("expected single empty stage for empty resource list, got %d stages", len(stageList))
+	}
+}
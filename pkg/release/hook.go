@@ -17,6 +17,10 @@ limitations under the License.
 package release
 
 import (
+	"fmt"
+	"strings"
+	stdtime "time"
+
 	"github.com/werf/3p-helm-for-werf-helm/pkg/time"
 )
 
@@ -34,6 +38,10 @@ const (
 	HookPreRollback  HookEvent = "pre-rollback"
 	HookPostRollback HookEvent = "post-rollback"
 	HookTest         HookEvent = "test"
+	// HookPreStage and HookPostStage fire before applying and after tracking
+	// the rollout stage with the weight set in HookStageWeightAnnotation.
+	HookPreStage  HookEvent = "pre-stage"
+	HookPostStage HookEvent = "post-stage"
 )
 
 func (x HookEvent) String() string { return string(x) }
@@ -59,6 +67,22 @@ const HookWeightAnnotation = "helm.sh/hook-weight"
 // HookDeleteAnnotation is the label name for the delete policy for a hook
 const HookDeleteAnnotation = "helm.sh/hook-delete-policy"
 
+// HookStageWeightAnnotation is the label name for the weight of the rollout
+// stage the pre-stage and post-stage hooks are bound to
+const HookStageWeightAnnotation = "werf.io/hook-stage-weight"
+
+// HookRetriesAnnotation is the label name for the number of times a failed
+// hook is executed again
+const HookRetriesAnnotation = "werf.io/hook-retries"
+
+// HookRetryBackoffAnnotation is the label name for the delay before the first
+// retry of a failed hook. The delay is doubled for every next retry
+const HookRetryBackoffAnnotation = "werf.io/hook-retry-backoff"
+
+// HookTimeoutAnnotation is the label name for the time to wait for a hook to
+// complete, overriding the timeout of the action
+const HookTimeoutAnnotation = "werf.io/hook-timeout"
+
 // Hook defines a hook object.
 type Hook struct {
 	Name string `json:"name,omitempty"`
@@ -76,6 +100,16 @@ type Hook struct {
 	Weight int `json:"weight,omitempty"`
 	// DeletePolicies are the policies that indicate when to delete the hook
 	DeletePolicies []HookDeletePolicy `json:"delete_policies,omitempty"`
+	// StageWeight is the weight of the rollout stage the pre-stage and
+	// post-stage hooks are bound to
+	StageWeight int `json:"stage_weight,omitempty"`
+	// Retries is the number of times the hook is executed again if it fails
+	Retries int `json:"retries,omitempty"`
+	// RetryBackoff is the delay before the first retry, doubled for every next one
+	RetryBackoff stdtime.Duration `json:"retry_backoff,omitempty"`
+	// Timeout is the time to wait for the hook to complete. If zero, the
+	// timeout of the action is used
+	Timeout stdtime.Duration `json:"timeout,omitempty"`
 }
 
 // A HookExecution records the result for the last execution of a hook for a given release.
@@ -86,6 +120,22 @@ type HookExecution struct {
 	CompletedAt time.Time `json:"completed_at,omitempty"`
 	// Phase indicates whether the hook completed successfully
 	Phase HookPhase `json:"phase"`
+	// Retries is the number of times the hook was executed again after failures
+	Retries int `json:"retries,omitempty"`
+	// Logs are the tails of the container logs of the hook pods, captured when
+	// the hook completed.
+	Logs []HookContainerLogs `json:"logs,omitempty"`
+}
+
+// HookContainerLogs are the logs of a single container of a hook pod.
+type HookContainerLogs struct {
+	Pod       string `json:"pod"`
+	Container string `json:"container"`
+	Logs      string `json:"logs"`
+}
+
+func (l HookContainerLogs) String() string {
+	return fmt.Sprintf("==> %s/%s logs:\n%s", l.Pod, l.Container, strings.TrimRight(l.Logs, "\n"))
 }
 
 // A HookPhase indicates the state of a hook execution
@@ -10,6 +10,11 @@ const (
 	PhaseRollout   Phase = "rollout"
 	PhaseUninstall Phase = "uninstall"
 	PhaseHooksPost Phase = "hooks-post"
+	// PhaseStageHooksPre and PhaseStageHooksPost are the parts of the rollout
+	// phase running the pre-stage hooks before the stage is applied and the
+	// post-stage hooks after it is tracked.
+	PhaseStageHooksPre  Phase = "stage-hooks-pre"
+	PhaseStageHooksPost Phase = "stage-hooks-post"
 )
 
 // May return empty string.
@@ -20,6 +25,10 @@ func PhaseFromHookEvent(hookEvent HookEvent) Phase {
 		phase = PhaseHooksPre
 	case HookPostInstall, HookPostDelete, HookPostUpgrade, HookPostRollback:
 		phase = PhaseHooksPost
+	case HookPreStage:
+		phase = PhaseStageHooksPre
+	case HookPostStage:
+		phase = PhaseStageHooksPost
 	case HookTest:
 	default:
 		panic(fmt.Sprintf("unexpected HookEvent: %s", hookEvent.String()))
@@ -37,6 +46,8 @@ func SetInitPhaseStageInfo(rel *Release) *Release {
 	return rel
 }
 
+// SetHookPhaseStageInfo records the hook being executed. For the stage hooks
+// the index is the index of the rollout stage the hooks are bound to.
 func SetHookPhaseStageInfo(rel *Release, hookIndex int, hook HookEvent) *Release {
 	lastPhase := PhaseFromHookEvent(hook)
 	rel.Info.LastPhase = &lastPhase
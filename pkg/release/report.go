@@ -20,6 +20,37 @@ type DeployReport struct {
 	LastStage         *int      `json:"last_stage,omitempty"`
 	FirstDeployedTime time.Time `json:"first_deployed,omitempty"`
 	LastDeployedTime  time.Time `json:"last_deployed,omitempty"`
+	// Stages are the rollout stages in the order of their weights, including
+	// the ones that weren't reached.
+	Stages []*DeployReportStage `json:"stages,omitempty"`
+	// Hooks are the hooks executed during the deploy.
+	Hooks []*DeployReportHook `json:"hooks,omitempty"`
+	// DeletedOrphans are the previously deployed resources which are not in
+	// the release anymore and were deleted.
+	DeletedOrphans []string `json:"deleted_orphans,omitempty"`
+	Errors         []string `json:"errors,omitempty"`
+}
+
+// DeployReportStage is the outcome of a single rollout stage. Resources are
+// identified as "namespace:Kind/name".
+type DeployReportStage struct {
+	Weight      int       `json:"weight"`
+	Created     []string  `json:"created,omitempty"`
+	Updated     []string  `json:"updated,omitempty"`
+	Deleted     []string  `json:"deleted,omitempty"`
+	StartedAt   time.Time `json:"started_at,omitempty"`
+	CompletedAt time.Time `json:"completed_at,omitempty"`
+	Errors      []string  `json:"errors,omitempty"`
+}
+
+// DeployReportHook is a hook executed during the deploy.
+type DeployReportHook struct {
+	Name      string        `json:"name"`
+	Kind      string        `json:"kind"`
+	Path      string        `json:"path,omitempty"`
+	Events    []HookEvent   `json:"events,omitempty"`
+	Weight    int           `json:"weight,omitempty"`
+	Execution HookExecution `json:"execution"`
 }
 
 func (r *DeployReport) FromRelease(release *Release) *DeployReport {
@@ -32,6 +63,22 @@ func (r *DeployReport) FromRelease(release *Release) *DeployReport {
 	r.FirstDeployedTime = release.Info.FirstDeployed
 	r.LastDeployedTime = release.Info.LastDeployed
 
+	r.Hooks = nil
+	for _, hook := range release.Hooks {
+		if hook.LastRun.StartedAt.IsZero() {
+			continue
+		}
+
+		r.Hooks = append(r.Hooks, &DeployReportHook{
+			Name:      hook.Name,
+			Kind:      hook.Kind,
+			Path:      hook.Path,
+			Events:    hook.Events,
+			Weight:    hook.Weight,
+			Execution: hook.LastRun,
+		})
+	}
+
 	return r
 }
 
@@ -22,6 +22,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"sigs.k8s.io/yaml"
@@ -62,6 +63,8 @@ var events = map[string]release.HookEvent{
 	release.HookPreRollback.String():  release.HookPreRollback,
 	release.HookPostRollback.String(): release.HookPostRollback,
 	release.HookTest.String():         release.HookTest,
+	release.HookPreStage.String():     release.HookPreStage,
+	release.HookPostStage.String():    release.HookPostStage,
 	// Support test-success for backward compatibility with Helm 2 tests
 	"test-success": release.HookTest,
 }
@@ -175,6 +178,10 @@ func (file *manifestFile) sort(result *result) error {
 			Events:         []release.HookEvent{},
 			Weight:         hw,
 			DeletePolicies: []release.HookDeletePolicy{},
+			StageWeight:    calculateHookStageWeight(entry),
+			Retries:        calculateHookRetries(entry),
+			RetryBackoff:   calculateHookDuration(entry, release.HookRetryBackoffAnnotation),
+			Timeout:        calculateHookDuration(entry, release.HookTimeoutAnnotation),
 		}
 
 		isUnknownHook := false
@@ -222,6 +229,42 @@ func calculateHookWeight(entry SimpleHead) int {
 	return hw
 }
 
+// calculateHookStageWeight finds the weight of the rollout stage in the hook
+// stage weight annotation.
+//
+// If no weight is found, the assigned weight is 0
+func calculateHookStageWeight(entry SimpleHead) int {
+	sw, err := strconv.Atoi(entry.Metadata.Annotations[release.HookStageWeightAnnotation])
+	if err != nil {
+		sw = 0
+	}
+	return sw
+}
+
+// calculateHookRetries finds the number of retries in the hook retries
+// annotation.
+//
+// If no valid number is found, the hook is not retried
+func calculateHookRetries(entry SimpleHead) int {
+	retries, err := strconv.Atoi(entry.Metadata.Annotations[release.HookRetriesAnnotation])
+	if err != nil || retries < 0 {
+		retries = 0
+	}
+	return retries
+}
+
+// calculateHookDuration finds the duration, e.g. "30s", in the given hook
+// annotation.
+//
+// If no valid duration is found, the duration is 0
+func calculateHookDuration(entry SimpleHead, annotation string) time.Duration {
+	d, err := time.ParseDuration(entry.Metadata.Annotations[annotation])
+	if err != nil || d < 0 {
+		d = 0
+	}
+	return d
+}
+
 // operateAnnotationValues finds the given annotation and runs the operate function with the value of that annotation
 func operateAnnotationValues(entry SimpleHead, annotation string, operate func(p string)) {
 	if dps, ok := entry.Metadata.Annotations[annotation]; ok {
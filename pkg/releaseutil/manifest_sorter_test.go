@@ -19,6 +19,7 @@ package releaseutil
 import (
 	"reflect"
 	"testing"
+	"time"
 
 	"sigs.k8s.io/yaml"
 
@@ -226,3 +227,75 @@ metadata:
 		}
 	}
 }
+
+func TestSortManifestsStageHooks(t *testing.T) {
+	manifests := map[string]string{
+		"smoke-test": `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: smoke-test
+  annotations:
+    "helm.sh/hook": post-stage
+    "werf.io/hook-stage-weight": "-10"
+`,
+	}
+
+	hs, _, err := SortManifests(manifests, chartutil.VersionSet{"v1", "batch/v1"}, InstallOrder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	if len(hs) != 1 {
+		t.Fatalf("Expected 1 hook, got %d", len(hs))
+	}
+	if len(hs[0].Events) != 1 || hs[0].Events[0] != release.HookPostStage {
+		t.Errorf("Expected post-stage hook event, got %v", hs[0].Events)
+	}
+	if hs[0].StageWeight != -10 {
+		t.Errorf("Expected stage weight -10, got %d", hs[0].StageWeight)
+	}
+}
+
+func TestSortManifestsHookRetries(t *testing.T) {
+	manifests := map[string]string{
+		"migration": `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migration
+  annotations:
+    "helm.sh/hook": pre-upgrade
+    "werf.io/hook-retries": "3"
+    "werf.io/hook-retry-backoff": 10s
+    "werf.io/hook-timeout": 5m
+`,
+		"invalid": `apiVersion: batch/v1
+kind: Job
+metadata:
+  name: invalid
+  annotations:
+    "helm.sh/hook": pre-upgrade
+    "werf.io/hook-retries": "-1"
+    "werf.io/hook-timeout": soon
+`,
+	}
+
+	hs, _, err := SortManifests(manifests, chartutil.VersionSet{"v1", "batch/v1"}, InstallOrder)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+
+	for _, h := range hs {
+		switch h.Name {
+		case "migration":
+			if h.Retries != 3 || h.RetryBackoff != 10*time.Second || h.Timeout != 5*time.Minute {
+				t.Errorf("Expected 3 retries, 10s backoff and 5m timeout, got %d, %s and %s", h.Retries, h.RetryBackoff, h.Timeout)
+			}
+		case "invalid":
+			if h.Retries != 0 || h.RetryBackoff != 0 || h.Timeout != 0 {
+				t.Errorf("Expected invalid annotations to be ignored, got %d, %s and %s", h.Retries, h.RetryBackoff, h.Timeout)
+			}
+		default:
+			t.Errorf("Unexpected hook %s", h.Name)
+		}
+	}
+}
@@ -19,7 +19,6 @@ package driver // import "helm.sh/helm/v3/pkg/storage/driver"
 import (
 	"context"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -27,13 +26,15 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kblabels "k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/util/validation"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
 )
 
 var _ Driver = (*ConfigMaps)(nil)
+var _ Locker = (*ConfigMaps)(nil)
+var _ Summarizer = (*ConfigMaps)(nil)
+var _ Rewriter = (*ConfigMaps)(nil)
 
 // ConfigMapsDriverName is the string name of the driver.
 const ConfigMapsDriverName = "ConfigMap"
@@ -43,6 +44,13 @@ const ConfigMapsDriverName = "ConfigMap"
 type ConfigMaps struct {
 	impl corev1.ConfigMapInterface
 	Log  func(string, ...interface{})
+
+	// ChunkSize is the maximum size of the encoded release kept in a single
+	// ConfigMap. Bigger releases are split across several ConfigMaps. Zero
+	// means DefaultChunkSize, a negative value disables splitting.
+	ChunkSize int
+	// Codec compresses the stored releases. Nil means GzipCodec.
+	Codec Codec
 }
 
 // NewConfigMaps initializes a new ConfigMaps wrapping an implementation of
@@ -73,7 +81,7 @@ func (cfgmaps *ConfigMaps) Get(key string) (*rspb.Release, error) {
 		return nil, err
 	}
 	// found the configmap, decode the base64 data string
-	r, err := decodeRelease(obj.Data["release"])
+	r, err := cfgmaps.decodeObject(obj, listChunkSet(configMapChunkObjects{cfgmaps.impl}))
 	if err != nil {
 		cfgmaps.Log("get: failed to decode data %q: %s", key, err)
 		return nil, err
@@ -87,7 +95,40 @@ func (cfgmaps *ConfigMaps) Get(key string) (*rspb.Release, error) {
 // that filter(release) == true. An error is returned if the
 // configmap fails to retrieve the releases.
 func (cfgmaps *ConfigMaps) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
-	lsel := kblabels.Set{"owner": "helm"}.AsSelector()
+	return cfgmaps.list(kblabels.Set{"owner": "helm"}.AsSelector(), filter, cfgmaps.decodeObject)
+}
+
+// ListSummaries fetches the summaries of all releases and returns the
+// summaries such that filter(summary) == true.
+func (cfgmaps *ConfigMaps) ListSummaries(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
+	summarySel, releaseSel, err := summarySelectors(nil)
+	if err != nil {
+		cfgmaps.Log("list: failed to select summaries: %s", err)
+		return nil, err
+	}
+
+	summaries, err := readSummaries(cfgmaps.summaryObjects(), summarySel, cfgmaps.Codec, cfgmaps.Log)
+	if err != nil {
+		cfgmaps.Log("list: failed to list summaries: %s", err)
+		return nil, err
+	}
+
+	var results []*rspb.Release
+	for _, rls := range summaries {
+		if filter(rls) {
+			results = append(results, rls)
+		}
+	}
+
+	// summarize the releases stored without a summary object
+	rels, err := cfgmaps.list(releaseSel, filter, cfgmaps.decodeSummary)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, rels...), nil
+}
+
+func (cfgmaps *ConfigMaps) list(lsel kblabels.Selector, filter func(*rspb.Release) bool, decode func(*v1.ConfigMap, chunkLister) (*rspb.Release, error)) ([]*rspb.Release, error) {
 	opts := metav1.ListOptions{LabelSelector: lsel.String()}
 
 	list, err := cfgmaps.impl.List(context.Background(), opts)
@@ -97,12 +138,16 @@ func (cfgmaps *ConfigMaps) List(filter func(*rspb.Release) bool) ([]*rspb.Releas
 	}
 
 	var results []*rspb.Release
+	chunks := cfgmaps.listChunks(list.Items)
 
 	// iterate over the configmaps object list
 	// and decode each release
 	for _, item := range list.Items {
-		rls, err := decodeRelease(item.Data["release"])
-		if err != nil {
+		rls, err := decode(&item, chunks)
+		if isMissingKeyError(err) {
+			cfgmaps.Log("list: failed to decode release %q: %s", item.Name, err)
+			return nil, err
+		} else if err != nil {
 			cfgmaps.Log("list: failed to decode release: %v: %s", item, err)
 			continue
 		}
@@ -119,15 +164,46 @@ func (cfgmaps *ConfigMaps) List(filter func(*rspb.Release) bool) ([]*rspb.Releas
 // Query fetches all releases that match the provided map of labels.
 // An error is returned if the configmap fails to retrieve the releases.
 func (cfgmaps *ConfigMaps) Query(labels map[string]string) ([]*rspb.Release, error) {
-	ls := kblabels.Set{}
-	for k, v := range labels {
-		if errs := validation.IsValidLabelValue(v); len(errs) != 0 {
-			return nil, errors.Errorf("invalid label value: %q: %s", v, strings.Join(errs, "; "))
-		}
-		ls[k] = v
+	ls, err := querySet(labels)
+	if err != nil {
+		return nil, err
 	}
 
-	opts := metav1.ListOptions{LabelSelector: ls.AsSelector().String()}
+	return cfgmaps.query(ls.AsSelector(), cfgmaps.decodeObject)
+}
+
+// QuerySummaries fetches the summaries of all releases that match the
+// provided map of labels.
+func (cfgmaps *ConfigMaps) QuerySummaries(labels map[string]string) ([]*rspb.Release, error) {
+	ls, err := querySet(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	summarySel, releaseSel, err := summarySelectors(ls)
+	if err != nil {
+		cfgmaps.Log("query: failed to select summaries: %s", err)
+		return nil, err
+	}
+
+	summaries, err := readSummaries(cfgmaps.summaryObjects(), summarySel, cfgmaps.Codec, cfgmaps.Log)
+	if err != nil {
+		cfgmaps.Log("query: failed to query summaries: %s", err)
+		return nil, err
+	}
+
+	// summarize the releases stored without a summary object
+	rels, err := cfgmaps.query(releaseSel, cfgmaps.decodeSummary)
+	if errors.Is(err, ErrReleaseNotFound) && len(summaries) > 0 {
+		return summaries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return append(summaries, rels...), nil
+}
+
+func (cfgmaps *ConfigMaps) query(lsel kblabels.Selector, decode func(*v1.ConfigMap, chunkLister) (*rspb.Release, error)) ([]*rspb.Release, error) {
+	opts := metav1.ListOptions{LabelSelector: lsel.String()}
 
 	list, err := cfgmaps.impl.List(context.Background(), opts)
 	if err != nil {
@@ -140,9 +216,13 @@ func (cfgmaps *ConfigMaps) Query(labels map[string]string) ([]*rspb.Release, err
 	}
 
 	var results []*rspb.Release
+	chunks := cfgmaps.listChunks(list.Items)
 	for _, item := range list.Items {
-		rls, err := decodeRelease(item.Data["release"])
-		if err != nil {
+		rls, err := decode(&item, chunks)
+		if isMissingKeyError(err) {
+			cfgmaps.Log("query: failed to decode release %q: %s", item.Name, err)
+			return nil, err
+		} else if err != nil {
 			cfgmaps.Log("query: failed to decode release: %s", err)
 			continue
 		}
@@ -161,15 +241,21 @@ func (cfgmaps *ConfigMaps) Create(key string, rls *rspb.Release) error {
 	lbs.init()
 	lbs.fromMap(rls.Labels)
 	lbs.set("createdAt", strconv.Itoa(int(time.Now().Unix())))
+	lbs.set(summarizedLabel, "true")
 
 	// create a new configmap to hold the release
-	obj, err := newConfigMapsObject(key, rls, lbs)
+	obj, err := newConfigMapsObject(key, rls, lbs, cfgmaps.Codec)
 	if err != nil {
 		cfgmaps.Log("create: failed to encode release %q: %s", rls.Name, err)
 		return err
 	}
+	if err := cfgmaps.splitObject(obj, rls); err != nil {
+		cfgmaps.Log("create: failed to split release %q: %s", rls.Name, err)
+		return err
+	}
 	// push the configmap object out into the kubiverse
 	if _, err := cfgmaps.impl.Create(context.Background(), obj, metav1.CreateOptions{}); err != nil {
+		deleteUnusedChunkSet(configMapChunkObjects{cfgmaps.impl}, obj.ObjectMeta, cfgmaps.Log)
 		if apierrors.IsAlreadyExists(err) {
 			return ErrReleaseExists
 		}
@@ -177,6 +263,15 @@ func (cfgmaps *ConfigMaps) Create(key string, rls *rspb.Release) error {
 		cfgmaps.Log("create: failed to create: %s", err)
 		return err
 	}
+	// store the summary, the release isn't created without it
+	if _, err := writeSummary(cfgmaps.summaryObjects(), key, rls, lbs, cfgmaps.Codec, chunkSize(cfgmaps.ChunkSize), false, cfgmaps.Log); err != nil {
+		if err := cfgmaps.impl.Delete(context.Background(), key, metav1.DeleteOptions{}); err != nil {
+			cfgmaps.Log("create: failed to delete release %q without a summary: %s", key, err)
+		}
+		deleteStaleChunks(configMapChunkObjects{cfgmaps.impl}, nil, rls.Name, rls.Version, cfgmaps.Log)
+		cfgmaps.Log("create: failed to create summary of %q: %s", rls.Name, err)
+		return err
+	}
 	return nil
 }
 
@@ -190,18 +285,56 @@ func (cfgmaps *ConfigMaps) Update(key string, rls *rspb.Release) error {
 	lbs.fromMap(rls.Labels)
 	lbs.set("modifiedAt", strconv.Itoa(int(time.Now().Unix())))
 
+	return cfgmaps.update(key, rls, lbs)
+}
+
+// Rewrite stores the release in the ConfigMap again, encoded with the current
+// codec. Unlike Update, it keeps the labels of the stored ConfigMap.
+func (cfgmaps *ConfigMaps) Rewrite(key string, rls *rspb.Release) error {
+	obj, err := cfgmaps.impl.Get(context.Background(), key, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return ErrReleaseNotFound
+		}
+		return err
+	}
+
+	var lbs labels
+
+	lbs.init()
+	lbs.fromMap(obj.ObjectMeta.Labels)
+
+	rewritten := *rls
+	rewritten.Labels = filterSystemLabels(obj.ObjectMeta.Labels)
+	return cfgmaps.update(key, &rewritten, lbs)
+}
+
+func (cfgmaps *ConfigMaps) update(key string, rls *rspb.Release, lbs labels) error {
+	lbs.set(summarizedLabel, "true")
+
 	// create a new configmap object to hold the release
-	obj, err := newConfigMapsObject(key, rls, lbs)
+	obj, err := newConfigMapsObject(key, rls, lbs, cfgmaps.Codec)
 	if err != nil {
 		cfgmaps.Log("update: failed to encode release %q: %s", rls.Name, err)
 		return err
 	}
+	if err := cfgmaps.splitObject(obj, rls); err != nil {
+		cfgmaps.Log("update: failed to split release %q: %s", rls.Name, err)
+		return err
+	}
 	// push the configmap object out into the kubiverse
 	_, err = cfgmaps.impl.Update(context.Background(), obj, metav1.UpdateOptions{})
 	if err != nil {
+		deleteUnusedChunkSet(configMapChunkObjects{cfgmaps.impl}, obj.ObjectMeta, cfgmaps.Log)
 		cfgmaps.Log("update: failed to update: %s", err)
 		return err
 	}
+	summaryMeta, err := writeSummary(cfgmaps.summaryObjects(), key, rls, lbs, cfgmaps.Codec, chunkSize(cfgmaps.ChunkSize), true, cfgmaps.Log)
+	if err != nil {
+		cfgmaps.Log("update: failed to update summary of %q: %s", rls.Name, err)
+		return err
+	}
+	deleteStaleChunks(configMapChunkObjects{cfgmaps.impl}, []metav1.ObjectMeta{obj.ObjectMeta, summaryMeta}, rls.Name, rls.Version, cfgmaps.Log)
 	return nil
 }
 
@@ -209,18 +342,87 @@ func (cfgmaps *ConfigMaps) Update(key string, rls *rspb.Release) error {
 func (cfgmaps *ConfigMaps) Delete(key string) (rls *rspb.Release, err error) {
 	// fetch the release to check existence
 	if rls, err = cfgmaps.Get(key); err != nil {
+		if errors.Is(err, ErrReleaseNotFound) {
+			// delete the summary left by an interrupted deletion
+			if err := deleteSummary(cfgmaps.summaryObjects(), key); err != nil {
+				cfgmaps.Log("delete: failed to delete summary of %q: %s", key, err)
+			}
+		}
 		return nil, err
 	}
-	// delete the release
+	// delete the release and then its summary
 	if err = cfgmaps.impl.Delete(context.Background(), key, metav1.DeleteOptions{}); err != nil {
 		return rls, err
 	}
+	if err := deleteSummary(cfgmaps.summaryObjects(), key); err != nil {
+		cfgmaps.Log("delete: failed to delete summary of %q: %s", key, err)
+	}
+	deleteStaleChunks(configMapChunkObjects{cfgmaps.impl}, nil, rls.Name, rls.Version, cfgmaps.Log)
 	return rls, nil
 }
 
+// decodeObject decodes the release kept in the ConfigMap and its chunks.
+func (cfgmaps *ConfigMaps) decodeObject(obj *v1.ConfigMap, chunks chunkLister) (*rspb.Release, error) {
+	data, err := readChunks(chunks, obj.ObjectMeta, obj.Data["release"])
+	if err != nil {
+		return nil, err
+	}
+	return decodeRelease(data, cfgmaps.Codec)
+}
+
+// decodeSummary summarizes the release kept in the ConfigMap stored without a
+// summary object by an older version.
+func (cfgmaps *ConfigMaps) decodeSummary(obj *v1.ConfigMap, chunks chunkLister) (*rspb.Release, error) {
+	rls, err := cfgmaps.decodeObject(obj, chunks)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeRelease(rls), nil
+}
+
+// listChunks returns a chunkLister listing the chunks of the ConfigMaps at once.
+func (cfgmaps *ConfigMaps) listChunks(items []v1.ConfigMap) chunkLister {
+	metas := make([]metav1.ObjectMeta, 0, len(items))
+	for _, item := range items {
+		metas = append(metas, item.ObjectMeta)
+	}
+	return listChunkSets(configMapChunkObjects{cfgmaps.impl}, metas)
+}
+
+// splitObject moves the part of the encoded release exceeding the chunk size
+// from the ConfigMap to the chunk ConfigMaps.
+func (cfgmaps *ConfigMaps) splitObject(obj *v1.ConfigMap, rls *rspb.Release) error {
+	data, err := writeChunks(configMapChunkObjects{cfgmaps.impl}, &obj.ObjectMeta, rls.Name, rls.Version, obj.Data["release"], chunkSize(cfgmaps.ChunkSize))
+	if err != nil {
+		return err
+	}
+	obj.Data["release"] = data
+	return nil
+}
+
+// summaryObjects returns the summary objects of the ConfigMaps.
+func (cfgmaps *ConfigMaps) summaryObjects() summaryObjects {
+	return configMapSummaryObjects{configMapChunkObjects{cfgmaps.impl}}
+}
+
+// AcquireLock locks the release in a ConfigMap holding the lock.
+func (cfgmaps *ConfigMaps) AcquireLock(name, holder string, ttl time.Duration) (*Lock, error) {
+	return acquireObjectLock(configMapLockObjects{cfgmaps.impl}, cfgmaps.Log, name, holder, ttl)
+}
+
+// RenewLock extends the lock for another TTL.
+func (cfgmaps *ConfigMaps) RenewLock(lock *Lock) error {
+	return renewObjectLock(configMapLockObjects{cfgmaps.impl}, lock)
+}
+
+// ReleaseLock deletes the ConfigMap holding the lock.
+func (cfgmaps *ConfigMaps) ReleaseLock(lock *Lock) error {
+	return releaseObjectLock(configMapLockObjects{cfgmaps.impl}, lock)
+}
+
 // newConfigMapsObject constructs a kubernetes ConfigMap object
 // to store a release. Each configmap data entry is the base64
-// encoded gzipped string of a release.
+// encoded string of a release compressed with the codec.
 //
 // The following labels are used within each configmap:
 //
@@ -230,11 +432,12 @@ func (cfgmaps *ConfigMaps) Delete(key string) (rls *rspb.Release, err error) {
 //	"status"         - status of the release (see pkg/release/status.go for variants)
 //	"owner"          - owner of the configmap, currently "helm".
 //	"name"           - name of the release.
-func newConfigMapsObject(key string, rls *rspb.Release, lbs labels) (*v1.ConfigMap, error) {
+//	"summarized"     - set once the summary of the release is stored. (set in Create and Update)
+func newConfigMapsObject(key string, rls *rspb.Release, lbs labels, codec Codec) (*v1.ConfigMap, error) {
 	const owner = "helm"
 
 	// encode the release
-	s, err := encodeRelease(rls)
+	s, err := encodeRelease(rls, codec)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,361 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apimachinery/pkg/util/rand"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+// DefaultChunkSize is the default maximum size of the encoded release stored
+// in a single Secret or ConfigMap. It leaves enough room for the metadata
+// within the 1MiB object size limit.
+const DefaultChunkSize = 768 * 1024
+
+const (
+	// chunkOwner is the owner label of the chunk objects. It differs from the
+	// owner of the release objects, so chunks are not listed as releases.
+	chunkOwner = "helm-release-chunk"
+
+	chunkSetAnnotation   = "helm.sh/release-chunk-set"
+	chunkCountAnnotation = "helm.sh/release-chunks"
+)
+
+// A release which encoded data exceeds the chunk size is split into chunks.
+// The first chunk is kept in the release object and the rest are kept in the
+// chunk objects linked to it by labels:
+//
+//	"owner"     - owner of the chunk, "helm-release-chunk".
+//	"name"      - name of the release.
+//	"version"   - version of the release.
+//	"chunk-set" - random ID of the chunks written together.
+//	"chunk"     - index of the chunk, starting at 1.
+//
+// The chunks are written before the release object and the ID of the chunk
+// set and the number of chunks are written in the release object annotations,
+// so a release is created or updated only once its release object is. The
+// chunks of the previous chunk set are deleted after the release object is
+// updated. If writing the release object fails, its new chunk set is deleted
+// only once the stored release object is confirmed not to use it.
+
+// chunkObject is a single chunk of the encoded release.
+type chunkObject struct {
+	name   string
+	labels map[string]string
+	data   string
+}
+
+// chunkObjects stores the chunk objects of a Kubernetes storage driver.
+// releaseMeta returns the meta of the stored release object named by key.
+type chunkObjects interface {
+	createChunk(chunk chunkObject) error
+	listChunks(selector string) ([]chunkObject, error)
+	deleteChunk(name string) error
+	releaseMeta(key string) (*metav1.ObjectMeta, error)
+}
+
+// chunkLister returns the chunks of a chunk set.
+type chunkLister func(chunkSet string) ([]chunkObject, error)
+
+// listChunkSet returns a chunkLister listing the chunks of a single release
+// object.
+func listChunkSet(objs chunkObjects) chunkLister {
+	return func(chunkSet string) ([]chunkObject, error) {
+		return objs.listChunks(chunkSetSelector(chunkSet))
+	}
+}
+
+// listChunkSets returns a chunkLister for the release objects listed
+// together. The chunks of all of them are listed at once the first time any
+// are asked for.
+func listChunkSets(objs chunkObjects, metas []metav1.ObjectMeta) chunkLister {
+	var chunks map[string][]chunkObject
+	return func(chunkSet string) ([]chunkObject, error) {
+		if chunks != nil {
+			return chunks[chunkSet], nil
+		}
+
+		var chunkSets []string
+		for _, meta := range metas {
+			if chunkSet, found := meta.Annotations[chunkSetAnnotation]; found {
+				chunkSets = append(chunkSets, chunkSet)
+			}
+		}
+		req, err := kblabels.NewRequirement("chunk-set", selection.In, chunkSets)
+		if err != nil {
+			return nil, err
+		}
+
+		list, err := objs.listChunks(kblabels.SelectorFromSet(kblabels.Set{"owner": chunkOwner}).Add(*req).String())
+		if err != nil {
+			return nil, err
+		}
+
+		chunks = map[string][]chunkObject{}
+		for _, chunk := range list {
+			chunks[chunk.labels["chunk-set"]] = append(chunks[chunk.labels["chunk-set"]], chunk)
+		}
+		return chunks[chunkSet], nil
+	}
+}
+
+// chunkSize returns the chunk size set for a driver.
+func chunkSize(size int) int {
+	if size == 0 {
+		return DefaultChunkSize
+	}
+	return size
+}
+
+// writeChunks splits the encoded release into chunks of the size if it is
+// bigger than it. The chunks other than the first one are stored in the chunk
+// objects and the release object meta is annotated with the chunk set. The
+// first chunk is returned. A size below zero disables chunking.
+func writeChunks(objs chunkObjects, meta *metav1.ObjectMeta, rlsName string, version int, data string, size int) (string, error) {
+	if size < 0 || len(data) <= size {
+		return data, nil
+	}
+
+	chunkSet := rand.String(8)
+	count := (len(data) + size - 1) / size
+	for i := 1; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(data) {
+			end = len(data)
+		}
+
+		chunk := chunkObject{
+			name: fmt.Sprintf("%s.chunk.%s.%d", meta.Name, chunkSet, i),
+			labels: map[string]string{
+				"owner":     chunkOwner,
+				"name":      rlsName,
+				"version":   strconv.Itoa(version),
+				"chunk-set": chunkSet,
+				"chunk":     strconv.Itoa(i),
+			},
+			data: data[i*size : end],
+		}
+		if err := objs.createChunk(chunk); err != nil {
+			deleteChunks(objs, chunkSetSelector(chunkSet), nopLog)
+			return "", errors.Wrapf(err, "failed to create chunk %d of %q", i, meta.Name)
+		}
+	}
+
+	if meta.Annotations == nil {
+		meta.Annotations = map[string]string{}
+	}
+	meta.Annotations[chunkSetAnnotation] = chunkSet
+	meta.Annotations[chunkCountAnnotation] = strconv.Itoa(count)
+
+	return data[:size], nil
+}
+
+// readChunks returns the encoded release kept in the release object and its
+// chunk objects. data is the first chunk kept in the release object.
+func readChunks(list chunkLister, meta metav1.ObjectMeta, data string) (string, error) {
+	chunkSet, found := meta.Annotations[chunkSetAnnotation]
+	if !found {
+		return data, nil
+	}
+
+	count, err := strconv.Atoi(meta.Annotations[chunkCountAnnotation])
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid %s annotation of %q", chunkCountAnnotation, meta.Name)
+	}
+
+	chunks, err := list(chunkSet)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to list chunks of %q", meta.Name)
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		a, _ := strconv.Atoi(chunks[i].labels["chunk"])
+		b, _ := strconv.Atoi(chunks[j].labels["chunk"])
+		return a < b
+	})
+
+	if len(chunks) != count-1 {
+		return "", errors.Errorf("release %q has %d of %d chunks", meta.Name, len(chunks)+1, count)
+	}
+
+	var sb strings.Builder
+	sb.WriteString(data)
+	for i, chunk := range chunks {
+		if chunk.labels["chunk"] != strconv.Itoa(i+1) {
+			return "", errors.Errorf("release %q is missing chunk %d", meta.Name, i+1)
+		}
+		sb.WriteString(chunk.data)
+	}
+
+	return sb.String(), nil
+}
+
+// deleteStaleChunks deletes the chunks of the release version other than the
+// chunks of the object metas, i.e. the release object and its summary object.
+func deleteStaleChunks(objs chunkObjects, metas []metav1.ObjectMeta, rlsName string, version int, log func(string, ...interface{})) {
+	ls := kblabels.Set{"owner": chunkOwner, "name": rlsName, "version": strconv.Itoa(version)}
+	selector := kblabels.SelectorFromSet(ls)
+
+	var chunkSets []string
+	for _, meta := range metas {
+		if chunkSet, found := meta.Annotations[chunkSetAnnotation]; found {
+			chunkSets = append(chunkSets, chunkSet)
+		}
+	}
+	if len(chunkSets) > 0 {
+		req, err := kblabels.NewRequirement("chunk-set", selection.NotIn, chunkSets)
+		if err != nil {
+			log("failed to select stale chunks of %s version %d: %s", rlsName, version, err)
+			return
+		}
+		selector = selector.Add(*req)
+	}
+
+	deleteChunks(objs, selector.String(), log)
+}
+
+// deleteUnusedChunkSet deletes the chunks of the release object meta after
+// writing the release object failed, unless the stored release object uses
+// them, e.g. because the write was applied despite the error. The chunks are
+// kept if the stored release object can't be fetched to confirm it.
+func deleteUnusedChunkSet(objs chunkObjects, meta metav1.ObjectMeta, log func(string, ...interface{})) {
+	chunkSet, found := meta.Annotations[chunkSetAnnotation]
+	if !found {
+		return
+	}
+
+	stored, err := objs.releaseMeta(meta.Name)
+	switch {
+	case apierrors.IsNotFound(err):
+	case err != nil:
+		log("keeping chunks of %q, failed to get the stored release object: %s", meta.Name, err)
+		return
+	case stored.Annotations[chunkSetAnnotation] == chunkSet:
+		return
+	}
+
+	deleteChunks(objs, chunkSetSelector(chunkSet), log)
+}
+
+func deleteChunks(objs chunkObjects, selector string, log func(string, ...interface{})) {
+	chunks, err := objs.listChunks(selector)
+	if err != nil {
+		log("failed to list chunks to delete: %s", err)
+		return
+	}
+
+	for _, chunk := range chunks {
+		if err := objs.deleteChunk(chunk.name); err != nil && !apierrors.IsNotFound(err) {
+			log("failed to delete chunk %q: %s", chunk.name, err)
+		}
+	}
+}
+
+func chunkSetSelector(chunkSet string) string {
+	return kblabels.Set{"owner": chunkOwner, "chunk-set": chunkSet}.AsSelector().String()
+}
+
+func nopLog(_ string, _ ...interface{}) {}
+
+// secretChunkObjects keeps the chunks in Secrets.
+type secretChunkObjects struct {
+	impl corev1.SecretInterface
+}
+
+func (o secretChunkObjects) createChunk(chunk chunkObject) error {
+	_, err := o.impl.Create(context.Background(), &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: chunk.name, Labels: chunk.labels},
+		Type:       "helm.sh/release-chunk.v1",
+		Data:       map[string][]byte{"chunk": []byte(chunk.data)},
+	}, metav1.CreateOptions{})
+	return err
+}
+
+func (o secretChunkObjects) listChunks(selector string) ([]chunkObject, error) {
+	list, err := o.impl.List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]chunkObject, 0, len(list.Items))
+	for _, item := range list.Items {
+		chunks = append(chunks, chunkObject{name: item.Name, labels: item.Labels, data: string(item.Data["chunk"])})
+	}
+	return chunks, nil
+}
+
+func (o secretChunkObjects) deleteChunk(name string) error {
+	return o.impl.Delete(context.Background(), name, metav1.DeleteOptions{})
+}
+
+func (o secretChunkObjects) releaseMeta(key string) (*metav1.ObjectMeta, error) {
+	obj, err := o.impl.Get(context.Background(), key, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &obj.ObjectMeta, nil
+}
+
+// configMapChunkObjects keeps the chunks in ConfigMaps.
+type configMapChunkObjects struct {
+	impl corev1.ConfigMapInterface
+}
+
+func (o configMapChunkObjects) createChunk(chunk chunkObject) error {
+	_, err := o.impl.Create(context.Background(), &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: chunk.name, Labels: chunk.labels},
+		Data:       map[string]string{"chunk": chunk.data},
+	}, metav1.CreateOptions{})
+	return err
+}
+
+func (o configMapChunkObjects) listChunks(selector string) ([]chunkObject, error) {
+	list, err := o.impl.List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	chunks := make([]chunkObject, 0, len(list.Items))
+	for _, item := range list.Items {
+		chunks = append(chunks, chunkObject{name: item.Name, labels: item.Labels, data: item.Data["chunk"]})
+	}
+	return chunks, nil
+}
+
+func (o configMapChunkObjects) deleteChunk(name string) error {
+	return o.impl.Delete(context.Background(), name, metav1.DeleteOptions{})
+}
+
+func (o configMapChunkObjects) releaseMeta(key string) (*metav1.ObjectMeta, error) {
+	obj, err := o.impl.Get(context.Background(), key, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &obj.ObjectMeta, nil
+}
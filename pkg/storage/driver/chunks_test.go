@@ -0,0 +1,244 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
+)
+
+func TestChunkedDrivers(t *testing.T) {
+	for name, newDriver := range map[string]func(t *testing.T) (Driver, func() int){
+		"secrets": func(t *testing.T) (Driver, func() int) {
+			secrets := newTestFixtureSecrets(t)
+			secrets.ChunkSize = 32
+			return secrets, func() int { return len(secrets.impl.(*MockSecretsInterface).objects) }
+		},
+		"configmaps": func(t *testing.T) (Driver, func() int) {
+			cfgmaps := newTestFixtureCfgMaps(t)
+			cfgmaps.ChunkSize = 32
+			return cfgmaps, func() int { return len(cfgmaps.impl.(*MockConfigMapsInterface).objects) }
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			driver, countObjects := newDriver(t)
+
+			key := testKey("smug-pigeon", 1)
+			rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+			rel.Manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: smug-pigeon\n"
+
+			if err := driver.Create(key, rel); err != nil {
+				t.Fatalf("Failed to create release: %s", err)
+			}
+			if countObjects() < 3 {
+				t.Fatalf("Expected release to be split into several objects, got %d", countObjects())
+			}
+
+			got, err := driver.Get(key)
+			if err != nil {
+				t.Fatalf("Failed to get release: %s", err)
+			}
+			if !reflect.DeepEqual(rel, got) {
+				t.Errorf("Expected {%v}, got {%v}", rel, got)
+			}
+
+			rels, err := driver.Query(map[string]string{"name": "smug-pigeon", "owner": "helm"})
+			if err != nil {
+				t.Fatalf("Failed to query releases: %s", err)
+			}
+			if len(rels) != 1 || rels[0].Manifest != rel.Manifest {
+				t.Errorf("Expected the chunked release to be queried, got %v", rels)
+			}
+
+			rels, err = driver.List(func(*rspb.Release) bool { return true })
+			if err != nil {
+				t.Fatalf("Failed to list releases: %s", err)
+			}
+			if len(rels) != 1 {
+				t.Errorf("Expected chunks not to be listed as releases, got %d releases", len(rels))
+			}
+
+			rel.Info.Status = rspb.StatusSuperseded
+			rel.Manifest = "short"
+			if err := driver.Update(key, rel); err != nil {
+				t.Fatalf("Failed to update release: %s", err)
+			}
+			got, err = driver.Get(key)
+			if err != nil {
+				t.Fatalf("Failed to get updated release: %s", err)
+			}
+			if !reflect.DeepEqual(rel, got) {
+				t.Errorf("Expected {%v}, got {%v}", rel, got)
+			}
+
+			if _, err := driver.Delete(key); err != nil {
+				t.Fatalf("Failed to delete release: %s", err)
+			}
+			if countObjects() != 0 {
+				t.Errorf("Expected chunks to be deleted with the release, %d objects left", countObjects())
+			}
+		})
+	}
+}
+
+func TestChunkedDriversUpdateDeletesStaleChunks(t *testing.T) {
+	secrets := newTestFixtureSecrets(t)
+	secrets.ChunkSize = 32
+	objects := secrets.impl.(*MockSecretsInterface).objects
+
+	key := testKey("smug-pigeon", 1)
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	rel.Manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: smug-pigeon\n"
+	if err := secrets.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release: %s", err)
+	}
+
+	rel.Info.Status = rspb.StatusSuperseded
+	if err := secrets.Update(key, rel); err != nil {
+		t.Fatalf("Failed to update release: %s", err)
+	}
+	chunkSet := objects[key].Annotations[chunkSetAnnotation]
+	summaryChunkSet := objects[summaryKey(key)].Annotations[chunkSetAnnotation]
+	for name, obj := range objects {
+		if name == key || name == summaryKey(key) {
+			continue
+		}
+		if set := obj.Labels["chunk-set"]; set != chunkSet && set != summaryChunkSet {
+			t.Errorf("Expected stale chunk %q to be deleted", name)
+		}
+	}
+	if got, err := secrets.Get(key); err != nil || got.Info.Status != rspb.StatusSuperseded {
+		t.Errorf("Expected updated release, got %v: %v", got, err)
+	}
+}
+
+// countingChunkObjects counts the chunk lists.
+type countingChunkObjects struct {
+	chunkObjects
+	lists int
+}
+
+func (o *countingChunkObjects) listChunks(selector string) ([]chunkObject, error) {
+	o.lists++
+	return o.chunkObjects.listChunks(selector)
+}
+
+func TestChunkedDriversListChunksOnce(t *testing.T) {
+	secrets := newTestFixtureSecrets(t)
+	secrets.ChunkSize = 32
+	mock := secrets.impl.(*MockSecretsInterface)
+
+	for _, vers := range []int{1, 2, 3} {
+		rel := releaseStub("smug-pigeon", vers, "default", rspb.StatusSuperseded)
+		rel.Manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: smug-pigeon\n"
+		if err := secrets.Create(testKey(rel.Name, vers), rel); err != nil {
+			t.Fatalf("Failed to create release: %s", err)
+		}
+	}
+
+	list, err := mock.List(context.Background(), metav1.ListOptions{LabelSelector: "owner=helm"})
+	if err != nil {
+		t.Fatalf("Failed to list release objects: %s", err)
+	}
+
+	objs := &countingChunkObjects{chunkObjects: secretChunkObjects{mock}}
+	var metas []metav1.ObjectMeta
+	for _, item := range list.Items {
+		metas = append(metas, item.ObjectMeta)
+	}
+	chunks := listChunkSets(objs, metas)
+	for _, item := range list.Items {
+		rel, err := secrets.decodeObject(&item, chunks)
+		if err != nil {
+			t.Fatalf("Failed to decode release: %s", err)
+		}
+		if rel.Manifest == "" {
+			t.Errorf("Expected v%d to be joined from its chunks", rel.Version)
+		}
+	}
+	if objs.lists != 1 {
+		t.Errorf("Expected the chunks of %d releases to be listed once, listed %d times", len(list.Items), objs.lists)
+	}
+}
+
+func TestChunkedDriversMissingChunk(t *testing.T) {
+	secrets := newTestFixtureSecrets(t)
+	secrets.ChunkSize = 32
+	objects := secrets.impl.(*MockSecretsInterface).objects
+
+	key := testKey("smug-pigeon", 1)
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	rel.Manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: smug-pigeon\n"
+	if err := secrets.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release: %s", err)
+	}
+	for name, obj := range objects {
+		if obj.Labels["chunk"] == "1" {
+			delete(objects, name)
+		}
+	}
+
+	if _, err := secrets.Get(key); err == nil {
+		t.Error("Expected an error getting a release with a missing chunk")
+	}
+}
+
+// appliedFailingSecrets fails updates after applying them.
+type appliedFailingSecrets struct {
+	*MockSecretsInterface
+}
+
+func (s appliedFailingSecrets) Update(ctx context.Context, secret *v1.Secret, opts metav1.UpdateOptions) (*v1.Secret, error) {
+	if _, err := s.MockSecretsInterface.Update(ctx, secret, opts); err != nil {
+		return nil, err
+	}
+	return nil, errors.New("connection reset")
+}
+
+func TestChunkedDriversUpdateAppliedDespiteError(t *testing.T) {
+	secrets := newTestFixtureSecrets(t)
+	secrets.ChunkSize = 32
+	mock := secrets.impl.(*MockSecretsInterface)
+
+	key := testKey("smug-pigeon", 1)
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	rel.Manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: smug-pigeon\n"
+	if err := secrets.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release: %s", err)
+	}
+
+	secrets.impl = appliedFailingSecrets{mock}
+	rel.Info.Status = rspb.StatusSuperseded
+	if err := secrets.Update(key, rel); err == nil {
+		t.Fatal("Expected the update to fail")
+	}
+
+	got, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Expected the chunks of the applied update to be kept, got %s", err)
+	}
+	if got.Info.Status != rspb.StatusSuperseded {
+		t.Errorf("Expected the applied update to be got, got status %s", got.Info.Status)
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+
+	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
+)
+
+// Codec compresses the JSON of a release before it is base64 encoded and
+// stored. The compressed data starts with the format marker of the codec, so
+// the codec of a stored release is detected when it is decoded.
+type Codec interface {
+	// Name returns the name of the codec.
+	Name() string
+	// Marker returns the format marker the compressed data starts with. A
+	// codec without a marker can't be detected.
+	Marker() []byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// Rewriter is the interface of the drivers which can store a release again,
+// so it is encoded with their current codec.
+//
+// Rewrite stores rls, the release kept under key, again. The labels and the
+// timestamps of the stored release are kept. It returns ErrReleaseNotFound if
+// the release does not exist.
+type Rewriter interface {
+	Rewrite(key string, rls *rspb.Release) error
+}
+
+var (
+	// GzipCodec compresses releases with gzip. It is the default codec.
+	GzipCodec Codec = gzipCodec{}
+	// ZstdCodec compresses releases with zstd, which is faster and compresses
+	// better than gzip, but the releases can't be read by the Helm versions
+	// without the codec.
+	ZstdCodec Codec = &zstdCodec{}
+	// NoneCodec stores the JSON of releases as is.
+	NoneCodec Codec = noneCodec{}
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = []Codec{GzipCodec, ZstdCodec, NoneCodec}
+)
+
+// RegisterCodec registers a custom codec, so the releases it compressed are
+// decoded and it can be found by name with CodecByName.
+func RegisterCodec(codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	codecs = append(codecs, codec)
+}
+
+// CodecByName returns the registered codec with the name. An empty name
+// means GzipCodec.
+func CodecByName(name string) (Codec, error) {
+	if name == "" {
+		return GzipCodec, nil
+	}
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	for _, codec := range codecs {
+		if codec.Name() == name {
+			return codec, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown release codec %q", name)
+}
+
+// detectCodec returns the codec which format marker the data starts with or
+// nil if there is none.
+func detectCodec(data []byte) Codec {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	for _, codec := range codecs {
+		if marker := codec.Marker(); len(marker) > 0 && len(data) > len(marker) && bytes.HasPrefix(data, marker) {
+			return codec
+		}
+	}
+
+	return nil
+}
+
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) Marker() []byte { return magicGzip }
+
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+var magicZstd = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// zstdCodec shares an encoder and a decoder, which are safe for concurrent
+// use with EncodeAll and DecodeAll.
+type zstdCodec struct {
+	once    sync.Once
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+	err     error
+}
+
+func (*zstdCodec) Name() string { return "zstd" }
+
+func (*zstdCodec) Marker() []byte { return magicZstd }
+
+func (c *zstdCodec) init() error {
+	c.once.Do(func() {
+		if c.encoder, c.err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedBetterCompression)); c.err != nil {
+			return
+		}
+		c.decoder, c.err = zstd.NewReader(nil)
+	})
+
+	return c.err
+}
+
+func (c *zstdCodec) Compress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	return c.encoder.EncodeAll(data, nil), nil
+}
+
+func (c *zstdCodec) Decompress(data []byte) ([]byte, error) {
+	if err := c.init(); err != nil {
+		return nil, err
+	}
+
+	return c.decoder.DecodeAll(data, nil)
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string { return "none" }
+
+func (noneCodec) Marker() []byte { return nil }
+
+func (noneCodec) Compress(data []byte) ([]byte, error) { return data, nil }
+
+func (noneCodec) Decompress(data []byte) ([]byte, error) { return data, nil }
@@ -0,0 +1,121 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
+)
+
+func TestCodecs(t *testing.T) {
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	// labels are not stored in the encoded release
+	rel.Labels = nil
+
+	for _, codec := range []Codec{GzipCodec, ZstdCodec, NoneCodec} {
+		t.Run(codec.Name(), func(t *testing.T) {
+			data, err := encodeRelease(rel, codec)
+			if err != nil {
+				t.Fatalf("Failed to encode release: %s", err)
+			}
+
+			b, err := b64.DecodeString(data)
+			if err != nil {
+				t.Fatalf("Failed to decode base64: %s", err)
+			}
+			if !bytes.HasPrefix(b, codec.Marker()) {
+				t.Errorf("Expected data to start with the format marker %x", codec.Marker())
+			}
+
+			got, err := decodeRelease(data, nil)
+			if err != nil {
+				t.Fatalf("Failed to decode release: %s", err)
+			}
+			if !reflect.DeepEqual(rel, got) {
+				t.Errorf("Expected {%v}, got {%v}", rel, got)
+			}
+		})
+	}
+}
+
+func TestDecodeReleaseLegacyFormats(t *testing.T) {
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	// labels are not stored in the encoded release
+	rel.Labels = nil
+
+	b, err := json.Marshal(rel)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decodeRelease(b64.EncodeToString(b), nil)
+	if err != nil {
+		t.Fatalf("Failed to decode uncompressed release: %s", err)
+	}
+	if !reflect.DeepEqual(rel, got) {
+		t.Errorf("Expected {%v}, got {%v}", rel, got)
+	}
+}
+
+func TestCodecByName(t *testing.T) {
+	for name, expected := range map[string]Codec{"": GzipCodec, "gzip": GzipCodec, "zstd": ZstdCodec, "none": NoneCodec} {
+		codec, err := CodecByName(name)
+		if err != nil {
+			t.Errorf("Failed to get codec %q: %s", name, err)
+		} else if codec != expected {
+			t.Errorf("Expected codec %q, got %q", expected.Name(), codec.Name())
+		}
+	}
+
+	if _, err := CodecByName("lz4"); err == nil {
+		t.Error("Expected an error for unknown codec")
+	}
+}
+
+func TestSecretsCodec(t *testing.T) {
+	secrets := newTestFixtureSecrets(t, releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed))
+	secrets.Codec = ZstdCodec
+
+	key := testKey("smug-pigeon", 1)
+	rel, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get gzipped release: %s", err)
+	}
+	if err := secrets.Update(key, rel); err != nil {
+		t.Fatalf("Failed to update release: %s", err)
+	}
+
+	b, err := b64.DecodeString(string(secrets.impl.(*MockSecretsInterface).objects[key].Data["release"]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, magicZstd) {
+		t.Error("Expected updated release to be compressed with zstd")
+	}
+
+	got, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get zstd release: %s", err)
+	}
+	if !reflect.DeepEqual(rel, got) {
+		t.Errorf("Expected {%v}, got {%v}", rel, got)
+	}
+}
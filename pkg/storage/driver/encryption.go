@@ -0,0 +1,347 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+// ErrEncryptedRelease indicates that a release is encrypted, but the
+// encryption keys are not configured.
+var ErrEncryptedRelease = errors.New("release: encrypted, no encryption keys configured")
+
+// ErrEncryptionKeyNotFound indicates that the key a release is encrypted with
+// is not provided by the KeyProvider.
+var ErrEncryptionKeyNotFound = errors.New("encryption key not found")
+
+// magicEncrypted is the format marker of the releases encrypted by
+// EncryptedCodec.
+var magicEncrypted = []byte("helm.sh/encrypted.v1:")
+
+// dataKeySize is the size of the AES-256 data keys the releases are encrypted
+// with.
+const dataKeySize = 32
+
+// KeyProvider provides the AES key encryption keys of EncryptedCodec. Each key
+// is 16, 24 or 32 bytes long and is identified by an ID stored with the
+// encrypted release.
+type KeyProvider interface {
+	// PrimaryKey returns the key the data keys of new releases are wrapped
+	// with.
+	PrimaryKey() (id string, key []byte, err error)
+	// Key returns the key with the ID to unwrap the data keys wrapped with it,
+	// or ErrEncryptionKeyNotFound if there is no such key.
+	Key(id string) ([]byte, error)
+}
+
+// EncryptedCodec encrypts the releases compressed by Codec with AES-GCM using
+// envelope encryption: each release is encrypted with its own random data key,
+// which is stored with the release wrapped with the primary key of Keys.
+//
+// To rotate the keys, make a new key primary while keeping the old keys for
+// decryption, rewrite the releases with Storage.RewriteReleases and remove the
+// old keys.
+type EncryptedCodec struct {
+	// Codec compresses the releases before they are encrypted. Nil means
+	// GzipCodec.
+	Codec Codec
+	Keys  KeyProvider
+}
+
+var _ Codec = (*EncryptedCodec)(nil)
+
+func (c *EncryptedCodec) Name() string {
+	return "encrypted+" + c.codec().Name()
+}
+
+func (c *EncryptedCodec) Marker() []byte {
+	return magicEncrypted
+}
+
+// Compress compresses data with Codec and encrypts it with a new data key.
+// The result is the header made of the format marker, the length of the key
+// ID and the key ID, followed by the nonce and the data key wrapped with the
+// key, and the nonce and the sealed data. The header is authenticated by both
+// the wrapped data key and the sealed data.
+func (c *EncryptedCodec) Compress(data []byte) ([]byte, error) {
+	compressed, err := c.codec().Compress(data)
+	if err != nil {
+		return nil, err
+	}
+
+	id, key, err := c.Keys.PrimaryKey()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get primary encryption key")
+	}
+	if len(id) == 0 || len(id) > 255 {
+		return nil, errors.Errorf("invalid encryption key ID %q", id)
+	}
+
+	kek, err := newAEAD(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid encryption key %q", id)
+	}
+
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 0, len(magicEncrypted)+1+len(id))
+	header = append(header, magicEncrypted...)
+	header = append(header, byte(len(id)))
+	header = append(header, id...)
+
+	out := make([]byte, 0, len(header)+wrappedDataKeySize(kek)+aead.NonceSize()+len(compressed)+aead.Overhead())
+	out = append(out, header...)
+	if out, err = seal(kek, out, dataKey, header); err != nil {
+		return nil, err
+	}
+	return seal(aead, out, compressed, header)
+}
+
+// Decompress unwraps the data key with the key it was wrapped with, decrypts
+// data and decompresses it with the codec detected by its format marker.
+func (c *EncryptedCodec) Decompress(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, magicEncrypted) || len(data) < len(magicEncrypted)+1 {
+		return nil, errors.New("invalid encrypted release")
+	}
+
+	idLen := int(data[len(magicEncrypted)])
+	headerLen := len(magicEncrypted) + 1 + idLen
+	if len(data) < headerLen {
+		return nil, errors.New("invalid encrypted release")
+	}
+	header, id := data[:headerLen], string(data[len(magicEncrypted)+1:headerLen])
+
+	key, err := c.Keys.Key(id)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to get encryption key %q", id)
+	}
+
+	kek, err := newAEAD(key)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid encryption key %q", id)
+	}
+
+	rest := data[headerLen:]
+	if len(rest) < wrappedDataKeySize(kek) {
+		return nil, errors.New("invalid encrypted release")
+	}
+	dataKey, err := open(kek, rest[:wrappedDataKeySize(kek)], header)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to unwrap data key with key %q", id)
+	}
+
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid data key")
+	}
+	compressed, err := open(aead, rest[wrappedDataKeySize(kek):], header)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decrypt release")
+	}
+
+	if codec := detectCodec(compressed); codec != nil {
+		return codec.Decompress(compressed)
+	}
+	return compressed, nil
+}
+
+func (c *EncryptedCodec) codec() Codec {
+	if c.Codec == nil {
+		return GzipCodec
+	}
+	return c.Codec
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// wrappedDataKeySize returns the size of a data key wrapped by seal with the
+// key encryption key.
+func wrappedDataKeySize(kek cipher.AEAD) int {
+	return kek.NonceSize() + dataKeySize + kek.Overhead()
+}
+
+// seal appends a random nonce and the plaintext sealed with it to dst.
+func seal(aead cipher.AEAD, dst, plaintext, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	dst = append(dst, nonce...)
+	return aead.Seal(dst, nonce, plaintext, additionalData), nil
+}
+
+// open opens the data made by seal.
+func open(aead cipher.AEAD, data, additionalData []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("invalid encrypted release")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], additionalData)
+}
+
+// keySet is a parsed set of keys. The first key is the primary one.
+type keySet struct {
+	primary string
+	keys    map[string][]byte
+}
+
+// parseKeys parses the keys separated by whitespace or commas, each in the
+// "<id>:<base64 encoded key>" format. The first key is the primary one.
+func parseKeys(data string) (*keySet, error) {
+	set := &keySet{keys: map[string][]byte{}}
+
+	for _, entry := range strings.FieldsFunc(data, func(r rune) bool { return unicode.IsSpace(r) || r == ',' }) {
+		id, encoded, found := strings.Cut(entry, ":")
+		if !found || id == "" {
+			return nil, errors.New("invalid encryption key, expected <id>:<base64 encoded key>")
+		}
+
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key %q", id)
+		}
+		if _, err := aes.NewCipher(key); err != nil {
+			return nil, errors.Wrapf(err, "invalid encryption key %q", id)
+		}
+
+		if set.primary == "" {
+			set.primary = id
+		}
+		set.keys[id] = key
+	}
+
+	if set.primary == "" {
+		return nil, errors.New("no encryption keys found")
+	}
+
+	return set, nil
+}
+
+// loadingKeyProvider loads the keys on the first use.
+type loadingKeyProvider struct {
+	source string
+	load   func() (string, error)
+
+	once sync.Once
+	set  *keySet
+	err  error
+}
+
+func (p *loadingKeyProvider) keys() (*keySet, error) {
+	p.once.Do(func() {
+		data, err := p.load()
+		if err != nil {
+			p.err = errors.Wrapf(err, "unable to load encryption keys from %s", p.source)
+			return
+		}
+		if p.set, err = parseKeys(data); err != nil {
+			p.err = errors.Wrapf(err, "unable to load encryption keys from %s", p.source)
+		}
+	})
+
+	return p.set, p.err
+}
+
+func (p *loadingKeyProvider) PrimaryKey() (string, []byte, error) {
+	set, err := p.keys()
+	if err != nil {
+		return "", nil, err
+	}
+	return set.primary, set.keys[set.primary], nil
+}
+
+func (p *loadingKeyProvider) Key(id string) ([]byte, error) {
+	set, err := p.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	key, found := set.keys[id]
+	if !found {
+		return nil, errors.Wrapf(ErrEncryptionKeyNotFound, "no key %q in %s", id, p.source)
+	}
+	return key, nil
+}
+
+// NewFileKeyProvider returns a KeyProvider reading the keys from the file,
+// one "<id>:<base64 encoded key>" per line. The first key is the primary one.
+func NewFileKeyProvider(path string) KeyProvider {
+	return &loadingKeyProvider{
+		source: fmt.Sprintf("file %q", path),
+		load: func() (string, error) {
+			data, err := os.ReadFile(path)
+			return string(data), err
+		},
+	}
+}
+
+// NewEnvKeyProvider returns a KeyProvider reading the comma separated keys
+// from the environment variable, each in the "<id>:<base64 encoded key>"
+// format. The first key is the primary one.
+func NewEnvKeyProvider(name string) KeyProvider {
+	return &loadingKeyProvider{
+		source: fmt.Sprintf("environment variable %s", name),
+		load: func() (string, error) {
+			return os.Getenv(name), nil
+		},
+	}
+}
+
+// NewCommandKeyProvider returns a KeyProvider running the command, e.g. a
+// plugin fetching the keys from a KMS, and reading the keys from its output in
+// the same format as NewFileKeyProvider does.
+func NewCommandKeyProvider(command string, args ...string) KeyProvider {
+	return &loadingKeyProvider{
+		source: fmt.Sprintf("command %q", command),
+		load: func() (string, error) {
+			var stderr bytes.Buffer
+			cmd := exec.Command(command, args...)
+			cmd.Stderr = &stderr
+
+			out, err := cmd.Output()
+			if err != nil {
+				return "", errors.Wrapf(err, "%s", strings.TrimSpace(stderr.String()))
+			}
+			return string(out), nil
+		},
+	}
+}
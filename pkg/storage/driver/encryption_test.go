@@ -0,0 +1,229 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
+)
+
+func testEncryptionKey(id string, b byte) string {
+	return id + ":" + base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, 32))
+}
+
+func TestEncryptedCodec(t *testing.T) {
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	rel.Labels = nil
+	rel.Manifest = "password: hunter2"
+
+	t.Setenv("TEST_HELM_KEYS", testEncryptionKey("k1", 1))
+	codec := &EncryptedCodec{Codec: ZstdCodec, Keys: NewEnvKeyProvider("TEST_HELM_KEYS")}
+
+	data, err := encodeRelease(rel, codec)
+	if err != nil {
+		t.Fatalf("Failed to encode release: %s", err)
+	}
+	b, _ := b64.DecodeString(data)
+	if !bytes.HasPrefix(b, magicEncrypted) || bytes.Contains(b, []byte("hunter2")) {
+		t.Fatal("Expected release to be encrypted")
+	}
+
+	got, err := decodeRelease(data, codec)
+	if err != nil {
+		t.Fatalf("Failed to decode release: %s", err)
+	}
+	if !reflect.DeepEqual(rel, got) {
+		t.Errorf("Expected {%v}, got {%v}", rel, got)
+	}
+
+	if _, err := decodeRelease(data, nil); !errors.Is(err, ErrEncryptedRelease) {
+		t.Errorf("Expected ErrEncryptedRelease without keys, got %v", err)
+	}
+
+	// The header is followed by the wrapped data key, so its last byte is
+	// part of the key.
+	wrapped := bytes.Clone(b)
+	wrapped[len(magicEncrypted)+1+len("k1")+wrappedDataKeySize(mustNewAEAD(t))-1] ^= 0xff
+	if _, err := decodeRelease(b64.EncodeToString(wrapped), codec); err == nil {
+		t.Error("Expected an error decoding release with tampered data key")
+	}
+
+	b[len(b)-1] ^= 0xff
+	if _, err := decodeRelease(b64.EncodeToString(b), codec); err == nil {
+		t.Error("Expected an error decoding tampered release")
+	}
+
+	again, err := encodeRelease(rel, codec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b2, _ := b64.DecodeString(again)
+	headerLen := len(magicEncrypted) + 1 + len("k1")
+	if bytes.Equal(b[headerLen:headerLen+wrappedDataKeySize(mustNewAEAD(t))], b2[headerLen:headerLen+wrappedDataKeySize(mustNewAEAD(t))]) {
+		t.Error("Expected each release to be encrypted with its own data key")
+	}
+
+	plain, err := encodeRelease(rel, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeRelease(plain, codec); err != nil {
+		t.Errorf("Expected unencrypted release to be decoded, got %s", err)
+	}
+}
+
+func TestEncryptedCodecKeyRotation(t *testing.T) {
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	rel.Labels = nil
+
+	old := &EncryptedCodec{Keys: mustParseKeys(t, testEncryptionKey("k1", 1))}
+	rotated := &EncryptedCodec{Keys: mustParseKeys(t, testEncryptionKey("k2", 2)+","+testEncryptionKey("k1", 1))}
+	rotatedOnly := &EncryptedCodec{Keys: mustParseKeys(t, testEncryptionKey("k2", 2))}
+
+	data, err := encodeRelease(rel, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := decodeRelease(data, rotated)
+	if err != nil {
+		t.Fatalf("Expected release encrypted with the old key to be decoded, got %s", err)
+	}
+	if _, err := decodeRelease(data, rotatedOnly); err == nil {
+		t.Error("Expected an error decoding release encrypted with a removed key")
+	}
+
+	if data, err = encodeRelease(got, rotated); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decodeRelease(data, rotatedOnly); err != nil {
+		t.Errorf("Expected re-encrypted release to be decoded with the new key, got %s", err)
+	}
+}
+
+func mustNewAEAD(t *testing.T) cipher.AEAD {
+	t.Helper()
+
+	aead, err := newAEAD(make([]byte, dataKeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return aead
+}
+
+func mustParseKeys(t *testing.T, keys string) KeyProvider {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte(strings.ReplaceAll(keys, ",", "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return NewFileKeyProvider(path)
+}
+
+func TestKeyProviders(t *testing.T) {
+	t.Setenv("TEST_HELM_KEYS", "k1:invalid")
+	if _, _, err := NewEnvKeyProvider("TEST_HELM_KEYS").PrimaryKey(); err == nil {
+		t.Error("Expected an error for invalid key")
+	}
+
+	keys := NewCommandKeyProvider("echo", testEncryptionKey("k1", 1), testEncryptionKey("k2", 2))
+	id, key, err := keys.PrimaryKey()
+	if err != nil {
+		t.Fatalf("Failed to get primary key from command: %s", err)
+	}
+	if id != "k1" || len(key) != 32 {
+		t.Errorf("Expected 32 bytes primary key k1, got %d bytes key %q", len(key), id)
+	}
+	if _, err := keys.Key("k3"); err == nil {
+		t.Error("Expected an error for unknown key")
+	}
+}
+
+func TestSecretsEncryption(t *testing.T) {
+	secrets := newTestFixtureSecrets(t)
+	secrets.Codec = &EncryptedCodec{Keys: mustParseKeys(t, testEncryptionKey("k1", 1))}
+
+	key := testKey("smug-pigeon", 1)
+	rel := releaseStub("smug-pigeon", 1, "default", rspb.StatusDeployed)
+	rel.Manifest = "password: hunter2"
+	if err := secrets.Create(key, rel); err != nil {
+		t.Fatalf("Failed to create release: %s", err)
+	}
+
+	obj := secrets.impl.(*MockSecretsInterface).objects[key]
+	b, _ := b64.DecodeString(string(obj.Data["release"]))
+	if !bytes.HasPrefix(b, magicEncrypted) {
+		t.Error("Expected stored release to be encrypted")
+	}
+	if obj.Labels["status"] != "deployed" {
+		t.Errorf("Expected labels to stay queryable, got %v", obj.Labels)
+	}
+
+	got, err := secrets.Get(key)
+	if err != nil {
+		t.Fatalf("Failed to get release: %s", err)
+	}
+	if got.Manifest != rel.Manifest {
+		t.Errorf("Expected manifest %q, got %q", rel.Manifest, got.Manifest)
+	}
+
+	secrets.Codec = nil
+	if _, err := secrets.Get(key); !errors.Is(err, ErrEncryptedRelease) {
+		t.Errorf("Expected ErrEncryptedRelease getting release without keys, got %v", err)
+	}
+	if _, err := secrets.List(func(*rspb.Release) bool { return true }); !errors.Is(err, ErrEncryptedRelease) {
+		t.Errorf("Expected ErrEncryptedRelease listing releases without keys, got %v", err)
+	}
+	if _, err := secrets.Query(map[string]string{"name": "smug-pigeon", "owner": "helm"}); !errors.Is(err, ErrEncryptedRelease) {
+		t.Errorf("Expected ErrEncryptedRelease querying releases without keys, got %v", err)
+	}
+
+	secrets.Codec = &EncryptedCodec{Keys: mustParseKeys(t, testEncryptionKey("k2", 2))}
+	if _, err := secrets.List(func(*rspb.Release) bool { return true }); !errors.Is(err, ErrEncryptionKeyNotFound) {
+		t.Errorf("Expected ErrEncryptionKeyNotFound listing releases without their key, got %v", err)
+	}
+	if _, err := secrets.Query(map[string]string{"name": "smug-pigeon", "owner": "helm"}); !errors.Is(err, ErrEncryptionKeyNotFound) {
+		t.Errorf("Expected ErrEncryptionKeyNotFound querying releases without their key, got %v", err)
+	}
+
+	// Other undecodable releases are skipped.
+	secrets.Codec = &EncryptedCodec{Keys: mustParseKeys(t, testEncryptionKey("k1", 1))}
+	corrupt := obj.DeepCopy()
+	corrupt.Name = testKey("smug-pigeon", 2)
+	corrupt.Labels["version"] = "2"
+	corrupt.Data["release"] = []byte("corrupt")
+	secrets.impl.(*MockSecretsInterface).objects[corrupt.Name] = corrupt
+
+	rels, err := secrets.List(func(*rspb.Release) bool { return true })
+	if err != nil || len(rels) != 1 {
+		t.Errorf("Expected the undecodable release to be skipped when listing, got %v, %v", rels, err)
+	}
+	rels, err = secrets.Query(map[string]string{"name": "smug-pigeon", "owner": "helm"})
+	if err != nil || len(rels) != 1 {
+		t.Errorf("Expected the undecodable release to be skipped when querying, got %v, %v", rels, err)
+	}
+}
@@ -0,0 +1,303 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+)
+
+var (
+	// ErrReleaseLocked indicates that the release is locked by another holder.
+	ErrReleaseLocked = errors.New("release: locked")
+	// ErrLockNotHeld indicates that the lock was released or taken over by
+	// another holder.
+	ErrLockNotHeld = errors.New("release: lock not held")
+)
+
+const (
+	lockHolderAnnotation    = "helm.sh/lock-holder"
+	lockRenewedAtAnnotation = "helm.sh/lock-renewed-at"
+	lockTTLAnnotation       = "helm.sh/lock-ttl"
+)
+
+// lockNow returns the current time. It is replaced in tests.
+var lockNow = time.Now
+
+// Lock is a lease on a release held by Holder. A lock which is not renewed
+// within TTL is stale and can be taken over by another holder.
+type Lock struct {
+	// Name is the name of the locked release.
+	Name      string
+	Holder    string
+	TTL       time.Duration
+	RenewedAt time.Time
+
+	// version is the version of the lock object the holder knows about.
+	version string
+}
+
+// Stale reports whether the lock was not renewed within its TTL.
+func (l *Lock) Stale() bool {
+	return lockNow().After(l.RenewedAt.Add(l.TTL))
+}
+
+// Locker is the interface of the drivers which can lock releases.
+//
+// AcquireLock locks the release named by name for holder. It returns an error
+// wrapping ErrReleaseLocked if the release is locked by another holder and
+// the lock is not stale. A stale lock is taken over.
+//
+// RenewLock extends the lock for another TTL. It returns an error wrapping
+// ErrLockNotHeld if the lock was taken over.
+//
+// ReleaseLock unlocks the release. It returns an error wrapping
+// ErrLockNotHeld if the lock was taken over.
+type Locker interface {
+	AcquireLock(name, holder string, ttl time.Duration) (*Lock, error)
+	RenewLock(lock *Lock) error
+	ReleaseLock(lock *Lock) error
+}
+
+func newErrReleaseLocked(lock *Lock) error {
+	return fmt.Errorf("%w by %q until %s", ErrReleaseLocked, lock.Holder, lock.RenewedAt.Add(lock.TTL).Format(time.RFC3339))
+}
+
+// lockObjectName returns the name of the object holding the lock of the
+// release.
+func lockObjectName(name string) string {
+	return fmt.Sprintf("sh.helm.release.lock.v1.%s", name)
+}
+
+// lockObjects stores the Kubernetes objects holding the release locks. The
+// locks are kept in the annotations of the objects and the resource version of
+// an object is used to detect concurrent changes.
+type lockObjects interface {
+	get(name string) (*metav1.ObjectMeta, error)
+	create(meta metav1.ObjectMeta) (*metav1.ObjectMeta, error)
+	update(meta metav1.ObjectMeta) (*metav1.ObjectMeta, error)
+	delete(name, resourceVersion string) error
+}
+
+func newLockObjectMeta(lock *Lock) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:            lockObjectName(lock.Name),
+		ResourceVersion: lock.version,
+		Annotations: map[string]string{
+			lockHolderAnnotation:    lock.Holder,
+			lockRenewedAtAnnotation: lock.RenewedAt.Format(time.RFC3339Nano),
+			lockTTLAnnotation:       lock.TTL.String(),
+		},
+	}
+}
+
+func lockFromObjectMeta(name string, meta *metav1.ObjectMeta) (*Lock, error) {
+	renewedAt, err := time.Parse(time.RFC3339Nano, meta.Annotations[lockRenewedAtAnnotation])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s annotation of lock %q", lockRenewedAtAnnotation, meta.Name)
+	}
+
+	ttl, err := time.ParseDuration(meta.Annotations[lockTTLAnnotation])
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid %s annotation of lock %q", lockTTLAnnotation, meta.Name)
+	}
+
+	return &Lock{
+		Name:      name,
+		Holder:    meta.Annotations[lockHolderAnnotation],
+		TTL:       ttl,
+		RenewedAt: renewedAt,
+		version:   meta.ResourceVersion,
+	}, nil
+}
+
+func acquireObjectLock(objs lockObjects, log func(string, ...interface{}), name, holder string, ttl time.Duration) (*Lock, error) {
+	lock := &Lock{Name: name, Holder: holder, TTL: ttl, RenewedAt: lockNow()}
+
+	meta, err := objs.create(newLockObjectMeta(lock))
+	if err == nil {
+		lock.version = meta.ResourceVersion
+		return lock, nil
+	}
+	if !apierrors.IsAlreadyExists(err) {
+		return nil, errors.Wrapf(err, "acquire lock: failed to create lock of %q", name)
+	}
+
+	meta, err = objs.get(lockObjectName(name))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: lock released concurrently", ErrReleaseLocked)
+		}
+		return nil, errors.Wrapf(err, "acquire lock: failed to get lock of %q", name)
+	}
+
+	held, err := lockFromObjectMeta(name, meta)
+	if err != nil {
+		log("acquire lock: taking over lock with invalid data: %s", err)
+	} else if held.Holder != holder {
+		if !held.Stale() {
+			return nil, newErrReleaseLocked(held)
+		}
+		log("acquire lock: taking over stale lock of %q held by %q", name, held.Holder)
+	}
+
+	lock.version = meta.ResourceVersion
+	meta, err = objs.update(newLockObjectMeta(lock))
+	if err != nil {
+		if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("%w: lock changed concurrently", ErrReleaseLocked)
+		}
+		return nil, errors.Wrapf(err, "acquire lock: failed to update lock of %q", name)
+	}
+
+	lock.version = meta.ResourceVersion
+	return lock, nil
+}
+
+// getHeldObjectLock returns the lock object if it is still held by the lock
+// holder.
+func getHeldObjectLock(objs lockObjects, lock *Lock) (*metav1.ObjectMeta, error) {
+	meta, err := objs.get(lockObjectName(lock.Name))
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, ErrLockNotHeld
+		}
+		return nil, err
+	}
+
+	if meta.Annotations[lockHolderAnnotation] != lock.Holder || meta.ResourceVersion != lock.version {
+		return nil, fmt.Errorf("%w: lock taken over by %q", ErrLockNotHeld, meta.Annotations[lockHolderAnnotation])
+	}
+
+	return meta, nil
+}
+
+func renewObjectLock(objs lockObjects, lock *Lock) error {
+	if _, err := getHeldObjectLock(objs, lock); err != nil {
+		return errors.Wrapf(err, "renew lock of %q", lock.Name)
+	}
+
+	renewed := *lock
+	renewed.RenewedAt = lockNow()
+	meta, err := objs.update(newLockObjectMeta(&renewed))
+	if err != nil {
+		if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			return errors.Wrapf(ErrLockNotHeld, "renew lock of %q", lock.Name)
+		}
+		return errors.Wrapf(err, "renew lock of %q", lock.Name)
+	}
+
+	lock.RenewedAt = renewed.RenewedAt
+	lock.version = meta.ResourceVersion
+	return nil
+}
+
+func releaseObjectLock(objs lockObjects, lock *Lock) error {
+	if _, err := getHeldObjectLock(objs, lock); err != nil {
+		return errors.Wrapf(err, "release lock of %q", lock.Name)
+	}
+
+	if err := objs.delete(lockObjectName(lock.Name), lock.version); err != nil {
+		if apierrors.IsConflict(err) || apierrors.IsNotFound(err) {
+			return errors.Wrapf(ErrLockNotHeld, "release lock of %q", lock.Name)
+		}
+		return errors.Wrapf(err, "release lock of %q", lock.Name)
+	}
+
+	return nil
+}
+
+func deleteOptionsWithResourceVersion(resourceVersion string) metav1.DeleteOptions {
+	var opts metav1.DeleteOptions
+	if resourceVersion != "" {
+		opts.Preconditions = &metav1.Preconditions{ResourceVersion: &resourceVersion}
+	}
+	return opts
+}
+
+// secretLockObjects keeps the locks in Secrets.
+type secretLockObjects struct {
+	impl corev1.SecretInterface
+}
+
+func (o secretLockObjects) get(name string) (*metav1.ObjectMeta, error) {
+	obj, err := o.impl.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &obj.ObjectMeta, nil
+}
+
+func (o secretLockObjects) create(meta metav1.ObjectMeta) (*metav1.ObjectMeta, error) {
+	obj, err := o.impl.Create(context.Background(), &v1.Secret{ObjectMeta: meta, Type: "helm.sh/release-lock.v1"}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &obj.ObjectMeta, nil
+}
+
+func (o secretLockObjects) update(meta metav1.ObjectMeta) (*metav1.ObjectMeta, error) {
+	obj, err := o.impl.Update(context.Background(), &v1.Secret{ObjectMeta: meta, Type: "helm.sh/release-lock.v1"}, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &obj.ObjectMeta, nil
+}
+
+func (o secretLockObjects) delete(name, resourceVersion string) error {
+	return o.impl.Delete(context.Background(), name, deleteOptionsWithResourceVersion(resourceVersion))
+}
+
+// configMapLockObjects keeps the locks in ConfigMaps.
+type configMapLockObjects struct {
+	impl corev1.ConfigMapInterface
+}
+
+func (o configMapLockObjects) get(name string) (*metav1.ObjectMeta, error) {
+	obj, err := o.impl.Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &obj.ObjectMeta, nil
+}
+
+func (o configMapLockObjects) create(meta metav1.ObjectMeta) (*metav1.ObjectMeta, error) {
+	obj, err := o.impl.Create(context.Background(), &v1.ConfigMap{ObjectMeta: meta}, metav1.CreateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &obj.ObjectMeta, nil
+}
+
+func (o configMapLockObjects) update(meta metav1.ObjectMeta) (*metav1.ObjectMeta, error) {
+	obj, err := o.impl.Update(context.Background(), &v1.ConfigMap{ObjectMeta: meta}, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	return &obj.ObjectMeta, nil
+}
+
+func (o configMapLockObjects) delete(name, resourceVersion string) error {
+	return o.impl.Delete(context.Background(), name, deleteOptionsWithResourceVersion(resourceVersion))
+}
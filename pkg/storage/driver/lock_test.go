@@ -0,0 +1,81 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLockers(t *testing.T) {
+	for name, newLocker := range map[string]func(t *testing.T) Locker{
+		"memory":     func(_ *testing.T) Locker { return NewMemory() },
+		"secrets":    func(t *testing.T) Locker { return newTestFixtureSecrets(t) },
+		"configmaps": func(t *testing.T) Locker { return newTestFixtureCfgMaps(t) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+			lockNow = func() time.Time { return now }
+			defer func() { lockNow = time.Now }()
+
+			locker := newLocker(t)
+
+			lock, err := locker.AcquireLock("smug-pigeon", "ci-1", time.Minute)
+			if err != nil {
+				t.Fatalf("Failed to acquire lock: %s", err)
+			}
+
+			if _, err := locker.AcquireLock("smug-pigeon", "ci-2", time.Minute); !errors.Is(err, ErrReleaseLocked) {
+				t.Errorf("Expected ErrReleaseLocked for held lock, got %v", err)
+			}
+			if _, err := locker.AcquireLock("other-release", "ci-2", time.Minute); err != nil {
+				t.Errorf("Expected lock of another release to be acquired, got %s", err)
+			}
+
+			now = now.Add(50 * time.Second)
+			if err := locker.RenewLock(lock); err != nil {
+				t.Fatalf("Failed to renew lock: %s", err)
+			}
+
+			now = now.Add(50 * time.Second)
+			if _, err := locker.AcquireLock("smug-pigeon", "ci-2", time.Minute); !errors.Is(err, ErrReleaseLocked) {
+				t.Errorf("Expected ErrReleaseLocked for renewed lock, got %v", err)
+			}
+
+			now = now.Add(time.Minute)
+			takenOver, err := locker.AcquireLock("smug-pigeon", "ci-2", time.Minute)
+			if err != nil {
+				t.Fatalf("Expected stale lock to be taken over, got %s", err)
+			}
+
+			if err := locker.RenewLock(lock); !errors.Is(err, ErrLockNotHeld) {
+				t.Errorf("Expected ErrLockNotHeld renewing taken over lock, got %v", err)
+			}
+			if err := locker.ReleaseLock(lock); !errors.Is(err, ErrLockNotHeld) {
+				t.Errorf("Expected ErrLockNotHeld releasing taken over lock, got %v", err)
+			}
+
+			if err := locker.ReleaseLock(takenOver); err != nil {
+				t.Fatalf("Failed to release lock: %s", err)
+			}
+			if _, err := locker.AcquireLock("smug-pigeon", "ci-1", time.Minute); err != nil {
+				t.Errorf("Expected released lock to be acquired, got %s", err)
+			}
+		})
+	}
+}
@@ -17,14 +17,18 @@ limitations under the License.
 package driver
 
 import (
+	"fmt"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
 )
 
 var _ Driver = (*Memory)(nil)
+var _ Locker = (*Memory)(nil)
+var _ Rewriter = (*Memory)(nil)
 
 const (
 	// MemoryDriverName is the string name of this driver.
@@ -42,6 +46,9 @@ type Memory struct {
 	namespace string
 	// A map of namespaces to releases
 	cache map[string]memReleases
+	// A map of namespaced release names to their locks
+	locks       map[string]*Lock
+	lockVersion int
 }
 
 // NewMemory initializes a new memory driver.
@@ -195,6 +202,22 @@ func (mem *Memory) Update(key string, rls *rspb.Release) error {
 	return ErrReleaseNotFound
 }
 
+// Rewrite does nothing but checking that the release exists, as Memory keeps
+// the releases unencoded.
+func (mem *Memory) Rewrite(key string, rls *rspb.Release) error {
+	defer unlock(mem.rlock())
+
+	namespace := rls.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	if rs, ok := mem.cache[namespace][rls.Name]; ok && rs.Exists(key) {
+		return nil
+	}
+	return ErrReleaseNotFound
+}
+
 // Delete deletes a release or returns ErrReleaseNotFound.
 func (mem *Memory) Delete(key string) (*rspb.Release, error) {
 	defer unlock(mem.wlock())
@@ -222,6 +245,69 @@ func (mem *Memory) Delete(key string) (*rspb.Release, error) {
 	return nil, ErrReleaseNotFound
 }
 
+// AcquireLock locks the release in the current namespace.
+func (mem *Memory) AcquireLock(name, holder string, ttl time.Duration) (*Lock, error) {
+	defer unlock(mem.wlock())
+
+	key := mem.lockKey(name)
+	if held, ok := mem.locks[key]; ok && held.Holder != holder && !held.Stale() {
+		return nil, newErrReleaseLocked(held)
+	}
+
+	if mem.locks == nil {
+		mem.locks = map[string]*Lock{}
+	}
+	mem.lockVersion++
+	lock := &Lock{Name: name, Holder: holder, TTL: ttl, RenewedAt: lockNow(), version: strconv.Itoa(mem.lockVersion)}
+	mem.locks[key] = lock
+
+	copied := *lock
+	return &copied, nil
+}
+
+// RenewLock extends the lock for another TTL.
+func (mem *Memory) RenewLock(lock *Lock) error {
+	defer unlock(mem.wlock())
+
+	_, held, err := mem.heldLock(lock)
+	if err != nil {
+		return fmt.Errorf("renew lock of %q: %w", lock.Name, err)
+	}
+
+	held.RenewedAt = lockNow()
+	lock.RenewedAt = held.RenewedAt
+	return nil
+}
+
+// ReleaseLock unlocks the release.
+func (mem *Memory) ReleaseLock(lock *Lock) error {
+	defer unlock(mem.wlock())
+
+	key, _, err := mem.heldLock(lock)
+	if err != nil {
+		return fmt.Errorf("release lock of %q: %w", lock.Name, err)
+	}
+
+	delete(mem.locks, key)
+	return nil
+}
+
+// heldLock returns the key and the state of the lock if it is still held. The
+// lock is looked up by its version, since the namespace of mem follows the
+// stored releases and may have changed since the lock was acquired.
+func (mem *Memory) heldLock(lock *Lock) (string, *Lock, error) {
+	for key, held := range mem.locks {
+		if held.Name == lock.Name && held.version == lock.version {
+			return key, held, nil
+		}
+	}
+	return "", nil, ErrLockNotHeld
+}
+
+func (mem *Memory) lockKey(name string) string {
+	return mem.namespace + "/" + name
+}
+
 // wlock locks mem for writing
 func (mem *Memory) wlock() func() {
 	mem.Lock()
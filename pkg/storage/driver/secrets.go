@@ -19,7 +19,6 @@ package driver // import "helm.sh/helm/v3/pkg/storage/driver"
 import (
 	"context"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/pkg/errors"
@@ -27,13 +26,15 @@ import (
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kblabels "k8s.io/apimachinery/pkg/labels"
-	"k8s.io/apimachinery/pkg/util/validation"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
 
 	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
 )
 
 var _ Driver = (*Secrets)(nil)
+var _ Locker = (*Secrets)(nil)
+var _ Summarizer = (*Secrets)(nil)
+var _ Rewriter = (*Secrets)(nil)
 
 // SecretsDriverName is the string name of the driver.
 const SecretsDriverName = "Secret"
@@ -43,6 +44,13 @@ const SecretsDriverName = "Secret"
 type Secrets struct {
 	impl corev1.SecretInterface
 	Log  func(string, ...interface{})
+
+	// ChunkSize is the maximum size of the encoded release kept in a single
+	// Secret. Bigger releases are split across several Secrets. Zero means
+	// DefaultChunkSize, a negative value disables splitting.
+	ChunkSize int
+	// Codec compresses the stored releases. Nil means GzipCodec.
+	Codec Codec
 }
 
 // NewSecrets initializes a new Secrets wrapping an implementation of
@@ -71,16 +79,50 @@ func (secrets *Secrets) Get(key string) (*rspb.Release, error) {
 		return nil, errors.Wrapf(err, "get: failed to get %q", key)
 	}
 	// found the secret, decode the base64 data string
-	r, err := decodeRelease(string(obj.Data["release"]))
+	r, err := secrets.decodeObject(obj, listChunkSet(secretChunkObjects{secrets.impl}))
+	if err != nil {
+		return nil, errors.Wrapf(err, "get: failed to decode data %q", key)
+	}
 	r.Labels = filterSystemLabels(obj.ObjectMeta.Labels)
-	return r, errors.Wrapf(err, "get: failed to decode data %q", key)
+	return r, nil
 }
 
 // List fetches all releases and returns the list releases such
 // that filter(release) == true. An error is returned if the
 // secret fails to retrieve the releases.
 func (secrets *Secrets) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
-	lsel := kblabels.Set{"owner": "helm"}.AsSelector()
+	return secrets.list(kblabels.Set{"owner": "helm"}.AsSelector(), filter, secrets.decodeObject)
+}
+
+// ListSummaries fetches the summaries of all releases and returns the
+// summaries such that filter(summary) == true.
+func (secrets *Secrets) ListSummaries(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
+	summarySel, releaseSel, err := summarySelectors(nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "list: failed to select summaries")
+	}
+
+	summaries, err := readSummaries(secrets.summaryObjects(), summarySel, secrets.Codec, secrets.Log)
+	if err != nil {
+		return nil, errors.Wrap(err, "list: failed to list summaries")
+	}
+
+	var results []*rspb.Release
+	for _, rls := range summaries {
+		if filter(rls) {
+			results = append(results, rls)
+		}
+	}
+
+	// summarize the releases stored without a summary object
+	rels, err := secrets.list(releaseSel, filter, secrets.decodeSummary)
+	if err != nil {
+		return nil, err
+	}
+	return append(results, rels...), nil
+}
+
+func (secrets *Secrets) list(lsel kblabels.Selector, filter func(*rspb.Release) bool, decode func(*v1.Secret, chunkLister) (*rspb.Release, error)) ([]*rspb.Release, error) {
 	opts := metav1.ListOptions{LabelSelector: lsel.String()}
 
 	list, err := secrets.impl.List(context.Background(), opts)
@@ -89,12 +131,15 @@ func (secrets *Secrets) List(filter func(*rspb.Release) bool) ([]*rspb.Release,
 	}
 
 	var results []*rspb.Release
+	chunks := secrets.listChunks(list.Items)
 
 	// iterate over the secrets object list
 	// and decode each release
 	for _, item := range list.Items {
-		rls, err := decodeRelease(string(item.Data["release"]))
-		if err != nil {
+		rls, err := decode(&item, chunks)
+		if isMissingKeyError(err) {
+			return nil, errors.Wrapf(err, "list: failed to decode release %q", item.Name)
+		} else if err != nil {
 			secrets.Log("list: failed to decode release: %v: %s", item, err)
 			continue
 		}
@@ -111,15 +156,44 @@ func (secrets *Secrets) List(filter func(*rspb.Release) bool) ([]*rspb.Release,
 // Query fetches all releases that match the provided map of labels.
 // An error is returned if the secret fails to retrieve the releases.
 func (secrets *Secrets) Query(labels map[string]string) ([]*rspb.Release, error) {
-	ls := kblabels.Set{}
-	for k, v := range labels {
-		if errs := validation.IsValidLabelValue(v); len(errs) != 0 {
-			return nil, errors.Errorf("invalid label value: %q: %s", v, strings.Join(errs, "; "))
-		}
-		ls[k] = v
+	ls, err := querySet(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	return secrets.query(ls.AsSelector(), secrets.decodeObject)
+}
+
+// QuerySummaries fetches the summaries of all releases that match the
+// provided map of labels.
+func (secrets *Secrets) QuerySummaries(labels map[string]string) ([]*rspb.Release, error) {
+	ls, err := querySet(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	summarySel, releaseSel, err := summarySelectors(ls)
+	if err != nil {
+		return nil, errors.Wrap(err, "query: failed to select summaries")
 	}
 
-	opts := metav1.ListOptions{LabelSelector: ls.AsSelector().String()}
+	summaries, err := readSummaries(secrets.summaryObjects(), summarySel, secrets.Codec, secrets.Log)
+	if err != nil {
+		return nil, errors.Wrap(err, "query: failed to query summaries")
+	}
+
+	// summarize the releases stored without a summary object
+	rels, err := secrets.query(releaseSel, secrets.decodeSummary)
+	if errors.Is(err, ErrReleaseNotFound) && len(summaries) > 0 {
+		return summaries, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return append(summaries, rels...), nil
+}
+
+func (secrets *Secrets) query(lsel kblabels.Selector, decode func(*v1.Secret, chunkLister) (*rspb.Release, error)) ([]*rspb.Release, error) {
+	opts := metav1.ListOptions{LabelSelector: lsel.String()}
 
 	list, err := secrets.impl.List(context.Background(), opts)
 	if err != nil {
@@ -131,9 +205,12 @@ func (secrets *Secrets) Query(labels map[string]string) ([]*rspb.Release, error)
 	}
 
 	var results []*rspb.Release
+	chunks := secrets.listChunks(list.Items)
 	for _, item := range list.Items {
-		rls, err := decodeRelease(string(item.Data["release"]))
-		if err != nil {
+		rls, err := decode(&item, chunks)
+		if isMissingKeyError(err) {
+			return nil, errors.Wrapf(err, "query: failed to decode release %q", item.Name)
+		} else if err != nil {
 			secrets.Log("query: failed to decode release: %s", err)
 			continue
 		}
@@ -152,20 +229,33 @@ func (secrets *Secrets) Create(key string, rls *rspb.Release) error {
 	lbs.init()
 	lbs.fromMap(rls.Labels)
 	lbs.set("createdAt", strconv.Itoa(int(time.Now().Unix())))
+	lbs.set(summarizedLabel, "true")
 
 	// create a new secret to hold the release
-	obj, err := newSecretsObject(key, rls, lbs)
+	obj, err := newSecretsObject(key, rls, lbs, secrets.Codec)
 	if err != nil {
 		return errors.Wrapf(err, "create: failed to encode release %q", rls.Name)
 	}
+	if err := secrets.splitObject(obj, rls); err != nil {
+		return errors.Wrapf(err, "create: failed to split release %q", rls.Name)
+	}
 	// push the secret object out into the kubiverse
 	if _, err := secrets.impl.Create(context.Background(), obj, metav1.CreateOptions{}); err != nil {
+		deleteUnusedChunkSet(secretChunkObjects{secrets.impl}, obj.ObjectMeta, secrets.Log)
 		if apierrors.IsAlreadyExists(err) {
 			return ErrReleaseExists
 		}
 
 		return errors.Wrap(err, "create: failed to create")
 	}
+	// store the summary, the release isn't created without it
+	if _, err := writeSummary(secrets.summaryObjects(), key, rls, lbs, secrets.Codec, chunkSize(secrets.ChunkSize), false, secrets.Log); err != nil {
+		if err := secrets.impl.Delete(context.Background(), key, metav1.DeleteOptions{}); err != nil {
+			secrets.Log("create: failed to delete release %q without a summary: %s", key, err)
+		}
+		deleteStaleChunks(secretChunkObjects{secrets.impl}, nil, rls.Name, rls.Version, secrets.Log)
+		return errors.Wrapf(err, "create: failed to create summary of %q", rls.Name)
+	}
 	return nil
 }
 
@@ -179,30 +269,139 @@ func (secrets *Secrets) Update(key string, rls *rspb.Release) error {
 	lbs.fromMap(rls.Labels)
 	lbs.set("modifiedAt", strconv.Itoa(int(time.Now().Unix())))
 
+	return secrets.update(key, rls, lbs)
+}
+
+// Rewrite stores the release in the Secret again, encoded with the current
+// codec. Unlike Update, it keeps the labels of the stored Secret.
+func (secrets *Secrets) Rewrite(key string, rls *rspb.Release) error {
+	obj, err := secrets.impl.Get(context.Background(), key, metav1.GetOptions{})
+	if err != nil {
+		if apierrors.IsNotFound(err) {
+			return ErrReleaseNotFound
+		}
+		return err
+	}
+
+	var lbs labels
+
+	lbs.init()
+	lbs.fromMap(obj.ObjectMeta.Labels)
+
+	rewritten := *rls
+	rewritten.Labels = filterSystemLabels(obj.ObjectMeta.Labels)
+	return secrets.update(key, &rewritten, lbs)
+}
+
+func (secrets *Secrets) update(key string, rls *rspb.Release, lbs labels) error {
+	lbs.set(summarizedLabel, "true")
+
 	// create a new secret object to hold the release
-	obj, err := newSecretsObject(key, rls, lbs)
+	obj, err := newSecretsObject(key, rls, lbs, secrets.Codec)
 	if err != nil {
 		return errors.Wrapf(err, "update: failed to encode release %q", rls.Name)
 	}
+	if err := secrets.splitObject(obj, rls); err != nil {
+		return errors.Wrapf(err, "update: failed to split release %q", rls.Name)
+	}
 	// push the secret object out into the kubiverse
-	_, err = secrets.impl.Update(context.Background(), obj, metav1.UpdateOptions{})
-	return errors.Wrap(err, "update: failed to update")
+	if _, err = secrets.impl.Update(context.Background(), obj, metav1.UpdateOptions{}); err != nil {
+		deleteUnusedChunkSet(secretChunkObjects{secrets.impl}, obj.ObjectMeta, secrets.Log)
+		return errors.Wrap(err, "update: failed to update")
+	}
+	summaryMeta, err := writeSummary(secrets.summaryObjects(), key, rls, lbs, secrets.Codec, chunkSize(secrets.ChunkSize), true, secrets.Log)
+	if err != nil {
+		return errors.Wrapf(err, "update: failed to update summary of %q", rls.Name)
+	}
+	deleteStaleChunks(secretChunkObjects{secrets.impl}, []metav1.ObjectMeta{obj.ObjectMeta, summaryMeta}, rls.Name, rls.Version, secrets.Log)
+	return nil
 }
 
 // Delete deletes the Secret holding the release named by key.
 func (secrets *Secrets) Delete(key string) (rls *rspb.Release, err error) {
 	// fetch the release to check existence
 	if rls, err = secrets.Get(key); err != nil {
+		if errors.Is(err, ErrReleaseNotFound) {
+			// delete the summary left by an interrupted deletion
+			if err := deleteSummary(secrets.summaryObjects(), key); err != nil {
+				secrets.Log("delete: failed to delete summary of %q: %s", key, err)
+			}
+		}
 		return nil, err
 	}
-	// delete the release
-	err = secrets.impl.Delete(context.Background(), key, metav1.DeleteOptions{})
-	return rls, err
+	// delete the release and then its summary
+	if err = secrets.impl.Delete(context.Background(), key, metav1.DeleteOptions{}); err != nil {
+		return rls, err
+	}
+	if err := deleteSummary(secrets.summaryObjects(), key); err != nil {
+		secrets.Log("delete: failed to delete summary of %q: %s", key, err)
+	}
+	deleteStaleChunks(secretChunkObjects{secrets.impl}, nil, rls.Name, rls.Version, secrets.Log)
+	return rls, nil
+}
+
+// decodeObject decodes the release kept in the Secret and its chunks.
+func (secrets *Secrets) decodeObject(obj *v1.Secret, chunks chunkLister) (*rspb.Release, error) {
+	data, err := readChunks(chunks, obj.ObjectMeta, string(obj.Data["release"]))
+	if err != nil {
+		return nil, err
+	}
+	return decodeRelease(data, secrets.Codec)
+}
+
+// decodeSummary summarizes the release kept in the Secret stored without a
+// summary object by an older version.
+func (secrets *Secrets) decodeSummary(obj *v1.Secret, chunks chunkLister) (*rspb.Release, error) {
+	rls, err := secrets.decodeObject(obj, chunks)
+	if err != nil {
+		return nil, err
+	}
+	return summarizeRelease(rls), nil
+}
+
+// listChunks returns a chunkLister listing the chunks of the Secrets at once.
+func (secrets *Secrets) listChunks(items []v1.Secret) chunkLister {
+	metas := make([]metav1.ObjectMeta, 0, len(items))
+	for _, item := range items {
+		metas = append(metas, item.ObjectMeta)
+	}
+	return listChunkSets(secretChunkObjects{secrets.impl}, metas)
+}
+
+// splitObject moves the part of the encoded release exceeding the chunk size
+// from the Secret to the chunk Secrets.
+func (secrets *Secrets) splitObject(obj *v1.Secret, rls *rspb.Release) error {
+	data, err := writeChunks(secretChunkObjects{secrets.impl}, &obj.ObjectMeta, rls.Name, rls.Version, string(obj.Data["release"]), chunkSize(secrets.ChunkSize))
+	if err != nil {
+		return err
+	}
+	obj.Data["release"] = []byte(data)
+	return nil
+}
+
+// summaryObjects returns the summary objects of the Secrets.
+func (secrets *Secrets) summaryObjects() summaryObjects {
+	return secretSummaryObjects{secretChunkObjects{secrets.impl}}
+}
+
+// AcquireLock locks the release in a Secret holding the lock.
+func (secrets *Secrets) AcquireLock(name, holder string, ttl time.Duration) (*Lock, error) {
+	return acquireObjectLock(secretLockObjects{secrets.impl}, secrets.Log, name, holder, ttl)
+}
+
+// RenewLock extends the lock for another TTL.
+func (secrets *Secrets) RenewLock(lock *Lock) error {
+	return renewObjectLock(secretLockObjects{secrets.impl}, lock)
+}
+
+// ReleaseLock deletes the Secret holding the lock.
+func (secrets *Secrets) ReleaseLock(lock *Lock) error {
+	return releaseObjectLock(secretLockObjects{secrets.impl}, lock)
 }
 
 // newSecretsObject constructs a kubernetes Secret object
 // to store a release. Each secret data entry is the base64
-// encoded gzipped string of a release.
+// encoded string of a release compressed with the codec.
 //
 // The following labels are used within each secret:
 //
@@ -212,11 +411,12 @@ func (secrets *Secrets) Delete(key string) (rls *rspb.Release, err error) {
 //	"status"         - status of the release (see pkg/release/status.go for variants)
 //	"owner"          - owner of the secret, currently "helm".
 //	"name"           - name of the release.
-func newSecretsObject(key string, rls *rspb.Release, lbs labels) (*v1.Secret, error) {
+//	"summarized"     - set once the summary of the release is stored. (set in Create and Update)
+func newSecretsObject(key string, rls *rspb.Release, lbs labels, codec Codec) (*v1.Secret, error) {
 	const owner = "helm"
 
 	// encode the release
-	s, err := encodeRelease(rls)
+	s, err := encodeRelease(rls, codec)
 	if err != nil {
 		return nil, err
 	}
@@ -34,6 +34,8 @@ import (
 )
 
 var _ Driver = (*SQL)(nil)
+var _ Rewriter = (*SQL)(nil)
+var _ Summarizer = (*SQL)(nil)
 
 var labelMap = map[string]struct{}{
 	"modifiedAt": {},
@@ -56,6 +58,7 @@ const (
 	sqlReleaseTableKeyColumn        = "key"
 	sqlReleaseTableTypeColumn       = "type"
 	sqlReleaseTableBodyColumn       = "body"
+	sqlReleaseTableSummaryColumn    = "summary"
 	sqlReleaseTableNameColumn       = "name"
 	sqlReleaseTableNamespaceColumn  = "namespace"
 	sqlReleaseTableVersionColumn    = "version"
@@ -88,6 +91,8 @@ type SQL struct {
 	statementBuilder sq.StatementBuilderType
 
 	Log func(string, ...interface{})
+	// Codec compresses the stored releases. Nil means GzipCodec.
+	Codec Codec
 }
 
 // Name returns the name of the driver.
@@ -232,6 +237,22 @@ func (s *SQL) ensureDBSetup() error {
 					`, sqlCustomLabelsTableName),
 				},
 			},
+			{
+				Id: "summary",
+				Up: []string{
+					fmt.Sprintf(`
+						ALTER TABLE %s ADD COLUMN %s TEXT NOT NULL DEFAULT '';
+					`,
+						sqlReleaseTableName,
+						sqlReleaseTableSummaryColumn,
+					),
+				},
+				Down: []string{
+					fmt.Sprintf(`
+						ALTER TABLE %s DROP COLUMN %s;
+					`, sqlReleaseTableName, sqlReleaseTableSummaryColumn),
+				},
+			},
 		},
 	}
 
@@ -256,6 +277,10 @@ type SQLReleaseWrapper struct {
 	// The rspb.Release body, as a base64-encoded string
 	Body string `db:"body"`
 
+	// The summary of the rspb.Release, encoded the same way as the body. It is
+	// empty for the releases stored before the summary column was added.
+	Summary string `db:"summary"`
+
 	// Release "labels" that can be used as filters in the storage.Query(labels map[string]string)
 	// we implemented. Note that allowing Helm users to filter against new dimensions will require a
 	// new migration to be added, and the Create and/or update functions to be updated accordingly.
@@ -319,7 +344,7 @@ func (s *SQL) Get(key string) (*rspb.Release, error) {
 		return nil, ErrReleaseNotFound
 	}
 
-	release, err := decodeRelease(record.Body)
+	release, err := decodeRelease(record.Body, s.Codec)
 	if err != nil {
 		s.Log("get: failed to decode data %q: %v", key, err)
 		return nil, err
@@ -335,8 +360,19 @@ func (s *SQL) Get(key string) (*rspb.Release, error) {
 
 // List returns the list of all releases such that filter(release) == true
 func (s *SQL) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
+	return s.list(sqlReleaseTableBodyColumn, filter, false)
+}
+
+// ListSummaries returns the summaries of all releases such that
+// filter(summary) == true. The release body is only fetched for the releases
+// stored without a summary.
+func (s *SQL) ListSummaries(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
+	return s.list(sqlSummaryOrBodyColumn(), filter, true)
+}
+
+func (s *SQL) list(column string, filter func(*rspb.Release) bool, summarize bool) ([]*rspb.Release, error) {
 	sb := s.statementBuilder.
-		Select(sqlReleaseTableKeyColumn, sqlReleaseTableNamespaceColumn, sqlReleaseTableBodyColumn).
+		Select(sqlReleaseTableKeyColumn, sqlReleaseTableNamespaceColumn, column).
 		From(sqlReleaseTableName).
 		Where(sq.Eq{sqlReleaseTableOwnerColumn: sqlReleaseDefaultOwner})
 
@@ -359,11 +395,17 @@ func (s *SQL) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
 
 	var releases []*rspb.Release
 	for _, record := range records {
-		release, err := decodeRelease(record.Body)
-		if err != nil {
+		release, err := decodeRelease(record.Body, s.Codec)
+		if isMissingKeyError(err) {
+			s.Log("list: failed to decode release %s: %v", record.Key, err)
+			return nil, err
+		} else if err != nil {
 			s.Log("list: failed to decode release: %v: %v", record, err)
 			continue
 		}
+		if summarize {
+			release = summarizeRelease(release)
+		}
 
 		if release.Labels, err = s.getReleaseCustomLabels(record.Key, record.Namespace); err != nil {
 			s.Log("failed to get release %s/%s custom labels: %v", record.Namespace, record.Key, err)
@@ -383,8 +425,19 @@ func (s *SQL) List(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
 
 // Query returns the set of releases that match the provided set of labels.
 func (s *SQL) Query(labels map[string]string) ([]*rspb.Release, error) {
+	return s.query(sqlReleaseTableBodyColumn, labels, false)
+}
+
+// QuerySummaries returns the summaries of the releases that match the
+// provided set of labels. The release body is only fetched for the releases
+// stored without a summary.
+func (s *SQL) QuerySummaries(labels map[string]string) ([]*rspb.Release, error) {
+	return s.query(sqlSummaryOrBodyColumn(), labels, true)
+}
+
+func (s *SQL) query(column string, labels map[string]string, summarize bool) ([]*rspb.Release, error) {
 	sb := s.statementBuilder.
-		Select(sqlReleaseTableKeyColumn, sqlReleaseTableNamespaceColumn, sqlReleaseTableBodyColumn).
+		Select(sqlReleaseTableKeyColumn, sqlReleaseTableNamespaceColumn, column).
 		From(sqlReleaseTableName)
 
 	keys := make([]string, 0, len(labels))
@@ -425,11 +478,17 @@ func (s *SQL) Query(labels map[string]string) ([]*rspb.Release, error) {
 
 	var releases []*rspb.Release
 	for _, record := range records {
-		release, err := decodeRelease(record.Body)
-		if err != nil {
+		release, err := decodeRelease(record.Body, s.Codec)
+		if isMissingKeyError(err) {
+			s.Log("list: failed to decode release %s: %v", record.Key, err)
+			return nil, err
+		} else if err != nil {
 			s.Log("list: failed to decode release: %v: %v", record, err)
 			continue
 		}
+		if summarize {
+			release = summarizeRelease(release)
+		}
 
 		if release.Labels, err = s.getReleaseCustomLabels(record.Key, record.Namespace); err != nil {
 			s.Log("failed to get release %s/%s custom labels: %v", record.Namespace, record.Key, err)
@@ -454,11 +513,16 @@ func (s *SQL) Create(key string, rls *rspb.Release) error {
 	}
 	s.namespace = namespace
 
-	body, err := encodeRelease(rls)
+	body, err := encodeRelease(rls, s.Codec)
 	if err != nil {
 		s.Log("failed to encode release: %v", err)
 		return err
 	}
+	summary, err := encodeSummary(rls, s.Codec)
+	if err != nil {
+		s.Log("failed to encode release summary: %v", err)
+		return err
+	}
 
 	transaction, err := s.db.Beginx()
 	if err != nil {
@@ -472,6 +536,7 @@ func (s *SQL) Create(key string, rls *rspb.Release) error {
 			sqlReleaseTableKeyColumn,
 			sqlReleaseTableTypeColumn,
 			sqlReleaseTableBodyColumn,
+			sqlReleaseTableSummaryColumn,
 			sqlReleaseTableNameColumn,
 			sqlReleaseTableNamespaceColumn,
 			sqlReleaseTableVersionColumn,
@@ -483,6 +548,7 @@ func (s *SQL) Create(key string, rls *rspb.Release) error {
 			key,
 			sqlReleaseDefaultType,
 			body,
+			summary,
 			rls.Name,
 			namespace,
 			int(rls.Version),
@@ -561,15 +627,21 @@ func (s *SQL) Update(key string, rls *rspb.Release) error {
 	}
 	s.namespace = namespace
 
-	body, err := encodeRelease(rls)
+	body, err := encodeRelease(rls, s.Codec)
 	if err != nil {
 		s.Log("failed to encode release: %v", err)
 		return err
 	}
+	summary, err := encodeSummary(rls, s.Codec)
+	if err != nil {
+		s.Log("failed to encode release summary: %v", err)
+		return err
+	}
 
 	query, args, err := s.statementBuilder.
 		Update(sqlReleaseTableName).
 		Set(sqlReleaseTableBodyColumn, body).
+		Set(sqlReleaseTableSummaryColumn, summary).
 		Set(sqlReleaseTableNameColumn, rls.Name).
 		Set(sqlReleaseTableVersionColumn, int(rls.Version)).
 		Set(sqlReleaseTableStatusColumn, rls.Info.Status.String()).
@@ -592,6 +664,50 @@ func (s *SQL) Update(key string, rls *rspb.Release) error {
 	return nil
 }
 
+// Rewrite stores the release body and summary again, encoded with the current codec.
+// Unlike Update, it keeps the modifiedAt column.
+func (s *SQL) Rewrite(key string, rls *rspb.Release) error {
+	namespace := rls.Namespace
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	body, err := encodeRelease(rls, s.Codec)
+	if err != nil {
+		s.Log("failed to encode release: %v", err)
+		return err
+	}
+	summary, err := encodeSummary(rls, s.Codec)
+	if err != nil {
+		s.Log("failed to encode release summary: %v", err)
+		return err
+	}
+
+	query, args, err := s.statementBuilder.
+		Update(sqlReleaseTableName).
+		Set(sqlReleaseTableBodyColumn, body).
+		Set(sqlReleaseTableSummaryColumn, summary).
+		Where(sq.Eq{sqlReleaseTableKeyColumn: key}).
+		Where(sq.Eq{sqlReleaseTableNamespaceColumn: namespace}).
+		ToSql()
+
+	if err != nil {
+		s.Log("failed to build update query: %v", err)
+		return err
+	}
+
+	result, err := s.db.Exec(query, args...)
+	if err != nil {
+		s.Log("failed to rewrite release %s in SQL database: %v", key, err)
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return ErrReleaseNotFound
+	}
+
+	return nil
+}
+
 // Delete deletes a release or returns ErrReleaseNotFound.
 func (s *SQL) Delete(key string) (*rspb.Release, error) {
 	transaction, err := s.db.Beginx()
@@ -618,7 +734,7 @@ func (s *SQL) Delete(key string) (*rspb.Release, error) {
 		return nil, ErrReleaseNotFound
 	}
 
-	release, err := decodeRelease(record.Body)
+	release, err := decodeRelease(record.Body, s.Codec)
 	if err != nil {
 		s.Log("failed to decode release %s: %v", key, err)
 		transaction.Rollback()
@@ -661,6 +777,12 @@ func (s *SQL) Delete(key string) (*rspb.Release, error) {
 	return release, err
 }
 
+// sqlSummaryOrBodyColumn selects the summary of the release as the body
+// column, or the release body if the release is stored without a summary.
+func sqlSummaryOrBodyColumn() string {
+	return fmt.Sprintf("COALESCE(NULLIF(%s, ''), %s) AS %s", sqlReleaseTableSummaryColumn, sqlReleaseTableBodyColumn, sqlReleaseTableBodyColumn)
+}
+
 // Get release custom labels from database
 func (s *SQL) getReleaseCustomLabels(key string, _ string) (map[string]string, error) {
 	query, args, err := s.statementBuilder.
@@ -40,7 +40,7 @@ func TestSQLGet(t *testing.T) {
 	key := testKey(name, vers)
 	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
 
-	body, _ := encodeRelease(rel)
+	body, _ := encodeRelease(rel, nil)
 
 	sqlDriver, mock := newTestFixtureSQL(t)
 
@@ -105,7 +105,7 @@ func TestSQLList(t *testing.T) {
 			sqlReleaseTableBodyColumn,
 		})
 		for _, r := range releases {
-			body, _ := encodeRelease(r)
+			body, _ := encodeRelease(r, nil)
 			rows.AddRow(body)
 		}
 		mock.
@@ -178,14 +178,16 @@ func TestSqlCreate(t *testing.T) {
 	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
 
 	sqlDriver, mock := newTestFixtureSQL(t)
-	body, _ := encodeRelease(rel)
+	body, _ := encodeRelease(rel, nil)
+	summary, _ := encodeSummary(rel, nil)
 
 	query := fmt.Sprintf(
-		"INSERT INTO %s (%s,%s,%s,%s,%s,%s,%s,%s,%s) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)",
+		"INSERT INTO %s (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)",
 		sqlReleaseTableName,
 		sqlReleaseTableKeyColumn,
 		sqlReleaseTableTypeColumn,
 		sqlReleaseTableBodyColumn,
+		sqlReleaseTableSummaryColumn,
 		sqlReleaseTableNameColumn,
 		sqlReleaseTableNamespaceColumn,
 		sqlReleaseTableVersionColumn,
@@ -197,7 +199,7 @@ func TestSqlCreate(t *testing.T) {
 	mock.ExpectBegin()
 	mock.
 		ExpectExec(regexp.QuoteMeta(query)).
-		WithArgs(key, sqlReleaseDefaultType, body, rel.Name, rel.Namespace, int(rel.Version), rel.Info.Status.String(), sqlReleaseDefaultOwner, int(time.Now().Unix())).
+		WithArgs(key, sqlReleaseDefaultType, body, summary, rel.Name, rel.Namespace, int(rel.Version), rel.Info.Status.String(), sqlReleaseDefaultOwner, int(time.Now().Unix())).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	labelsQuery := fmt.Sprintf(
@@ -235,14 +237,16 @@ func TestSqlCreateAlreadyExists(t *testing.T) {
 	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
 
 	sqlDriver, mock := newTestFixtureSQL(t)
-	body, _ := encodeRelease(rel)
+	body, _ := encodeRelease(rel, nil)
+	summary, _ := encodeSummary(rel, nil)
 
 	insertQuery := fmt.Sprintf(
-		"INSERT INTO %s (%s,%s,%s,%s,%s,%s,%s,%s,%s) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)",
+		"INSERT INTO %s (%s,%s,%s,%s,%s,%s,%s,%s,%s,%s) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)",
 		sqlReleaseTableName,
 		sqlReleaseTableKeyColumn,
 		sqlReleaseTableTypeColumn,
 		sqlReleaseTableBodyColumn,
+		sqlReleaseTableSummaryColumn,
 		sqlReleaseTableNameColumn,
 		sqlReleaseTableNamespaceColumn,
 		sqlReleaseTableVersionColumn,
@@ -255,7 +259,7 @@ func TestSqlCreateAlreadyExists(t *testing.T) {
 	mock.ExpectBegin()
 	mock.
 		ExpectExec(regexp.QuoteMeta(insertQuery)).
-		WithArgs(key, sqlReleaseDefaultType, body, rel.Name, rel.Namespace, int(rel.Version), rel.Info.Status.String(), sqlReleaseDefaultOwner, int(time.Now().Unix())).
+		WithArgs(key, sqlReleaseDefaultType, body, summary, rel.Name, rel.Namespace, int(rel.Version), rel.Info.Status.String(), sqlReleaseDefaultOwner, int(time.Now().Unix())).
 		WillReturnError(fmt.Errorf("dialect dependent SQL error"))
 
 	selectQuery := fmt.Sprintf(
@@ -296,12 +300,14 @@ func TestSqlUpdate(t *testing.T) {
 	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
 
 	sqlDriver, mock := newTestFixtureSQL(t)
-	body, _ := encodeRelease(rel)
+	body, _ := encodeRelease(rel, nil)
+	summary, _ := encodeSummary(rel, nil)
 
 	query := fmt.Sprintf(
-		"UPDATE %s SET %s = $1, %s = $2, %s = $3, %s = $4, %s = $5, %s = $6 WHERE %s = $7 AND %s = $8",
+		"UPDATE %s SET %s = $1, %s = $2, %s = $3, %s = $4, %s = $5, %s = $6, %s = $7 WHERE %s = $8 AND %s = $9",
 		sqlReleaseTableName,
 		sqlReleaseTableBodyColumn,
+		sqlReleaseTableSummaryColumn,
 		sqlReleaseTableNameColumn,
 		sqlReleaseTableVersionColumn,
 		sqlReleaseTableStatusColumn,
@@ -313,7 +319,7 @@ func TestSqlUpdate(t *testing.T) {
 
 	mock.
 		ExpectExec(regexp.QuoteMeta(query)).
-		WithArgs(body, rel.Name, int(rel.Version), rel.Info.Status.String(), sqlReleaseDefaultOwner, int(time.Now().Unix()), key, namespace).
+		WithArgs(body, summary, rel.Name, int(rel.Version), rel.Info.Status.String(), sqlReleaseDefaultOwner, int(time.Now().Unix()), key, namespace).
 		WillReturnResult(sqlmock.NewResult(0, 1))
 
 	if err := sqlDriver.Update(key, rel); err != nil {
@@ -325,6 +331,41 @@ func TestSqlUpdate(t *testing.T) {
 	}
 }
 
+func TestSqlRewrite(t *testing.T) {
+	vers := 1
+	name := "smug-pigeon"
+	namespace := "default"
+	key := testKey(name, vers)
+	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
+
+	sqlDriver, mock := newTestFixtureSQL(t)
+	sqlDriver.Codec = ZstdCodec
+	body, _ := encodeRelease(rel, ZstdCodec)
+	summary, _ := encodeSummary(rel, ZstdCodec)
+
+	query := fmt.Sprintf(
+		"UPDATE %s SET %s = $1, %s = $2 WHERE %s = $3 AND %s = $4",
+		sqlReleaseTableName,
+		sqlReleaseTableBodyColumn,
+		sqlReleaseTableSummaryColumn,
+		sqlReleaseTableKeyColumn,
+		sqlReleaseTableNamespaceColumn,
+	)
+
+	mock.
+		ExpectExec(regexp.QuoteMeta(query)).
+		WithArgs(body, summary, key, namespace).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	if err := sqlDriver.Rewrite(key, rel); err != nil {
+		t.Fatalf("failed to rewrite release with key %s: %v", key, err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("sql expectations weren't met: %v", err)
+	}
+}
+
 func TestSqlQuery(t *testing.T) {
 	// Reflect actual use cases in ../storage.go
 	labelSetUnknown := map[string]string{
@@ -343,9 +384,9 @@ func TestSqlQuery(t *testing.T) {
 	}
 
 	supersededRelease := releaseStub("smug-pigeon", 1, "default", rspb.StatusSuperseded)
-	supersededReleaseBody, _ := encodeRelease(supersededRelease)
+	supersededReleaseBody, _ := encodeRelease(supersededRelease, nil)
 	deployedRelease := releaseStub("smug-pigeon", 2, "default", rspb.StatusDeployed)
-	deployedReleaseBody, _ := encodeRelease(deployedRelease)
+	deployedReleaseBody, _ := encodeRelease(deployedRelease, nil)
 
 	// Let's actually start our test
 	sqlDriver, mock := newTestFixtureSQL(t)
@@ -449,6 +490,70 @@ func TestSqlQuery(t *testing.T) {
 	}
 }
 
+func TestSqlQuerySummaries(t *testing.T) {
+	summarizedRelease := releaseStub("smug-pigeon", 1, "default", rspb.StatusSuperseded)
+	summarizedRelease.Manifest = "manifest"
+	summarizedRelease.Config = map[string]interface{}{"name": "value"}
+	summary, _ := encodeSummary(summarizedRelease, nil)
+	// the release stored before the summary column was added is selected
+	// whole
+	legacyRelease := releaseStub("smug-pigeon", 2, "default", rspb.StatusDeployed)
+	legacyRelease.Config = map[string]interface{}{"name": "value"}
+	legacyReleaseBody, _ := encodeRelease(legacyRelease, nil)
+
+	sqlDriver, mock := newTestFixtureSQL(t)
+
+	query := fmt.Sprintf(
+		"SELECT %s, %s, COALESCE(NULLIF(%s, ''), %s) AS %s FROM %s WHERE %s = $1 AND %s = $2 AND %s = $3",
+		sqlReleaseTableKeyColumn,
+		sqlReleaseTableNamespaceColumn,
+		sqlReleaseTableSummaryColumn,
+		sqlReleaseTableBodyColumn,
+		sqlReleaseTableBodyColumn,
+		sqlReleaseTableName,
+		sqlReleaseTableNameColumn,
+		sqlReleaseTableOwnerColumn,
+		sqlReleaseTableNamespaceColumn,
+	)
+
+	mock.
+		ExpectQuery(regexp.QuoteMeta(query)).
+		WithArgs("smug-pigeon", sqlReleaseDefaultOwner, "default").
+		WillReturnRows(
+			mock.NewRows([]string{
+				sqlReleaseTableBodyColumn,
+			}).AddRow(
+				summary,
+			).AddRow(
+				legacyReleaseBody,
+			),
+		).RowsWillBeClosed()
+
+	mockGetReleaseCustomLabels(mock, "", summarizedRelease.Namespace, summarizedRelease.Labels)
+	mockGetReleaseCustomLabels(mock, "", legacyRelease.Namespace, legacyRelease.Labels)
+
+	results, err := sqlDriver.QuerySummaries(map[string]string{"name": "smug-pigeon", "owner": sqlReleaseDefaultOwner})
+	if err != nil {
+		t.Fatalf("failed to query release summaries for smug-pigeon: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected a resultset of size 2, got %d", len(results))
+	}
+	for _, res := range results {
+		if res.Config != nil {
+			t.Errorf("Expected summary of v%d without values, got %v", res.Version, res)
+		}
+	}
+	if results[0].Manifest != "manifest" {
+		t.Errorf("Expected summary with manifest, got %q", results[0].Manifest)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("sql expectations weren't met: %v", err)
+	}
+}
+
 func TestSqlDelete(t *testing.T) {
 	vers := 1
 	name := "smug-pigeon"
@@ -456,7 +561,7 @@ func TestSqlDelete(t *testing.T) {
 	key := testKey(name, vers)
 	rel := releaseStub(name, vers, namespace, rspb.StatusDeployed)
 
-	body, _ := encodeRelease(rel)
+	body, _ := encodeRelease(rel, nil)
 
 	sqlDriver, mock := newTestFixtureSQL(t)
 
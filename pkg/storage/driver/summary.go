@@ -0,0 +1,313 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	v1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/chart"
+	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
+)
+
+// Summarizer is the interface of the drivers which store a summary of every
+// release apart from it, so the release history and status are read without
+// fetching and decoding the whole releases. A summary keeps what the release
+// history, the release list and the calculation of the deployed resources
+// need: the release info without the notes and the resources, the labels, the
+// chart metadata and the manifest.
+//
+// ListSummaries returns the summaries of all releases that satisfy the filter
+// predicate.
+//
+// QuerySummaries returns the summaries of all releases that match the provided
+// label set.
+type Summarizer interface {
+	ListSummaries(filter func(*rspb.Release) bool) ([]*rspb.Release, error)
+	QuerySummaries(labels map[string]string) ([]*rspb.Release, error)
+}
+
+// summarizeRelease returns the summary of the release.
+func summarizeRelease(rls *rspb.Release) *rspb.Release {
+	summary := &rspb.Release{
+		Name:      rls.Name,
+		Version:   rls.Version,
+		Namespace: rls.Namespace,
+		Labels:    rls.Labels,
+		Manifest:  rls.Manifest,
+	}
+
+	if rls.Info != nil {
+		info := *rls.Info
+		info.Notes = ""
+		info.Resources = nil
+		summary.Info = &info
+	}
+
+	if rls.Chart != nil {
+		summary.Chart = &chart.Chart{Metadata: rls.Chart.Metadata}
+	}
+
+	return summary
+}
+
+// encodeSummary encodes the summary of the release the same way as the
+// release is encoded.
+func encodeSummary(rls *rspb.Release, codec Codec) (string, error) {
+	return encodeRelease(summarizeRelease(rls), codec)
+}
+
+const (
+	// summaryOwner is the owner label of the summary objects. It differs from
+	// the owner of the release objects, so summaries are not listed as
+	// releases.
+	summaryOwner = "helm-release-summary"
+
+	// summarizedLabel marks the release objects which summary is stored in a
+	// summary object. The summaries of the release objects stored by older
+	// versions are made of the whole releases.
+	summarizedLabel = "summarized"
+)
+
+// The summary of a release kept in a Secret or a ConfigMap is stored in its
+// own object named after the release object, so the summaries are listed
+// without fetching the releases. The summary object has the labels of the
+// release object, except that its owner is "helm-release-summary". A summary
+// exceeding the chunk size is split into chunks the same way a release is.
+//
+// The summary object is written after the release object and is deleted
+// after it. The release object is labeled "summarized" once its summary is
+// written.
+
+// summaryObject is the encoded summary of a release.
+type summaryObject struct {
+	meta metav1.ObjectMeta
+	data string
+}
+
+// summaryObjects stores the summary objects of a Kubernetes storage driver.
+type summaryObjects interface {
+	chunkObjects
+	createSummary(obj summaryObject) error
+	updateSummary(obj summaryObject) error
+	listSummaries(selector string) ([]summaryObject, error)
+	deleteSummary(name string) error
+}
+
+// summaryKey returns the name of the summary object of the release object
+// named by key.
+func summaryKey(key string) string {
+	return key + ".summary"
+}
+
+// writeSummary stores the summary of the release in the summary object of the
+// release object named by key. lbs are the labels of the release object. The
+// summary object is created if exists is false and updated otherwise, falling
+// back to the other if needed. The meta of the written summary object is
+// returned.
+func writeSummary(objs summaryObjects, key string, rls *rspb.Release, lbs labels, codec Codec, size int, exists bool, log func(string, ...interface{})) (metav1.ObjectMeta, error) {
+	meta := metav1.ObjectMeta{Name: summaryKey(key), Labels: map[string]string{}}
+	for k, v := range lbs {
+		meta.Labels[k] = v
+	}
+	meta.Labels["owner"] = summaryOwner
+	delete(meta.Labels, summarizedLabel)
+
+	data, err := encodeSummary(rls, codec)
+	if err != nil {
+		return meta, err
+	}
+	if data, err = writeChunks(objs, &meta, rls.Name, rls.Version, data, size); err != nil {
+		return meta, err
+	}
+
+	obj := summaryObject{meta: meta, data: data}
+	if exists {
+		if err = objs.updateSummary(obj); apierrors.IsNotFound(err) {
+			err = objs.createSummary(obj)
+		}
+	} else {
+		if err = objs.createSummary(obj); apierrors.IsAlreadyExists(err) {
+			err = objs.updateSummary(obj)
+		}
+	}
+	if err != nil {
+		deleteUnusedChunkSet(objs, meta, log)
+		return meta, err
+	}
+	return meta, nil
+}
+
+// readSummaries decodes the summaries kept in the summary objects matching
+// the selector. The summaries failing to decode are skipped, unless they miss
+// the encryption keys.
+func readSummaries(objs summaryObjects, selector kblabels.Selector, codec Codec, log func(string, ...interface{})) ([]*rspb.Release, error) {
+	items, err := objs.listSummaries(selector.String())
+	if err != nil {
+		return nil, err
+	}
+
+	metas := make([]metav1.ObjectMeta, 0, len(items))
+	for _, item := range items {
+		metas = append(metas, item.meta)
+	}
+	chunks := listChunkSets(objs, metas)
+
+	var results []*rspb.Release
+	for _, item := range items {
+		data, err := readChunks(chunks, item.meta, item.data)
+		var rls *rspb.Release
+		if err == nil {
+			rls, err = decodeRelease(data, codec)
+		}
+		if isMissingKeyError(err) {
+			return nil, errors.Wrapf(err, "failed to decode summary %q", item.meta.Name)
+		} else if err != nil {
+			log("failed to decode summary %q: %s", item.meta.Name, err)
+			continue
+		}
+
+		rls.Labels = summaryReleaseLabels(item.meta.Labels)
+		results = append(results, rls)
+	}
+	return results, nil
+}
+
+// deleteSummary deletes the summary object of the release object named by
+// key. A missing summary object is not an error.
+func deleteSummary(objs summaryObjects, key string) error {
+	if err := objs.deleteSummary(summaryKey(key)); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// summarySelectors returns the selectors of the summary objects and of the
+// release objects without a summary object matching the labels.
+func summarySelectors(lbs map[string]string) (kblabels.Selector, kblabels.Selector, error) {
+	summaries := kblabels.Set{}
+	releases := kblabels.Set{}
+	for k, v := range lbs {
+		summaries[k] = v
+		releases[k] = v
+	}
+	summaries["owner"] = summaryOwner
+	releases["owner"] = "helm"
+
+	req, err := kblabels.NewRequirement(summarizedLabel, selection.DoesNotExist, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return summaries.AsSelector(), kblabels.SelectorFromSet(releases).Add(*req), nil
+}
+
+// summaryReleaseLabels returns the labels of the release object of the
+// summary object labels.
+func summaryReleaseLabels(lbs map[string]string) map[string]string {
+	result := make(map[string]string, len(lbs)+1)
+	for k, v := range lbs {
+		result[k] = v
+	}
+	result["owner"] = "helm"
+	result[summarizedLabel] = "true"
+	return result
+}
+
+// secretSummaryObjects keeps the summaries in Secrets.
+type secretSummaryObjects struct {
+	secretChunkObjects
+}
+
+func (o secretSummaryObjects) createSummary(obj summaryObject) error {
+	_, err := o.impl.Create(context.Background(), o.secret(obj), metav1.CreateOptions{})
+	return err
+}
+
+func (o secretSummaryObjects) updateSummary(obj summaryObject) error {
+	_, err := o.impl.Update(context.Background(), o.secret(obj), metav1.UpdateOptions{})
+	return err
+}
+
+func (o secretSummaryObjects) secret(obj summaryObject) *v1.Secret {
+	return &v1.Secret{
+		ObjectMeta: obj.meta,
+		Type:       "helm.sh/release-summary.v1",
+		Data:       map[string][]byte{"summary": []byte(obj.data)},
+	}
+}
+
+func (o secretSummaryObjects) listSummaries(selector string) ([]summaryObject, error) {
+	list, err := o.impl.List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make([]summaryObject, 0, len(list.Items))
+	for _, item := range list.Items {
+		objs = append(objs, summaryObject{meta: item.ObjectMeta, data: string(item.Data["summary"])})
+	}
+	return objs, nil
+}
+
+func (o secretSummaryObjects) deleteSummary(name string) error {
+	return o.impl.Delete(context.Background(), name, metav1.DeleteOptions{})
+}
+
+// configMapSummaryObjects keeps the summaries in ConfigMaps.
+type configMapSummaryObjects struct {
+	configMapChunkObjects
+}
+
+func (o configMapSummaryObjects) createSummary(obj summaryObject) error {
+	_, err := o.impl.Create(context.Background(), o.configMap(obj), metav1.CreateOptions{})
+	return err
+}
+
+func (o configMapSummaryObjects) updateSummary(obj summaryObject) error {
+	_, err := o.impl.Update(context.Background(), o.configMap(obj), metav1.UpdateOptions{})
+	return err
+}
+
+func (o configMapSummaryObjects) configMap(obj summaryObject) *v1.ConfigMap {
+	return &v1.ConfigMap{
+		ObjectMeta: obj.meta,
+		Data:       map[string]string{"summary": obj.data},
+	}
+}
+
+func (o configMapSummaryObjects) listSummaries(selector string) ([]summaryObject, error) {
+	list, err := o.impl.List(context.Background(), metav1.ListOptions{LabelSelector: selector})
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make([]summaryObject, 0, len(list.Items))
+	for _, item := range list.Items {
+		objs = append(objs, summaryObject{meta: item.ObjectMeta, data: item.Data["summary"]})
+	}
+	return objs, nil
+}
+
+func (o configMapSummaryObjects) deleteSummary(name string) error {
+	return o.impl.Delete(context.Background(), name, metav1.DeleteOptions{})
+}
@@ -0,0 +1,171 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"testing"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/chart"
+	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
+)
+
+func TestSummarizers(t *testing.T) {
+	type fixture struct {
+		driver Driver
+		// dropSummary makes the release object look stored by an older
+		// version, without a summary object.
+		dropSummary func(key string)
+		// corruptRelease breaks the encoded release kept in the release
+		// object, so it only decodes from the summary object.
+		corruptRelease func(key string)
+		hasObject      func(key string) bool
+	}
+
+	for name, newFixture := range map[string]func(t *testing.T) fixture{
+		"secrets": func(t *testing.T) fixture {
+			secrets := newTestFixtureSecrets(t)
+			secrets.ChunkSize = 64
+			objects := secrets.impl.(*MockSecretsInterface).objects
+			return fixture{
+				driver: secrets,
+				dropSummary: func(key string) {
+					delete(objects, summaryKey(key))
+					delete(objects[key].Labels, summarizedLabel)
+				},
+				corruptRelease: func(key string) {
+					objects[key].Data["release"] = []byte("corrupt")
+				},
+				hasObject: func(key string) bool {
+					_, found := objects[key]
+					return found
+				},
+			}
+		},
+		"configmaps": func(t *testing.T) fixture {
+			cfgmaps := newTestFixtureCfgMaps(t)
+			cfgmaps.ChunkSize = 64
+			objects := cfgmaps.impl.(*MockConfigMapsInterface).objects
+			return fixture{
+				driver: cfgmaps,
+				dropSummary: func(key string) {
+					delete(objects, summaryKey(key))
+					delete(objects[key].Labels, summarizedLabel)
+				},
+				corruptRelease: func(key string) {
+					objects[key].Data["release"] = "corrupt"
+				},
+				hasObject: func(key string) bool {
+					_, found := objects[key]
+					return found
+				},
+			}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f := newFixture(t)
+			summarizer := f.driver.(Summarizer)
+
+			for _, vers := range []int{1, 2} {
+				rel := releaseStub("smug-pigeon", vers, "default", rspb.StatusSuperseded)
+				rel.Manifest = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: smug-pigeon\n"
+				rel.Info.Notes = "notes"
+				rel.Config = map[string]interface{}{"name": "value"}
+				rel.Hooks = []*rspb.Hook{{Name: "hook"}}
+				rel.Chart = &chart.Chart{
+					Metadata:  &chart.Metadata{Name: "pigeon", Version: "0.1.0"},
+					Templates: []*chart.File{{Name: "templates/cm.yaml"}},
+				}
+				if err := f.driver.Create(testKey(rel.Name, vers), rel); err != nil {
+					t.Fatalf("Failed to create release: %s", err)
+				}
+				// updating the release keeps the chunks of its summary
+				if err := f.driver.Update(testKey(rel.Name, vers), rel); err != nil {
+					t.Fatalf("Failed to update release: %s", err)
+				}
+			}
+
+			rel, err := f.driver.Get(testKey("smug-pigeon", 2))
+			if err != nil {
+				t.Fatalf("Failed to get release: %s", err)
+			}
+			if rel.Config == nil || len(rel.Hooks) != 1 || len(rel.Chart.Templates) != 1 || rel.Manifest == "" {
+				t.Errorf("Expected whole release to be got, got %v", rel)
+			}
+
+			// Objects stored without a summary are summarized when decoded.
+			f.dropSummary(testKey("smug-pigeon", 1))
+			// The summaries are decoded without the releases.
+			f.corruptRelease(testKey("smug-pigeon", 2))
+
+			rels, err := summarizer.QuerySummaries(map[string]string{"name": "smug-pigeon", "owner": "helm"})
+			if err != nil {
+				t.Fatalf("Failed to query summaries: %s", err)
+			}
+			if len(rels) != 2 {
+				t.Fatalf("Expected 2 summaries, got %d", len(rels))
+			}
+			for _, rel := range rels {
+				if rel.Config != nil || rel.Hooks != nil || rel.Info.Notes != "" {
+					t.Errorf("Expected summary of v%d without values, hooks and notes, got %v", rel.Version, rel)
+				}
+				if rel.Manifest == "" {
+					t.Errorf("Expected summary of v%d with manifest, got %v", rel.Version, rel)
+				}
+				if rel.Chart == nil || rel.Chart.Metadata.Name != "pigeon" || rel.Chart.Templates != nil {
+					t.Errorf("Expected summary of v%d with chart metadata only, got %v", rel.Version, rel.Chart)
+				}
+				if rel.Info.Status != rspb.StatusSuperseded {
+					t.Errorf("Expected summary of v%d with status, got %v", rel.Version, rel)
+				}
+				if rel.Labels["name"] != "smug-pigeon" || rel.Labels["owner"] != "helm" {
+					t.Errorf("Expected summary of v%d with labels, got %v", rel.Version, rel.Labels)
+				}
+			}
+
+			rels, err = summarizer.ListSummaries(func(rel *rspb.Release) bool { return rel.Version == 2 })
+			if err != nil {
+				t.Fatalf("Failed to list summaries: %s", err)
+			}
+			if len(rels) != 1 || rels[0].Version != 2 {
+				t.Errorf("Expected summary of v2 to be listed, got %v", rels)
+			}
+
+			rels, err = f.driver.List(func(_ *rspb.Release) bool { return true })
+			if err != nil {
+				t.Fatalf("Failed to list releases: %s", err)
+			}
+			if len(rels) != 1 || rels[0].Version != 1 {
+				t.Errorf("Expected only the decodable release to be listed, got %v", rels)
+			}
+
+			if _, err := f.driver.Delete(testKey("smug-pigeon", 1)); err != nil {
+				t.Fatalf("Failed to delete release: %s", err)
+			}
+			f.corruptRelease(testKey("smug-pigeon", 2))
+			if _, err := f.driver.Delete(testKey("smug-pigeon", 2)); err == nil {
+				t.Fatalf("Expected deleting a corrupt release to fail")
+			}
+			rels, err = summarizer.QuerySummaries(map[string]string{"name": "smug-pigeon", "owner": "helm"})
+			if err != nil || len(rels) != 1 || rels[0].Version != 2 {
+				t.Errorf("Expected only the summary of v2 to be left, got %v, %v", rels, err)
+			}
+			if f.hasObject(summaryKey(testKey("smug-pigeon", 1))) {
+				t.Errorf("Expected summary of v1 to be deleted")
+			}
+		})
+	}
+}
@@ -18,10 +18,14 @@ package driver // import "helm.sh/helm/v3/pkg/storage/driver"
 
 import (
 	"bytes"
-	"compress/gzip"
 	"encoding/base64"
 	"encoding/json"
-	"io"
+	"errors"
+	"fmt"
+	"strings"
+
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/validation"
 
 	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
 )
@@ -30,52 +34,51 @@ var b64 = base64.StdEncoding
 
 var magicGzip = []byte{0x1f, 0x8b, 0x08}
 
-var systemLabels = []string{"name", "owner", "status", "version", "createdAt", "modifiedAt"}
+var systemLabels = []string{"name", "owner", "status", "version", "createdAt", "modifiedAt", "summarized"}
 
 // encodeRelease encodes a release returning a base64 encoded
-// gzipped string representation, or error.
-func encodeRelease(rls *rspb.Release) (string, error) {
-	b, err := json.Marshal(rls)
-	if err != nil {
-		return "", err
+// string representation compressed with the codec, or error. A nil
+// codec means GzipCodec.
+func encodeRelease(rls *rspb.Release, codec Codec) (string, error) {
+	if codec == nil {
+		codec = GzipCodec
 	}
-	var buf bytes.Buffer
-	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+
+	b, err := json.Marshal(rls)
 	if err != nil {
 		return "", err
 	}
-	if _, err = w.Write(b); err != nil {
+	if b, err = codec.Compress(b); err != nil {
 		return "", err
 	}
-	w.Close()
 
-	return b64.EncodeToString(buf.Bytes()), nil
+	return b64.EncodeToString(b), nil
 }
 
 // decodeRelease decodes the bytes of data into a release
-// type. Data must contain a base64 encoded gzipped string of a
-// valid release, otherwise an error is returned.
-func decodeRelease(data string) (*rspb.Release, error) {
+// type. Data must contain a base64 encoded string of a valid
+// release compressed with the codec or any of the registered
+// codecs, otherwise an error is returned.
+func decodeRelease(data string, codec Codec) (*rspb.Release, error) {
 	// base64 decode string
 	b, err := b64.DecodeString(data)
 	if err != nil {
 		return nil, err
 	}
 
+	if codec == nil || len(codec.Marker()) == 0 || !bytes.HasPrefix(b, codec.Marker()) {
+		codec = detectCodec(b)
+	}
+
 	// For backwards compatibility with releases that were stored before
-	// compression was introduced we skip decompression if the
-	// gzip magic header is not found
-	if len(b) > 3 && bytes.Equal(b[0:3], magicGzip) {
-		r, err := gzip.NewReader(bytes.NewReader(b))
-		if err != nil {
+	// compression was introduced we skip decompression if the format
+	// marker of a codec is not found
+	if codec != nil {
+		if b, err = codec.Decompress(b); err != nil {
 			return nil, err
 		}
-		defer r.Close()
-		b2, err := io.ReadAll(r)
-		if err != nil {
-			return nil, err
-		}
-		b = b2
+	} else if bytes.HasPrefix(b, magicEncrypted) {
+		return nil, ErrEncryptedRelease
 	}
 
 	var rls rspb.Release
@@ -86,6 +89,26 @@ func decodeRelease(data string) (*rspb.Release, error) {
 	return &rls, nil
 }
 
+// isMissingKeyError reports whether a release failed to decode because the
+// encryption keys it needs are not configured. Listing fails on such errors
+// instead of skipping the release as undecodable, since the release is fine.
+func isMissingKeyError(err error) bool {
+	return errors.Is(err, ErrEncryptedRelease) || errors.Is(err, ErrEncryptionKeyNotFound)
+}
+
+// querySet returns the label set of the labels to query the releases with.
+// An error is returned if any label value is invalid.
+func querySet(labels map[string]string) (kblabels.Set, error) {
+	ls := kblabels.Set{}
+	for k, v := range labels {
+		if errs := validation.IsValidLabelValue(v); len(errs) != 0 {
+			return nil, fmt.Errorf("invalid label value: %q: %s", v, strings.Join(errs, "; "))
+		}
+		ls[k] = v
+	}
+	return ls, nil
+}
+
 // Checks if label is system
 func isSystemLabel(key string) bool {
 	for _, v := range GetSystemLabels() {
@@ -0,0 +1,193 @@
+/*
+Copyright The Helm Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/rand"
+
+	"github.com/werf/3p-helm-for-werf-helm/pkg/storage/driver"
+)
+
+const (
+	// DefaultLockTTL is the default time a release lock stays valid without
+	// being renewed.
+	DefaultLockTTL = time.Minute
+	// DefaultLockRetryInterval is the default interval of attempts to acquire a
+	// held release lock.
+	DefaultLockRetryInterval = 2 * time.Second
+)
+
+// LockOptions configure locking of a release.
+type LockOptions struct {
+	// Holder identifies the lock holder. Defaults to the hostname, the process
+	// ID and a random suffix.
+	Holder string
+	// TTL is the time the lock stays valid without being renewed. The lock is
+	// renewed every third of TTL while held. A lock of a crashed holder can be
+	// taken over once its TTL expires. Defaults to DefaultLockTTL.
+	TTL time.Duration
+	// WaitTimeout is the time to wait for the lock held by another holder.
+	// Zero fails right away.
+	WaitTimeout time.Duration
+	// RetryInterval is the interval of attempts to acquire the held lock.
+	// Defaults to DefaultLockRetryInterval.
+	RetryInterval time.Duration
+}
+
+// ReleaseLock is a lock on a release acquired with Storage.Lock. It is renewed
+// in the background until Unlock is called.
+type ReleaseLock struct {
+	locker driver.Locker
+	lock   *driver.Lock
+	log    func(string, ...interface{})
+
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	heldCtx    context.Context
+	cancelHeld context.CancelCauseFunc
+
+	stop chan struct{}
+	done chan struct{}
+	once sync.Once
+}
+
+// Lock locks the release for the whole operation on it, so concurrent
+// operations on the same release fail or wait according to opts. It fails if
+// the storage driver doesn't support locking.
+func (s *Storage) Lock(ctx context.Context, name string, opts LockOptions) (*ReleaseLock, error) {
+	locker, ok := s.Driver.(driver.Locker)
+	if !ok {
+		return nil, errors.Errorf("unable to lock release %q: storage driver %s doesn't support locking", name, s.Name())
+	}
+
+	if opts.Holder == "" {
+		opts.Holder = defaultLockHolder()
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultLockTTL
+	}
+	if opts.RetryInterval <= 0 {
+		opts.RetryInterval = DefaultLockRetryInterval
+	}
+
+	deadline := time.Now().Add(opts.WaitTimeout)
+	for {
+		s.Log("acquiring lock of release %q for %q", name, opts.Holder)
+		lock, err := locker.AcquireLock(name, opts.Holder, opts.TTL)
+		if err == nil {
+			lockCtx, cancel := context.WithCancelCause(ctx)
+			heldCtx, cancelHeld := context.WithCancelCause(context.WithoutCancel(ctx))
+			l := &ReleaseLock{
+				locker:     locker,
+				lock:       lock,
+				log:        s.Log,
+				ctx:        lockCtx,
+				cancel:     cancel,
+				heldCtx:    heldCtx,
+				cancelHeld: cancelHeld,
+				stop:       make(chan struct{}),
+				done:       make(chan struct{}),
+			}
+			go l.renew()
+
+			return l, nil
+		}
+
+		if !errors.Is(err, driver.ErrReleaseLocked) || !time.Now().Add(opts.RetryInterval).Before(deadline) {
+			return nil, fmt.Errorf("unable to lock release %q: %w", name, err)
+		}
+
+		s.Log("release %q is locked, retrying in %s: %s", name, opts.RetryInterval, err)
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("unable to lock release %q: %w", name, ctx.Err())
+		case <-time.After(opts.RetryInterval):
+		}
+	}
+}
+
+// Context returns the context passed to Storage.Lock which is also cancelled
+// once the lock is lost or unlocked. The operation under the lock should use
+// it, so it stops when another holder takes the lock over.
+func (l *ReleaseLock) Context() context.Context {
+	return l.ctx
+}
+
+// HeldContext returns a context which, unlike Context, is not cancelled with
+// the context passed to Storage.Lock, but only once the lock is lost or
+// unlocked. It is meant for the cleanup which has to be done under the lock
+// after the operation is cancelled, like an atomic rollback.
+func (l *ReleaseLock) HeldContext() context.Context {
+	return l.heldCtx
+}
+
+// renew renews the lock until it is unlocked or lost. A lost lock cancels the
+// lock context with the renewal error.
+func (l *ReleaseLock) renew() {
+	defer close(l.done)
+
+	ticker := time.NewTicker(l.lock.TTL / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := l.locker.RenewLock(l.lock); err != nil {
+			l.log("unable to renew lock: %s", err)
+			if errors.Is(err, driver.ErrLockNotHeld) {
+				l.cancel(err)
+				l.cancelHeld(err)
+				return
+			}
+		}
+	}
+}
+
+// Unlock stops renewing the lock, releases it and cancels the lock context. It
+// is safe to call Unlock multiple times.
+func (l *ReleaseLock) Unlock() error {
+	var err error
+	l.once.Do(func() {
+		close(l.stop)
+		<-l.done
+		err = l.locker.ReleaseLock(l.lock)
+		l.cancel(context.Canceled)
+		l.cancelHeld(context.Canceled)
+	})
+
+	return err
+}
+
+func defaultLockHolder() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	return fmt.Sprintf("%s-%d-%s", hostname, os.Getpid(), rand.String(5))
+}
@@ -22,6 +22,7 @@ import (
 
 	"github.com/pkg/errors"
 
+	"github.com/werf/3p-helm-for-werf-helm/pkg/events"
 	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
 	relutil "github.com/werf/3p-helm-for-werf-helm/pkg/releaseutil"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/storage/driver"
@@ -44,6 +45,9 @@ type Storage struct {
 	MaxHistory int
 
 	Log func(string, ...interface{})
+	// Events receives a ReleaseRecorded event for every created or updated
+	// release.
+	Events events.Recorder
 }
 
 // Get retrieves the release from storage. An error is returned
@@ -66,7 +70,12 @@ func (s *Storage) Create(rls *rspb.Release) error {
 			return err
 		}
 	}
-	return s.Driver.Create(makeKey(rls.Name, rls.Version), rls)
+	if err := s.Driver.Create(makeKey(rls.Name, rls.Version), rls); err != nil {
+		return err
+	}
+
+	s.recordReleaseEvent(rls)
+	return nil
 }
 
 // Update updates the release in storage. An error is returned if the
@@ -74,7 +83,21 @@ func (s *Storage) Create(rls *rspb.Release) error {
 // does not exist.
 func (s *Storage) Update(rls *rspb.Release) error {
 	s.Log("updating release %q", makeKey(rls.Name, rls.Version))
-	return s.Driver.Update(makeKey(rls.Name, rls.Version), rls)
+	if err := s.Driver.Update(makeKey(rls.Name, rls.Version), rls); err != nil {
+		return err
+	}
+
+	s.recordReleaseEvent(rls)
+	return nil
+}
+
+func (s *Storage) recordReleaseEvent(rls *rspb.Release) {
+	var status string
+	if rls.Info != nil {
+		status = rls.Info.Status.String()
+	}
+
+	events.Record(s.Events, events.NewReleaseRecorded(rls.Name, rls.Namespace, rls.Version, status))
 }
 
 // Delete deletes the release from storage. An error is returned if
@@ -111,10 +134,31 @@ func (s *Storage) ListDeployed() ([]*rspb.Release, error) {
 }
 
 // Deployed returns the last deployed release with the provided release name, or
-// returns ErrReleaseNotFound if not found.
+// returns ErrReleaseNotFound if not found. The deployed release is found by the
+// release summaries, so only the last deployed release is loaded whole.
 func (s *Storage) Deployed(name string) (*rspb.Release, error) {
-	ls, err := s.DeployedAll(name)
+	summary, err := s.deployedSummary(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Get(name, summary.Version)
+}
+
+// deployedSummary returns the summary of the last deployed release with the
+// provided release name.
+func (s *Storage) deployedSummary(name string) (*rspb.Release, error) {
+	s.Log("getting deployed release summaries from %q history", name)
+
+	ls, err := s.querySummaries(map[string]string{
+		"name":   name,
+		"owner":  "helm",
+		"status": "deployed",
+	})
 	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return nil, driver.NewErrNoDeployedReleases(name)
+		}
 		return nil, err
 	}
 
@@ -156,6 +200,36 @@ func (s *Storage) History(name string) ([]*rspb.Release, error) {
 	return s.Driver.Query(map[string]string{"name": name, "owner": "helm"})
 }
 
+// HistorySummaries returns the summaries of the revisions of the release with
+// the provided name, or returns ErrReleaseNotFound if no such release name
+// exists. The summaries keep the release info, the chart metadata and the
+// manifest, see driver.Summarizer. Use Get to load a whole revision.
+func (s *Storage) HistorySummaries(name string) ([]*rspb.Release, error) {
+	s.Log("getting release history summaries for %q", name)
+
+	return s.querySummaries(map[string]string{"name": name, "owner": "helm"})
+}
+
+// querySummaries returns the summaries of the releases that match the labels,
+// or the whole releases if the driver doesn't store summaries.
+func (s *Storage) querySummaries(labels map[string]string) ([]*rspb.Release, error) {
+	if summarizer, ok := s.Driver.(driver.Summarizer); ok {
+		return summarizer.QuerySummaries(labels)
+	}
+	return s.Driver.Query(labels)
+}
+
+// ListSummaries returns the summaries of the releases such that
+// filter(summary) == true. See HistorySummaries for what a summary keeps.
+func (s *Storage) ListSummaries(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
+	s.Log("listing release summaries in storage")
+
+	if summarizer, ok := s.Driver.(driver.Summarizer); ok {
+		return summarizer.ListSummaries(filter)
+	}
+	return s.Driver.List(filter)
+}
+
 // removeLeastRecent removes items from history until the length number of releases
 // does not exceed max.
 //
@@ -165,7 +239,7 @@ func (s *Storage) removeLeastRecent(name string, max int) error {
 	if max < 0 {
 		return nil
 	}
-	h, err := s.History(name)
+	h, err := s.HistorySummaries(name)
 	if err != nil {
 		return err
 	}
@@ -176,7 +250,7 @@ func (s *Storage) removeLeastRecent(name string, max int) error {
 	// We want oldest to newest
 	relutil.SortByRevision(h)
 
-	lastDeployed, err := s.Deployed(name)
+	lastDeployed, err := s.deployedSummary(name)
 	if err != nil && !errors.Is(err, driver.ErrNoDeployedReleases) {
 		return err
 	}
@@ -243,6 +317,34 @@ func (s *Storage) Last(name string) (*rspb.Release, error) {
 	return h[0], nil
 }
 
+// RewriteReleases stores all of the releases again, so they are encoded with
+// the current codec of the storage driver, e.g. after the codec or the
+// encryption keys are changed. It returns the number of rewritten releases.
+func (s *Storage) RewriteReleases() (int, error) {
+	rewriter, ok := s.Driver.(driver.Rewriter)
+	if !ok {
+		return 0, errors.Errorf("storage driver %s doesn't support rewriting releases", s.Name())
+	}
+
+	s.Log("rewriting all releases in storage")
+	rels, err := s.ListReleases()
+	if err != nil {
+		return 0, err
+	}
+
+	var rewritten int
+	for _, rls := range rels {
+		key := makeKey(rls.Name, rls.Version)
+		if err := rewriter.Rewrite(key, rls); err != nil {
+			return rewritten, errors.Wrapf(err, "unable to rewrite release %q", key)
+		}
+		rewritten++
+	}
+
+	s.Log("rewrote %d release(s)", rewritten)
+	return rewritten, nil
+}
+
 // makeKey concatenates the Kubernetes storage object type, a release name and version
 // into a string with format:```<helm_storage_type>.<release_name>.v<release_version>```.
 // The storage type is prepended to keep name uniqueness between different
@@ -266,8 +368,11 @@ func Init(d driver.Driver) *Storage {
 	}
 }
 
+// HistoryUntilRevision returns the summaries of the revisions of the release
+// with the provided name older than ignoreSinceRevision, sorted by revision.
+// See HistorySummaries for what a summary keeps.
 func (s *Storage) HistoryUntilRevision(name string, ignoreSinceRevision int) ([]*rspb.Release, error) {
-	history, err := s.History(name)
+	history, err := s.HistorySummaries(name)
 	if err != nil {
 		return nil, fmt.Errorf("error getting release history: %w", err)
 	}
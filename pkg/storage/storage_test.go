@@ -17,11 +17,17 @@ limitations under the License.
 package storage // import "helm.sh/helm/v3/pkg/storage"
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"fmt"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 
 	rspb "github.com/werf/3p-helm-for-werf-helm/pkg/release"
 	"github.com/werf/3p-helm-for-werf-helm/pkg/storage/driver"
@@ -247,6 +253,66 @@ func TestStorageDeployedWithCorruption(t *testing.T) {
 	}
 }
 
+// summarizingDriver stores the releases in memory and summarizes them, failing
+// the test if the releases are queried whole.
+type summarizingDriver struct {
+	*driver.Memory
+	t *testing.T
+}
+
+func (d summarizingDriver) Query(labels map[string]string) ([]*rspb.Release, error) {
+	d.t.Errorf("Expected summaries to be queried, got a query for releases with labels %v", labels)
+	return d.Memory.Query(labels)
+}
+
+func (d summarizingDriver) ListSummaries(filter func(*rspb.Release) bool) ([]*rspb.Release, error) {
+	return d.Memory.List(filter)
+}
+
+func (d summarizingDriver) QuerySummaries(labels map[string]string) ([]*rspb.Release, error) {
+	rels, err := d.Memory.Query(labels)
+	if err != nil {
+		return nil, err
+	}
+
+	summaries := make([]*rspb.Release, 0, len(rels))
+	for _, rel := range rels {
+		summaries = append(summaries, &rspb.Release{Name: rel.Name, Version: rel.Version, Info: rel.Info, Manifest: rel.Manifest})
+	}
+	return summaries, nil
+}
+
+func TestStorageDeployedQueriesSummaries(t *testing.T) {
+	storage := Init(summarizingDriver{Memory: driver.NewMemory(), t: t})
+
+	const name = "angry-bird"
+
+	rls0 := ReleaseTestData{Name: name, Version: 1, Status: rspb.StatusSuperseded}.ToRelease()
+	rls1 := ReleaseTestData{Name: name, Version: 2, Status: rspb.StatusDeployed}.ToRelease()
+	rls1.Config = map[string]interface{}{"name": "value"}
+	rls2 := ReleaseTestData{Name: name, Version: 3, Status: rspb.StatusFailed}.ToRelease()
+
+	assertErrNil(t.Fatal, storage.Create(rls0), "Storing release 'angry-bird' (v1)")
+	assertErrNil(t.Fatal, storage.Create(rls1), "Storing release 'angry-bird' (v2)")
+	assertErrNil(t.Fatal, storage.Create(rls2), "Storing release 'angry-bird' (v3)")
+
+	rls, err := storage.Deployed(name)
+	if err != nil {
+		t.Fatalf("Failed to query for deployed release: %s\n", err)
+	}
+	if rls.Version != 2 || rls.Config == nil {
+		t.Errorf("Expected whole deployed release v2, got %v", rls)
+	}
+
+	h, err := storage.HistoryUntilRevision(name, 3)
+	if err != nil {
+		t.Fatalf("Failed to query for release history summaries (%q): %s\n", name, err)
+	}
+	if len(h) != 2 || h[0].Version != 1 || h[1].Version != 2 {
+		t.Errorf("Expected summaries of v1 and v2, got %v", h)
+	}
+}
+
 func TestStorageHistory(t *testing.T) {
 	storage := Init(driver.NewMemory())
 
@@ -276,6 +342,14 @@ func TestStorageHistory(t *testing.T) {
 	if len(h) != 4 {
 		t.Fatalf("Release history (%q) is empty\n", name)
 	}
+
+	h, err = storage.HistorySummaries(name)
+	if err != nil {
+		t.Fatalf("Failed to query for release history summaries (%q): %s\n", name, err)
+	}
+	if len(h) != 4 {
+		t.Fatalf("Release history summaries (%q) is empty\n", name)
+	}
 }
 
 var errMaxHistoryMockDriverSomethingHappened = errors.New("something happened")
@@ -558,3 +632,136 @@ func assertErrNil(eh func(args ...interface{}), err error, message string) {
 		eh(fmt.Sprintf("%s: %q", message, err))
 	}
 }
+
+func TestStorageLock(t *testing.T) {
+	storage := Init(driver.NewMemory())
+
+	lock, err := storage.Lock(context.Background(), "angry-beaver", LockOptions{Holder: "ci-1"})
+	assertErrNil(t.Fatal, err, "Lock")
+
+	if _, err := storage.Lock(context.Background(), "angry-beaver", LockOptions{Holder: "ci-2"}); !errors.Is(err, driver.ErrReleaseLocked) {
+		t.Fatalf("Expected ErrReleaseLocked failing fast, got %v", err)
+	}
+
+	time.AfterFunc(20*time.Millisecond, func() {
+		if err := lock.Unlock(); err != nil {
+			t.Errorf("Failed to unlock: %s", err)
+		}
+	})
+
+	waited, err := storage.Lock(context.Background(), "angry-beaver", LockOptions{
+		Holder:        "ci-2",
+		WaitTimeout:   time.Minute,
+		RetryInterval: 5 * time.Millisecond,
+	})
+	assertErrNil(t.Fatal, err, "Lock after waiting")
+	assertErrNil(t.Fatal, waited.Unlock(), "Unlock")
+	assertErrNil(t.Fatal, waited.Unlock(), "Unlock twice")
+}
+
+func TestStorageLockLost(t *testing.T) {
+	mem := driver.NewMemory()
+	storage := Init(mem)
+
+	lock, err := storage.Lock(context.Background(), "angry-beaver", LockOptions{Holder: "ci-1", TTL: 30 * time.Millisecond})
+	assertErrNil(t.Fatal, err, "Lock")
+
+	// Lose the lock behind the back of the holder.
+	assertErrNil(t.Fatal, mem.ReleaseLock(lock.lock), "ReleaseLock")
+
+	select {
+	case <-lock.Context().Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the lock context to be cancelled once the lock is lost")
+	}
+	if err := context.Cause(lock.Context()); !errors.Is(err, driver.ErrLockNotHeld) {
+		t.Errorf("Expected the lock context to be cancelled with ErrLockNotHeld, got %v", err)
+	}
+}
+
+func TestStorageLockHeldContext(t *testing.T) {
+	mem := driver.NewMemory()
+	storage := Init(mem)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	lock, err := storage.Lock(ctx, "angry-beaver", LockOptions{Holder: "ci-1", TTL: 30 * time.Millisecond})
+	assertErrNil(t.Fatal, err, "Lock")
+
+	// The held context outlives the cancelled operation.
+	cancel()
+	if lock.HeldContext().Err() != nil {
+		t.Fatal("Expected the held context not to be cancelled with the operation")
+	}
+
+	// Lose the lock behind the back of the holder.
+	assertErrNil(t.Fatal, mem.ReleaseLock(lock.lock), "ReleaseLock")
+
+	select {
+	case <-lock.HeldContext().Done():
+	case <-time.After(time.Second):
+		t.Fatal("Expected the held context to be cancelled once the lock is lost")
+	}
+	if err := context.Cause(lock.HeldContext()); !errors.Is(err, driver.ErrLockNotHeld) {
+		t.Errorf("Expected the held context to be cancelled with ErrLockNotHeld, got %v", err)
+	}
+}
+
+func TestStorageLockUnsupported(t *testing.T) {
+	storage := Init(struct{ driver.Driver }{driver.NewMemory()})
+
+	if _, err := storage.Lock(context.Background(), "angry-beaver", LockOptions{}); err == nil {
+		t.Fatal("Expected locking to fail with a driver which doesn't support it")
+	}
+}
+
+func TestStorageRewriteReleases(t *testing.T) {
+	secrets := fake.NewSimpleClientset().CoreV1().Secrets("default")
+	d := driver.NewSecrets(secrets)
+	storage := Init(d)
+
+	for _, rls := range []*rspb.Release{
+		ReleaseTestData{Name: "angry-beaver", Version: 1}.ToRelease(),
+		ReleaseTestData{Name: "angry-beaver", Version: 2}.ToRelease(),
+		ReleaseTestData{Name: "happy-catdog", Version: 1}.ToRelease(),
+	} {
+		assertErrNil(t.Fatal, storage.Create(rls), "Create")
+	}
+
+	list, err := secrets.List(context.Background(), metav1.ListOptions{})
+	assertErrNil(t.Fatal, err, "List")
+	labels := map[string]map[string]string{}
+	for _, item := range list.Items {
+		labels[item.Name] = item.Labels
+	}
+
+	d.Codec = driver.ZstdCodec
+	rewritten, err := storage.RewriteReleases()
+	assertErrNil(t.Fatal, err, "RewriteReleases")
+	if rewritten != 3 {
+		t.Errorf("Expected 3 rewritten releases, got %d", rewritten)
+	}
+
+	list, err = secrets.List(context.Background(), metav1.ListOptions{})
+	assertErrNil(t.Fatal, err, "List")
+	for _, item := range list.Items {
+		// the summaries are rewritten with the releases
+		dataKey := "release"
+		if item.Labels["owner"] != "helm" {
+			dataKey = "summary"
+		}
+		data, err := base64.StdEncoding.DecodeString(string(item.Data[dataKey]))
+		assertErrNil(t.Fatal, err, "DecodeString")
+		if !bytes.HasPrefix(data, driver.ZstdCodec.Marker()) {
+			t.Errorf("Expected %q to be rewritten with the zstd codec", item.Name)
+		}
+		if !reflect.DeepEqual(labels[item.Name], item.Labels) {
+			t.Errorf("Expected the labels of %q to be kept, got %v, expected %v", item.Name, item.Labels, labels[item.Name])
+		}
+	}
+
+	rls, err := storage.Get("angry-beaver", 2)
+	assertErrNil(t.Fatal, err, "Get")
+	if rls.Version != 2 {
+		t.Errorf("Expected rewritten release to be got, got %v", rls)
+	}
+}